@@ -0,0 +1,240 @@
+// Package account implements account deletion and data exports.
+// Deleting an account takes effect after a grace period, during which the user can change their mind.
+// Data exports bundle the user's data into a ZIP archive of JSON files, which is prepared in the
+// background and can be downloaded for a limited time.
+package account
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pageza/chat-app/internal/errors"
+	"github.com/pageza/chat-app/internal/middleware"
+	"github.com/pageza/chat-app/internal/models"
+	redisI "github.com/pageza/chat-app/internal/redis"
+	"github.com/pageza/chat-app/internal/storage"
+	"github.com/pageza/chat-app/internal/utils"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Store is an interface for the persistence needed by account deletion and data exports.
+type Store interface {
+	GetUserByID(userID string) (*models.User, error)
+	UpdateUser(user *models.User) error
+	ListUsersDueForDeletion(now time.Time, limit int) ([]models.User, error)
+	AnonymizeUser(user *models.User, now time.Time) error
+
+	CreateDataExport(export *models.DataExport) error
+	UpdateDataExport(export *models.DataExport) error
+	GetDataExport(userID, exportID uint) (*models.DataExport, error)
+	ListDataExports(userID uint) ([]models.DataExport, error)
+	ListDataExportsByStatus(status string, limit int) ([]models.DataExport, error)
+	ListExpiredDataExports(now time.Time, limit int) ([]models.DataExport, error)
+	ClaimDataExport(exportID uint) (bool, error)
+
+	// The data bundled into exports
+	ListMessagesBySender(userID, afterID uint, limit int) ([]models.Message, error)
+	ListAPITokens(userID uint) ([]models.APIToken, error)
+	ListWebAuthnCredentials(userID uint) ([]models.WebAuthnCredential, error)
+	ListUserIdentities(userID uint) ([]models.UserIdentity, error)
+	ListUserRelations(userID uint, kind string) ([]models.UserRelation, error)
+}
+
+// Service contains dependencies for account deletion and data exports.
+// All handlers expect the request to have been authenticated by the auth middleware.
+type Service struct {
+	Store           Store
+	Revocations     redisI.RevocationStore
+	Media           storage.Storage // Holds the avatars, which are deleted with the account
+	Exports         storage.Storage // Holds the export archives; must not be publicly served
+	GracePeriod     time.Duration   // Time between a deletion request and the deletion
+	ExportRetention time.Duration   // Time an export archive can be downloaded for
+}
+
+// DeletionResponse describes a scheduled account deletion.
+type DeletionResponse struct {
+	DeletionScheduledFor *time.Time `json:"deletion_scheduled_for"`
+	Message              string     `json:"message"`
+}
+
+// ExportView is a data export, as shown to the user who requested it.
+type ExportView struct {
+	models.DataExport
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+// DeleteAccountHandler schedules the deletion of the logged-in user's account at the end of the grace period.
+// Until then, the user can keep using the account and cancel the deletion.
+func (s *Service) DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.CurrentUser(r)
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+
+	if !user.IsDeletionPending() {
+		scheduledFor := time.Now().Add(s.GracePeriod)
+		user.DeletionScheduledFor = &scheduledFor
+		if err := s.Store.UpdateUser(user); err != nil {
+			s.internalError(w, r, "Could not schedule account deletion", err)
+			return
+		}
+		logrus.WithFields(logrus.Fields{
+			"user":          user.Username,
+			"scheduled_for": scheduledFor,
+		}).Info("Account deletion scheduled")
+	}
+
+	utils.SendJSONResponse(w, http.StatusAccepted, DeletionResponse{
+		DeletionScheduledFor: user.DeletionScheduledFor,
+		Message:              "Your account will be deleted at the scheduled time unless you cancel the deletion before then",
+	})
+}
+
+// CancelDeletionHandler cancels the scheduled deletion of the logged-in user's account.
+func (s *Service) CancelDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.CurrentUser(r)
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+	if !user.IsDeletionPending() {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusConflict, "Account deletion is not scheduled"))
+		return
+	}
+
+	user.DeletionScheduledFor = nil
+	if err := s.Store.UpdateUser(user); err != nil {
+		s.internalError(w, r, "Could not cancel account deletion", err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, map[string]string{"message": "Account deletion has been canceled"})
+}
+
+// RequestExportHandler starts a data export of the logged-in user's data.
+// Only one export can be in progress at a time.
+func (s *Service) RequestExportHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.CurrentUser(r)
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+
+	exports, err := s.Store.ListDataExports(user.ID)
+	if err != nil {
+		s.internalError(w, r, "Could not start data export", err)
+		return
+	}
+	for _, export := range exports {
+		if export.IsActive() {
+			errors.RespondWithError(w, errors.NewAPIError(http.StatusConflict, "A data export is already in progress"))
+			return
+		}
+	}
+
+	export := &models.DataExport{UserID: user.ID, Status: models.DataExportPending}
+	if err := s.Store.CreateDataExport(export); err != nil {
+		s.internalError(w, r, "Could not start data export", err)
+		return
+	}
+
+	// Start right away; the worker picks the export up if this server stops before it is done
+	go s.processExport(context.Background(), *export)
+
+	utils.SendJSONResponse(w, http.StatusAccepted, newExportView(export))
+}
+
+// ListExportsHandler lists the logged-in user's data exports, newest first.
+func (s *Service) ListExportsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.CurrentUser(r)
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+
+	exports, err := s.Store.ListDataExports(user.ID)
+	if err != nil {
+		s.internalError(w, r, "Could not list data exports", err)
+		return
+	}
+
+	views := make([]ExportView, 0, len(exports))
+	for i := range exports {
+		views = append(views, newExportView(&exports[i]))
+	}
+	utils.SendJSONResponse(w, http.StatusOK, views)
+}
+
+// DownloadExportHandler sends the ZIP archive of one of the logged-in user's data exports.
+func (s *Service) DownloadExportHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.CurrentUser(r)
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+
+	exportID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid export ID"))
+		return
+	}
+	export, err := s.Store.GetDataExport(user.ID, uint(exportID))
+	if err == gorm.ErrRecordNotFound {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusNotFound, "Data export not found"))
+		return
+	}
+	if err != nil {
+		s.internalError(w, r, "Could not load data export", err)
+		return
+	}
+
+	switch export.Status {
+	case models.DataExportReady:
+	case models.DataExportExpired:
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusGone, "Data export has expired"))
+		return
+	default:
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusConflict, "Data export is not ready"))
+		return
+	}
+
+	archive, err := s.Exports.Open(r.Context(), export.StorageKey)
+	if err != nil {
+		s.internalError(w, r, "Could not load data export", err)
+		return
+	}
+	defer archive.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="chat-app-export-%d.zip"`, export.ID))
+	w.Header().Set("Content-Length", strconv.FormatInt(export.Size, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, archive); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"user":   user.Username,
+			"export": export.ID,
+		}).Warnf("Could not send data export: %v", err)
+	}
+}
+
+// newExportView converts a data export to its API representation.
+func newExportView(export *models.DataExport) ExportView {
+	view := ExportView{DataExport: *export}
+	if export.Status == models.DataExportReady {
+		view.DownloadURL = fmt.Sprintf("%s/v1/me/exports/%d/download", middleware.APIPrefix, export.ID)
+	}
+	return view
+}
+
+// internalError logs a server-side error and responds with a generic message.
+func (s *Service) internalError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	errors.Respond(w, r, errors.Wrap(err, http.StatusInternalServerError, errors.CodeInternal, message))
+}
@@ -0,0 +1,235 @@
+// Package account implements account deletion and data exports.
+// This file specifically includes the bundling of a user's data into a ZIP archive.
+package account
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/pageza/chat-app/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// messageBatchSize is the number of messages loaded at a time while writing an export.
+const messageBatchSize = 500
+
+// AccountExport is the content of account.json in a data export.
+type AccountExport struct {
+	ID                   uint                       `json:"id"`
+	Username             string                     `json:"username"`
+	Email                string                     `json:"email"`
+	Role                 string                     `json:"role"`
+	TOTPEnabled          bool                       `json:"totp_enabled"`
+	VeteranStatus        bool                       `json:"veteran_status"`
+	VeteranVerification  models.VeteranVerification `json:"veteran_verification"`
+	Profile              models.Profile             `json:"profile"`
+	Privacy              map[string]string          `json:"privacy"`
+	SuspendedAt          *time.Time                 `json:"suspended_at,omitempty"`
+	SuspendedReason      string                     `json:"suspended_reason,omitempty"`
+	DeletionScheduledFor *time.Time                 `json:"deletion_scheduled_for,omitempty"`
+	CreatedAt            time.Time                  `json:"created_at"`
+}
+
+// CredentialsExport is the content of credentials.json in a data export. Sessions are not listed,
+// since they are not stored on the server; the API tokens, passkeys and linked identities that can
+// be used to start one are.
+type CredentialsExport struct {
+	APITokens        []models.APIToken           `json:"api_tokens"`
+	Passkeys         []models.WebAuthnCredential `json:"passkeys"`
+	LinkedIdentities []models.UserIdentity       `json:"linked_identities"`
+}
+
+// RelationsExport is the content of relations.json in a data export.
+type RelationsExport struct {
+	Blocked []models.UserRelation `json:"blocked"`
+	Muted   []models.UserRelation `json:"muted"`
+}
+
+// processExport prepares the archive of a pending data export and stores it. Failures are recorded on the export.
+func (s *Service) processExport(ctx context.Context, export models.DataExport) {
+	logger := logrus.WithFields(logrus.Fields{
+		"export": export.ID,
+		"user":   export.UserID,
+	})
+
+	claimed, err := s.Store.ClaimDataExport(export.ID)
+	if err != nil {
+		logger.Errorf("Could not claim data export: %v", err)
+		return
+	}
+	if !claimed {
+		return // Processed elsewhere
+	}
+
+	key, size, err := s.writeExport(ctx, export.UserID)
+	now := time.Now()
+	export.CompletedAt = &now
+	if err != nil {
+		logger.Errorf("Could not prepare data export: %v", err)
+		export.Status = models.DataExportFailed
+	} else {
+		expiresAt := now.Add(s.ExportRetention)
+		export.Status = models.DataExportReady
+		export.StorageKey = key
+		export.Size = size
+		export.ExpiresAt = &expiresAt
+	}
+
+	if err := s.Store.UpdateDataExport(&export); err != nil {
+		logger.Errorf("Could not update data export: %v", err)
+		if key != "" {
+			s.deleteExportFile(ctx, key)
+		}
+	}
+}
+
+// writeExport bundles a user's data into a ZIP archive and stores it. It returns the storage key and size of the archive.
+func (s *Service) writeExport(ctx context.Context, userID uint) (string, int64, error) {
+	user, err := s.Store.GetUserByID(strconv.FormatUint(uint64(userID), 10))
+	if err != nil {
+		return "", 0, err
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	if err := s.writeArchive(archive, user); err != nil {
+		return "", 0, err
+	}
+	if err := archive.Close(); err != nil {
+		return "", 0, err
+	}
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", 0, err
+	}
+	key := fmt.Sprintf("exports/%d/%s.zip", user.ID, hex.EncodeToString(random))
+	if err := s.Exports.Put(ctx, key, buf.Bytes(), "application/zip"); err != nil {
+		return "", 0, err
+	}
+	return key, int64(buf.Len()), nil
+}
+
+// writeArchive writes the JSON files of a data export.
+func (s *Service) writeArchive(archive *zip.Writer, user *models.User) error {
+	privacy := make(map[string]string, len(models.PrivateProfileFields))
+	for _, field := range models.PrivateProfileFields {
+		privacy[field] = user.FieldVisibility(field)
+	}
+	if err := writeJSON(archive, "account.json", AccountExport{
+		ID:                   user.ID,
+		Username:             user.Username,
+		Email:                user.Email,
+		Role:                 user.EffectiveRole(),
+		TOTPEnabled:          user.TOTPEnabled,
+		VeteranStatus:        user.VeteranStatus,
+		VeteranVerification:  user.VeteranVerification,
+		Profile:              user.Profile,
+		Privacy:              privacy,
+		SuspendedAt:          user.SuspendedAt,
+		SuspendedReason:      user.SuspendedReason,
+		DeletionScheduledFor: user.DeletionScheduledFor,
+		CreatedAt:            user.CreatedAt,
+	}); err != nil {
+		return err
+	}
+
+	if err := s.writeMessages(archive, user.ID); err != nil {
+		return err
+	}
+
+	var credentials CredentialsExport
+	var err error
+	if credentials.APITokens, err = s.Store.ListAPITokens(user.ID); err != nil {
+		return err
+	}
+	if credentials.Passkeys, err = s.Store.ListWebAuthnCredentials(user.ID); err != nil {
+		return err
+	}
+	if credentials.LinkedIdentities, err = s.Store.ListUserIdentities(user.ID); err != nil {
+		return err
+	}
+	if err := writeJSON(archive, "credentials.json", credentials); err != nil {
+		return err
+	}
+
+	var relations RelationsExport
+	if relations.Blocked, err = s.Store.ListUserRelations(user.ID, models.RelationBlock); err != nil {
+		return err
+	}
+	if relations.Muted, err = s.Store.ListUserRelations(user.ID, models.RelationMute); err != nil {
+		return err
+	}
+	return writeJSON(archive, "relations.json", relations)
+}
+
+// writeMessages writes every message the user sent to messages.json, as a JSON array in the order they were sent.
+// Messages are loaded in batches, so that users with a long history don't have to fit in memory twice.
+func (s *Service) writeMessages(archive *zip.Writer, userID uint) error {
+	file, err := archive.Create("messages.json")
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(file, "["); err != nil {
+		return err
+	}
+	var afterID uint
+	first := true
+	for {
+		messages, err := s.Store.ListMessagesBySender(userID, afterID, messageBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, message := range messages {
+			data, err := json.MarshalIndent(message, "  ", "  ")
+			if err != nil {
+				return err
+			}
+			separator := ",\n  "
+			if first {
+				separator, first = "\n  ", false
+			}
+			if _, err := io.WriteString(file, separator); err != nil {
+				return err
+			}
+			if _, err := file.Write(data); err != nil {
+				return err
+			}
+		}
+		if len(messages) < messageBatchSize {
+			break
+		}
+		afterID = messages[len(messages)-1].ID
+	}
+	_, err = io.WriteString(file, "\n]\n")
+	return err
+}
+
+// writeJSON writes a value to the archive as an indented JSON file.
+func writeJSON(archive *zip.Writer, name string, value interface{}) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// deleteExportFile deletes an export archive, logging failures.
+func (s *Service) deleteExportFile(ctx context.Context, key string) {
+	if err := s.Exports.Delete(ctx, key); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"key": key,
+		}).Warnf("Could not delete data export: %v", err)
+	}
+}
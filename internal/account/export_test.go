@@ -0,0 +1,119 @@
+package account
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/pageza/chat-app/internal/models"
+	"github.com/pageza/chat-app/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryStore implements the parts of Store used by data exports.
+type memoryStore struct {
+	Store
+	user     models.User
+	messages []models.Message
+	exports  map[uint]*models.DataExport
+}
+
+func (m *memoryStore) GetUserByID(string) (*models.User, error) { return &m.user, nil }
+
+func (m *memoryStore) ClaimDataExport(id uint) (bool, error) {
+	if m.exports[id].Status != models.DataExportPending {
+		return false, nil
+	}
+	m.exports[id].Status = models.DataExportProcessing
+	return true, nil
+}
+
+func (m *memoryStore) UpdateDataExport(export *models.DataExport) error {
+	saved := *export
+	m.exports[export.ID] = &saved
+	return nil
+}
+
+func (m *memoryStore) ListMessagesBySender(userID, afterID uint, limit int) ([]models.Message, error) {
+	var messages []models.Message
+	for _, message := range m.messages {
+		if message.SenderID == userID && message.ID > afterID && len(messages) < limit {
+			messages = append(messages, message)
+		}
+	}
+	return messages, nil
+}
+
+func (m *memoryStore) ListAPITokens(uint) ([]models.APIToken, error) { return nil, nil }
+func (m *memoryStore) ListWebAuthnCredentials(uint) ([]models.WebAuthnCredential, error) {
+	return nil, nil
+}
+func (m *memoryStore) ListUserIdentities(uint) ([]models.UserIdentity, error) { return nil, nil }
+func (m *memoryStore) ListUserRelations(userID uint, kind string) ([]models.UserRelation, error) {
+	return []models.UserRelation{{UserID: userID, TargetID: 9, Kind: kind}}, nil
+}
+
+func TestProcessExport(t *testing.T) {
+	room := uint(1)
+	store := &memoryStore{
+		user: models.User{ID: 7, Username: "sam", Email: "sam@example.com", Profile: models.Profile{DisplayName: "Sam"}},
+		messages: []models.Message{
+			{ID: 1, RoomID: &room, SenderID: 7, Body: "hello"},
+			{ID: 2, RoomID: &room, SenderID: 8, Body: "someone else"},
+			{ID: 3, RoomID: &room, SenderID: 7, Body: "bye"},
+		},
+		exports: map[uint]*models.DataExport{1: {ID: 1, UserID: 7, Status: models.DataExportPending}},
+	}
+	exports := &storage.LocalStorage{Dir: t.TempDir()}
+	s := &Service{Store: store, Exports: exports, ExportRetention: time.Hour}
+
+	s.processExport(context.Background(), *store.exports[1])
+	export := store.exports[1]
+	require.Equal(t, models.DataExportReady, export.Status)
+	require.NotNil(t, export.ExpiresAt)
+	assert.Equal(t, "/api/v1/me/exports/1/download", newExportView(export).DownloadURL)
+
+	// A second run does not process the export again
+	s.processExport(context.Background(), models.DataExport{ID: 1, UserID: 7})
+	assert.Equal(t, export, store.exports[1])
+
+	file, err := exports.Open(context.Background(), export.StorageKey)
+	require.NoError(t, err)
+	data, err := io.ReadAll(file)
+	file.Close()
+	require.NoError(t, err)
+	assert.Equal(t, export.Size, int64(len(data)))
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	files := map[string][]byte{}
+	for _, f := range archive.File {
+		r, err := f.Open()
+		require.NoError(t, err)
+		files[f.Name], err = io.ReadAll(r)
+		require.NoError(t, err)
+		r.Close()
+	}
+	assert.Len(t, files, 4)
+
+	var account AccountExport
+	require.NoError(t, json.Unmarshal(files["account.json"], &account))
+	assert.Equal(t, "sam@example.com", account.Email)
+	assert.Equal(t, "Sam", account.Profile.DisplayName)
+
+	var messages []models.Message
+	require.NoError(t, json.Unmarshal(files["messages.json"], &messages))
+	require.Len(t, messages, 2)
+	assert.Equal(t, "hello", messages[0].Body)
+	assert.Equal(t, "bye", messages[1].Body)
+
+	var relations RelationsExport
+	require.NoError(t, json.Unmarshal(files["relations.json"], &relations))
+	assert.Len(t, relations.Blocked, 1)
+	assert.Contains(t, files, "credentials.json")
+}
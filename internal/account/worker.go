@@ -0,0 +1,127 @@
+// Package account implements account deletion and data exports.
+// This file specifically includes the background worker that deletes accounts and manages export archives.
+package account
+
+import (
+	"context"
+	"time"
+
+	"github.com/pageza/chat-app/internal/avatar"
+	"github.com/pageza/chat-app/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// workerBatchSize is the maximum number of accounts or exports handled by the worker per run and task.
+const workerBatchSize = 50
+
+// StartWorker runs the background work every interval until stop is closed: deleting the accounts whose
+// grace period has ended, preparing pending data exports, and deleting expired export archives.
+func (s *Service) StartWorker(interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.RunOnce(context.Background(), time.Now())
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// RunOnce performs one run of the background work.
+func (s *Service) RunOnce(ctx context.Context, now time.Time) {
+	s.deleteDueAccounts(ctx, now)
+	s.processPendingExports(ctx)
+	s.expireExports(ctx, now)
+}
+
+// deleteDueAccounts deletes and anonymizes the accounts whose deletion grace period has ended.
+func (s *Service) deleteDueAccounts(ctx context.Context, now time.Time) {
+	users, err := s.Store.ListUsersDueForDeletion(now, workerBatchSize)
+	if err != nil {
+		logrus.Errorf("Could not list accounts due for deletion: %v", err)
+		return
+	}
+	for i := range users {
+		if err := s.deleteAccount(ctx, &users[i], now); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"user": users[i].ID,
+			}).Errorf("Could not delete account: %v", err)
+		}
+	}
+}
+
+// deleteAccount ends the user's sessions, deletes their files, and anonymizes their account.
+func (s *Service) deleteAccount(ctx context.Context, user *models.User, now time.Time) error {
+	// Revoke tokens under the current username, before it is replaced, so that they stay
+	// invalid even if someone else registers the username later
+	if err := s.Revocations.RevokeUserTokens(ctx, user.Username); err != nil {
+		return err
+	}
+
+	avatarKey := user.AvatarKey
+	exports, err := s.Store.ListDataExports(user.ID)
+	if err != nil {
+		return err
+	}
+	if err := s.Store.AnonymizeUser(user, now); err != nil {
+		return err
+	}
+
+	// The files are no longer referenced, so failures to delete them are only logged
+	if avatarKey != "" {
+		for _, size := range avatar.Sizes {
+			if err := s.Media.Delete(ctx, avatar.File(avatarKey, size)); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"key": avatarKey,
+				}).Warnf("Could not delete avatar: %v", err)
+			}
+		}
+	}
+	for _, export := range exports {
+		if export.StorageKey != "" {
+			s.deleteExportFile(ctx, export.StorageKey)
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"user": user.ID,
+	}).Info("Account deleted")
+	return nil
+}
+
+// processPendingExports prepares the data exports that were not started when they were requested,
+// e.g. because the server stopped.
+func (s *Service) processPendingExports(ctx context.Context) {
+	exports, err := s.Store.ListDataExportsByStatus(models.DataExportPending, workerBatchSize)
+	if err != nil {
+		logrus.Errorf("Could not list pending data exports: %v", err)
+		return
+	}
+	for _, export := range exports {
+		s.processExport(ctx, export)
+	}
+}
+
+// expireExports deletes the archives of the data exports whose retention period has ended.
+func (s *Service) expireExports(ctx context.Context, now time.Time) {
+	exports, err := s.Store.ListExpiredDataExports(now, workerBatchSize)
+	if err != nil {
+		logrus.Errorf("Could not list expired data exports: %v", err)
+		return
+	}
+	for i := range exports {
+		export := &exports[i]
+		s.deleteExportFile(ctx, export.StorageKey)
+		export.Status = models.DataExportExpired
+		export.StorageKey = ""
+		if err := s.Store.UpdateDataExport(export); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"export": export.ID,
+			}).Errorf("Could not update data export: %v", err)
+		}
+	}
+}
@@ -0,0 +1,394 @@
+// Package admin implements the moderation and administration API.
+// Every action that changes state is recorded in the audit log.
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pageza/chat-app/internal/errors"
+	"github.com/pageza/chat-app/internal/middleware"
+	"github.com/pageza/chat-app/internal/models"
+	redisI "github.com/pageza/chat-app/internal/redis"
+	"github.com/pageza/chat-app/internal/utils"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Pagination limits for list endpoints.
+const (
+	defaultPerPage = 50
+	maxPerPage     = 100
+)
+
+// statsWindow is the default reporting window of the moderation stats.
+const statsWindow = 24 * time.Hour
+
+// Store is an interface for the persistence needed by the admin API.
+type Store interface {
+	GetUserByID(userID string) (*models.User, error)
+	UpdateUser(user *models.User) error
+	ListUsers(search string, offset, limit int) ([]models.User, int64, error)
+	CreateAuditLogEntry(entry *models.AuditLogEntry) error
+	ListAuditLogEntries(offset, limit int) ([]models.AuditLogEntry, int64, error)
+	GetModerationStats(since time.Time) (*models.ModerationStats, error)
+}
+
+// Handler contains dependencies for handling admin requests.
+// All handlers expect the request to have been authenticated by the auth middleware.
+type Handler struct {
+	Store       Store
+	Revocations redisI.RevocationStore
+	ClientIP    *middleware.ClientIPResolver // Resolves the address recorded in the audit log behind trusted proxies
+}
+
+// UserView is the representation of a user in admin API responses.
+type UserView struct {
+	ID              uint       `json:"id"`
+	Username        string     `json:"username"`
+	Email           string     `json:"email"`
+	Role            string     `json:"role"`
+	TOTPEnabled     bool       `json:"totp_enabled"`
+	SuspendedAt     *time.Time `json:"suspended_at,omitempty"`
+	SuspendedReason string     `json:"suspended_reason,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+
+	VeteranStatus       bool                        `json:"veteran_status"`
+	VeteranVerification *models.VeteranVerification `json:"veteran_verification,omitempty"`
+}
+
+// SuspendRequest is the payload of a suspend request.
+type SuspendRequest struct {
+	Reason string `json:"reason"`
+}
+
+// VerifyVeteranRequest is the payload of a manual veteran verification.
+type VerifyVeteranRequest struct {
+	Authority string `json:"authority"` // What the verification was based on, e.g. "DD-214 reviewed"
+}
+
+// SetRoleRequest is the payload of a role change request.
+type SetRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// newUserView converts a user to its admin API representation.
+func newUserView(user *models.User) UserView {
+	view := UserView{
+		ID:              user.ID,
+		Username:        user.Username,
+		Email:           user.Email,
+		Role:            user.EffectiveRole(),
+		TOTPEnabled:     user.TOTPEnabled,
+		SuspendedAt:     user.SuspendedAt,
+		SuspendedReason: user.SuspendedReason,
+		CreatedAt:       user.CreatedAt,
+		VeteranStatus:   user.VeteranStatus,
+	}
+	if user.VeteranStatus {
+		verification := user.VeteranVerification
+		view.VeteranVerification = &verification
+	}
+	return view
+}
+
+// ListUsersHandler lists users, optionally filtered by the "q" query parameter.
+func (h *Handler) ListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	page, perPage := pagination(r)
+	users, total, err := h.Store.ListUsers(r.URL.Query().Get("q"), (page-1)*perPage, perPage)
+	if err != nil {
+		h.internalError(w, r, "Could not list users", err)
+		return
+	}
+
+	views := make([]UserView, 0, len(users))
+	for i := range users {
+		views = append(views, newUserView(&users[i]))
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"users":    views,
+		"total":    total,
+		"page":     page,
+		"per_page": perPage,
+	})
+}
+
+// SuspendUserHandler suspends a user and revokes all of their sessions.
+func (h *Handler) SuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	actor, target, ok := h.loadTarget(w, r)
+	if !ok {
+		return
+	}
+
+	var req SuspendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid request payload"))
+		return
+	}
+
+	now := time.Now()
+	target.SuspendedAt = &now
+	target.SuspendedReason = req.Reason
+	if err := h.Store.UpdateUser(target); err != nil {
+		h.internalError(w, r, "Could not suspend user", err)
+		return
+	}
+	h.revokeSessions(r, target)
+
+	h.audit(r, actor, models.AuditUserSuspended, target, map[string]string{"reason": req.Reason})
+	utils.SendJSONResponse(w, http.StatusOK, newUserView(target))
+}
+
+// UnsuspendUserHandler lifts a user's suspension.
+func (h *Handler) UnsuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	actor, target, ok := h.loadTarget(w, r)
+	if !ok {
+		return
+	}
+
+	target.SuspendedAt = nil
+	target.SuspendedReason = ""
+	if err := h.Store.UpdateUser(target); err != nil {
+		h.internalError(w, r, "Could not unsuspend user", err)
+		return
+	}
+
+	h.audit(r, actor, models.AuditUserUnsuspended, target, nil)
+	utils.SendJSONResponse(w, http.StatusOK, newUserView(target))
+}
+
+// LogoutUserHandler revokes all of a user's sessions, forcing them to log in again.
+func (h *Handler) LogoutUserHandler(w http.ResponseWriter, r *http.Request) {
+	actor, target, ok := h.loadTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.Revocations.RevokeUserTokens(r.Context(), target.Username); err != nil {
+		h.internalError(w, r, "Could not log out user", err)
+		return
+	}
+
+	h.audit(r, actor, models.AuditUserLoggedOut, target, nil)
+	utils.SendJSONResponse(w, http.StatusOK, map[string]string{"message": "User has been logged out"})
+}
+
+// SetRoleHandler changes a user's global role. Only administrators may change roles,
+// and the user's sessions are revoked so that new tokens carry the new role.
+func (h *Handler) SetRoleHandler(w http.ResponseWriter, r *http.Request) {
+	actor, target, ok := h.loadTarget(w, r)
+	if !ok {
+		return
+	}
+
+	var req SetRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid request payload"))
+		return
+	}
+	if !models.IsValidRole(req.Role) {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid role"))
+		return
+	}
+
+	previous := target.EffectiveRole()
+	target.Role = req.Role
+	if err := h.Store.UpdateUser(target); err != nil {
+		h.internalError(w, r, "Could not change role", err)
+		return
+	}
+	h.revokeSessions(r, target)
+
+	h.audit(r, actor, models.AuditUserRoleChanged, target, map[string]string{"from": previous, "to": req.Role})
+	utils.SendJSONResponse(w, http.StatusOK, newUserView(target))
+}
+
+// VerifyVeteranHandler manually verifies a user's veteran status, e.g. after an administrator
+// reviewed their discharge papers. It replaces any verification by an identity provider.
+func (h *Handler) VerifyVeteranHandler(w http.ResponseWriter, r *http.Request) {
+	actor, target, ok := h.loadTarget(w, r)
+	if !ok {
+		return
+	}
+
+	var req VerifyVeteranRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid request payload"))
+		return
+	}
+	req.Authority = strings.TrimSpace(req.Authority)
+	if req.Authority == "" {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "The authority the verification is based on is required"))
+		return
+	}
+
+	target.VerifyVeteran(models.VerificationMethodManual, req.Authority, &actor.ID, time.Now())
+	if err := h.Store.UpdateUser(target); err != nil {
+		h.internalError(w, r, "Could not verify veteran status", err)
+		return
+	}
+
+	h.audit(r, actor, models.AuditUserVeteranVerified, target, map[string]string{"authority": req.Authority})
+	utils.SendJSONResponse(w, http.StatusOK, newUserView(target))
+}
+
+// UnverifyVeteranHandler revokes a user's verified veteran status, however it was verified.
+// A status verified by an identity provider is verified again at the user's next login through it.
+func (h *Handler) UnverifyVeteranHandler(w http.ResponseWriter, r *http.Request) {
+	actor, target, ok := h.loadTarget(w, r)
+	if !ok {
+		return
+	}
+
+	previous := target.VeteranVerification
+	target.RevokeVeteranVerification()
+	if err := h.Store.UpdateUser(target); err != nil {
+		h.internalError(w, r, "Could not revoke veteran status", err)
+		return
+	}
+
+	h.audit(r, actor, models.AuditUserVeteranUnverified, target, map[string]string{"method": previous.Method, "authority": previous.Authority})
+	utils.SendJSONResponse(w, http.StatusOK, newUserView(target))
+}
+
+// StatsHandler returns moderation statistics. The reporting window can be set with
+// the "window" query parameter as a duration, e.g. "168h"; it defaults to 24 hours.
+func (h *Handler) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	window := statsWindow
+	if value := r.URL.Query().Get("window"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid window"))
+			return
+		}
+		window = parsed
+	}
+
+	stats, err := h.Store.GetModerationStats(time.Now().Add(-window))
+	if err != nil {
+		h.internalError(w, r, "Could not load moderation stats", err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, stats)
+}
+
+// AuditLogHandler lists audit log entries, newest first.
+func (h *Handler) AuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	page, perPage := pagination(r)
+	entries, total, err := h.Store.ListAuditLogEntries((page-1)*perPage, perPage)
+	if err != nil {
+		h.internalError(w, r, "Could not list audit log", err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"entries":  entries,
+		"total":    total,
+		"page":     page,
+		"per_page": perPage,
+	})
+}
+
+// loadTarget loads the acting user and the user identified by the {id} route variable,
+// and checks that the actor may act on the target: nobody may act on themselves, and
+// only administrators may act on moderators and administrators.
+// It writes an error response and returns false if the action is not allowed.
+func (h *Handler) loadTarget(w http.ResponseWriter, r *http.Request) (*models.User, *models.User, bool) {
+	actor, ok := middleware.CurrentUser(r)
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
+		return nil, nil, false
+	}
+
+	target, err := h.Store.GetUserByID(mux.Vars(r)["id"])
+	if err == gorm.ErrRecordNotFound || (err == nil && target == nil) {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusNotFound, "User not found"))
+		return nil, nil, false
+	}
+	if err != nil {
+		h.internalError(w, r, "Could not load user", err)
+		return nil, nil, false
+	}
+
+	if target.ID == actor.ID {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusForbidden, "You cannot perform this action on your own account"))
+		return nil, nil, false
+	}
+	if target.HasRole(models.RoleModerator) && !actor.HasRole(models.RoleAdmin) {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusForbidden, "Forbidden"))
+		return nil, nil, false
+	}
+
+	return actor, target, true
+}
+
+// revokeSessions revokes all of the user's sessions. Failures are logged, since
+// the change that prompted the revocation has already been stored.
+func (h *Handler) revokeSessions(r *http.Request, user *models.User) {
+	if h.Revocations == nil {
+		return
+	}
+	if err := h.Revocations.RevokeUserTokens(r.Context(), user.Username); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"user": user.Username,
+		}).Errorf("Could not revoke sessions: %v", err)
+	}
+}
+
+// audit records an admin action in the audit log.
+func (h *Handler) audit(r *http.Request, actor *models.User, action string, target *models.User, details map[string]string) {
+	entry := &models.AuditLogEntry{
+		ActorID:    actor.ID,
+		Action:     action,
+		TargetType: "user",
+		TargetID:   target.ID,
+		Details:    details,
+		IP:         h.clientIP(r),
+	}
+	if err := h.Store.CreateAuditLogEntry(entry); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"method": r.Method,
+			"url":    r.URL.String(),
+			"ip":     entry.IP,
+			"actor":  actor.Username,
+			"action": action,
+			"target": target.ID,
+		}).Errorf("Could not write audit log entry: %v", err)
+	}
+}
+
+// clientIP returns the address of the client that made the request. Without a resolver,
+// no proxy is trusted and the address of the peer is used.
+func (h *Handler) clientIP(r *http.Request) string {
+	resolver := h.ClientIP
+	if resolver == nil {
+		resolver = &middleware.ClientIPResolver{}
+	}
+	return resolver.ClientIP(r)
+}
+
+// internalError logs an error and responds with a 500 Internal Server Error.
+func (h *Handler) internalError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	errors.Respond(w, r, errors.Wrap(err, http.StatusInternalServerError, errors.CodeInternal, message))
+}
+
+// pagination reads the "page" and "per_page" query parameters, applying defaults and limits.
+func pagination(r *http.Request) (int, int) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil || perPage < 1 {
+		perPage = defaultPerPage
+	}
+	if perPage > maxPerPage {
+		perPage = maxPerPage
+	}
+	return page, perPage
+}
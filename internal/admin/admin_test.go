@@ -0,0 +1,275 @@
+package admin_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/pageza/chat-app/internal/admin"
+	"github.com/pageza/chat-app/internal/memory"
+	"github.com/pageza/chat-app/internal/middleware"
+	"github.com/pageza/chat-app/internal/models"
+	redisI "github.com/pageza/chat-app/internal/redis"
+)
+
+// memoryStore is an in-memory implementation of admin.Store.
+type memoryStore struct {
+	users map[uint]*models.User
+	audit []models.AuditLogEntry
+}
+
+func (s *memoryStore) GetUserByID(userID string) (*models.User, error) {
+	id, _ := strconv.ParseUint(userID, 10, 64)
+	if user, ok := s.users[uint(id)]; ok {
+		copied := *user
+		return &copied, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) UpdateUser(user *models.User) error {
+	copied := *user
+	s.users[user.ID] = &copied
+	return nil
+}
+
+func (s *memoryStore) ListUsers(search string, offset, limit int) ([]models.User, int64, error) {
+	var users []models.User
+	for _, user := range s.users {
+		users = append(users, *user)
+	}
+	return users, int64(len(users)), nil
+}
+
+func (s *memoryStore) CreateAuditLogEntry(entry *models.AuditLogEntry) error {
+	entry.ID = uint(len(s.audit) + 1)
+	s.audit = append(s.audit, *entry)
+	return nil
+}
+
+func (s *memoryStore) ListAuditLogEntries(offset, limit int) ([]models.AuditLogEntry, int64, error) {
+	return s.audit, int64(len(s.audit)), nil
+}
+
+func (s *memoryStore) GetModerationStats(since time.Time) (*models.ModerationStats, error) {
+	return &models.ModerationStats{TotalUsers: int64(len(s.users))}, nil
+}
+
+// Users of every role, keyed by ID.
+const (
+	userID      = 1
+	otherUserID = 2
+	moderatorID = 3
+	otherModID  = 4
+	adminID     = 5
+	otherAdmin  = 6
+)
+
+// newTestHandler returns a handler with a user, two moderators and two administrators,
+// trusting the proxy at 10.0.0.1.
+func newTestHandler(t *testing.T) (*admin.Handler, *memoryStore, redisI.RevocationStore) {
+	store := &memoryStore{users: map[uint]*models.User{
+		userID:      {ID: userID, Username: "user", Role: models.RoleUser},
+		otherUserID: {ID: otherUserID, Username: "other-user"},
+		moderatorID: {ID: moderatorID, Username: "moderator", Role: models.RoleModerator},
+		otherModID:  {ID: otherModID, Username: "other-moderator", Role: models.RoleModerator},
+		adminID:     {ID: adminID, Username: "admin", Role: models.RoleAdmin},
+		otherAdmin:  {ID: otherAdmin, Username: "other-admin", Role: models.RoleAdmin},
+	}}
+	resolver, err := middleware.NewClientIPResolver([]string{"10.0.0.1"})
+	require.NoError(t, err)
+	revocations := &redisI.Revocations{Client: memory.NewClient()}
+	return &admin.Handler{Store: store, Revocations: revocations, ClientIP: resolver}, store, revocations
+}
+
+// serve calls handler as the given actor, on the user identified by target, through the trusted proxy.
+func serve(handler http.HandlerFunc, store *memoryStore, actor uint, target uint, body interface{}) *httptest.ResponseRecorder {
+	payload, _ := json.Marshal(body)
+	req := httptest.NewRequest("POST", "/admin/users/"+strconv.Itoa(int(target)), bytes.NewReader(payload))
+	req.RemoteAddr = "10.0.0.1:41000"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	req = mux.SetURLVars(req, map[string]string{"id": strconv.Itoa(int(target))})
+	if user, ok := store.users[actor]; ok {
+		req = req.WithContext(middleware.WithPrincipal(req.Context(), &middleware.Principal{User: user}))
+	}
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	return rr
+}
+
+// sessionsRevoked reports whether the sessions the user had before the request were revoked.
+func sessionsRevoked(t *testing.T, revocations redisI.RevocationStore, username string) bool {
+	revoked, err := revocations.IsTokenRevoked(context.Background(), "session", username, time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	return revoked
+}
+
+func TestPermissions(t *testing.T) {
+	// Each handler wrapped in the role middleware its route uses
+	routes := map[string]func(h *admin.Handler) http.HandlerFunc{
+		"suspend":   func(h *admin.Handler) http.HandlerFunc { return middleware.RequireModerator(h.SuspendUserHandler) },
+		"unsuspend": func(h *admin.Handler) http.HandlerFunc { return middleware.RequireModerator(h.UnsuspendUserHandler) },
+		"logout":    func(h *admin.Handler) http.HandlerFunc { return middleware.RequireModerator(h.LogoutUserHandler) },
+		"set role":  func(h *admin.Handler) http.HandlerFunc { return middleware.RequireAdmin(h.SetRoleHandler) },
+	}
+	adminOnly := map[string]bool{"set role": true}
+	body := map[string]string{"reason": "spam", "role": models.RoleModerator}
+
+	tests := []struct {
+		name          string
+		actor, target uint
+		moderatorWant int
+		adminWant     int
+	}{
+		{"Users cannot moderate", userID, otherUserID, http.StatusForbidden, http.StatusForbidden},
+		{"Moderators can act on users", moderatorID, userID, http.StatusOK, http.StatusForbidden},
+		{"Moderators cannot act on moderators", moderatorID, otherModID, http.StatusForbidden, http.StatusForbidden},
+		{"Moderators cannot act on administrators", moderatorID, adminID, http.StatusForbidden, http.StatusForbidden},
+		{"Moderators cannot act on themselves", moderatorID, moderatorID, http.StatusForbidden, http.StatusForbidden},
+		{"Administrators can act on moderators", adminID, moderatorID, http.StatusOK, http.StatusOK},
+		{"Administrators can act on administrators", adminID, otherAdmin, http.StatusOK, http.StatusOK},
+		{"Administrators cannot act on themselves", adminID, adminID, http.StatusForbidden, http.StatusForbidden},
+		{"Unknown users are not found", adminID, 99, http.StatusNotFound, http.StatusNotFound},
+		{"Anonymous requests are rejected", 0, userID, http.StatusUnauthorized, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for action, route := range routes {
+				// A fresh store for every action, so that one action does not affect the next
+				handler, store, _ := newTestHandler(t)
+				want := tt.moderatorWant
+				if adminOnly[action] {
+					want = tt.adminWant
+				}
+				assert.Equal(t, want, serve(route(handler), store, tt.actor, tt.target, body).Code, action)
+			}
+		})
+	}
+}
+
+func TestSuspendUserHandler(t *testing.T) {
+	handler, store, revocations := newTestHandler(t)
+
+	rr := serve(handler.SuspendUserHandler, store, moderatorID, userID, map[string]string{"reason": "spam"})
+	require.Equal(t, http.StatusOK, rr.Code)
+	var view admin.UserView
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &view))
+	assert.NotNil(t, view.SuspendedAt)
+	assert.Equal(t, "spam", view.SuspendedReason)
+
+	assert.True(t, store.users[userID].IsSuspended())
+	assert.Equal(t, "spam", store.users[userID].SuspendedReason)
+	assert.True(t, sessionsRevoked(t, revocations, "user"), "the user's sessions should be revoked")
+	assert.False(t, sessionsRevoked(t, revocations, "other-user"))
+
+	require.Len(t, store.audit, 1)
+	assert.Equal(t, models.AuditLogEntry{
+		ID:         1,
+		ActorID:    moderatorID,
+		Action:     models.AuditUserSuspended,
+		TargetType: "user",
+		TargetID:   userID,
+		Details:    map[string]string{"reason": "spam"},
+		IP:         "203.0.113.7",
+	}, store.audit[0])
+
+	t.Run("Suspensions can be lifted", func(t *testing.T) {
+		rr := serve(handler.UnsuspendUserHandler, store, moderatorID, userID, nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.False(t, store.users[userID].IsSuspended())
+		assert.Empty(t, store.users[userID].SuspendedReason)
+		require.Len(t, store.audit, 2)
+		assert.Equal(t, models.AuditUserUnsuspended, store.audit[1].Action)
+	})
+
+	t.Run("Malformed payloads change nothing", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/admin/users/2/suspend", bytes.NewBufferString("{"))
+		req = mux.SetURLVars(req, map[string]string{"id": "2"})
+		req = req.WithContext(middleware.WithPrincipal(req.Context(), &middleware.Principal{User: store.users[moderatorID]}))
+		rr := httptest.NewRecorder()
+		handler.SuspendUserHandler(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.False(t, store.users[otherUserID].IsSuspended())
+		assert.Len(t, store.audit, 2)
+	})
+}
+
+func TestSetRoleHandler(t *testing.T) {
+	handler, store, revocations := newTestHandler(t)
+
+	rr := serve(handler.SetRoleHandler, store, adminID, userID, map[string]string{"role": models.RoleModerator})
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, models.RoleModerator, store.users[userID].Role)
+	assert.True(t, sessionsRevoked(t, revocations, "user"), "new tokens should carry the new role")
+
+	require.Len(t, store.audit, 1)
+	entry := store.audit[0]
+	assert.Equal(t, models.AuditUserRoleChanged, entry.Action)
+	assert.Equal(t, uint(adminID), entry.ActorID)
+	assert.Equal(t, uint(userID), entry.TargetID)
+	assert.Equal(t, map[string]string{"from": models.RoleUser, "to": models.RoleModerator}, entry.Details)
+	assert.Equal(t, "203.0.113.7", entry.IP)
+
+	t.Run("Unknown roles are rejected", func(t *testing.T) {
+		rr := serve(handler.SetRoleHandler, store, adminID, otherUserID, map[string]string{"role": "owner"})
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Empty(t, store.users[otherUserID].Role)
+		assert.Len(t, store.audit, 1)
+	})
+}
+
+func TestAuditLog(t *testing.T) {
+	handler, store, _ := newTestHandler(t)
+
+	t.Run("Untrusted peers cannot choose the recorded address", func(t *testing.T) {
+		payload, _ := json.Marshal(map[string]string{"reason": "spam"})
+		req := httptest.NewRequest("POST", "/admin/users/1/suspend", bytes.NewReader(payload))
+		req.RemoteAddr = "198.51.100.9:52000"
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		req = mux.SetURLVars(req, map[string]string{"id": "1"})
+		req = req.WithContext(middleware.WithPrincipal(req.Context(), &middleware.Principal{User: store.users[adminID]}))
+		rr := httptest.NewRecorder()
+		handler.SuspendUserHandler(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Len(t, store.audit, 1)
+		assert.Equal(t, "198.51.100.9", store.audit[0].IP)
+	})
+
+	t.Run("Forced logouts are recorded", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, serve(handler.LogoutUserHandler, store, adminID, moderatorID, nil).Code)
+		require.Len(t, store.audit, 2)
+		assert.Equal(t, models.AuditUserLoggedOut, store.audit[1].Action)
+		assert.Equal(t, uint(moderatorID), store.audit[1].TargetID)
+	})
+
+	t.Run("Refused actions are not recorded", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, serve(handler.SuspendUserHandler, store, moderatorID, adminID, nil).Code)
+		assert.Len(t, store.audit, 2)
+	})
+
+	t.Run("Entries can be listed", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/admin/audit-log?per_page=500", nil)
+		rr := httptest.NewRecorder()
+		handler.AuditLogHandler(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var page struct {
+			Entries []models.AuditLogEntry `json:"entries"`
+			Total   int64                  `json:"total"`
+			PerPage int                    `json:"per_page"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+		assert.Len(t, page.Entries, 2)
+		assert.Equal(t, int64(2), page.Total)
+		assert.Equal(t, 100, page.PerPage)
+	})
+}
@@ -0,0 +1,159 @@
+// Package auth provides authentication handlers for the chat application.
+// This file specifically includes the handlers for managing personal API tokens.
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pageza/chat-app/internal/errors"
+	"github.com/pageza/chat-app/internal/models"
+	"github.com/pageza/chat-app/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// maxAPITokensPerUser limits how many personal API tokens a user can have.
+const maxAPITokensPerUser = 25
+
+// APITokenStore is an interface for persisting personal API tokens.
+type APITokenStore interface {
+	CreateAPIToken(token *models.APIToken) error
+	ListAPITokens(userID uint) ([]models.APIToken, error)
+	DeleteAPIToken(userID, tokenID uint) error
+}
+
+// CreateAPITokenRequest is the payload for creating a personal API token.
+type CreateAPITokenRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// CreateAPITokenResponse contains the new token. This is the only time the token itself is returned.
+type CreateAPITokenResponse struct {
+	models.APIToken
+	Token string `json:"token"`
+}
+
+// CreateAPITokenHandler creates a named, scoped personal API token for the logged-in user.
+func (a *AuthHandler) CreateAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.userFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req CreateAPITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid request payload"))
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		errors.RespondWithError(w, errors.Validation(errors.FieldError{Field: "name", Message: "name must be between 1 and 100 characters"}))
+		return
+	}
+	if len(req.Scopes) == 0 {
+		errors.RespondWithError(w, errors.Validation(errors.FieldError{Field: "scopes", Message: "at least one scope is required"}))
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !models.IsValidScope(scope) {
+			errors.RespondWithError(w, errors.Validation(errors.FieldError{Field: "scopes", Message: "unknown scope: " + scope}))
+			return
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		errors.RespondWithError(w, errors.Validation(errors.FieldError{Field: "expires_at", Message: "expires_at must be in the future"}))
+		return
+	}
+
+	existing, err := a.APITokens.ListAPITokens(user.ID)
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not create API token"))
+		return
+	}
+	if len(existing) >= maxAPITokensPerUser {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusConflict, "Too many API tokens, revoke one first"))
+		return
+	}
+
+	token, err := generateAPIToken()
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not create API token"))
+		return
+	}
+
+	stored := models.APIToken{
+		UserID:    user.ID,
+		Name:      req.Name,
+		Hint:      token[len(token)-4:],
+		TokenHash: models.HashAPIToken(token),
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := a.APITokens.CreateAPIToken(&stored); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"method": r.Method,
+			"url":    r.URL.String(),
+			"ip":     r.RemoteAddr,
+			"user":   user.Username,
+		}).Errorf("Could not store API token: %v", err)
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not create API token"))
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusCreated, CreateAPITokenResponse{APIToken: stored, Token: token})
+}
+
+// ListAPITokensHandler lists the logged-in user's personal API tokens, without the tokens themselves.
+func (a *AuthHandler) ListAPITokensHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.userFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	tokens, err := a.APITokens.ListAPITokens(user.ID)
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not list API tokens"))
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, tokens)
+}
+
+// RevokeAPITokenHandler revokes one of the logged-in user's personal API tokens.
+func (a *AuthHandler) RevokeAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.userFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid API token ID"))
+		return
+	}
+
+	if err := a.APITokens.DeleteAPIToken(user.ID, uint(id)); err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusNotFound, "API token not found"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// generateAPIToken returns a new random personal API token.
+func generateAPIToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return models.APITokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
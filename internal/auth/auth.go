@@ -7,13 +7,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/go-redis/redis/v8"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/pageza/chat-app/internal/errors"
 	jwtI "github.com/pageza/chat-app/internal/jwt"
+	"github.com/pageza/chat-app/internal/mailer"
+	"github.com/pageza/chat-app/internal/middleware"
 	"github.com/pageza/chat-app/internal/models"
 	redisI "github.com/pageza/chat-app/internal/redis"
 	"github.com/pageza/chat-app/internal/utils"
@@ -23,7 +25,17 @@ import (
 
 type AuthHandler struct {
 	DB         database.Database
-	JwtManager jwtI.JwtManager // Add this line
+	JwtManager *jwtI.JwtManager
+	Redis      redisI.Client // Used to track and revoke sessions and password reset tokens
+	Mailer     mailer.Mailer // Used to send password reset emails
+
+	PasswordResetTTL time.Duration // How long password reset links stay valid
+	PasswordResetURL string        // Page of the client that password reset links point to, optional
+	TOTPIssuer       string        // Issuer shown in authenticator apps
+
+	WebAuthn    *webauthn.WebAuthn // WebAuthn relying party, nil when passkey login is disabled
+	Credentials CredentialStore    // Stores users' WebAuthn credentials
+	APITokens   APITokenStore      // Stores users' personal API tokens
 }
 
 // RedisClient is an interface representing the methods of the Redis client
@@ -33,10 +45,18 @@ type RedisClient interface {
 	// Add other methods as needed
 }
 
+// RegisterRequest is the payload of a registration. The role and moderation state of
+// new accounts are always set by the server, never by the client.
+type RegisterRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
 func (a *AuthHandler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Println("Entering RegisterHandler")
-	var user models.User
-	err := json.NewDecoder(r.Body).Decode(&user)
+	var req RegisterRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"method": r.Method,
@@ -47,6 +67,13 @@ func (a *AuthHandler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	user := models.User{
+		Username:    req.Username,
+		Email:       req.Email,
+		Password:    req.Password,
+		Role:        models.RoleUser,
+		SuspendedAt: nil,
+	}
 	err = a.DB.CreateUser(&user)
 	fmt.Println("Debug: CreateUser error:", err)
 
@@ -60,20 +87,10 @@ func (a *AuthHandler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	accessToken, refreshToken, err := a.JwtManager.GenerateToken(user)
-	if err != nil {
+	if _, err := a.IssueSession(w, r, user); err != nil {
 		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not log in"))
 		return
 	}
-	a.JwtManager.SetTokenCookie(w, accessToken)
-
-	http.SetCookie(w, &http.Cookie{
-		Name:     "refresh_token",
-		Value:    refreshToken,
-		HttpOnly: true,
-		Secure:   true,
-		Path:     "/",
-	})
 
 	w.WriteHeader(http.StatusCreated)
 	fmt.Fprintf(w, "User successfully registered and logged in")
@@ -119,30 +136,29 @@ func (a *AuthHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fmt.Println("Debug: About to call GenerateToken") // Debug print
-	fmt.Printf("Debug: dbUser type: %T, content: %+v\n", dbUser, dbUser)
-
-	accessToken, refreshToken, err := a.JwtManager.GenerateToken(*dbUser)
+	if dbUser.IsSuspended() {
+		logrus.WithFields(logrus.Fields{
+			"method": r.Method,
+			"url":    r.URL.String(),
+			"ip":     r.RemoteAddr,
+			"user":   dbUser.Username,
+		}).Warn("Login attempt by suspended user")
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusForbidden, "Account suspended"))
+		return
+	}
 
-	fmt.Println("Debug: GenerateToken called") // Debug print
+	// Users with two-factor authentication must complete a second step at /login/mfa
+	if dbUser.TOTPEnabled {
+		a.respondMFARequired(w, r, dbUser)
+		return
+	}
 
+	accessToken, err := a.IssueSession(w, r, *dbUser)
 	if err != nil {
-		fmt.Println("Debug: Entering error block")      // Debug print
-		fmt.Println("Debug: GenerateToken error:", err) // Debug print
 		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not log in"))
 		return
 	}
 
-	a.JwtManager.SetTokenCookie(w, accessToken)
-
-	http.SetCookie(w, &http.Cookie{
-		Name:     "refresh_token",
-		Value:    refreshToken,
-		HttpOnly: true,
-		Secure:   true,
-		Path:     "/",
-	})
-
 	jsonResponse := map[string]string{"token": accessToken}
 	utils.SendJSONResponse(w, http.StatusOK, jsonResponse)
 }
@@ -159,10 +175,9 @@ func (a *AuthHandler) LogoutHandler(w http.ResponseWriter, r *http.Request, redi
 		return
 	}
 
-	// Extract and validate the JWT token from the Authorization header
-	tokenString := r.Header.Get("Authorization")
-	actualToken := strings.TrimPrefix(tokenString, "Bearer ")
-	if actualToken == "" || actualToken == tokenString {
+	// Extract the JWT token from the Authorization header or the token cookie
+	actualToken := middleware.TokenFromRequest(r)
+	if actualToken == "" {
 		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Invalid credentials"))
 		return
 	}
@@ -180,11 +195,16 @@ func (a *AuthHandler) LogoutHandler(w http.ResponseWriter, r *http.Request, redi
 		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Invalid credentials"))
 		return
 	}
+	tokenID, _ := claims["jti"].(string)
+	if tokenID == "" {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Invalid credentials"))
+		return
+	}
 	expirationTime := int64(claims["exp"].(float64))
 	const maxRetries = 3
 	var currentRetry = 0
 	for currentRetry < maxRetries {
-		err = redisI.BlacklistToken(context.TODO(), redisClient, actualToken, expirationTime)
+		err = redisI.BlacklistToken(context.TODO(), redisClient, tokenID, expirationTime)
 		if err == nil {
 			break
 		}
@@ -201,8 +221,81 @@ func (a *AuthHandler) LogoutHandler(w http.ResponseWriter, r *http.Request, redi
 		}).Warnf("Failed to blacklist token after %d retries", maxRetries)
 	}
 
+	// The refresh token issued with the session is revoked as well
+	a.revokeRefreshToken(r, redisClient)
+
 	// Clear the JWT cookie and send a success response
 	a.JwtManager.ClearTokenCookie(w)
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintf(w, "Logged out successfully")
 }
+
+// revokeRefreshToken blacklists the refresh token from the request's refresh_token cookie, if any.
+func (a *AuthHandler) revokeRefreshToken(r *http.Request, redisClient RedisClient) {
+	cookie, err := r.Cookie("refresh_token")
+	if err != nil {
+		return
+	}
+	token, err := a.JwtManager.ParseToken(cookie.Value)
+	if err != nil || !token.Valid {
+		return
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return
+	}
+	tokenID, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	if tokenID == "" {
+		return
+	}
+	if err := redisI.BlacklistToken(r.Context(), redisClient, tokenID, int64(exp)); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"method": r.Method,
+			"url":    r.URL.String(),
+			"ip":     r.RemoteAddr,
+		}).Warnf("Failed to blacklist refresh token: %v", err)
+	}
+}
+
+// LogoutAllHandler ends all of the logged-in user's sessions on every device.
+func (a *AuthHandler) LogoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.userFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := redisI.RevokeUserTokens(r.Context(), a.Redis, user.Username); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"method": r.Method,
+			"url":    r.URL.String(),
+			"ip":     r.RemoteAddr,
+			"user":   user.Username,
+		}).Errorf("Could not revoke sessions: %v", err)
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not log out"))
+		return
+	}
+
+	a.JwtManager.ClearTokenCookie(w)
+	utils.SendJSONResponse(w, http.StatusOK, map[string]string{"message": "Logged out of all sessions"})
+}
+
+// IssueSession generates an access/refresh token pair for the user, records them as a session
+// and sets them as cookies. It returns the access token so that it can also be sent in the response body.
+// Suspended users are refused with models.ErrAccountSuspended.
+func (a *AuthHandler) IssueSession(w http.ResponseWriter, r *http.Request, user models.User) (string, error) {
+	if user.IsSuspended() {
+		return "", models.ErrAccountSuspended
+	}
+
+	accessToken, refreshToken, err := a.JwtManager.GenerateToken(user)
+	if err != nil {
+		return "", err
+	}
+
+	a.JwtManager.SetTokenCookie(w, accessToken)
+
+	http.SetCookie(w, a.JwtManager.SessionCookie("refresh_token", refreshToken))
+
+	return accessToken, nil
+}
@@ -2,7 +2,6 @@ package auth_test
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,7 +10,6 @@ import (
 	"testing"
 	"time"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"golang.org/x/crypto/bcrypt"
@@ -19,6 +17,7 @@ import (
 
 	"github.com/pageza/chat-app/internal/auth"
 	"github.com/pageza/chat-app/internal/config"
+	"github.com/pageza/chat-app/internal/jwt"
 	"github.com/pageza/chat-app/internal/models"
 	"github.com/pageza/chat-app/internal/utils"
 )
@@ -84,44 +83,33 @@ func (m *MockDatabase) GetUserByID(userID string) (*models.User, error) {
 	return user, args.Error(1)
 }
 
-type RedisClient interface {
-	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd
-}
-
-type MockRedisClient struct {
-	mock.Mock
+func (m *MockDatabase) GetUserByEmail(email string) (*models.User, error) {
+	args := m.Called(email)
+	user, ok := args.Get(0).(*models.User)
+	if !ok {
+		return nil, args.Error(1)
+	}
+	return user, args.Error(1)
 }
 
-func (m *MockRedisClient) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
-	args := m.Called(ctx, key, value, expiration)
-	return args.Get(0).(*redis.StatusCmd)
+func (m *MockDatabase) UpdateUser(user *models.User) error {
+	args := m.Called(user)
+	return args.Error(0)
 }
 
 func TestHandlers(t *testing.T) {
 	mockDB := new(MockDatabase)
-	mockJwt := new(MockJwt)
-	mockRedisClient := new(MockRedisClient)
-
-	mockDB.On("InitializeDB").Return(new(gorm.DB), nil)
-	mockDB.On("AutoMigrateDB").Return(nil)
 	mockDB.On("CreateUser", mock.AnythingOfType("*models.User")).Return(nil)
 	mockDB.On("GetUserByUsername", mock.AnythingOfType("string")).Return(new(models.User), nil)
-	mockDB.On("UpdateLastLoginTime", mock.AnythingOfType("*models.User")).Return(nil)
-	mockDB.On("HandleFailedLoginAttempt", mock.AnythingOfType("*models.User")).Return(nil)
-	mockDB.On("Where", mock.Anything, mock.Anything).Return(new(gorm.DB))
 
-	mockRedisClient.On("Set", mock.Anything, mock.Anything, mock.Anything, mock.AnythingOfType("time.Duration")).Return(new(redis.StatusCmd))
-
-	authHandler := &auth.AuthHandler{
-		DB: mockDB,
-	}
+	authHandler, client, _ := newTestAuthHandler(t, mockDB)
 
 	t.Run("Test LogoutHandler", func(t *testing.T) {
 		user := models.User{
 			Username: "testuser",
 		}
 
-		accessToken, _, err := mockJwt.GenerateToken(user)
+		accessToken, _, err := authHandler.JwtManager.GenerateToken(user)
 		if err != nil {
 			t.Fatalf("Could not generate token: %v", err)
 		}
@@ -131,21 +119,19 @@ func TestHandlers(t *testing.T) {
 
 		req.Header.Set("Authorization", "Bearer "+accessToken)
 
-		authHandler.LogoutHandler(rr, req, mockRedisClient)
+		authHandler.LogoutHandler(rr, req, client)
 
 		assert.Equal(t, http.StatusOK, rr.Code)
 		assert.Contains(t, rr.Body.String(), "Logged out successfully")
 	})
 
 	t.Run("Test RegisterHandler", func(t *testing.T) {
-		// Create a user object
-		user := models.User{
+		// Create a registration payload
+		payload, _ := json.Marshal(auth.RegisterRequest{
 			Username: "newuser",
+			Email:    "new@example.com",
 			Password: "newpassword",
-		}
-
-		// Convert user object to JSON
-		payload, _ := json.Marshal(user)
+		})
 
 		// Create a new request
 		req, _ := http.NewRequest("POST", "/register", bytes.NewBuffer(payload))
@@ -156,17 +142,43 @@ func TestHandlers(t *testing.T) {
 
 		// Check the status code and the response
 		assert.Equal(t, http.StatusCreated, rr.Code)
-		// Add more assertions based on your requirements
+	})
+
+	t.Run("Clients cannot choose their role or suspension", func(t *testing.T) {
+		db := new(MockDatabase)
+		var created *models.User
+		db.On("CreateUser", mock.AnythingOfType("*models.User")).Run(func(args mock.Arguments) {
+			created = args.Get(0).(*models.User)
+		}).Return(nil)
+		handler, _, _ := newTestAuthHandler(t, db)
+
+		payload := `{"username": "mallory", "email": "mallory@example.com", "password": "Passw0rd!",` +
+			` "role": "admin", "Role": "admin", "suspended_at": null, "SuspendedReason": "none"}`
+		req, _ := http.NewRequest("POST", "/register", bytes.NewBufferString(payload))
+		rr := httptest.NewRecorder()
+		handler.RegisterHandler(rr, req)
+
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		if assert.NotNil(t, created) {
+			assert.Equal(t, "mallory", created.Username)
+			assert.Equal(t, models.RoleUser, created.Role)
+			assert.Nil(t, created.SuspendedAt)
+			assert.Empty(t, created.SuspendedReason)
+		}
 	})
 }
 
 func TestLoginHandler(t *testing.T) {
-	config.Initialize()
 	// Initialize dependencies and mock objects
 	dbMock := new(MockDatabase)
 	jwtMock := new(MockJwt)
+	keys, err := jwt.NewKeySet("HS256", []byte("secret"), 0)
+	if err != nil {
+		t.Fatalf("Could not create the signing keys: %v", err)
+	}
 	authHandler := &auth.AuthHandler{
-		DB: dbMock,
+		DB:         dbMock,
+		JwtManager: jwt.NewJwtManager(keys, config.JWTConfig{Issuer: "chat-app", TokenExpiration: time.Hour}, config.Default().Cookies),
 	}
 
 	// Define specific errors for use in tests
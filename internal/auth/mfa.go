@@ -0,0 +1,354 @@
+// Package auth provides authentication handlers for the chat application.
+// This file specifically includes the TOTP two-factor authentication handlers.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pageza/chat-app/internal/errors"
+	jwtI "github.com/pageza/chat-app/internal/jwt"
+	"github.com/pageza/chat-app/internal/middleware"
+	"github.com/pageza/chat-app/internal/models"
+	redisI "github.com/pageza/chat-app/internal/redis"
+	"github.com/pageza/chat-app/internal/totp"
+	"github.com/pageza/chat-app/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// recoveryCodeCount is the number of recovery codes generated when TOTP is enabled.
+const recoveryCodeCount = 10
+
+// maxMFAAttempts is the number of invalid codes accepted per "MFA pending" token.
+// After that, the token is revoked and the user has to log in with their password again.
+const maxMFAAttempts = 5
+
+// TOTPCodeRequest is the payload for confirming TOTP enrollment.
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// DisableTOTPRequest is the payload for disabling TOTP.
+// It requires the current password and either a TOTP code or a recovery code.
+type DisableTOTPRequest struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// LoginMFARequest is the payload for the second login step.
+// Either a TOTP code or a recovery code must be provided.
+type LoginMFARequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// respondMFARequired responds to a successful password check for a user with 2FA enabled.
+// Instead of access and refresh tokens, it returns a short-lived "MFA pending" token.
+func (a *AuthHandler) respondMFARequired(w http.ResponseWriter, r *http.Request, user *models.User) {
+	mfaToken, err := a.JwtManager.GenerateMFAToken(*user)
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not log in"))
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"mfa_required": true,
+		"mfa_token":    mfaToken,
+	})
+}
+
+// IssueMFAToken returns an "MFA pending" token for a user with 2FA enabled, to be exchanged for a session
+// at /login/mfa. It lets login methods other than passwords, such as OIDC, require the second factor.
+func (a *AuthHandler) IssueMFAToken(user models.User) (string, error) {
+	return a.JwtManager.GenerateMFAToken(user)
+}
+
+// LoginMFAHandler completes a login for a user with 2FA enabled.
+// It exchanges an "MFA pending" token and a valid TOTP or recovery code for access and refresh tokens.
+func (a *AuthHandler) LoginMFAHandler(w http.ResponseWriter, r *http.Request) {
+	var req LoginMFARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid request payload"))
+		return
+	}
+
+	pending, err := a.JwtManager.ParseMFAToken(req.MFAToken)
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Invalid or expired MFA token"))
+		return
+	}
+
+	if a.Redis == nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Internal server error"))
+		return
+	}
+
+	// MFA pending tokens are revoked along with the user's sessions
+	revoked, err := redisI.IsTokenRevoked(r.Context(), a.Redis, pending.TokenID, pending.Username, pending.IssuedAt)
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Internal server error"))
+		return
+	}
+	if revoked {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Invalid or expired MFA token"))
+		return
+	}
+
+	// Claim the token before checking the code, so that concurrent requests with the same token cannot
+	// both log in or get around the attempt limit. A successful login keeps the claim, so the token can
+	// only be used once; an invalid code releases it while attempts remain.
+	claimed, err := redisI.ClaimToken(r.Context(), a.Redis, pending.TokenID, pending.ExpiresAt)
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Internal server error"))
+		return
+	}
+	if !claimed {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Invalid or expired MFA token"))
+		return
+	}
+
+	user, err := a.DB.GetUserByUsername(pending.Username)
+	if err != nil || user == nil || !user.TOTPEnabled {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Invalid or expired MFA token"))
+		return
+	}
+
+	if !a.verifySecondFactor(user, req.Code, req.RecoveryCode) {
+		logrus.WithFields(logrus.Fields{
+			"method": r.Method,
+			"url":    r.URL.String(),
+			"ip":     r.RemoteAddr,
+			"user":   user.Username,
+		}).Warn("Invalid second factor")
+		a.countFailedMFAAttempt(r, pending)
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Invalid code"))
+		return
+	}
+
+	accessToken, err := a.IssueSession(w, r, *user)
+	if err == models.ErrAccountSuspended {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusForbidden, "Account suspended"))
+		return
+	}
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not log in"))
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, map[string]string{"token": accessToken})
+}
+
+// countFailedMFAAttempt records an invalid code for a claimed "MFA pending" token, and releases the
+// token for another attempt. Once maxMFAAttempts is reached, the token stays claimed, which revokes it.
+func (a *AuthHandler) countFailedMFAAttempt(r *http.Request, pending *jwtI.MFAPendingToken) {
+	attempts, err := redisI.CountFailedAttempt(r.Context(), a.Redis, pending.TokenID, pending.ExpiresAt)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"user": pending.Username,
+		}).Errorf("Could not count failed MFA attempt, revoking the MFA token: %v", err)
+		return
+	}
+	if attempts >= maxMFAAttempts {
+		return
+	}
+	if err := redisI.ReleaseToken(r.Context(), a.Redis, pending.TokenID); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"user": pending.Username,
+		}).Errorf("Could not release MFA token: %v", err)
+	}
+}
+
+// EnrollTOTPHandler starts TOTP enrollment for the logged-in user.
+// It generates a new secret and returns it along with an otpauth:// URI for QR code provisioning.
+// TOTP is not enforced until the enrollment is confirmed with a valid code.
+func (a *AuthHandler) EnrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.userFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if user.TOTPEnabled {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusConflict, "Two-factor authentication is already enabled"))
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not start enrollment"))
+		return
+	}
+
+	user.TOTPSecret = secret
+	if err := a.DB.UpdateUser(user); err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not start enrollment"))
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, map[string]string{
+		"secret":           secret,
+		"provisioning_uri": totp.ProvisioningURI(a.TOTPIssuer, user.Username, secret),
+	})
+}
+
+// ConfirmTOTPHandler enables TOTP for the logged-in user after verifying a code from the authenticator app.
+// It responds with a set of single-use recovery codes, which are only shown once.
+func (a *AuthHandler) ConfirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.userFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid request payload"))
+		return
+	}
+
+	if user.TOTPEnabled {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusConflict, "Two-factor authentication is already enabled"))
+		return
+	}
+	if user.TOTPSecret == "" {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Two-factor enrollment has not been started"))
+		return
+	}
+
+	step, valid := totp.Validate(user.TOTPSecret, req.Code, time.Now())
+	if !valid {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid code"))
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not enable two-factor authentication"))
+		return
+	}
+
+	user.TOTPEnabled = true
+	user.TOTPLastStep = step
+	user.RecoveryCodes = hashes
+	if err := a.DB.UpdateUser(user); err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not enable two-factor authentication"))
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, map[string][]string{"recovery_codes": codes})
+}
+
+// DisableTOTPHandler disables TOTP for the logged-in user.
+// It requires the current password and a valid TOTP or recovery code.
+func (a *AuthHandler) DisableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.userFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req DisableTOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid request payload"))
+		return
+	}
+
+	if !user.TOTPEnabled {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Two-factor authentication is not enabled"))
+		return
+	}
+
+	if err := utils.ValidateUser(user, req.Password); err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Invalid password"))
+		return
+	}
+
+	if !a.verifySecondFactor(user, req.Code, req.RecoveryCode) {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Invalid code"))
+		return
+	}
+
+	user.TOTPEnabled = false
+	user.TOTPSecret = ""
+	user.TOTPLastStep = 0
+	user.RecoveryCodes = nil
+	if err := a.DB.UpdateUser(user); err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not disable two-factor authentication"))
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, map[string]string{"message": "Two-factor authentication disabled"})
+}
+
+// userFromRequest loads the logged-in user from the database.
+// It writes an error response and returns false if the user cannot be determined.
+func (a *AuthHandler) userFromRequest(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	user, ok := a.CurrentUser(r)
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
+		return nil, false
+	}
+	return user, true
+}
+
+// CurrentUser returns the user authenticated by the auth middleware, if any.
+func (a *AuthHandler) CurrentUser(r *http.Request) (*models.User, bool) {
+	return middleware.CurrentUser(r)
+}
+
+// verifySecondFactor checks a TOTP code or, failing that, a recovery code.
+// Accepted TOTP time steps and used recovery codes are persisted so that they cannot be replayed.
+func (a *AuthHandler) verifySecondFactor(user *models.User, code, recoveryCode string) bool {
+	if code != "" {
+		step, valid := totp.Validate(user.TOTPSecret, code, time.Now())
+		if !valid || step <= user.TOTPLastStep {
+			return false
+		}
+		user.TOTPLastStep = step
+		return a.DB.UpdateUser(user) == nil
+	}
+
+	if recoveryCode != "" {
+		hash := hashRecoveryCode(recoveryCode)
+		for i, stored := range user.RecoveryCodes {
+			if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+				user.RecoveryCodes = append(user.RecoveryCodes[:i:i], user.RecoveryCodes[i+1:]...)
+				return a.DB.UpdateUser(user) == nil
+			}
+		}
+	}
+
+	return false
+}
+
+// generateRecoveryCodes returns a set of new recovery codes and their hashes.
+// Codes are formatted as two groups of five characters, e.g. "abcde-fghij".
+func generateRecoveryCodes() ([]string, []string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(encoding.EncodeToString(b))[:10]
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		hashes = append(hashes, hashRecoveryCode(raw))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode normalizes and hashes a recovery code so that only the hash is stored.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
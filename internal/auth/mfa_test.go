@@ -0,0 +1,148 @@
+package auth_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/pageza/chat-app/internal/auth"
+	"github.com/pageza/chat-app/internal/models"
+	"github.com/pageza/chat-app/internal/totp"
+)
+
+// mfaTestUser returns a user with TOTP enabled and the recovery code "abcde-fghij".
+func mfaTestUser(t *testing.T) *models.User {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("Could not generate the TOTP secret: %v", err)
+	}
+	sum := sha256.Sum256([]byte("abcdefghij"))
+	return &models.User{
+		ID:            9,
+		Username:      "mfauser",
+		TOTPSecret:    secret,
+		TOTPEnabled:   true,
+		RecoveryCodes: []string{hex.EncodeToString(sum[:])},
+	}
+}
+
+func TestLoginMFAHandler(t *testing.T) {
+	newMFALogin := func(t *testing.T) (*auth.AuthHandler, *models.User, string) {
+		db := new(MockDatabase)
+		user := mfaTestUser(t)
+		db.On("GetUserByUsername", "mfauser").Return(user, nil)
+		db.On("UpdateUser", mock.AnythingOfType("*models.User")).Return(nil)
+		a, _, _ := newTestAuthHandler(t, db)
+
+		mfaToken, err := a.JwtManager.GenerateMFAToken(*user)
+		if err != nil {
+			t.Fatalf("Could not generate the MFA token: %v", err)
+		}
+		return a, user, mfaToken
+	}
+
+	currentCode := func(t *testing.T, user *models.User) string {
+		code, err := totp.GenerateCode(user.TOTPSecret, time.Now())
+		if err != nil {
+			t.Fatalf("Could not generate the TOTP code: %v", err)
+		}
+		return code
+	}
+
+	t.Run("Valid code", func(t *testing.T) {
+		a, user, mfaToken := newMFALogin(t)
+
+		rr := postJSON(a.LoginMFAHandler, "/login/mfa", auth.LoginMFARequest{MFAToken: mfaToken, Code: currentCode(t, user)}, nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var body map[string]string
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+		assert.NotEmpty(t, body["token"])
+	})
+
+	t.Run("Wrong code", func(t *testing.T) {
+		a, _, mfaToken := newMFALogin(t)
+
+		rr := postJSON(a.LoginMFAHandler, "/login/mfa", auth.LoginMFARequest{MFAToken: mfaToken, RecoveryCode: "wrong-codes"}, nil)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("A wrong code can be corrected", func(t *testing.T) {
+		a, user, mfaToken := newMFALogin(t)
+
+		rr := postJSON(a.LoginMFAHandler, "/login/mfa", auth.LoginMFARequest{MFAToken: mfaToken, RecoveryCode: "wrong-codes"}, nil)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		rr = postJSON(a.LoginMFAHandler, "/login/mfa", auth.LoginMFARequest{MFAToken: mfaToken, Code: currentCode(t, user)}, nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("Concurrent requests with the same token log in once", func(t *testing.T) {
+		a, user, mfaToken := newMFALogin(t)
+		code := currentCode(t, user)
+
+		var wg sync.WaitGroup
+		codes := make(chan int, 10)
+		for i := 0; i < cap(codes); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				codes <- postJSON(a.LoginMFAHandler, "/login/mfa", auth.LoginMFARequest{MFAToken: mfaToken, Code: code}, nil).Code
+			}()
+		}
+		wg.Wait()
+		close(codes)
+
+		succeeded := 0
+		for code := range codes {
+			if code == http.StatusOK {
+				succeeded++
+			} else {
+				assert.Equal(t, http.StatusUnauthorized, code)
+			}
+		}
+		assert.Equal(t, 1, succeeded)
+	})
+
+	t.Run("MFA token can only be used once", func(t *testing.T) {
+		a, user, mfaToken := newMFALogin(t)
+
+		rr := postJSON(a.LoginMFAHandler, "/login/mfa", auth.LoginMFARequest{MFAToken: mfaToken, Code: currentCode(t, user)}, nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		// A valid recovery code does not help once the token has been used
+		rr = postJSON(a.LoginMFAHandler, "/login/mfa", auth.LoginMFARequest{MFAToken: mfaToken, RecoveryCode: "abcde-fghij"}, nil)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Len(t, user.RecoveryCodes, 1, "the recovery code should not be used up")
+	})
+
+	t.Run("MFA token is revoked after too many invalid codes", func(t *testing.T) {
+		a, user, mfaToken := newMFALogin(t)
+
+		for i := 0; i < 5; i++ {
+			rr := postJSON(a.LoginMFAHandler, "/login/mfa", auth.LoginMFARequest{MFAToken: mfaToken, RecoveryCode: "wrong-codes"}, nil)
+			assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		}
+
+		rr := postJSON(a.LoginMFAHandler, "/login/mfa", auth.LoginMFARequest{MFAToken: mfaToken, Code: currentCode(t, user)}, nil)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Contains(t, rr.Body.String(), "Invalid or expired MFA token")
+	})
+
+	t.Run("Access tokens are not MFA tokens", func(t *testing.T) {
+		a, user, _ := newMFALogin(t)
+		accessToken, _, err := a.JwtManager.GenerateToken(*user)
+		if err != nil {
+			t.Fatalf("Could not generate the access token: %v", err)
+		}
+
+		rr := postJSON(a.LoginMFAHandler, "/login/mfa", auth.LoginMFARequest{MFAToken: accessToken, Code: currentCode(t, user)}, nil)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+}
@@ -0,0 +1,470 @@
+// Package auth provides authentication handlers for the chat application.
+// This file specifically includes the WebAuthn (passkey) registration and login handlers.
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gorilla/mux"
+	"github.com/pageza/chat-app/internal/config"
+	"github.com/pageza/chat-app/internal/errors"
+	"github.com/pageza/chat-app/internal/models"
+	redisI "github.com/pageza/chat-app/internal/redis"
+	"github.com/pageza/chat-app/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// webAuthnSessionTTL is how long a client has to complete a WebAuthn ceremony.
+const webAuthnSessionTTL = 5 * time.Minute
+
+// webAuthnSessionCookie holds the ID of the pending WebAuthn login ceremony.
+const webAuthnSessionCookie = "webauthn_session"
+
+// CredentialStore is an interface for persisting WebAuthn credentials.
+type CredentialStore interface {
+	ListWebAuthnCredentials(userID uint) ([]models.WebAuthnCredential, error)
+	CreateWebAuthnCredential(credential *models.WebAuthnCredential) error
+	UpdateWebAuthnCredential(credential *models.WebAuthnCredential) error
+	DeleteWebAuthnCredential(userID, credentialID uint) error
+}
+
+// PasskeyLoginRequest is the payload for starting a passkey login.
+// The username is optional; without it, the browser offers any discoverable passkey for this site.
+type PasskeyLoginRequest struct {
+	Username string `json:"username"`
+}
+
+// NewWebAuthn creates the WebAuthn relying party from the configuration.
+// It returns nil if no relying party ID is configured, in which case passkey login is disabled.
+func NewWebAuthn(cfg config.WebAuthnConfig) (*webauthn.WebAuthn, error) {
+	if cfg.RPID == "" {
+		return nil, nil
+	}
+	return webauthn.New(&webauthn.Config{
+		RPID:          cfg.RPID,
+		RPDisplayName: cfg.RPDisplayName,
+		RPOrigins:     cfg.RPOrigins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementPreferred,
+			UserVerification: protocol.VerificationPreferred,
+		},
+	})
+}
+
+// webAuthnUser adapts a models.User and its stored credentials to the webauthn.User interface.
+type webAuthnUser struct {
+	user        *models.User
+	credentials []models.WebAuthnCredential
+}
+
+// WebAuthnID returns the user handle, which is the user's ID.
+func (u *webAuthnUser) WebAuthnID() []byte {
+	return []byte(strconv.FormatUint(uint64(u.user.ID), 10))
+}
+
+// WebAuthnName returns the username.
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.user.Username
+}
+
+// WebAuthnDisplayName returns the username.
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	return u.user.Username
+}
+
+// WebAuthnIcon is deprecated in the specification and always returns an empty string.
+func (u *webAuthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+// WebAuthnCredentials converts the stored credentials to the library's representation.
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.credentials))
+	for _, c := range u.credentials {
+		transports := make([]protocol.AuthenticatorTransport, 0, len(c.Transports))
+		for _, t := range c.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+		credentials = append(credentials, webauthn.Credential{
+			ID:              c.CredentialID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: c.BackupEligible,
+				BackupState:    c.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    c.AAGUID,
+				SignCount: c.SignCount,
+			},
+		})
+	}
+	return credentials
+}
+
+// BeginPasskeyRegistrationHandler starts registering a new passkey for the logged-in user.
+// It responds with the options to pass to navigator.credentials.create().
+func (a *AuthHandler) BeginPasskeyRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.passkeysEnabled(w) {
+		return
+	}
+
+	user, ok := a.userFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	waUser, err := a.loadWebAuthnUser(user)
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not start passkey registration"))
+		return
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(waUser.credentials))
+	for _, c := range waUser.WebAuthnCredentials() {
+		exclusions = append(exclusions, c.Descriptor())
+	}
+
+	creation, session, err := a.WebAuthn.BeginRegistration(waUser, webauthn.WithExclusions(exclusions))
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not start passkey registration"))
+		return
+	}
+
+	if err := a.storeWebAuthnSession(r, registrationSessionKey(user.ID), session); err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not start passkey registration"))
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, creation)
+}
+
+// FinishPasskeyRegistrationHandler verifies the authenticator's response and stores the new passkey.
+// An optional "name" query parameter labels the passkey.
+func (a *AuthHandler) FinishPasskeyRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.passkeysEnabled(w) {
+		return
+	}
+
+	user, ok := a.userFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	session, err := a.consumeWebAuthnSession(r, registrationSessionKey(user.ID))
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Passkey registration has not been started or has expired"))
+		return
+	}
+
+	waUser, err := a.loadWebAuthnUser(user)
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not register passkey"))
+		return
+	}
+
+	credential, err := a.WebAuthn.FinishRegistration(waUser, *session, r)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"method": r.Method,
+			"url":    r.URL.String(),
+			"ip":     r.RemoteAddr,
+			"user":   user.Username,
+		}).Warnf("Passkey registration failed: %v", err)
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Could not verify passkey"))
+		return
+	}
+
+	transports := make([]string, 0, len(credential.Transport))
+	for _, t := range credential.Transport {
+		transports = append(transports, string(t))
+	}
+
+	stored := &models.WebAuthnCredential{
+		UserID:          user.ID,
+		Name:            r.URL.Query().Get("name"),
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transports:      transports,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+	}
+	if err := a.Credentials.CreateWebAuthnCredential(stored); err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not register passkey"))
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusCreated, stored)
+}
+
+// BeginPasskeyLoginHandler starts a passkey login.
+// It responds with the options to pass to navigator.credentials.get() and sets a cookie identifying the ceremony.
+func (a *AuthHandler) BeginPasskeyLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.passkeysEnabled(w) {
+		return
+	}
+
+	var req PasskeyLoginRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid request payload"))
+			return
+		}
+	}
+
+	var (
+		assertion *protocol.CredentialAssertion
+		session   *webauthn.SessionData
+		err       error
+	)
+	if req.Username != "" {
+		user, lookupErr := a.DB.GetUserByUsername(req.Username)
+		if lookupErr != nil || user == nil {
+			errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "User not found"))
+			return
+		}
+		waUser, loadErr := a.loadWebAuthnUser(user)
+		if loadErr != nil {
+			errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not start passkey login"))
+			return
+		}
+		assertion, session, err = a.WebAuthn.BeginLogin(waUser)
+	} else {
+		assertion, session, err = a.WebAuthn.BeginDiscoverableLogin()
+	}
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Could not start passkey login"))
+		return
+	}
+
+	sessionID, err := generateSessionID()
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not start passkey login"))
+		return
+	}
+	if err := a.storeWebAuthnSession(r, loginSessionKey(sessionID), session); err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not start passkey login"))
+		return
+	}
+
+	cookie := a.JwtManager.SessionCookie(webAuthnSessionCookie, sessionID)
+	cookie.MaxAge = int(webAuthnSessionTTL.Seconds())
+	http.SetCookie(w, cookie)
+	utils.SendJSONResponse(w, http.StatusOK, assertion)
+}
+
+// FinishPasskeyLoginHandler verifies the authenticator's assertion and logs the user in.
+// It issues the same access and refresh tokens as a password login.
+func (a *AuthHandler) FinishPasskeyLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.passkeysEnabled(w) {
+		return
+	}
+
+	cookie, err := r.Cookie(webAuthnSessionCookie)
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Passkey login has not been started or has expired"))
+		return
+	}
+	expired := a.JwtManager.SessionCookie(webAuthnSessionCookie, "")
+	expired.MaxAge = -1
+	http.SetCookie(w, expired)
+
+	session, err := a.consumeWebAuthnSession(r, loginSessionKey(cookie.Value))
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Passkey login has not been started or has expired"))
+		return
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponse(r)
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid passkey response"))
+		return
+	}
+
+	var waUser *webAuthnUser
+	var credential *webauthn.Credential
+	if len(session.UserID) > 0 {
+		// The login was started for a specific username
+		waUser, err = a.webAuthnUserByHandle(session.UserID)
+		if err == nil {
+			credential, err = a.WebAuthn.ValidateLogin(waUser, *session, parsed)
+		}
+	} else {
+		// Discoverable login: the authenticator tells us who the user is
+		credential, err = a.WebAuthn.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+			waUser, err = a.webAuthnUserByHandle(userHandle)
+			return waUser, err
+		}, *session, parsed)
+	}
+	if err != nil || waUser == nil {
+		logrus.WithFields(logrus.Fields{
+			"method": r.Method,
+			"url":    r.URL.String(),
+			"ip":     r.RemoteAddr,
+		}).Warnf("Passkey login failed: %v", err)
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Could not verify passkey"))
+		return
+	}
+
+	if credential.Authenticator.CloneWarning {
+		logrus.WithFields(logrus.Fields{
+			"ip":   r.RemoteAddr,
+			"user": waUser.user.Username,
+		}).Warn("Passkey signature counter went backwards, the authenticator may be cloned")
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Could not verify passkey"))
+		return
+	}
+
+	a.recordPasskeyUse(waUser, credential)
+
+	accessToken, err := a.IssueSession(w, r, *waUser.user)
+	if err == models.ErrAccountSuspended {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusForbidden, "Account suspended"))
+		return
+	}
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not log in"))
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, map[string]string{"token": accessToken})
+}
+
+// ListPasskeysHandler lists the passkeys registered by the logged-in user.
+func (a *AuthHandler) ListPasskeysHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.userFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	credentials, err := a.Credentials.ListWebAuthnCredentials(user.ID)
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not list passkeys"))
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, credentials)
+}
+
+// DeletePasskeyHandler removes one of the logged-in user's passkeys.
+func (a *AuthHandler) DeletePasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.userFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid passkey ID"))
+		return
+	}
+
+	if err := a.Credentials.DeleteWebAuthnCredential(user.ID, uint(id)); err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusNotFound, "Passkey not found"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// passkeysEnabled responds with 404 Not Found if no WebAuthn relying party is configured.
+func (a *AuthHandler) passkeysEnabled(w http.ResponseWriter) bool {
+	if a.WebAuthn == nil || a.Credentials == nil || a.Redis == nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusNotFound, "Passkey login is not enabled"))
+		return false
+	}
+	return true
+}
+
+// loadWebAuthnUser loads the user's stored credentials.
+func (a *AuthHandler) loadWebAuthnUser(user *models.User) (*webAuthnUser, error) {
+	credentials, err := a.Credentials.ListWebAuthnCredentials(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &webAuthnUser{user: user, credentials: credentials}, nil
+}
+
+// webAuthnUserByHandle loads a user and their credentials from a WebAuthn user handle.
+func (a *AuthHandler) webAuthnUserByHandle(userHandle []byte) (*webAuthnUser, error) {
+	if _, err := strconv.ParseUint(string(userHandle), 10, 64); err != nil {
+		return nil, err
+	}
+	user, err := a.DB.GetUserByID(string(userHandle))
+	if err != nil {
+		return nil, err
+	}
+	return a.loadWebAuthnUser(user)
+}
+
+// recordPasskeyUse stores the updated signature counter and last-used time of the credential.
+func (a *AuthHandler) recordPasskeyUse(waUser *webAuthnUser, credential *webauthn.Credential) {
+	for i := range waUser.credentials {
+		stored := &waUser.credentials[i]
+		if string(stored.CredentialID) != string(credential.ID) {
+			continue
+		}
+		now := time.Now()
+		stored.SignCount = credential.Authenticator.SignCount
+		stored.BackupState = credential.Flags.BackupState
+		stored.LastUsedAt = &now
+		if err := a.Credentials.UpdateWebAuthnCredential(stored); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"user": waUser.user.Username,
+			}).Warnf("Could not update passkey after login: %v", err)
+		}
+		return
+	}
+}
+
+// storeWebAuthnSession stores the ceremony state in Redis until the client finishes it.
+func (a *AuthHandler) storeWebAuthnSession(r *http.Request, key string, session *webauthn.SessionData) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return redisI.StoreChallenge(r.Context(), a.Redis, key, data, webAuthnSessionTTL)
+}
+
+// consumeWebAuthnSession loads and deletes the ceremony state from Redis.
+func (a *AuthHandler) consumeWebAuthnSession(r *http.Request, key string) (*webauthn.SessionData, error) {
+	data, err := redisI.ConsumeChallenge(r.Context(), a.Redis, key)
+	if err != nil {
+		return nil, err
+	}
+	var session webauthn.SessionData
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// registrationSessionKey returns the Redis key for a user's pending passkey registration.
+func registrationSessionKey(userID uint) string {
+	return "webauthn:register:" + strconv.FormatUint(uint64(userID), 10)
+}
+
+// loginSessionKey returns the Redis key for a pending passkey login.
+func loginSessionKey(sessionID string) string {
+	return "webauthn:login:" + sessionID
+}
+
+// generateSessionID returns a random identifier for a pending ceremony.
+func generateSessionID() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
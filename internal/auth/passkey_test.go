@@ -0,0 +1,356 @@
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pageza/chat-app/internal/auth"
+	"github.com/pageza/chat-app/internal/config"
+	"github.com/pageza/chat-app/internal/middleware"
+	"github.com/pageza/chat-app/internal/models"
+)
+
+const (
+	testRPID   = "chat.example.com"
+	testOrigin = "https://chat.example.com"
+)
+
+// MemoryCredentialStore is an in-memory auth.CredentialStore.
+type MemoryCredentialStore struct {
+	credentials []models.WebAuthnCredential
+}
+
+func (s *MemoryCredentialStore) ListWebAuthnCredentials(userID uint) ([]models.WebAuthnCredential, error) {
+	var credentials []models.WebAuthnCredential
+	for _, c := range s.credentials {
+		if c.UserID == userID {
+			credentials = append(credentials, c)
+		}
+	}
+	return credentials, nil
+}
+
+func (s *MemoryCredentialStore) CreateWebAuthnCredential(credential *models.WebAuthnCredential) error {
+	credential.ID = uint(len(s.credentials) + 1)
+	s.credentials = append(s.credentials, *credential)
+	return nil
+}
+
+func (s *MemoryCredentialStore) UpdateWebAuthnCredential(credential *models.WebAuthnCredential) error {
+	for i := range s.credentials {
+		if s.credentials[i].ID == credential.ID {
+			s.credentials[i] = *credential
+		}
+	}
+	return nil
+}
+
+func (s *MemoryCredentialStore) DeleteWebAuthnCredential(userID, credentialID uint) error {
+	return nil
+}
+
+// virtualAuthenticator is a software passkey that answers WebAuthn ceremonies with "none" attestation.
+type virtualAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	signCount    uint32
+}
+
+func newVirtualAuthenticator(t *testing.T) *virtualAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	credentialID := make([]byte, 16)
+	_, err = rand.Read(credentialID)
+	require.NoError(t, err)
+	return &virtualAuthenticator{key: key, credentialID: credentialID}
+}
+
+// clientData returns the clientDataJSON a browser would produce for the ceremony.
+func (v *virtualAuthenticator) clientData(ceremony, challenge string) []byte {
+	data, _ := json.Marshal(map[string]string{"type": ceremony, "challenge": challenge, "origin": testOrigin})
+	return data
+}
+
+// authenticatorData returns the authenticator data with the user present and verified flags set,
+// followed by the attested credential data if it is given.
+func (v *virtualAuthenticator) authenticatorData(attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	flags := byte(0x05)
+	if attested != nil {
+		flags |= 0x40
+	}
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, v.signCount)
+	return append(data, attested...)
+}
+
+// create answers a registration ceremony started with the creation options.
+func (v *virtualAuthenticator) create(t *testing.T, challenge string) []byte {
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{KeyType: int64(webauthncose.EllipticKey), Algorithm: int64(webauthncose.AlgES256)},
+		Curve:         1, // P-256
+		XCoord:        v.key.X.FillBytes(make([]byte, 32)),
+		YCoord:        v.key.Y.FillBytes(make([]byte, 32)),
+	})
+	require.NoError(t, err)
+
+	attested := make([]byte, 16) // Zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(v.credentialID)))
+	attested = append(attested, v.credentialID...)
+	attested = append(attested, publicKey...)
+
+	attestationObject, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": v.authenticatorData(attested),
+	})
+	require.NoError(t, err)
+
+	id := base64.RawURLEncoding.EncodeToString(v.credentialID)
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":    id,
+		"rawId": id,
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(v.clientData("webauthn.create", challenge)),
+			"attestationObject": base64.RawURLEncoding.EncodeToString(attestationObject),
+		},
+	})
+	return body
+}
+
+// get answers a login ceremony with the given challenge, signing as the user with the handle.
+func (v *virtualAuthenticator) get(t *testing.T, challenge string, userHandle []byte) []byte {
+	v.signCount++
+	authData := v.authenticatorData(nil)
+	clientData := v.clientData("webauthn.get", challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, v.key, digest[:])
+	require.NoError(t, err)
+
+	id := base64.RawURLEncoding.EncodeToString(v.credentialID)
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":    id,
+		"rawId": id,
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientData),
+			"authenticatorData": base64.RawURLEncoding.EncodeToString(authData),
+			"signature":         base64.RawURLEncoding.EncodeToString(signature),
+			"userHandle":        base64.RawURLEncoding.EncodeToString(userHandle),
+		},
+	})
+	return body
+}
+
+// challengeFrom returns the challenge of the creation or assertion options in a response body.
+func challengeFrom(t *testing.T, rr *httptest.ResponseRecorder) string {
+	var options struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+		} `json:"publicKey"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &options))
+	require.NotEmpty(t, options.PublicKey.Challenge)
+	return options.PublicKey.Challenge
+}
+
+func newPasskeyTestHandler(t *testing.T) (*auth.AuthHandler, *MockDatabase, *MemoryCredentialStore, *models.User) {
+	db := new(MockDatabase)
+	user := &models.User{ID: 11, Username: "passkeyuser"}
+	db.On("GetUserByUsername", "passkeyuser").Return(user, nil)
+	db.On("GetUserByID", "11").Return(user, nil)
+
+	a, _, _ := newTestAuthHandler(t, db)
+	wa, err := auth.NewWebAuthn(config.WebAuthnConfig{RPID: testRPID, RPDisplayName: "Chat", RPOrigins: []string{testOrigin}})
+	require.NoError(t, err)
+	store := &MemoryCredentialStore{}
+	a.WebAuthn = wa
+	a.Credentials = store
+	return a, db, store, user
+}
+
+// registerPasskey runs a registration ceremony for the user with the authenticator.
+func registerPasskey(t *testing.T, a *auth.AuthHandler, user *models.User, authenticator *virtualAuthenticator) *httptest.ResponseRecorder {
+	principal := &middleware.Principal{User: user}
+	rr := postJSON(a.BeginPasskeyRegistrationHandler, "/passkeys/register/begin", nil, principal)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	req := httptest.NewRequest(http.MethodPost, "/passkeys/register/finish?name=Laptop", bytes.NewReader(authenticator.create(t, challengeFrom(t, rr))))
+	req = req.WithContext(middleware.WithPrincipal(req.Context(), principal))
+	rr = httptest.NewRecorder()
+	a.FinishPasskeyRegistrationHandler(rr, req)
+	return rr
+}
+
+// beginPasskeyLogin starts a login ceremony for the username and returns its challenge and session cookie.
+func beginPasskeyLogin(t *testing.T, a *auth.AuthHandler, username string) (string, *http.Cookie) {
+	rr := postJSON(a.BeginPasskeyLoginHandler, "/login/passkey/begin", auth.PasskeyLoginRequest{Username: username}, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == "webauthn_session" {
+			return challengeFrom(t, rr), cookie
+		}
+	}
+	t.Fatal("No webauthn_session cookie was set")
+	return "", nil
+}
+
+func finishPasskeyLogin(a *auth.AuthHandler, body []byte, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/login/passkey/finish", bytes.NewReader(body))
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rr := httptest.NewRecorder()
+	a.FinishPasskeyLoginHandler(rr, req)
+	return rr
+}
+
+func TestPasskeyRegistration(t *testing.T) {
+	t.Run("Registers a passkey", func(t *testing.T) {
+		a, _, store, user := newPasskeyTestHandler(t)
+
+		rr := registerPasskey(t, a, user, newVirtualAuthenticator(t))
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		if assert.Len(t, store.credentials, 1) {
+			assert.Equal(t, user.ID, store.credentials[0].UserID)
+			assert.Equal(t, "Laptop", store.credentials[0].Name)
+		}
+	})
+
+	t.Run("Finishing without starting is rejected", func(t *testing.T) {
+		a, _, store, user := newPasskeyTestHandler(t)
+
+		req := httptest.NewRequest(http.MethodPost, "/passkeys/register/finish", bytes.NewReader(newVirtualAuthenticator(t).create(t, "challenge")))
+		req = req.WithContext(middleware.WithPrincipal(req.Context(), &middleware.Principal{User: user}))
+		rr := httptest.NewRecorder()
+		a.FinishPasskeyRegistrationHandler(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Empty(t, store.credentials)
+	})
+
+	t.Run("Mismatched challenge is rejected", func(t *testing.T) {
+		a, _, store, user := newPasskeyTestHandler(t)
+		principal := &middleware.Principal{User: user}
+		rr := postJSON(a.BeginPasskeyRegistrationHandler, "/passkeys/register/begin", nil, principal)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		other := base64.RawURLEncoding.EncodeToString([]byte("a different challenge value"))
+		req := httptest.NewRequest(http.MethodPost, "/passkeys/register/finish", bytes.NewReader(newVirtualAuthenticator(t).create(t, other)))
+		req = req.WithContext(middleware.WithPrincipal(req.Context(), principal))
+		rr = httptest.NewRecorder()
+		a.FinishPasskeyRegistrationHandler(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Empty(t, store.credentials)
+	})
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	setup := func(t *testing.T) (*auth.AuthHandler, *models.User, *virtualAuthenticator) {
+		a, _, _, user := newPasskeyTestHandler(t)
+		authenticator := newVirtualAuthenticator(t)
+		require.Equal(t, http.StatusCreated, registerPasskey(t, a, user, authenticator).Code)
+		return a, user, authenticator
+	}
+
+	t.Run("Logs in with a registered passkey", func(t *testing.T) {
+		a, _, authenticator := setup(t)
+		challenge, cookie := beginPasskeyLogin(t, a, "passkeyuser")
+
+		rr := finishPasskeyLogin(a, authenticator.get(t, challenge, []byte("11")), cookie)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var body map[string]string
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+		assert.NotEmpty(t, body["token"])
+	})
+
+	t.Run("Session cookies follow the cookie settings", func(t *testing.T) {
+		a, _, authenticator := setup(t)
+		a.JwtManager.Cookies = config.CookieConfig{Secure: false, SameSite: http.SameSiteLaxMode, Path: "/app"}
+
+		challenge, cookie := beginPasskeyLogin(t, a, "passkeyuser")
+		assert.False(t, cookie.Secure)
+		assert.True(t, cookie.HttpOnly)
+		assert.Equal(t, "/app", cookie.Path)
+		assert.Equal(t, int((5 * time.Minute).Seconds()), cookie.MaxAge)
+
+		rr := finishPasskeyLogin(a, authenticator.get(t, challenge, []byte("11")), cookie)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var cleared *http.Cookie
+		for _, c := range rr.Result().Cookies() {
+			if c.Name == "webauthn_session" {
+				cleared = c
+			}
+		}
+		if assert.NotNil(t, cleared) {
+			assert.Equal(t, -1, cleared.MaxAge)
+			assert.Equal(t, "/app", cleared.Path)
+			assert.Equal(t, http.SameSiteLaxMode, cleared.SameSite)
+		}
+	})
+
+	t.Run("Missing session is rejected", func(t *testing.T) {
+		a, _, authenticator := setup(t)
+		challenge, _ := beginPasskeyLogin(t, a, "passkeyuser")
+
+		rr := finishPasskeyLogin(a, authenticator.get(t, challenge, []byte("11")), nil)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("Expired session is rejected", func(t *testing.T) {
+		a, _, authenticator := setup(t)
+		challenge, cookie := beginPasskeyLogin(t, a, "passkeyuser")
+
+		// Expired ceremony state is gone from the store, just like deleted state
+		a.Redis.Del(context.Background(), "challenge:webauthn:login:"+cookie.Value)
+		rr := finishPasskeyLogin(a, authenticator.get(t, challenge, []byte("11")), cookie)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("Unknown or used session is rejected", func(t *testing.T) {
+		a, _, authenticator := setup(t)
+		challenge, cookie := beginPasskeyLogin(t, a, "passkeyuser")
+
+		rr := finishPasskeyLogin(a, authenticator.get(t, challenge, []byte("11")), &http.Cookie{Name: "webauthn_session", Value: "unknown"})
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+		require.Equal(t, http.StatusOK, finishPasskeyLogin(a, authenticator.get(t, challenge, []byte("11")), cookie).Code)
+		rr = finishPasskeyLogin(a, authenticator.get(t, challenge, []byte("11")), cookie)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("Assertion for another challenge is rejected", func(t *testing.T) {
+		a, _, authenticator := setup(t)
+		_, cookie := beginPasskeyLogin(t, a, "passkeyuser")
+		other := base64.RawURLEncoding.EncodeToString([]byte("a different challenge value"))
+
+		rr := finishPasskeyLogin(a, authenticator.get(t, other, []byte("11")), cookie)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("Unregistered passkey is rejected", func(t *testing.T) {
+		a, _, _ := setup(t)
+		challenge, cookie := beginPasskeyLogin(t, a, "passkeyuser")
+
+		rr := finishPasskeyLogin(a, newVirtualAuthenticator(t).get(t, challenge, []byte("11")), cookie)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+}
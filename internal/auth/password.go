@@ -0,0 +1,229 @@
+// Package auth provides authentication handlers for the chat application.
+// This file specifically includes the password reset and password change handlers.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/pageza/chat-app/internal/errors"
+	"github.com/pageza/chat-app/internal/mailer"
+	"github.com/pageza/chat-app/internal/models"
+	redisI "github.com/pageza/chat-app/internal/redis"
+	"github.com/pageza/chat-app/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// ForgotPasswordRequest is the payload for requesting a password reset email.
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetPasswordRequest is the payload for resetting a password with a reset token.
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// ChangePasswordRequest is the payload for changing the password of a logged-in user.
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// ForgotPasswordHandler emails a one-time, short-lived password reset token to the user.
+// It always responds with 202 Accepted so that it cannot be used to discover registered emails.
+func (a *AuthHandler) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid request payload"))
+		return
+	}
+
+	response := map[string]string{"message": "If an account with that email exists, a password reset link has been sent"}
+
+	user, err := a.DB.GetUserByEmail(req.Email)
+	if err != nil || user == nil {
+		utils.SendJSONResponse(w, http.StatusAccepted, response)
+		return
+	}
+
+	if err := a.sendPasswordReset(r, user); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"method": r.Method,
+			"url":    r.URL.String(),
+			"ip":     r.RemoteAddr,
+			"user":   user.Username,
+		}).Errorf("Could not send password reset email: %v", err)
+	}
+
+	utils.SendJSONResponse(w, http.StatusAccepted, response)
+}
+
+// sendPasswordReset generates a reset token, stores its hash in Redis and emails the token to the user.
+func (a *AuthHandler) sendPasswordReset(r *http.Request, user *models.User) error {
+	if a.Redis == nil || a.Mailer == nil {
+		return fmt.Errorf("password reset is not configured")
+	}
+
+	token, err := generateResetToken()
+	if err != nil {
+		return err
+	}
+
+	if err := redisI.StorePasswordResetToken(r.Context(), a.Redis, hashResetToken(token), user.ID, a.PasswordResetTTL); err != nil {
+		return err
+	}
+
+	link := token
+	if a.PasswordResetURL != "" {
+		link = a.PasswordResetURL + "?token=" + token
+	}
+
+	return a.Mailer.Send(r.Context(), mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone requested a password reset for your account.\n\n"+
+			"Use the following link to choose a new password. It expires in %s.\n\n%s\n\n"+
+			"If you did not request this, you can ignore this email.", a.PasswordResetTTL, link),
+	})
+}
+
+// ResetPasswordHandler sets a new password using a token from ForgotPasswordHandler.
+// The token can only be used once, and all of the user's existing sessions are revoked.
+func (a *AuthHandler) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid request payload"))
+		return
+	}
+
+	if err := models.ValidatePassword(req.NewPassword); err != nil {
+		errors.RespondWithError(w, errors.Validation(errors.FieldError{Field: "new_password", Message: err.Error()}))
+		return
+	}
+
+	if a.Redis == nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Internal server error"))
+		return
+	}
+
+	userID, err := redisI.ConsumePasswordResetToken(r.Context(), a.Redis, hashResetToken(req.Token))
+	if err == redisI.ErrTokenNotFound {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid or expired reset token"))
+		return
+	}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"method": r.Method,
+			"url":    r.URL.String(),
+			"ip":     r.RemoteAddr,
+		}).Errorf("Could not look up password reset token: %v", err)
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Internal server error"))
+		return
+	}
+
+	user, err := a.DB.GetUserByID(strconv.FormatUint(uint64(userID), 10))
+	if err != nil || user == nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid or expired reset token"))
+		return
+	}
+
+	if !a.updatePassword(w, r, user, req.NewPassword) {
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, map[string]string{"message": "Password has been reset"})
+}
+
+// ChangePasswordHandler changes the password of the logged-in user.
+// The current password is required, and all of the user's existing sessions are revoked.
+func (a *AuthHandler) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.userFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid request payload"))
+		return
+	}
+
+	if err := utils.ValidateUser(user, req.CurrentPassword); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"method": r.Method,
+			"url":    r.URL.String(),
+			"ip":     r.RemoteAddr,
+			"user":   user.Username,
+		}).Warn("Invalid current password on password change")
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Invalid password"))
+		return
+	}
+
+	if err := models.ValidatePassword(req.NewPassword); err != nil {
+		errors.RespondWithError(w, errors.Validation(errors.FieldError{Field: "new_password", Message: err.Error()}))
+		return
+	}
+
+	if !a.updatePassword(w, r, user, req.NewPassword) {
+		return
+	}
+
+	// The current session was revoked along with the others
+	a.JwtManager.ClearTokenCookie(w)
+	utils.SendJSONResponse(w, http.StatusOK, map[string]string{"message": "Password has been changed"})
+}
+
+// updatePassword hashes and stores a new password and revokes all of the user's sessions.
+// It writes an error response and returns false if anything fails.
+func (a *AuthHandler) updatePassword(w http.ResponseWriter, r *http.Request, user *models.User, newPassword string) bool {
+	hashedPassword, err := utils.HashPassword(newPassword)
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not update password"))
+		return false
+	}
+
+	user.Password = hashedPassword
+	if err := a.DB.UpdateUser(user); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"method": r.Method,
+			"url":    r.URL.String(),
+			"ip":     r.RemoteAddr,
+			"user":   user.Username,
+		}).Errorf("Could not update password: %v", err)
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not update password"))
+		return false
+	}
+
+	if a.Redis != nil {
+		if err := redisI.RevokeUserTokens(r.Context(), a.Redis, user.Username); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"user": user.Username,
+			}).Errorf("Could not revoke sessions after password update: %v", err)
+		}
+	}
+
+	return true
+}
+
+// generateResetToken returns a random URL-safe password reset token.
+func generateResetToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashResetToken hashes a reset token so that only the hash is stored in Redis.
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
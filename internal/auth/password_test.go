@@ -0,0 +1,185 @@
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/pageza/chat-app/internal/auth"
+	"github.com/pageza/chat-app/internal/config"
+	"github.com/pageza/chat-app/internal/jwt"
+	"github.com/pageza/chat-app/internal/mailer"
+	"github.com/pageza/chat-app/internal/memory"
+	"github.com/pageza/chat-app/internal/middleware"
+	"github.com/pageza/chat-app/internal/models"
+	redisI "github.com/pageza/chat-app/internal/redis"
+	"github.com/pageza/chat-app/internal/utils"
+)
+
+// MockMailer records the emails sent through it.
+type MockMailer struct {
+	Sent []mailer.Message
+}
+
+func (m *MockMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.Sent = append(m.Sent, msg)
+	return nil
+}
+
+// newTestAuthHandler returns an AuthHandler backed by an in-process Redis client and a recording mailer.
+func newTestAuthHandler(t *testing.T, db *MockDatabase) (*auth.AuthHandler, *memory.Client, *MockMailer) {
+	keys, err := jwt.NewKeySet("HS256", []byte("secret"), 0)
+	if err != nil {
+		t.Fatalf("Could not create the signing keys: %v", err)
+	}
+	client := memory.NewClient()
+	mail := &MockMailer{}
+	return &auth.AuthHandler{
+		DB:               db,
+		JwtManager:       jwt.NewJwtManager(keys, config.JWTConfig{Issuer: "chat-app", TokenExpiration: time.Hour}, config.Default().Cookies),
+		Redis:            client,
+		Mailer:           mail,
+		PasswordResetTTL: time.Hour,
+	}, client, mail
+}
+
+// passwordTestUser returns a user whose password is "OldPassw0rd!".
+func passwordTestUser(t *testing.T) *models.User {
+	hashed, err := utils.HashPassword("OldPassw0rd!")
+	if err != nil {
+		t.Fatalf("Could not hash the password: %v", err)
+	}
+	return &models.User{ID: 7, Username: "resetuser", Email: "reset@example.com", Password: hashed}
+}
+
+// resetTokenFromMail extracts the reset token from the body of a password reset email.
+func resetTokenFromMail(t *testing.T, msg mailer.Message) string {
+	lines := strings.Split(strings.TrimSpace(msg.Body), "\n\n")
+	if len(lines) < 3 {
+		t.Fatalf("Unexpected password reset email: %q", msg.Body)
+	}
+	return lines[2]
+}
+
+// sessionsRevoked reports whether tokens issued to the user before now are revoked.
+func sessionsRevoked(t *testing.T, client *memory.Client, username string) bool {
+	revoked, err := redisI.IsTokenRevoked(context.Background(), client, "unrelated-jti", username, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("Could not check the revocation: %v", err)
+	}
+	return revoked
+}
+
+func postJSON(handler http.HandlerFunc, path string, body interface{}, principal *middleware.Principal) *httptest.ResponseRecorder {
+	payload, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(payload))
+	if principal != nil {
+		req = req.WithContext(middleware.WithPrincipal(req.Context(), principal))
+	}
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	return rr
+}
+
+func TestForgotPasswordHandler(t *testing.T) {
+	db := new(MockDatabase)
+	user := passwordTestUser(t)
+	db.On("GetUserByEmail", "reset@example.com").Return(user, nil)
+	db.On("GetUserByEmail", "nobody@example.com").Return(nil, assert.AnError)
+	a, _, mail := newTestAuthHandler(t, db)
+
+	known := postJSON(a.ForgotPasswordHandler, "/forgot-password", auth.ForgotPasswordRequest{Email: "reset@example.com"}, nil)
+	unknown := postJSON(a.ForgotPasswordHandler, "/forgot-password", auth.ForgotPasswordRequest{Email: "nobody@example.com"}, nil)
+
+	assert.Equal(t, http.StatusAccepted, known.Code)
+	assert.Equal(t, known.Code, unknown.Code)
+	assert.Equal(t, known.Body.String(), unknown.Body.String())
+	if assert.Len(t, mail.Sent, 1) {
+		assert.Equal(t, "reset@example.com", mail.Sent[0].To)
+	}
+}
+
+func TestResetPasswordHandler(t *testing.T) {
+	newReset := func(t *testing.T, ttl time.Duration) (*auth.AuthHandler, *memory.Client, *MockDatabase, string) {
+		db := new(MockDatabase)
+		user := passwordTestUser(t)
+		db.On("GetUserByEmail", user.Email).Return(user, nil)
+		db.On("GetUserByID", "7").Return(user, nil)
+		db.On("UpdateUser", mock.AnythingOfType("*models.User")).Return(nil)
+		a, client, mail := newTestAuthHandler(t, db)
+		a.PasswordResetTTL = ttl
+
+		rr := postJSON(a.ForgotPasswordHandler, "/forgot-password", auth.ForgotPasswordRequest{Email: user.Email}, nil)
+		if rr.Code != http.StatusAccepted || len(mail.Sent) != 1 {
+			t.Fatalf("Could not request a password reset: %d", rr.Code)
+		}
+		return a, client, db, resetTokenFromMail(t, mail.Sent[0])
+	}
+
+	t.Run("Token can only be used once", func(t *testing.T) {
+		a, client, db, token := newReset(t, time.Hour)
+
+		rr := postJSON(a.ResetPasswordHandler, "/reset-password", auth.ResetPasswordRequest{Token: token, NewPassword: "NewPassw0rd!"}, nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		db.AssertCalled(t, "UpdateUser", mock.AnythingOfType("*models.User"))
+		assert.True(t, sessionsRevoked(t, client, "resetuser"), "existing sessions should be revoked")
+
+		rr = postJSON(a.ResetPasswordHandler, "/reset-password", auth.ResetPasswordRequest{Token: token, NewPassword: "OtherPassw0rd!"}, nil)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("Wrong token", func(t *testing.T) {
+		a, client, db, _ := newReset(t, time.Hour)
+
+		rr := postJSON(a.ResetPasswordHandler, "/reset-password", auth.ResetPasswordRequest{Token: "not-the-token", NewPassword: "NewPassw0rd!"}, nil)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		db.AssertNotCalled(t, "UpdateUser", mock.Anything)
+		assert.False(t, sessionsRevoked(t, client, "resetuser"))
+	})
+
+	t.Run("Expired token", func(t *testing.T) {
+		a, _, db, token := newReset(t, time.Millisecond)
+		time.Sleep(10 * time.Millisecond)
+
+		rr := postJSON(a.ResetPasswordHandler, "/reset-password", auth.ResetPasswordRequest{Token: token, NewPassword: "NewPassw0rd!"}, nil)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		db.AssertNotCalled(t, "UpdateUser", mock.Anything)
+	})
+}
+
+func TestChangePasswordHandler(t *testing.T) {
+	t.Run("Wrong current password", func(t *testing.T) {
+		db := new(MockDatabase)
+		user := passwordTestUser(t)
+		a, client, _ := newTestAuthHandler(t, db)
+
+		rr := postJSON(a.ChangePasswordHandler, "/change-password",
+			auth.ChangePasswordRequest{CurrentPassword: "WrongPassw0rd!", NewPassword: "NewPassw0rd!"},
+			&middleware.Principal{User: user})
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		db.AssertNotCalled(t, "UpdateUser", mock.Anything)
+		assert.False(t, sessionsRevoked(t, client, "resetuser"))
+	})
+
+	t.Run("Correct current password", func(t *testing.T) {
+		db := new(MockDatabase)
+		user := passwordTestUser(t)
+		db.On("UpdateUser", user).Return(nil)
+		a, client, _ := newTestAuthHandler(t, db)
+
+		rr := postJSON(a.ChangePasswordHandler, "/change-password",
+			auth.ChangePasswordRequest{CurrentPassword: "OldPassw0rd!", NewPassword: "NewPassw0rd!"},
+			&middleware.Principal{User: user})
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NoError(t, utils.ValidateUser(user, "NewPassw0rd!"))
+		assert.True(t, sessionsRevoked(t, client, "resetuser"), "existing sessions should be revoked")
+	})
+}
@@ -0,0 +1,200 @@
+// Package avatar validates uploaded profile pictures and generates their thumbnails.
+// Images are decoded and re-encoded from their pixels only, which drops all metadata
+// such as EXIF and GPS tags. The EXIF orientation is applied to the pixels first,
+// so that photos taken with a rotated camera still display upright.
+package avatar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif" // Register the GIF decoder
+	_ "image/jpeg"
+	"image/png"
+	"strconv"
+
+	"golang.org/x/image/draw"
+)
+
+// Sizes are the edge lengths, in pixels, of the square thumbnails generated for every avatar.
+var Sizes = []int{64, 128, 256}
+
+// Limits on uploaded images.
+const (
+	MaxUploadSize  = 5 << 20                // Maximum size of the uploaded file, in bytes
+	MaxRequestSize = MaxUploadSize + 64<<10 // Maximum size of an upload request, leaving room for the multipart headers
+	maxDimension   = 8000                   // Maximum width and height, to guard against decompression bombs
+)
+
+// Errors returned for invalid uploads.
+var (
+	ErrUnsupportedFormat = errors.New("image must be a JPEG, PNG or GIF")
+	ErrTooLarge          = errors.New("image dimensions must be at most 8000x8000 pixels")
+)
+
+// File returns the storage key of the thumbnail of a given size, under the key prefix of an avatar.
+func File(key string, size int) string {
+	return key + "/" + strconv.Itoa(size) + ".png"
+}
+
+// Process decodes an uploaded image and returns PNG-encoded square thumbnails, keyed by size.
+// The image is cropped to a centered square before scaling.
+func Process(data []byte) (map[int][]byte, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+	if config.Width > maxDimension || config.Height > maxDimension || config.Width == 0 || config.Height == 0 {
+		return nil, ErrTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+	if format == "jpeg" {
+		img = applyOrientation(img, jpegOrientation(data))
+	}
+
+	square := cropSquare(img)
+	thumbnails := make(map[int][]byte, len(Sizes))
+	for _, size := range Sizes {
+		dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), square, square.Bounds(), draw.Src, nil)
+
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, dst); err != nil {
+			return nil, err
+		}
+		thumbnails[size] = buf.Bytes()
+	}
+	return thumbnails, nil
+}
+
+// cropSquare returns the largest centered square of the image.
+func cropSquare(img image.Image) image.Image {
+	b := img.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	x := b.Min.X + (b.Dx()-side)/2
+	y := b.Min.Y + (b.Dy()-side)/2
+	rect := image.Rect(x, y, x+side, y+side)
+
+	if sub, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(rect)
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(dst, dst.Bounds(), img, rect.Min, draw.Src)
+	return dst
+}
+
+// applyOrientation transforms the image according to an EXIF orientation value (1-8),
+// so that it displays upright without the orientation tag.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	// Orientations 5-8 swap the width and height
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // Mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // Rotated 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // Mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // Mirrored along the top-left diagonal
+				dx, dy = y, x
+			case 6: // Rotated 90° clockwise
+				dx, dy = h-1-y, x
+			case 7: // Mirrored along the top-right diagonal
+				dx, dy = h-1-y, w-1-x
+			case 8: // Rotated 90° counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)))
+		}
+	}
+	return dst
+}
+
+// jpegOrientation reads the EXIF orientation tag from a JPEG file.
+// It returns 1 (upright) if the file has no readable orientation tag.
+func jpegOrientation(data []byte) int {
+	const (
+		markerSOI  = 0xD8
+		markerAPP1 = 0xE1
+		markerSOS  = 0xDA
+		tagOrient  = 0x0112
+	)
+
+	if len(data) < 4 || data[0] != 0xFF || data[1] != markerSOI {
+		return 1
+	}
+
+	// Walk the segments until the EXIF segment or the start of the image data
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return 1
+		}
+		marker := data[pos+1]
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if marker == markerSOS || length < 2 || pos+2+length > len(data) {
+			return 1
+		}
+		segment := data[pos+4 : pos+2+length]
+		pos += 2 + length
+
+		if marker != markerAPP1 || len(segment) < 14 || string(segment[:6]) != "Exif\x00\x00" {
+			continue
+		}
+
+		// The TIFF header declares the byte order
+		tiff := segment[6:]
+		var order binary.ByteOrder
+		switch string(tiff[:2]) {
+		case "II":
+			order = binary.LittleEndian
+		case "MM":
+			order = binary.BigEndian
+		default:
+			return 1
+		}
+
+		// Look for the orientation tag in the first image file directory
+		ifd := int(order.Uint32(tiff[4:]))
+		if ifd+2 > len(tiff) {
+			return 1
+		}
+		entries := int(order.Uint16(tiff[ifd:]))
+		for i := 0; i < entries; i++ {
+			entry := ifd + 2 + i*12
+			if entry+12 > len(tiff) {
+				return 1
+			}
+			if order.Uint16(tiff[entry:]) == tagOrient {
+				return int(order.Uint16(tiff[entry+8:]))
+			}
+		}
+		return 1
+	}
+	return 1
+}
@@ -0,0 +1,86 @@
+package avatar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// jpegWithExif encodes a JPEG image and inserts an EXIF segment with an orientation tag
+// and a fake GPS marker after the start-of-image marker.
+func jpegWithExif(t *testing.T, img image.Image, orientation uint16) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, nil))
+	data := buf.Bytes()
+
+	// Little-endian TIFF header followed by a single-entry image file directory
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	tiff = binary.LittleEndian.AppendUint16(tiff, 1)
+	entry := make([]byte, 12)
+	binary.LittleEndian.PutUint16(entry[0:], 0x0112)
+	binary.LittleEndian.PutUint16(entry[2:], 3) // SHORT
+	binary.LittleEndian.PutUint32(entry[4:], 1)
+	binary.LittleEndian.PutUint16(entry[8:], orientation)
+	tiff = append(tiff, entry...)
+	tiff = append(tiff, 0, 0, 0, 0)
+	tiff = append(tiff, "GPS 38.8977N 77.0365W"...)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, app1...)
+	return append(out, data[2:]...)
+}
+
+func TestProcess(t *testing.T) {
+	// A landscape image whose left half is red and right half is blue
+	img := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 400; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= 200 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	data := jpegWithExif(t, img, 6)
+	require.Equal(t, 6, jpegOrientation(data))
+
+	thumbnails, err := Process(data)
+	require.NoError(t, err)
+	require.Len(t, thumbnails, len(Sizes))
+
+	for _, size := range Sizes {
+		thumbnail := thumbnails[size]
+		assert.NotContains(t, string(thumbnail), "Exif")
+		assert.NotContains(t, string(thumbnail), "GPS")
+
+		decoded, format, err := image.Decode(bytes.NewReader(thumbnail))
+		require.NoError(t, err)
+		assert.Equal(t, "png", format)
+		assert.Equal(t, image.Rect(0, 0, size, size), decoded.Bounds())
+	}
+
+	// Rotated 90° clockwise, red ends up at the top and blue at the bottom
+	decoded, _, err := image.Decode(bytes.NewReader(thumbnails[64]))
+	require.NoError(t, err)
+	r, _, b, _ := decoded.At(32, 2).RGBA()
+	assert.Greater(t, r, b)
+	r, _, b, _ = decoded.At(32, 61).RGBA()
+	assert.Greater(t, b, r)
+}
+
+func TestProcessRejectsInvalidImages(t *testing.T) {
+	_, err := Process([]byte("not an image"))
+	assert.Equal(t, ErrUnsupportedFormat, err)
+}
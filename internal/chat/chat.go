@@ -1,29 +1,288 @@
 // Package chat provides chat-related functionalities for the chat application.
-// It includes functionalities for sending and receiving messages.
+// It includes functionalities for sending and receiving messages, both in rooms and directly between users.
+// Block and mute lists are honored throughout.
 package chat
 
 import (
-	"fmt"
+	"context"
+	"encoding/json"
 	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pageza/chat-app/internal/errors"
+	"github.com/pageza/chat-app/internal/events"
+	"github.com/pageza/chat-app/internal/middleware"
+	"github.com/pageza/chat-app/internal/models"
+	"github.com/pageza/chat-app/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// Limits on message history pages.
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 100
 )
 
-// ChatHandler handles chat-related requests.
-// Currently, this is a placeholder for future chat functionalities.
-func ChatHandler(w http.ResponseWriter, r *http.Request) {
-	// Placeholder for chat functionality
-	fmt.Fprintf(w, "Chat endpoint")
+// maxPresenceUsers is the maximum number of users whose presence can be requested at once.
+const maxPresenceUsers = 100
+
+// Store is an interface for the persistence needed by the chat handlers.
+type Store interface {
+	GetUsersByIDs(userIDs []uint) ([]models.User, error)
+	ListRooms() ([]models.Room, error)
+	CreateRoom(room *models.Room) error
+	GetRoomByID(roomID uint) (*models.Room, error)
+	GetRoomByName(name string) (*models.Room, error)
+	UpdateRoom(room *models.Room) error
+	AddRoomMember(member *models.RoomMember) error
+	GetRoomMember(roomID, userID uint) (*models.RoomMember, error)
+	RemoveRoomMember(roomID, userID uint) error
+	ListRoomMemberIDs(roomID uint, verifiedOnly bool) ([]uint, error)
+	CreateMessage(message *models.Message) error
+	ListRoomMessages(roomID, viewerID, beforeID uint, limit int) ([]models.Message, error)
+	ListDirectMessages(viewerID, otherID, beforeID uint, limit int) ([]models.Message, error)
+	IsBlockedBetween(userID, otherID uint) (bool, error)
+	BlockedByIDs(userID uint) ([]uint, error)
+}
+
+// PresenceStore is an interface for looking up which users are online.
+type PresenceStore interface {
+	Online(ctx context.Context, userIDs []uint) (map[uint]bool, error)
+}
+
+// Handler contains dependencies for handling chat requests.
+// All handlers expect the request to have been authenticated by the auth middleware.
+type Handler struct {
+	Store    Store
+	Events   events.Publisher // Used to deliver new messages in real time, may be nil
+	Presence PresenceStore
+	Flood    *FloodControl // Limits how fast users can send messages, may be nil
+}
+
+// SendMessageRequest is the payload of a message. Exactly one of RoomID and RecipientID must be set.
+type SendMessageRequest struct {
+	RoomID      uint   `json:"room_id"`
+	RecipientID uint   `json:"recipient_id"`
+	Body        string `json:"body"`
+}
+
+// SendMessageHandler sends a message to a room the user has joined, or directly to another user.
+// Direct messages are refused if either user has blocked the other. Messages rejected by flood control
+// are answered with an error, and announced to the sender's event streams with a MessageRejected event.
+func (h *Handler) SendMessageHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.CurrentUser(r)
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+
+	var req SendMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid request payload"))
+		return
+	}
+	if (req.RoomID == 0) == (req.RecipientID == 0) {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Exactly one of room_id and recipient_id must be set"))
+		return
+	}
+	if problem := models.ValidateMessageBody(req.Body); problem != "" {
+		errors.RespondWithError(w, errors.Validation(errors.FieldError{Field: "body", Message: problem}))
+		return
+	}
+
+	message := &models.Message{SenderID: user.ID, Body: req.Body}
+	event := events.Event{Type: events.MessageCreated, Data: message, ActorID: user.ID}
+	var room *models.Room
+	var member *models.RoomMember
+	var conversation string
+
+	if req.RoomID != 0 {
+		var ok bool
+		room, member, ok = h.roomAccess(w, r, user, req.RoomID)
+		if !ok {
+			return
+		}
+		// Only deliver the message to the members who may read it
+		members, err := h.Store.ListRoomMemberIDs(room.ID, room.VerifiedOnly)
+		if err != nil {
+			h.internalError(w, r, "Could not send message", err)
+			return
+		}
+		message.RoomID = &req.RoomID
+		// The sender is always listed, so that the list is never empty, which would address everyone
+		event.Recipients = append(members, user.ID)
+		conversation = "room:" + strconv.FormatUint(uint64(room.ID), 10)
+	} else {
+		if req.RecipientID == user.ID {
+			errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "You cannot send a direct message to yourself"))
+			return
+		}
+		recipients, err := h.Store.GetUsersByIDs([]uint{req.RecipientID})
+		if err != nil {
+			h.internalError(w, r, "Could not send message", err)
+			return
+		}
+		if len(recipients) == 0 {
+			errors.RespondWithError(w, errors.NewAPIError(http.StatusNotFound, "User not found"))
+			return
+		}
+		blocked, err := h.Store.IsBlockedBetween(user.ID, req.RecipientID)
+		if err != nil {
+			h.internalError(w, r, "Could not send message", err)
+			return
+		}
+		if blocked {
+			errors.RespondWithError(w, errors.NewAPIError(http.StatusForbidden, "You cannot send direct messages to this user"))
+			return
+		}
+		message.RecipientID = &req.RecipientID
+		event.Recipients = []uint{user.ID, req.RecipientID}
+		conversation = "user:" + strconv.FormatUint(uint64(req.RecipientID), 10)
+	}
+
+	if h.Flood != nil {
+		if rejected := h.Flood.check(r.Context(), user, conversation, room, member, req.Body); rejected != nil {
+			h.reject(w, r, user, req, rejected)
+			return
+		}
+	}
+
+	if err := h.Store.CreateMessage(message); err != nil {
+		h.internalError(w, r, "Could not send message", err)
+		return
+	}
+	if h.Flood != nil {
+		h.Flood.record(r.Context(), user, conversation, req.Body)
+	}
+	h.publish(r, event)
+
+	utils.SendJSONResponse(w, http.StatusCreated, message)
+}
+
+// ReceiveMessageHandler returns the message history of a room the user has joined (the "room_id" query parameter),
+// or the direct messages exchanged with another user (the "user_id" query parameter), newest first.
+// Older pages are requested with the "before" parameter, set to the ID of the oldest message received.
+// Messages from users the logged-in user has blocked or muted are left out.
+func (h *Handler) ReceiveMessageHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.CurrentUser(r)
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+
+	query := r.URL.Query()
+	roomID, roomErr := parseID(query.Get("room_id"))
+	otherID, userErr := parseID(query.Get("user_id"))
+	beforeID, beforeErr := parseID(query.Get("before"))
+	if roomErr != nil || userErr != nil || beforeErr != nil || (roomID == 0) == (otherID == 0) {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Exactly one of room_id and user_id must be set to a valid ID"))
+		return
+	}
+
+	limit := defaultHistoryLimit
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid limit"))
+			return
+		}
+		if parsed < maxHistoryLimit {
+			limit = parsed
+		} else {
+			limit = maxHistoryLimit
+		}
+	}
+
+	var messages []models.Message
+	var err error
+	if roomID != 0 {
+		if _, _, ok := h.roomAccess(w, r, user, roomID); !ok {
+			return
+		}
+		messages, err = h.Store.ListRoomMessages(roomID, user.ID, beforeID, limit)
+	} else {
+		messages, err = h.Store.ListDirectMessages(user.ID, otherID, beforeID, limit)
+	}
+	if err != nil {
+		h.internalError(w, r, "Could not load messages", err)
+		return
+	}
+	if messages == nil {
+		messages = []models.Message{}
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, messages)
+}
+
+// PresenceHandler reports which of the users listed in the comma-separated "user_ids" query parameter
+// are online. Users who have blocked the logged-in user are always reported as offline.
+func (h *Handler) PresenceHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.CurrentUser(r)
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+
+	var userIDs []uint
+	for _, value := range strings.Split(r.URL.Query().Get("user_ids"), ",") {
+		id, err := parseID(strings.TrimSpace(value))
+		if err != nil || id == 0 {
+			errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "user_ids must be a comma-separated list of user IDs"))
+			return
+		}
+		userIDs = append(userIDs, id)
+	}
+	if len(userIDs) > maxPresenceUsers {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "At most 100 users can be requested at once"))
+		return
+	}
+
+	online, err := h.Presence.Online(r.Context(), userIDs)
+	if err != nil {
+		h.internalError(w, r, "Could not load presence", err)
+		return
+	}
+	blockedBy, err := h.Store.BlockedByIDs(user.ID)
+	if err != nil {
+		h.internalError(w, r, "Could not load presence", err)
+		return
+	}
+	for _, id := range blockedBy {
+		if _, ok := online[id]; ok {
+			online[id] = false
+		}
+	}
+
+	response := make(map[string]bool, len(online))
+	for id, isOnline := range online {
+		response[strconv.FormatUint(uint64(id), 10)] = isOnline
+	}
+	utils.SendJSONResponse(w, http.StatusOK, response)
+}
+
+// publish publishes an event, logging failures, since the message itself has already been stored.
+func (h *Handler) publish(r *http.Request, event events.Event) {
+	if h.Events == nil {
+		return
+	}
+	if err := h.Events.Publish(r.Context(), event); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"event": event.Type,
+		}).Warnf("Could not publish event: %v", err)
+	}
 }
 
-// SendMessageHandler handles the sending of chat messages.
-// Currently, this is a placeholder for future message sending functionalities.
-func SendMessageHandler(w http.ResponseWriter, r *http.Request) {
-	// Placeholder for sending messages
-	fmt.Fprintf(w, "Send message endpoint")
+// internalError logs a server-side error and responds with a generic message.
+func (h *Handler) internalError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	errors.Respond(w, r, errors.Wrap(err, http.StatusInternalServerError, errors.CodeInternal, message))
 }
 
-// ReceiveMessageHandler handles the receiving of chat messages.
-// Currently, this is a placeholder for future message receiving functionalities.
-func ReceiveMessageHandler(w http.ResponseWriter, r *http.Request) {
-	// Placeholder for receiving messages
-	fmt.Fprintf(w, "Receive message endpoint")
+// parseID parses an optional ID query parameter, returning 0 if it is empty.
+func parseID(value string) (uint, error) {
+	if value == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	return uint(id), err
 }
@@ -0,0 +1,168 @@
+// Package chat provides chat-related functionalities for the chat application.
+// This file specifically includes flood control: message rate limits, duplicate detection and slow mode.
+package chat
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/pageza/chat-app/internal/errors"
+	"github.com/pageza/chat-app/internal/events"
+	"github.com/pageza/chat-app/internal/models"
+	redisI "github.com/pageza/chat-app/internal/redis"
+	"github.com/sirupsen/logrus"
+)
+
+// Reasons a message is rejected by flood control.
+const (
+	ReasonRateLimited = "rate_limited"      // The sender exceeded the message rate limit
+	ReasonSlowMode    = "slow_mode"         // The room's slow mode interval has not passed
+	ReasonDuplicate   = "duplicate_message" // The sender just sent the same message to the same conversation
+)
+
+// lastMessagePrefix prefixes the hash of the last message a user sent to a conversation.
+const lastMessagePrefix = "chat_last_message:"
+
+// RateLimitStore is an interface for counting messages against a limit, such as redis.RateLimiter.
+type RateLimitStore interface {
+	Allow(ctx context.Context, key string, limit int, window time.Duration) (redisI.RateLimitResult, error)
+}
+
+// FloodControl limits how fast users can send messages, on top of the HTTP request limits.
+// Failures of its stores are logged and let messages through, since flooding is a nuisance
+// rather than a security issue.
+type FloodControl struct {
+	Limits          RateLimitStore
+	Recent          redisI.Client // Holds the last message of each user and conversation
+	MessageLimit    int           // Messages a user may send to a conversation per window; zero for no limit
+	MessageWindow   time.Duration
+	DuplicateWindow time.Duration // Identical messages to a conversation are rejected within this time; zero to allow them
+}
+
+// rejection describes why flood control rejected a message.
+type rejection struct {
+	reason     string
+	message    string
+	retryAfter time.Duration
+}
+
+// check checks a message against the flood control rules. The conversation identifies the room or
+// direct conversation the message is sent to. The room and member are nil for direct messages.
+func (f *FloodControl) check(ctx context.Context, sender *models.User, conversation string, room *models.Room, member *models.RoomMember, body string) *rejection {
+	logger := logrus.WithFields(logrus.Fields{
+		"user":         sender.Username,
+		"conversation": conversation,
+	})
+
+	// Duplicates are checked first, so that they don't use up the sender's allowance
+	if f.DuplicateWindow > 0 {
+		last, err := f.Recent.Get(ctx, lastMessageKey(sender.ID, conversation)).Result()
+		if err != nil && err != goredis.Nil {
+			logger.Warnf("Could not check for duplicate messages: %v", err)
+		} else if err == nil && last == hashMessage(body) {
+			return &rejection{
+				reason:  ReasonDuplicate,
+				message: "You just sent the same message",
+			}
+		}
+	}
+
+	if f.MessageLimit > 0 {
+		key := fmt.Sprintf("chat_send:%d:%s", sender.ID, conversation)
+		result, err := f.Limits.Allow(ctx, key, f.MessageLimit, f.MessageWindow)
+		if err != nil {
+			logger.Warnf("Could not check message rate limit: %v", err)
+		} else if !result.Allowed {
+			return &rejection{
+				reason:     ReasonRateLimited,
+				message:    "You are sending messages too fast",
+				retryAfter: result.RetryAfter,
+			}
+		}
+	}
+
+	// Slow mode is checked last, so that a rejected message does not start the interval
+	if room != nil && room.SlowModeSeconds > 0 && !exemptFromSlowMode(sender, member) {
+		// A bucket of one token that refills over the interval allows one message per interval
+		key := fmt.Sprintf("chat_slow:%d:%d", room.ID, sender.ID)
+		result, err := f.Limits.Allow(ctx, key, 1, room.SlowMode())
+		if err != nil {
+			logger.Warnf("Could not check slow mode: %v", err)
+		} else if !result.Allowed {
+			return &rejection{
+				reason:     ReasonSlowMode,
+				message:    fmt.Sprintf("Slow mode is on: you can send one message every %d seconds", room.SlowModeSeconds),
+				retryAfter: result.RetryAfter,
+			}
+		}
+	}
+	return nil
+}
+
+// record remembers a message that was sent, for duplicate detection.
+func (f *FloodControl) record(ctx context.Context, sender *models.User, conversation, body string) {
+	if f.DuplicateWindow <= 0 {
+		return
+	}
+	if err := f.Recent.Set(ctx, lastMessageKey(sender.ID, conversation), hashMessage(body), f.DuplicateWindow).Err(); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"user":         sender.Username,
+			"conversation": conversation,
+		}).Warnf("Could not record message for duplicate detection: %v", err)
+	}
+}
+
+// exemptFromSlowMode reports whether the user may ignore a room's slow mode: room owners
+// and moderators, and global moderators, may.
+func exemptFromSlowMode(user *models.User, member *models.RoomMember) bool {
+	return user.HasRole(models.RoleModerator) || (member != nil && member.CanModerate())
+}
+
+// lastMessageKey returns the key of the last message a user sent to a conversation.
+func lastMessageKey(userID uint, conversation string) string {
+	return fmt.Sprintf("%s%d:%s", lastMessagePrefix, userID, conversation)
+}
+
+// hashMessage hashes a message body for duplicate detection. Case and whitespace are ignored,
+// so that trivial variations of a message count as duplicates.
+func hashMessage(body string) string {
+	normalized := strings.ToLower(strings.Join(strings.Fields(body), " "))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// reject responds to a message rejected by flood control, and sends the sender a MessageRejected
+// event, so that clients streaming events can show the error next to the conversation.
+func (h *Handler) reject(w http.ResponseWriter, r *http.Request, sender *models.User, req SendMessageRequest, rejected *rejection) {
+	retryAfter := int(math.Ceil(rejected.retryAfter.Seconds()))
+	if rejected.reason != ReasonDuplicate && retryAfter < 1 {
+		retryAfter = 1
+	}
+
+	data := events.MessageRejection{Reason: rejected.reason, Message: rejected.message, RetryAfter: retryAfter}
+	if req.RoomID != 0 {
+		data.RoomID = &req.RoomID
+	} else {
+		data.RecipientID = &req.RecipientID
+	}
+	h.publish(r, events.Event{
+		Type:       events.MessageRejected,
+		Data:       data,
+		ActorID:    sender.ID,
+		Recipients: []uint{sender.ID},
+	})
+
+	// The rejection reasons double as error codes
+	if rejected.reason == ReasonDuplicate {
+		errors.RespondWithError(w, errors.New(http.StatusConflict, ReasonDuplicate, rejected.message))
+		return
+	}
+	errors.RespondWithError(w, errors.RateLimited(rejected.reason, rejected.message, retryAfter))
+}
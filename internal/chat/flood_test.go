@@ -0,0 +1,113 @@
+package chat
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pageza/chat-app/internal/events"
+	"github.com/pageza/chat-app/internal/memory"
+	"github.com/pageza/chat-app/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingPublisher is an events.Publisher that keeps the published events.
+type recordingPublisher []events.Event
+
+func (p *recordingPublisher) Publish(ctx context.Context, event events.Event) error {
+	*p = append(*p, event)
+	return nil
+}
+
+func newFloodControl() *FloodControl {
+	return &FloodControl{
+		Limits:          memory.NewRateLimiter(),
+		Recent:          memory.NewClient(),
+		MessageLimit:    2,
+		MessageWindow:   time.Minute,
+		DuplicateWindow: time.Minute,
+	}
+}
+
+func TestFloodControl(t *testing.T) {
+	ctx := context.Background()
+	sender := &models.User{ID: 1, Username: "alice"}
+
+	t.Run("Messages over the rate limit are rejected per conversation", func(t *testing.T) {
+		f := newFloodControl()
+		assert.Nil(t, f.check(ctx, sender, "room:1", nil, nil, "one"))
+		assert.Nil(t, f.check(ctx, sender, "room:1", nil, nil, "two"))
+		rejected := f.check(ctx, sender, "room:1", nil, nil, "three")
+		require.NotNil(t, rejected)
+		assert.Equal(t, ReasonRateLimited, rejected.reason)
+		assert.True(t, rejected.retryAfter > 0)
+		assert.Nil(t, f.check(ctx, sender, "room:2", nil, nil, "three"))
+	})
+
+	t.Run("Duplicates are rejected, ignoring case and whitespace", func(t *testing.T) {
+		f := newFloodControl()
+		f.record(ctx, sender, "user:2", "Buy  cheap stuff")
+		rejected := f.check(ctx, sender, "user:2", nil, nil, "buy cheap STUFF ")
+		require.NotNil(t, rejected)
+		assert.Equal(t, ReasonDuplicate, rejected.reason)
+		assert.Nil(t, f.check(ctx, sender, "user:3", nil, nil, "buy cheap stuff"))
+	})
+
+	t.Run("Slow mode allows one message per interval, except for moderators", func(t *testing.T) {
+		f := newFloodControl()
+		f.MessageLimit = 0
+		room := &models.Room{ID: 7, SlowModeSeconds: 30}
+		member := &models.RoomMember{RoomID: 7, UserID: sender.ID, Role: models.RoomRoleMember}
+		assert.Nil(t, f.check(ctx, sender, "room:7", room, member, "first"))
+		rejected := f.check(ctx, sender, "room:7", room, member, "second")
+		require.NotNil(t, rejected)
+		assert.Equal(t, ReasonSlowMode, rejected.reason)
+		assert.InDelta(t, 30*time.Second, rejected.retryAfter, float64(time.Second))
+
+		moderator := &models.RoomMember{RoomID: 7, UserID: sender.ID, Role: models.RoomRoleModerator}
+		assert.Nil(t, f.check(ctx, sender, "room:7", room, moderator, "second"))
+	})
+
+	t.Run("Messages rejected by other rules do not start the slow mode interval", func(t *testing.T) {
+		f := newFloodControl()
+		f.MessageLimit = 1
+		room := &models.Room{ID: 8, SlowModeSeconds: 30}
+		member := &models.RoomMember{RoomID: 8, UserID: sender.ID, Role: models.RoomRoleMember}
+		assert.Nil(t, f.check(ctx, sender, "room:8", nil, nil, "first"))
+		rejected := f.check(ctx, sender, "room:8", room, member, "second")
+		require.NotNil(t, rejected)
+		assert.Equal(t, ReasonRateLimited, rejected.reason)
+
+		result, err := f.Limits.Allow(ctx, "chat_slow:8:1", 1, room.SlowMode())
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+	})
+}
+
+func TestRejectSendsEvent(t *testing.T) {
+	publisher := &recordingPublisher{}
+	h := &Handler{Events: publisher}
+	sender := &models.User{ID: 1, Username: "alice"}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/send", nil)
+	h.reject(rr, req, sender, SendMessageRequest{RoomID: 7}, &rejection{
+		reason:     ReasonSlowMode,
+		message:    "Slow mode is on",
+		retryAfter: 1500 * time.Millisecond,
+	})
+
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "2", rr.Header().Get("Retry-After"))
+	require.Len(t, *publisher, 1)
+	event := (*publisher)[0]
+	assert.Equal(t, events.MessageRejected, event.Type)
+	assert.Equal(t, []uint{sender.ID}, event.Recipients)
+	data := event.Data.(events.MessageRejection)
+	assert.Equal(t, ReasonSlowMode, data.Reason)
+	assert.Equal(t, uint(7), *data.RoomID)
+	assert.Equal(t, 2, data.RetryAfter)
+}
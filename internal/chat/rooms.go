@@ -0,0 +1,266 @@
+// Package chat provides chat-related functionalities for the chat application.
+// This file specifically includes the rooms, their membership and their settings.
+package chat
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pageza/chat-app/internal/errors"
+	"github.com/pageza/chat-app/internal/middleware"
+	"github.com/pageza/chat-app/internal/models"
+	"github.com/pageza/chat-app/internal/utils"
+	"gorm.io/gorm"
+)
+
+// CreateRoomRequest is the payload of a room creation request.
+type CreateRoomRequest struct {
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	VerifiedOnly bool   `json:"verified_only"`
+}
+
+// UpdateRoomRequest is the payload of a room settings update. Fields that are omitted are left unchanged.
+type UpdateRoomRequest struct {
+	Description     *string `json:"description"`
+	VerifiedOnly    *bool   `json:"verified_only"`
+	SlowModeSeconds *int    `json:"slow_mode_seconds"` // Zero turns slow mode off
+}
+
+// ChatHandler lists the chat rooms.
+func (h *Handler) ChatHandler(w http.ResponseWriter, r *http.Request) {
+	rooms, err := h.Store.ListRooms()
+	if err != nil {
+		h.internalError(w, r, "Could not list rooms", err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, rooms)
+}
+
+// CreateRoomHandler creates a chat room, with the logged-in user as its owner.
+// Only users who may take part in a verified-only room can create one.
+func (h *Handler) CreateRoomHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.CurrentUser(r)
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+
+	var req CreateRoomRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid request payload"))
+		return
+	}
+
+	room := &models.Room{
+		Name:         strings.ToLower(strings.TrimSpace(req.Name)),
+		Description:  strings.TrimSpace(req.Description),
+		VerifiedOnly: req.VerifiedOnly,
+		CreatedByID:  user.ID,
+	}
+	if !validateRoom(w, room) {
+		return
+	}
+	if err := room.CheckAccess(user); err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusForbidden, "Only verified veterans can create verified-only rooms"))
+		return
+	}
+
+	if _, err := h.Store.GetRoomByName(room.Name); err == nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusConflict, "A room with this name already exists"))
+		return
+	} else if err != gorm.ErrRecordNotFound {
+		h.internalError(w, r, "Could not create room", err)
+		return
+	}
+
+	if err := h.Store.CreateRoom(room); err != nil {
+		h.internalError(w, r, "Could not create room", err)
+		return
+	}
+	if err := h.Store.AddRoomMember(&models.RoomMember{RoomID: room.ID, UserID: user.ID, Role: models.RoomRoleOwner}); err != nil {
+		h.internalError(w, r, "Could not create room", err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusCreated, room)
+}
+
+// UpdateRoomHandler changes a room's settings. Room owners and moderators, as well as global
+// moderators, may change them.
+func (h *Handler) UpdateRoomHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.CurrentUser(r)
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+	room, ok := h.loadRoom(w, r)
+	if !ok {
+		return
+	}
+	if !h.canModerate(w, r, user, room) {
+		return
+	}
+
+	var req UpdateRoomRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid request payload"))
+		return
+	}
+	if req.Description != nil {
+		room.Description = strings.TrimSpace(*req.Description)
+	}
+	if req.VerifiedOnly != nil {
+		room.VerifiedOnly = *req.VerifiedOnly
+	}
+	if req.SlowModeSeconds != nil {
+		room.SlowModeSeconds = *req.SlowModeSeconds
+	}
+	if !validateRoom(w, room) {
+		return
+	}
+	// Don't let moderators lock themselves out of their room
+	if err := room.CheckAccess(user); err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusForbidden, "Only verified veterans can make a room verified-only"))
+		return
+	}
+
+	if err := h.Store.UpdateRoom(room); err != nil {
+		h.internalError(w, r, "Could not update room", err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, room)
+}
+
+// JoinRoomHandler adds the logged-in user to a room. Verified-only rooms can only be joined by verified veterans.
+func (h *Handler) JoinRoomHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.CurrentUser(r)
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+	room, ok := h.loadRoom(w, r)
+	if !ok {
+		return
+	}
+	if err := room.CheckAccess(user); err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusForbidden, "This room is for verified veterans only"))
+		return
+	}
+
+	if err := h.Store.AddRoomMember(&models.RoomMember{RoomID: room.ID, UserID: user.ID, Role: models.RoomRoleMember}); err != nil {
+		h.internalError(w, r, "Could not join room", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LeaveRoomHandler removes the logged-in user from a room.
+func (h *Handler) LeaveRoomHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.CurrentUser(r)
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+	room, ok := h.loadRoom(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.Store.RemoveRoomMember(room.ID, user.ID)
+	if err == gorm.ErrRecordNotFound {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusNotFound, "You are not a member of this room"))
+		return
+	}
+	if err != nil {
+		h.internalError(w, r, "Could not leave room", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadRoom loads the room identified by the {id} route variable.
+// It writes an error response and returns false if the room does not exist.
+func (h *Handler) loadRoom(w http.ResponseWriter, r *http.Request) (*models.Room, bool) {
+	roomID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid room ID"))
+		return nil, false
+	}
+	room, err := h.Store.GetRoomByID(uint(roomID))
+	if err == gorm.ErrRecordNotFound {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusNotFound, "Room not found"))
+		return nil, false
+	}
+	if err != nil {
+		h.internalError(w, r, "Could not load room", err)
+		return nil, false
+	}
+	return room, true
+}
+
+// roomAccess loads a room and the user's membership, and checks that the user is a member who may take part in it.
+// It writes an error response and returns false if the user may not.
+func (h *Handler) roomAccess(w http.ResponseWriter, r *http.Request, user *models.User, roomID uint) (*models.Room, *models.RoomMember, bool) {
+	room, err := h.Store.GetRoomByID(roomID)
+	if err == gorm.ErrRecordNotFound {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusNotFound, "Room not found"))
+		return nil, nil, false
+	}
+	if err != nil {
+		h.internalError(w, r, "Could not load room", err)
+		return nil, nil, false
+	}
+
+	member, err := h.Store.GetRoomMember(room.ID, user.ID)
+	if err == gorm.ErrRecordNotFound {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusForbidden, "You are not a member of this room"))
+		return nil, nil, false
+	}
+	if err != nil {
+		h.internalError(w, r, "Could not load room", err)
+		return nil, nil, false
+	}
+	if err := room.CheckAccess(user); err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusForbidden, "This room is for verified veterans only"))
+		return nil, nil, false
+	}
+	return room, member, true
+}
+
+// canModerate checks that the user may change the room's settings: room owners and moderators,
+// and global moderators, may. It writes an error response and returns false if the user may not.
+func (h *Handler) canModerate(w http.ResponseWriter, r *http.Request, user *models.User, room *models.Room) bool {
+	if user.HasRole(models.RoleModerator) {
+		return true
+	}
+	member, err := h.Store.GetRoomMember(room.ID, user.ID)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		h.internalError(w, r, "Could not load room", err)
+		return false
+	}
+	if err == gorm.ErrRecordNotFound || !member.CanModerate() || room.CheckAccess(user) != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusForbidden, "Only room moderators can change the room's settings"))
+		return false
+	}
+	return true
+}
+
+// validateRoom checks the room's fields, responding with a ValidationError and returning false if they are invalid.
+func validateRoom(w http.ResponseWriter, room *models.Room) bool {
+	problems := room.Validate()
+	if problems == nil {
+		return true
+	}
+
+	fields := make([]errors.FieldError, 0, len(problems))
+	for _, field := range []string{"name", "description", "slow_mode_seconds"} {
+		if problem, ok := problems[field]; ok {
+			fields = append(fields, errors.FieldError{Field: field, Message: problem})
+		}
+	}
+	errors.RespondWithError(w, errors.Validation(fields...))
+	return false
+}
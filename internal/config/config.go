@@ -1,89 +1,332 @@
+// Package config contains configuration settings and initializers for the chat application.
+// This file specifically defines the settings, their defaults and their validation.
+
 package config
 
 import (
+	"errors"
 	"fmt"
-	"os"
+	"net/http"
 	"time"
-
-	"github.com/joho/godotenv"
-	"github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
 )
 
-// Configuration variables
-var (
-	JwtSecret          string
-	JwtIssuer          string
-	RedisAddr          string
-	TokenExpiration    string
-	CorsAllowedOrigins []string
-	CorsAllowedMethods []string
-	CorsAllowedHeaders []string
-	ServerPort         string
-	PostgreDSN         string
-)
+// Config holds the application's settings. Every setting has a key, such as TOKEN_EXPIRATION, which
+// names it in the configuration file, as an environment variable and, in lower case with dashes,
+// as a command-line flag (--token-expiration). See Load for how the sources are combined.
+//
+// The sections are only there to group the settings: their keys are not prefixed by the section.
+type Config struct {
+	Server    ServerConfig     `mapstructure:",squash"`
+	Database  DatabaseConfig   `mapstructure:",squash"`
+	Redis     RedisConfig      `mapstructure:",squash"`
+	JWT       JWTConfig        `mapstructure:",squash"`
+	CORS      CORSConfig       `mapstructure:",squash"`
+	Cookies   CookieConfig     `mapstructure:",squash"`
+	Security  SecurityConfig   `mapstructure:",squash"`
+	Mail      MailConfig       `mapstructure:",squash"`
+	Auth      AuthConfig       `mapstructure:",squash"`
+	WebAuthn  WebAuthnConfig   `mapstructure:",squash"`
+	OIDC      OIDCConfig       `mapstructure:",squash"`
+	Storage   StorageConfig    `mapstructure:",squash"`
+	Account   AccountConfig    `mapstructure:",squash"`
+	RateLimit RateLimitsConfig `mapstructure:",squash"`
+	Chat      ChatConfig       `mapstructure:",squash"`
+}
 
-// Initialize sets up the application's configuration.
-func Initialize() {
-	// Debugging: Print the current working directory
-	dir, err := os.Getwd()
-	if err != nil {
-		logrus.Fatal("Getting working directory failed:", err)
-	}
-	fmt.Println("Current directory:", dir)
+// ServerConfig holds the HTTP server and request handling settings.
+type ServerConfig struct {
+	Port                string        `mapstructure:"SERVER_PORT"`
+	RequestTimeout      time.Duration `mapstructure:"REQUEST_TIMEOUT"`        // Maximum time to handle a request; zero disables the timeout
+	TimeoutExemptPaths  []string      `mapstructure:"TIMEOUT_EXEMPT_PATHS"`   // Path prefixes of long-lived routes without a timeout, such as the event stream
+	MaxRequestBodyBytes int64         `mapstructure:"MAX_REQUEST_BODY_BYTES"` // Maximum size of request bodies, except uploads; zero for no limit
+	IdempotencyKeyTTL   time.Duration `mapstructure:"IDEMPOTENCY_KEY_TTL"`    // How long responses are kept for retries with the same Idempotency-Key
+	TrustedProxies      []string      `mapstructure:"TRUSTED_PROXIES"`        // IP addresses or CIDR ranges of the reverse proxies in front of the server
 
-	// Load the .env file for sensitive variables
-	// Using a relative path
-	err = godotenv.Load("/workspaces/chat-app/.env") // Adjust the path as needed
-	if err != nil {
-		logrus.Fatal("Error loading .env file:", err)
-	}
+	// Legacy unversioned API routes, announced as deprecated in favor of /api/v1 (RFC 3339 times)
+	LegacyAPIDeprecatedAt time.Time `mapstructure:"LEGACY_API_DEPRECATED_AT"`
+	LegacyAPISunset       time.Time `mapstructure:"LEGACY_API_SUNSET"` // When the legacy routes will be removed; zero if not decided yet
+}
+
+// DatabaseConfig holds the database connection settings.
+type DatabaseConfig struct {
+	PostgresDSN string `mapstructure:"POSTGRE_DSN"`
+}
+
+// RedisConfig holds the Redis connection settings. Without an address, in-process stores are used
+// instead, which only suit a single server instance.
+type RedisConfig struct {
+	Addr string `mapstructure:"REDIS_ADDR"`
+
+	// Behavior when Redis is unavailable: fail open lets requests through unchecked, fail closed rejects them.
+	RateLimitFailOpen       bool `mapstructure:"RATE_LIMIT_FAIL_OPEN"`
+	TokenRevocationFailOpen bool `mapstructure:"TOKEN_REVOCATION_FAIL_OPEN"`
+}
+
+// JWTConfig holds the token signing settings.
+type JWTConfig struct {
+	Secret          string        `mapstructure:"JWT_SECRET"`
+	Issuer          string        `mapstructure:"JWT_ISSUER"`
+	TokenExpiration time.Duration `mapstructure:"TOKEN_EXPIRATION"` // Lifetime of access tokens
+
+	// Signing keys: HS256 signs with the secret; RS256 and EdDSA keys are loaded from KeyDir and reloaded
+	// every KeyRotationInterval, or, if EphemeralKeys is set, generated in memory and replaced every
+	// KeyRotationInterval. Generated keys are lost on restart and not shared between instances.
+	SigningAlg          string        `mapstructure:"JWT_SIGNING_ALG"`
+	KeyDir              string        `mapstructure:"JWT_KEY_DIR"`
+	EphemeralKeys       bool          `mapstructure:"JWT_EPHEMERAL_KEYS"`
+	ActiveKeyID         string        `mapstructure:"JWT_ACTIVE_KEY_ID"`
+	KeyRotationInterval time.Duration `mapstructure:"JWT_KEY_ROTATION_INTERVAL"` // Zero disables reloading and rotation
+	KeyRetention        time.Duration `mapstructure:"JWT_KEY_RETENTION"`         // How long retired keys still verify tokens
+}
+
+// CORSConfig holds the cross-origin request settings.
+type CORSConfig struct {
+	AllowedOrigins []string `mapstructure:"CORS_ALLOWED_ORIGINS"`
+	AllowedMethods []string `mapstructure:"CORS_ALLOWED_METHODS"`
+	AllowedHeaders []string `mapstructure:"CORS_ALLOWED_HEADERS"`
+}
+
+// CookieConfig holds the attributes of the session cookies.
+type CookieConfig struct {
+	Secure   bool          `mapstructure:"COOKIE_SECURE"`
+	SameSite http.SameSite `mapstructure:"COOKIE_SAME_SITE"` // "lax", "strict" or "none"
+	Path     string        `mapstructure:"COOKIE_PATH"`
+}
+
+// SecurityConfig holds the security header and CSRF protection settings.
+type SecurityConfig struct {
+	HSTSMaxAge            time.Duration `mapstructure:"HSTS_MAX_AGE"` // Zero disables Strict-Transport-Security
+	HSTSIncludeSubdomains bool          `mapstructure:"HSTS_INCLUDE_SUBDOMAINS"`
+	FrameOptions          string        `mapstructure:"FRAME_OPTIONS"` // X-Frame-Options value, "DENY" or "SAMEORIGIN"
+	ReferrerPolicy        string        `mapstructure:"REFERRER_POLICY"`
+	PermissionsPolicy     string        `mapstructure:"PERMISSIONS_POLICY"`
+	ContentSecurityPolicy string        `mapstructure:"CONTENT_SECURITY_POLICY"` // Occurrences of {nonce} are replaced by a random nonce per request
+	CSPReportOnly         bool          `mapstructure:"CSP_REPORT_ONLY"`         // Send the policy as Content-Security-Policy-Report-Only to try it out
+
+	CSRFSecret string `mapstructure:"CSRF_SECRET"` // Signs CSRF tokens; defaults to JWT_SECRET
+}
+
+// MailConfig holds the outgoing mail settings. Without a host, emails are logged instead of sent.
+type MailConfig struct {
+	SMTPHost     string `mapstructure:"SMTP_HOST"`
+	SMTPPort     string `mapstructure:"SMTP_PORT"`
+	SMTPUsername string `mapstructure:"SMTP_USERNAME"`
+	SMTPPassword string `mapstructure:"SMTP_PASSWORD"`
+	SMTPFrom     string `mapstructure:"SMTP_FROM"`
+}
+
+// AuthConfig holds the password reset and two-factor authentication settings.
+type AuthConfig struct {
+	PasswordResetExpiration time.Duration `mapstructure:"PASSWORD_RESET_EXPIRATION"`
+	PasswordResetURL        string        `mapstructure:"PASSWORD_RESET_URL"` // Page of the frontend where users choose a new password
+	TOTPIssuer              string        `mapstructure:"TOTP_ISSUER"`
+}
+
+// WebAuthnConfig holds the WebAuthn (passkey) relying party settings. Without an RP ID, passkey login is disabled.
+type WebAuthnConfig struct {
+	RPID          string   `mapstructure:"WEBAUTHN_RP_ID"`
+	RPDisplayName string   `mapstructure:"WEBAUTHN_RP_DISPLAY_NAME"` // Defaults to TOTP_ISSUER
+	RPOrigins     []string `mapstructure:"WEBAUTHN_RP_ORIGINS"`
+}
 
-	// Set the path for the config file
-	// Using a relative path
-	viper.SetConfigFile("/workspaces/chat-app/internal/config/config.yaml") // Adjust the path as needed
+// OIDCConfig holds the OpenID Connect login settings. Providers can only be set in the configuration file.
+type OIDCConfig struct {
+	Providers         []OIDCProviderConfig `mapstructure:"OIDC_PROVIDERS"`
+	PostLoginRedirect string               `mapstructure:"OIDC_POST_LOGIN_REDIRECT"`
+}
+
+// StorageConfig holds the uploaded file storage settings.
+type StorageConfig struct {
+	Dir     string `mapstructure:"STORAGE_DIR"`
+	BaseURL string `mapstructure:"STORAGE_BASE_URL"`
+}
+
+// AccountConfig holds the account deletion and data export settings.
+type AccountConfig struct {
+	DeletionGracePeriod time.Duration `mapstructure:"ACCOUNT_DELETION_GRACE_PERIOD"`
+	DataExportDir       string        `mapstructure:"DATA_EXPORT_DIR"` // Kept out of the publicly served storage directory
+	DataExportRetention time.Duration `mapstructure:"DATA_EXPORT_RETENTION"`
+}
+
+// RateLimitsConfig holds the rate limits of the route groups. They can only be set in the configuration file.
+type RateLimitsConfig struct {
+	Groups []RateLimitConfig `mapstructure:"RATE_LIMITS"`
+}
+
+// ChatConfig holds the chat flood control settings.
+type ChatConfig struct {
+	MessageRateLimit  int           `mapstructure:"CHAT_MESSAGE_RATE_LIMIT"`  // Messages a user may send to a room or user per window; zero for no limit
+	MessageRateWindow time.Duration `mapstructure:"CHAT_MESSAGE_RATE_WINDOW"` // e.g. "1s"
+	DuplicateWindow   time.Duration `mapstructure:"CHAT_DUPLICATE_WINDOW"`    // Identical messages to the same room or user are rejected within this time
+}
+
+// RateLimitConfig describes the rate limits of a group of routes. Requests are counted per client IP
+// address, and requests authenticated as a user are counted per user as well. A limit of zero disables
+// that count. The group named "default" applies to the routes that don't belong to any other group.
+type RateLimitConfig struct {
+	Name      string   `mapstructure:"name"`
+	Paths     []string `mapstructure:"paths"`      // Path prefixes of the routes in the group
+	IPLimit   int      `mapstructure:"ip_limit"`   // Requests allowed per client IP address and window
+	UserLimit int      `mapstructure:"user_limit"` // Requests allowed per user and window
+	Window    string   `mapstructure:"window"`     // Duration over which the limits apply, e.g. "1m"
+}
+
+// DefaultRateLimits are used when no rate limits are configured.
+var DefaultRateLimits = []RateLimitConfig{
+	{
+		Name:    "auth",
+		Paths:   []string{"/login", "/register", "/password/forgot", "/password/reset", "/webauthn/login", "/oidc"},
+		IPLimit: 10,
+		Window:  "1m",
+	},
+	{Name: "default", IPLimit: 300, UserLimit: 120, Window: "1m"},
+}
+
+// DefaultLegacyAPIDeprecatedAt is when the unversioned routes were deprecated, as the API moved under /api/v1.
+var DefaultLegacyAPIDeprecatedAt = time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
 
-	// Read the config file
-	if err := viper.ReadInConfig(); err != nil {
-		logrus.Fatalf("Error reading config file: %s", err)
+// OIDCProviderConfig describes an OpenID Connect identity provider, such as ID.me.
+// Client secrets are read from the OIDC_<NAME>_CLIENT_SECRET environment variable.
+type OIDCProviderConfig struct {
+	Name         string   `mapstructure:"name"`          // Used in the login and callback URLs
+	Issuer       string   `mapstructure:"issuer"`        // Issuer URL used for discovery
+	ClientID     string   `mapstructure:"client_id"`     // OAuth2 client ID
+	ClientSecret string   `mapstructure:"client_secret"` // OAuth2 client secret
+	RedirectURL  string   `mapstructure:"redirect_url"`  // Absolute URL of the callback route
+	Scopes       []string `mapstructure:"scopes"`        // Scopes to request in addition to "openid"
+	LinkByEmail  bool     `mapstructure:"link_by_email"` // Link to an existing account with the same verified email
+
+	// VeteranClaim is the ID token claim asserting veteran status, and VeteranValues are
+	// the claim values that count as a veteran. A boolean claim only needs to be true.
+	VeteranClaim  string   `mapstructure:"veteran_claim"`
+	VeteranValues []string `mapstructure:"veteran_values"`
+}
+
+// Default returns the settings used when no source sets them.
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Port:           "8080",
+			RequestTimeout: 30 * time.Second,
+			// Events are streamed, and export archives can take a while to download
+			TimeoutExemptPaths:    []string{"/events", "/me/exports"},
+			MaxRequestBodyBytes:   1 << 20, // 1 MiB
+			IdempotencyKeyTTL:     24 * time.Hour,
+			LegacyAPIDeprecatedAt: DefaultLegacyAPIDeprecatedAt,
+		},
+		JWT: JWTConfig{
+			TokenExpiration: 2 * time.Hour,
+			SigningAlg:      "HS256",
+			KeyRetention:    48 * time.Hour, // Longer than the refresh token lifetime
+		},
+		// Session cookies are only sent over HTTPS unless turned off for local development
+		Cookies: CookieConfig{Secure: true, SameSite: http.SameSiteLaxMode, Path: "/"},
+		Security: SecurityConfig{
+			HSTSMaxAge:            365 * 24 * time.Hour,
+			FrameOptions:          "DENY",
+			ReferrerPolicy:        "strict-origin-when-cross-origin",
+			PermissionsPolicy:     "camera=(), microphone=(), geolocation=(), payment=(), usb=()",
+			ContentSecurityPolicy: DefaultContentSecurityPolicy,
+		},
+		Mail: MailConfig{SMTPPort: "587"},
+		// Password reset tokens are short-lived
+		Auth: AuthConfig{PasswordResetExpiration: 15 * time.Minute, TOTPIssuer: "Chat App"},
+		OIDC: OIDCConfig{PostLoginRedirect: "/"},
+		// Uploaded files are stored on the local disk and served by the application
+		Storage: StorageConfig{Dir: "./data/media", BaseURL: "/media"},
+		Account: AccountConfig{
+			DeletionGracePeriod: 30 * 24 * time.Hour,
+			DataExportDir:       "./data/exports",
+			DataExportRetention: 7 * 24 * time.Hour,
+		},
+		RateLimit: RateLimitsConfig{Groups: DefaultRateLimits},
+		// Users may send five messages per second to each room or user
+		Chat: ChatConfig{MessageRateLimit: 5, MessageRateWindow: time.Second, DuplicateWindow: 30 * time.Second},
 	}
+}
 
-	// Debugging: Print all settings
-	// fmt.Println("All settings:", viper.AllSettings())
+// Validate checks the settings, reporting every invalid setting at once.
+func (c *Config) Validate() error {
+	var problems []error
+	problem := func(key, format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
 
-	// Read sensitive variables from environment
-	JwtSecret = os.Getenv("JWT_SECRET")
-	JwtIssuer = os.Getenv("JWT_ISSUER")
-	PostgreDSN = os.Getenv("POSTGRE_DSN")
-	// fmt.Println("JWT_SECRET:", os.Getenv("JWT_SECRET"))
-	// fmt.Println("JWT_ISSUER:", os.Getenv("JWT_ISSUER"))
-	// fmt.Println("POSTGRE_DSN:", os.Getenv("POSTGRE_DSN"))
+	required := []struct{ key, value string }{
+		{"JWT_ISSUER", c.JWT.Issuer},
+		{"POSTGRE_DSN", c.Database.PostgresDSN},
+	}
+	switch c.JWT.SigningAlg {
+	case "RS256", "EdDSA":
+		// Tokens are signed with the keys, so there is no JWT secret for CSRF tokens to default to
+		required = append(required, struct{ key, value string }{"CSRF_SECRET", c.Security.CSRFSecret})
+		if c.JWT.KeyDir == "" && !c.JWT.EphemeralKeys {
+			problem("JWT_KEY_DIR", "must be set for %s, or set JWT_EPHEMERAL_KEYS to generate keys that are lost on restart", c.JWT.SigningAlg)
+		}
+		if c.JWT.KeyDir != "" && c.JWT.EphemeralKeys {
+			problem("JWT_EPHEMERAL_KEYS", "cannot be used with JWT_KEY_DIR")
+		}
+	case "HS256":
+		required = append(required, struct{ key, value string }{"JWT_SECRET", c.JWT.Secret})
+	default:
+		problem("JWT_SIGNING_ALG", "must be HS256, RS256 or EdDSA, not %q", c.JWT.SigningAlg)
+		required = append(required, struct{ key, value string }{"JWT_SECRET", c.JWT.Secret})
+	}
+	for _, setting := range required {
+		if setting.value == "" {
+			problem(setting.key, "must be set")
+		}
+	}
 
-	// Check if sensitive environment variables are set
-	if JwtSecret == "" || JwtIssuer == "" || PostgreDSN == "" {
-		logrus.Fatal("Sensitive environment variables are not set")
+	// Some durations may be zero to disable what they limit, the others must be positive
+	durations := []struct {
+		key       string
+		value     time.Duration
+		allowZero bool
+	}{
+		{"TOKEN_EXPIRATION", c.JWT.TokenExpiration, false},
+		{"PASSWORD_RESET_EXPIRATION", c.Auth.PasswordResetExpiration, false},
+		{"IDEMPOTENCY_KEY_TTL", c.Server.IdempotencyKeyTTL, false},
+		{"CHAT_MESSAGE_RATE_WINDOW", c.Chat.MessageRateWindow, false},
+		{"REQUEST_TIMEOUT", c.Server.RequestTimeout, true},
+		{"JWT_KEY_ROTATION_INTERVAL", c.JWT.KeyRotationInterval, true},
+		{"JWT_KEY_RETENTION", c.JWT.KeyRetention, true},
+		{"HSTS_MAX_AGE", c.Security.HSTSMaxAge, true},
+		{"ACCOUNT_DELETION_GRACE_PERIOD", c.Account.DeletionGracePeriod, true},
+		{"DATA_EXPORT_RETENTION", c.Account.DataExportRetention, true},
+		{"CHAT_DUPLICATE_WINDOW", c.Chat.DuplicateWindow, true},
+	}
+	for _, d := range durations {
+		if d.value < 0 || (d.value == 0 && !d.allowZero) {
+			problem(d.key, "must be a positive duration, not %s", d.value)
+		}
+	}
+	if c.Server.MaxRequestBodyBytes < 0 {
+		problem("MAX_REQUEST_BODY_BYTES", "must not be negative")
+	}
+	if c.Chat.MessageRateLimit < 0 {
+		problem("CHAT_MESSAGE_RATE_LIMIT", "must not be negative")
+	}
+	if !c.Server.LegacyAPISunset.IsZero() && c.Server.LegacyAPISunset.Before(c.Server.LegacyAPIDeprecatedAt) {
+		problem("LEGACY_API_SUNSET", "must not be before LEGACY_API_DEPRECATED_AT")
+	}
+	if c.Cookies.SameSite == http.SameSiteNoneMode && !c.Cookies.Secure {
+		problem("COOKIE_SAME_SITE", "SameSite=None cookies must be secure")
 	}
 
-	// Read configurations from config file
-	RedisAddr = viper.GetString("REDIS_ADDR")
-	TokenExpiration = viper.GetString("TOKEN_EXPIRATION")
-	CorsAllowedOrigins = viper.GetStringSlice("CORS_ALLOWED_ORIGINS")
-	CorsAllowedMethods = viper.GetStringSlice("CORS_ALLOWED_METHODS")
-	CorsAllowedHeaders = viper.GetStringSlice("CORS_ALLOWED_HEADERS")
-	ServerPort = viper.GetString("SERVER_PORT")
-	fmt.Println("te: ", TokenExpiration, "TE: ", viper.GetString("TOKEN_EXPIRATION"))
-	// Set default value if TokenExpiration is not set
-	if TokenExpiration == "" {
-		TokenExpiration = "2h" // Default value
+	for i, group := range c.RateLimit.Groups {
+		if window, err := time.ParseDuration(group.Window); err != nil || window <= 0 {
+			problem("RATE_LIMITS", "group %d (%q) needs a positive window, not %q", i, group.Name, group.Window)
+		}
+		if group.IPLimit < 0 || group.UserLimit < 0 {
+			problem("RATE_LIMITS", "group %d (%q) has a negative limit", i, group.Name)
+		}
 	}
-	fmt.Println("THIS IS THE EXPIRY", TokenExpiration)
-	// New debug statement to print the exact string being passed to ParseDuration
-	fmt.Println("Parsing duration from this exact string:", fmt.Sprintf("%q", TokenExpiration))
-	fmt.Println("Debug TokenExpiration in test:", TokenExpiration)
-	// Validate the token expiration duration
-	_, err = time.ParseDuration(TokenExpiration)
-	if err != nil {
-		fmt.Println(err)
-		logrus.Fatalf("Invalid token expiration duration config: %v", err)
+	for i, provider := range c.OIDC.Providers {
+		if provider.Name == "" || provider.Issuer == "" || provider.ClientID == "" {
+			problem("OIDC_PROVIDERS", "provider %d needs a name, an issuer and a client ID", i)
+		}
 	}
+
+	return errors.Join(problems...)
 }
@@ -7,16 +7,23 @@ import (
 	"github.com/rs/cors"
 )
 
-// InitializeCORS sets up and returns the CORS middleware.
-// It uses the configuration settings defined in the config package.
-func InitializeCORS() *cors.Cors {
+// InitializeCORS sets up and returns the CORS middleware from the CORS settings.
+func InitializeCORS(cfg CORSConfig) *cors.Cors {
+	// Cross-origin clients must be able to send the CSRF token header along with their cookies,
+	// and idempotency keys with requests they may retry
+	allowedHeaders := append([]string{"X-CSRF-Token", "Idempotency-Key"}, cfg.AllowedHeaders...)
+
+	// Besides the Authorization header, clients need the request ID for support requests,
+	// the rate limit headers to pace themselves, and whether a response was replayed for an idempotency key
+	exposedHeaders := []string{"Authorization", "X-Request-ID", "X-RateLimit-Limit", "X-RateLimit-Remaining", "Retry-After", "Idempotent-Replayed"}
+
 	// Create a new CORS middleware with specific options
 	return cors.New(cors.Options{
-		AllowedOrigins:   CorsAllowedOrigins,        // Only allow specific origins to access resources
-		AllowCredentials: true,                      // Allow cookies and authentication headers
-		AllowedMethods:   CorsAllowedMethods,        // Only allow specific HTTP methods (e.g., GET, POST)
-		AllowedHeaders:   CorsAllowedHeaders,        // Only allow specific HTTP headers
-		ExposedHeaders:   []string{"Authorization"}, // Explicitly expose the Authorization header to clients
-		MaxAge:           600,                       // Cache CORS preflight requests for 10 minutes
+		AllowedOrigins:   cfg.AllowedOrigins, // Only allow specific origins to access resources
+		AllowCredentials: true,               // Allow cookies and authentication headers
+		AllowedMethods:   cfg.AllowedMethods, // Only allow specific HTTP methods (e.g., GET, POST)
+		AllowedHeaders:   allowedHeaders,     // Only allow specific HTTP headers
+		ExposedHeaders:   exposedHeaders,     // Let clients read the headers they may need
+		MaxAge:           600,                // Cache CORS preflight requests for 10 minutes
 	})
 }
@@ -0,0 +1,152 @@
+// Package config contains configuration settings and initializers for the chat application.
+// This file specifically deals with loading the settings from their sources.
+
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// ConfigFileEnv is the environment variable naming the configuration file, when the --config flag is not given.
+const ConfigFileEnv = "CONFIG_FILE"
+
+// Load builds the configuration from its sources, each overriding the previous ones:
+//  1. the defaults (see Default)
+//  2. the configuration file given by the --config flag or the CONFIG_FILE environment variable, if any,
+//     in any format Viper reads, such as YAML
+//  3. environment variables
+//  4. command-line flags, parsed from args
+//
+// Lists are comma-separated in environment variables and flags. Rate limits and OIDC providers can only
+// be set in the configuration file. The configuration is validated before it is returned.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+	settings := keys(reflect.TypeOf(cfg))
+
+	flags := pflag.NewFlagSet("chat-app", pflag.ContinueOnError)
+	configFile := flags.String("config", "", "path of the configuration file (default $"+ConfigFileEnv+")")
+	for _, setting := range settings {
+		if !setting.flag {
+			continue
+		}
+		name := strings.ReplaceAll(strings.ToLower(setting.key), "_", "-")
+		if setting.kind == reflect.Bool {
+			flags.Bool(name, false, "sets "+setting.key)
+		} else {
+			flags.String(name, "", "sets "+setting.key)
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	v := viper.New()
+	if *configFile == "" {
+		*configFile = os.Getenv(ConfigFileEnv)
+	}
+	if *configFile != "" {
+		v.SetConfigFile(*configFile)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("reading configuration file: %w", err)
+		}
+	}
+	for _, setting := range settings {
+		if !setting.flag {
+			continue
+		}
+		if err := v.BindEnv(setting.key, setting.key); err != nil {
+			return nil, err
+		}
+		// Only flags given on the command line override the other sources
+		if flag := flags.Lookup(strings.ReplaceAll(strings.ToLower(setting.key), "_", "-")); flag.Changed {
+			v.Set(setting.key, flag.Value.String())
+		}
+	}
+
+	// Settings that no source sets keep their default. Lists replace the default rather than
+	// being merged into it.
+	err := v.Unmarshal(&cfg, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+		stringToTimeHook,
+		stringToSameSiteHook,
+	)), func(c *mapstructure.DecoderConfig) { c.ZeroFields = true })
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	// Keep client secrets out of the configuration file
+	for i := range cfg.OIDC.Providers {
+		secretEnv := "OIDC_" + strings.ToUpper(cfg.OIDC.Providers[i].Name) + "_CLIENT_SECRET"
+		if secret := os.Getenv(secretEnv); secret != "" {
+			cfg.OIDC.Providers[i].ClientSecret = secret
+		}
+	}
+	// Settings that default to other settings
+	if cfg.Security.CSRFSecret == "" {
+		cfg.Security.CSRFSecret = cfg.JWT.Secret
+	}
+	if cfg.WebAuthn.RPDisplayName == "" {
+		cfg.WebAuthn.RPDisplayName = cfg.Auth.TOTPIssuer
+	}
+	if len(cfg.RateLimit.Groups) == 0 {
+		cfg.RateLimit.Groups = DefaultRateLimits
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return &cfg, nil
+}
+
+// setting describes a setting found in the Config struct.
+type setting struct {
+	key  string
+	kind reflect.Kind
+	flag bool // Whether the setting can be set by environment variables and flags
+}
+
+// keys lists the settings of a configuration struct, descending into the squashed sections.
+func keys(t reflect.Type) []setting {
+	var settings []setting
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("mapstructure")
+		if strings.HasSuffix(tag, ",squash") {
+			settings = append(settings, keys(field.Type)...)
+			continue
+		}
+		// Lists of structs, such as the rate limits, don't fit in a single value
+		structList := field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct
+		settings = append(settings, setting{key: tag, kind: field.Type.Kind(), flag: !structList})
+	}
+	return settings
+}
+
+// stringToTimeHook decodes RFC 3339 times, leaving empty strings as the zero time.
+func stringToTimeHook(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() != reflect.String || to != reflect.TypeOf(time.Time{}) {
+		return data, nil
+	}
+	if data.(string) == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, data.(string))
+}
+
+// stringToSameSiteHook decodes SameSite cookie attributes with ParseSameSite.
+func stringToSameSiteHook(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() != reflect.String || to != reflect.TypeOf(http.SameSite(0)) {
+		return data, nil
+	}
+	return ParseSameSite(data.(string))
+}
@@ -0,0 +1,97 @@
+package config_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pageza/chat-app/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeConfigFile writes a configuration file to a temporary directory and returns its path.
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	t.Setenv(config.ConfigFileEnv, "")
+
+	t.Run("Sources override each other in order", func(t *testing.T) {
+		path := writeConfigFile(t, "JWT_SECRET: file-secret\n"+
+			"JWT_ISSUER: file-issuer\n"+
+			"POSTGRE_DSN: postgres://file\n"+
+			"TOKEN_EXPIRATION: 15m\n"+
+			"SERVER_PORT: \"9000\"\n"+
+			"CORS_ALLOWED_ORIGINS: [https://file.example]\n")
+		t.Setenv("JWT_ISSUER", "env-issuer")
+		t.Setenv("SERVER_PORT", "9001")
+		t.Setenv("CORS_ALLOWED_ORIGINS", "https://a.example,https://b.example")
+
+		cfg, err := config.Load([]string{"--config", path, "--server-port", "9002", "--cookie-secure=false"})
+		require.NoError(t, err)
+		assert.Equal(t, "file-secret", cfg.JWT.Secret)
+		assert.Equal(t, "env-issuer", cfg.JWT.Issuer)
+		assert.Equal(t, 15*time.Minute, cfg.JWT.TokenExpiration)
+		assert.Equal(t, "9002", cfg.Server.Port)
+		assert.Equal(t, []string{"https://a.example", "https://b.example"}, cfg.CORS.AllowedOrigins)
+		assert.False(t, cfg.Cookies.Secure)
+
+		// Settings that no source sets keep their default
+		assert.Equal(t, "HS256", cfg.JWT.SigningAlg)
+		assert.Equal(t, []string{"/events", "/me/exports"}, cfg.Server.TimeoutExemptPaths)
+		assert.Equal(t, http.SameSiteLaxMode, cfg.Cookies.SameSite)
+		assert.Equal(t, config.DefaultRateLimits, cfg.RateLimit.Groups)
+		assert.Equal(t, "file-secret", cfg.Security.CSRFSecret)
+	})
+
+	t.Run("The configuration file can be named by the environment", func(t *testing.T) {
+		t.Setenv(config.ConfigFileEnv, writeConfigFile(t, "JWT_SECRET: secret\n"+
+			"JWT_ISSUER: issuer\n"+
+			"POSTGRE_DSN: postgres://file\n"+
+			"RATE_LIMITS:\n"+
+			"  - {name: default, ip_limit: 10, window: 1m}\n"))
+
+		cfg, err := config.Load(nil)
+		require.NoError(t, err)
+		assert.Equal(t, []config.RateLimitConfig{{Name: "default", IPLimit: 10, Window: "1m"}}, cfg.RateLimit.Groups)
+	})
+
+	t.Run("Every invalid setting is reported", func(t *testing.T) {
+		_, err := config.Load([]string{"--jwt-signing-alg", "none", "--token-expiration", "0s", "--chat-message-rate-limit", "-1"})
+		require.Error(t, err)
+		for _, key := range []string{"JWT_SECRET", "JWT_ISSUER", "POSTGRE_DSN", "JWT_SIGNING_ALG", "TOKEN_EXPIRATION", "CHAT_MESSAGE_RATE_LIMIT"} {
+			assert.Contains(t, err.Error(), key+":")
+		}
+	})
+
+	t.Run("Asymmetric signing keys need no JWT secret", func(t *testing.T) {
+		base := []string{"--jwt-issuer", "issuer", "--postgre-dsn", "postgres://flags", "--jwt-signing-alg", "EdDSA"}
+
+		cfg, err := config.Load(append(base, "--jwt-key-dir", t.TempDir(), "--csrf-secret", "csrf"))
+		require.NoError(t, err)
+		assert.Empty(t, cfg.JWT.Secret)
+
+		_, err = config.Load(base)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "JWT_KEY_DIR:")
+		assert.Contains(t, err.Error(), "CSRF_SECRET:")
+		assert.NotContains(t, err.Error(), "JWT_SECRET:")
+
+		_, err = config.Load(append(base, "--jwt-ephemeral-keys", "--csrf-secret", "csrf"))
+		require.NoError(t, err)
+	})
+
+	t.Run("Malformed values are rejected", func(t *testing.T) {
+		t.Setenv("TOKEN_EXPIRATION", "two hours")
+		_, err := config.Load(nil)
+		require.Error(t, err)
+		assert.True(t, strings.Contains(err.Error(), "TOKEN_EXPIRATION"), err.Error())
+	})
+}
@@ -0,0 +1,29 @@
+// Package config contains configuration settings and initializers for the chat application.
+// This file specifically deals with the security headers and cookie attributes configuration.
+
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// DefaultContentSecurityPolicy is used when no Content-Security-Policy is configured. The API mostly
+// serves JSON, so the policy only lets pages load resources from the application itself, and scripts
+// and styles only if they carry the request's nonce.
+const DefaultContentSecurityPolicy = "default-src 'self'; script-src 'self' 'nonce-{nonce}'; style-src 'self' 'nonce-{nonce}'; " +
+	"img-src 'self' data:; connect-src 'self'; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
+// ParseSameSite parses a SameSite cookie attribute. An empty value defaults to Lax.
+func ParseSameSite(value string) (http.SameSite, error) {
+	switch strings.ToLower(value) {
+	case "", "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return 0, fmt.Errorf("invalid SameSite value %q", value)
+}
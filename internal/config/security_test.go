@@ -0,0 +1,19 @@
+package config_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/pageza/chat-app/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSameSite(t *testing.T) {
+	for value, want := range map[string]http.SameSite{"": http.SameSiteLaxMode, "Strict": http.SameSiteStrictMode, "none": http.SameSiteNoneMode} {
+		mode, err := config.ParseSameSite(value)
+		assert.NoError(t, err)
+		assert.Equal(t, want, mode)
+	}
+	_, err := config.ParseSameSite("sometimes")
+	assert.Error(t, err)
+}
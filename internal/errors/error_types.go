@@ -1,54 +1,76 @@
-// Package errors defines custom error types that are used throughout the chat application.
-// These error types are designed to provide detailed information about different kinds of errors
-// that can occur, such as authentication errors, rate limit errors, and database errors.
+// Package errors defines the error model of the chat application's API. Every error response is
+// an RFC 7807 problem details object, sent as application/problem+json, carrying a stable code
+// that clients can act upon, a detail meant for people, and the ID of the failed request.
 
 package errors
 
-// AuthenticationError represents errors related to user authentication.
-// This could be due to invalid credentials, expired tokens, etc.
-type AuthenticationError struct {
-	Status  int    `json:"status"`           // HTTP status code
-	Message string `json:"message"`          // Error message
-	Reason  string `json:"reason,omitempty"` // Optional additional information about the error
-}
+// Error codes. Codes are stable, so clients may rely on them; details are meant for people and may change.
+// Codes for more specific problems are defined next to the code reporting them.
+const (
+	CodeBadRequest         = "bad_request"
+	CodeUnauthorized       = "unauthorized"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeConflict           = "conflict"
+	CodeGone               = "gone"
+	CodePayloadTooLarge    = "payload_too_large"
+	CodeUnprocessable      = "unprocessable_entity"
+	CodeRateLimited        = "rate_limited"
+	CodeInternal           = "internal_error"
+	CodeServiceUnavailable = "service_unavailable"
+	CodeValidationFailed   = "validation_failed"
+	CodeTimeout            = "timeout"
+)
 
-// RateLimitError represents errors related to exceeding API rate limits.
-type RateLimitError struct {
-	Status     int    `json:"status"`      // HTTP status code
-	Message    string `json:"message"`     // Error message
-	RetryAfter int    `json:"retry_after"` // Time in seconds after which the client may retry
+// statusCodes are the codes of errors created from a status alone.
+var statusCodes = map[int]string{
+	400: CodeBadRequest,
+	401: CodeUnauthorized,
+	403: CodeForbidden,
+	404: CodeNotFound,
+	405: CodeMethodNotAllowed,
+	409: CodeConflict,
+	410: CodeGone,
+	413: CodePayloadTooLarge,
+	422: CodeUnprocessable,
+	429: CodeRateLimited,
+	500: CodeInternal,
+	503: CodeServiceUnavailable,
 }
 
-// DatabaseError represents errors related to database operations.
-type DatabaseError struct {
-	Status  int    `json:"status"`          // HTTP status code
-	Message string `json:"message"`         // Error message
-	Query   string `json:"query,omitempty"` // Optional SQL query that caused the error
+// FieldError describes a problem with one field of the request payload.
+type FieldError struct {
+	Field   string `json:"field"`   // Name of the field in the payload, e.g. "new_password"
+	Message string `json:"message"` // What is wrong with it
 }
 
-// ValidationError represents errors related to invalid input or validation failure.
-type ValidationError struct {
-	Status  int      `json:"status"`           // HTTP status code
-	Message string   `json:"message"`          // Error message
-	Fields  []string `json:"fields,omitempty"` // Fields that failed validation
-}
+// APIError is an error returned by the API, in the shape of an RFC 7807 problem details object.
+// Type is "about:blank" and Title the status text, as the code identifies the problem.
+type APIError struct {
+	Type       string       `json:"type"`
+	Title      string       `json:"title"`
+	Status     int          `json:"status"`                // HTTP status code
+	Code       string       `json:"code"`                  // Stable, machine-readable error code
+	Detail     string       `json:"detail,omitempty"`      // Explanation meant for people
+	Instance   string       `json:"instance,omitempty"`    // Path of the request that failed
+	RequestID  string       `json:"request_id,omitempty"`  // ID of the request, for support requests and logs
+	Errors     []FieldError `json:"errors,omitempty"`      // Invalid fields of the payload
+	RetryAfter int          `json:"retry_after,omitempty"` // Seconds after which the client may retry
 
-// InternalServerError represents errors that are unexpected and likely indicate a system issue.
-type InternalServerError struct {
-	Status  int    `json:"status"`          // HTTP status code
-	Message string `json:"message"`         // Error message
-	Stack   string `json:"stack,omitempty"` // Optional stack trace
+	// Err is the error that caused this one. It is logged, but never sent to clients.
+	Err error `json:"-"`
 }
 
-// NotFoundError represents errors where a requested resource could not be found.
-type NotFoundError struct {
-	Status   int    `json:"status"`   // HTTP status code
-	Message  string `json:"message"`  // Error message
-	Resource string `json:"resource"` // The resource that could not be found
+// Error returns the detail of the error, followed by its cause if it has one.
+func (e *APIError) Error() string {
+	if e.Err != nil {
+		return e.Detail + ": " + e.Err.Error()
+	}
+	return e.Detail
 }
 
-// APIError is a general-purpose error type for API responses.
-type APIError struct {
-	Status  int    `json:"status"`  // HTTP status code
-	Message string `json:"message"` // Error message
+// Unwrap returns the error that caused this one.
+func (e *APIError) Unwrap() error {
+	return e.Err
 }
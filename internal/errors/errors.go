@@ -1,64 +1,126 @@
-// Package errors provides utility functions for creating and responding with custom error types.
-// This package is designed to make error handling more structured and informative across the application.
+// Package errors defines the error model of the chat application's API.
+// This file specifically includes the creation of API errors and the error responses.
 
 package errors
 
 import (
 	"encoding/json"
+	stderrors "errors"
 	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
 )
 
-// NewAPIError creates a new APIError instance.
-// This function is useful for generating API errors with a specific HTTP status and message.
+// ContentType is the media type of error responses.
+const ContentType = "application/problem+json"
+
+// requestIDHeader is the response header carrying the request ID, set by middleware.RequestIDMiddleware
+// before any handler runs.
+const requestIDHeader = "X-Request-ID"
+
+// New creates an APIError with a specific code.
 //
 // Parameters:
 // - status: HTTP status code for the error
-// - message: Error message to be displayed
+// - code: Stable, machine-readable error code
+// - detail: Error message to be displayed
+func New(status int, code, detail string) *APIError {
+	return &APIError{Status: status, Code: code, Detail: detail}
+}
+
+// NewAPIError creates an APIError whose code is derived from the status, such as "not_found" for 404.
 //
-// Returns:
-// - A pointer to a new APIError instance
-func NewAPIError(status int, message string) *APIError {
-	return &APIError{
-		Status:  status,
-		Message: message,
+// Parameters:
+// - status: HTTP status code for the error
+// - detail: Error message to be displayed
+func NewAPIError(status int, detail string) *APIError {
+	code, ok := statusCodes[status]
+	if !ok {
+		code = strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
 	}
+	return New(status, code, detail)
+}
+
+// Wrap creates an APIError caused by err. The cause is logged when the error is sent, but is not
+// part of the response.
+func Wrap(err error, status int, code, detail string) *APIError {
+	apiErr := New(status, code, detail)
+	apiErr.Err = err
+	return apiErr
 }
 
-// RespondWithError sends an API response containing an APIError.
-// This function sets the HTTP status code and Content-Type header before sending the error as JSON.
+// Validation creates an APIError for a payload with invalid fields. The detail lists the problems.
+func Validation(fields ...FieldError) *APIError {
+	messages := make([]string, 0, len(fields))
+	for _, field := range fields {
+		messages = append(messages, field.Message)
+	}
+	apiErr := New(http.StatusBadRequest, CodeValidationFailed, strings.Join(messages, "; "))
+	apiErr.Errors = fields
+	return apiErr
+}
+
+// RateLimited creates an APIError for a request that was rejected because the client is sending
+// too many, and may retry after retryAfter seconds.
+func RateLimited(code, detail string, retryAfter int) *APIError {
+	apiErr := New(http.StatusTooManyRequests, code, detail)
+	apiErr.RetryAfter = retryAfter
+	return apiErr
+}
+
+// RespondWithError sends an error response. The request ID is taken from the response headers,
+// and a Retry-After header is set for errors that have a retry delay.
 //
 // Parameters:
 // - w: The http.ResponseWriter to write the response to
 // - err: The APIError to send in the response
 func RespondWithError(w http.ResponseWriter, err *APIError) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(err.Status)
-	json.NewEncoder(w).Encode(err)
+	problem := *err
+	if problem.Type == "" {
+		problem.Type = "about:blank"
+	}
+	if problem.Title == "" {
+		problem.Title = http.StatusText(problem.Status)
+	}
+	if problem.RequestID == "" {
+		problem.RequestID = w.Header().Get(requestIDHeader)
+	}
+	if problem.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(problem.RetryAfter))
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(&problem)
 }
 
-// RespondWithCustomError sends an API response containing a custom error type.
-// This function sets the HTTP status code based on the custom error type and sends the error as JSON.
+// Respond sends an error response for any error. An APIError in the chain of err is sent as is;
+// other errors are unexpected and sent as internal errors without their message. Server errors are
+// logged with their cause.
 //
 // Parameters:
 // - w: The http.ResponseWriter to write the response to
-// - err: The custom error to send in the response
-func RespondWithCustomError(w http.ResponseWriter, err interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	switch e := err.(type) {
-	case *AuthenticationError:
-		w.WriteHeader(e.Status)
-	case *RateLimitError:
-		w.WriteHeader(e.Status)
-	case *DatabaseError:
-		w.WriteHeader(e.Status)
-	case *ValidationError:
-		w.WriteHeader(e.Status)
-	case *InternalServerError:
-		w.WriteHeader(e.Status)
-	case *NotFoundError:
-		w.WriteHeader(e.Status)
-	default:
-		w.WriteHeader(http.StatusInternalServerError)
+// - r: The request that failed
+// - err: The error to send in the response
+func Respond(w http.ResponseWriter, r *http.Request, err error) {
+	var apiErr *APIError
+	if !stderrors.As(err, &apiErr) {
+		apiErr = Wrap(err, http.StatusInternalServerError, CodeInternal, "Internal Server Error")
+	}
+	problem := *apiErr
+	problem.Instance = r.URL.Path
+
+	if problem.Status >= http.StatusInternalServerError {
+		logrus.WithFields(logrus.Fields{
+			"method":     r.Method,
+			"url":        r.URL.String(),
+			"ip":         r.RemoteAddr,
+			"request_id": w.Header().Get(requestIDHeader),
+			"code":       problem.Code,
+		}).Error(err.Error())
 	}
-	json.NewEncoder(w).Encode(err)
+	RespondWithError(w, &problem)
 }
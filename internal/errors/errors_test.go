@@ -0,0 +1,77 @@
+package errors_test
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pageza/chat-app/internal/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRespond(t *testing.T) {
+	decode := func(rr *httptest.ResponseRecorder) map[string]interface{} {
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+		return body
+	}
+
+	t.Run("Errors are problem details", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		rr.Header().Set("X-Request-ID", "req-1")
+		errors.Respond(rr, httptest.NewRequest("GET", "/rooms/7", nil), errors.NewAPIError(http.StatusNotFound, "Room not found"))
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+		assert.Equal(t, map[string]interface{}{
+			"type":       "about:blank",
+			"title":      "Not Found",
+			"status":     float64(404),
+			"code":       "not_found",
+			"detail":     "Room not found",
+			"instance":   "/rooms/7",
+			"request_id": "req-1",
+		}, decode(rr))
+	})
+
+	t.Run("Validation errors list the fields", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		errors.RespondWithError(rr, errors.Validation(
+			errors.FieldError{Field: "name", Message: "name is required"},
+			errors.FieldError{Field: "body", Message: "body is too long"},
+		))
+		body := decode(rr)
+		assert.Equal(t, "validation_failed", body["code"])
+		assert.Equal(t, "name is required; body is too long", body["detail"])
+		assert.Len(t, body["errors"], 2)
+	})
+
+	t.Run("Causes of wrapped errors are not sent", func(t *testing.T) {
+		cause := stderrors.New("connection refused")
+		apiErr := errors.Wrap(cause, http.StatusServiceUnavailable, errors.CodeServiceUnavailable, "Try again later")
+		assert.True(t, stderrors.Is(apiErr, cause))
+
+		rr := httptest.NewRecorder()
+		errors.Respond(rr, httptest.NewRequest("GET", "/", nil), apiErr)
+		assert.NotContains(t, rr.Body.String(), "connection refused")
+		assert.Equal(t, "Try again later", decode(rr)["detail"])
+	})
+
+	t.Run("Unexpected errors are internal errors", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		errors.Respond(rr, httptest.NewRequest("GET", "/", nil), stderrors.New("secret"))
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+		assert.Equal(t, "internal_error", decode(rr)["code"])
+		assert.NotContains(t, rr.Body.String(), "secret")
+	})
+
+	t.Run("Rate limited errors carry a retry delay", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		errors.RespondWithError(rr, errors.RateLimited(errors.CodeRateLimited, "Slow down", 3))
+		assert.Equal(t, "3", rr.Header().Get("Retry-After"))
+		assert.Equal(t, float64(3), decode(rr)["retry_after"])
+	})
+}
@@ -0,0 +1,72 @@
+// Package events distributes real-time events, such as messages and profile changes, to connected clients.
+// This file specifically includes the buses that carry events between publishers and streams.
+package events
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// channel is the Redis channel events are published on.
+const channel = "events"
+
+// Bus carries published events to every subscribed stream. memory.PubSub is an in-process Bus
+// for single-node deployments.
+type Bus interface {
+	Publish(ctx context.Context, payload []byte) error
+	// Subscribe returns the payloads published from now on, until the returned function is called.
+	Subscribe(ctx context.Context) (<-chan []byte, func(), error)
+}
+
+// PubSubClient describes the Redis commands used by RedisBus. *redis.Client satisfies it.
+type PubSubClient interface {
+	Publish(ctx context.Context, channel string, message interface{}) *redis.IntCmd
+	Subscribe(ctx context.Context, channels ...string) *redis.PubSub
+}
+
+// RedisBus is a Bus on a Redis channel, which delivers events to the streams of every server instance.
+type RedisBus struct {
+	Client PubSubClient
+}
+
+// Publish publishes a payload on the channel.
+func (b *RedisBus) Publish(ctx context.Context, payload []byte) error {
+	return b.Client.Publish(ctx, channel, payload).Err()
+}
+
+// Subscribe subscribes to the channel. It waits for the subscription to be confirmed,
+// so that no events published after it returns are missed.
+func (b *RedisBus) Subscribe(ctx context.Context) (<-chan []byte, func(), error) {
+	sub := b.Client.Subscribe(ctx, channel)
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return nil, nil, err
+	}
+
+	payloads := make(chan []byte)
+	done := make(chan struct{})
+	go func() {
+		defer close(payloads)
+		messages := sub.Channel()
+		for {
+			select {
+			case <-done:
+				return
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+				select {
+				case payloads <- []byte(message.Payload):
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+	return payloads, func() {
+		close(done)
+		sub.Close()
+	}, nil
+}
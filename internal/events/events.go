@@ -0,0 +1,225 @@
+// Package events distributes real-time events, such as messages and profile changes, to connected clients.
+// Events are published on a Bus, normally a Redis channel so that every server instance can deliver them,
+// and streamed to browsers and bots as Server-Sent Events. Each stream only delivers the events
+// its user may see, honoring their block and mute lists.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pageza/chat-app/internal/errors"
+	"github.com/pageza/chat-app/internal/middleware"
+	"github.com/sirupsen/logrus"
+)
+
+// Event types.
+const (
+	UserDisplayNameChanged = "user.display_name_changed"
+	UserPresenceChanged    = "user.presence_changed"
+	MessageCreated         = "message.created"
+	MessageRejected        = "message.rejected" // Sent to the sender only, e.g. when they send messages too fast
+
+	// RelationsChanged tells streams to reload the block and mute lists of its recipients.
+	// It is not delivered to clients.
+	RelationsChanged = "relations.changed"
+)
+
+// heartbeatInterval is how often a comment is sent on idle streams, to keep proxies from closing them.
+const heartbeatInterval = 25 * time.Second
+
+// Event is a real-time event delivered to clients.
+type Event struct {
+	Type       string      `json:"type"`
+	Data       interface{} `json:"data"`
+	ActorID    uint        `json:"actor_id,omitempty"`   // User who caused the event, whose blockers and muters don't receive it
+	Recipients []uint      `json:"recipients,omitempty"` // Users the event is delivered to, or everyone if empty
+}
+
+// DisplayNameChanged is the data of a UserDisplayNameChanged event.
+type DisplayNameChanged struct {
+	UserID      uint   `json:"user_id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+}
+
+// MessageRejection is the data of a MessageRejected event.
+type MessageRejection struct {
+	Reason      string `json:"reason"` // One of the chat.Reason* reasons
+	Message     string `json:"message"`
+	RoomID      *uint  `json:"room_id,omitempty"`
+	RecipientID *uint  `json:"recipient_id,omitempty"`
+	RetryAfter  int    `json:"retry_after,omitempty"` // Seconds until a message may be sent again
+}
+
+// PresenceChanged is the data of a UserPresenceChanged event.
+type PresenceChanged struct {
+	UserID uint `json:"user_id"`
+	Online bool `json:"online"`
+}
+
+// Publisher is an interface for publishing events.
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// RelationStore is an interface for loading the block and mute lists that filter a user's stream.
+type RelationStore interface {
+	HiddenUserIDs(userID uint) ([]uint, error)
+	BlockedByIDs(userID uint) ([]uint, error)
+}
+
+// PresenceTracker is an interface for recording which users are online.
+// Users are online while they have an event stream open.
+type PresenceTracker interface {
+	Connect(ctx context.Context, userID uint) (bool, error)
+	Refresh(ctx context.Context, userID uint) error
+	Disconnect(ctx context.Context, userID uint) (bool, error)
+}
+
+// Broker publishes events on a Bus and streams them to clients.
+type Broker struct {
+	Bus       Bus
+	Relations RelationStore   // Used to filter streams, may be nil
+	Presence  PresenceTracker // Used to track online users, may be nil
+}
+
+// Publish publishes an event to all connected clients.
+func (b *Broker) Publish(ctx context.Context, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return b.Bus.Publish(ctx, data)
+}
+
+// StreamHandler streams the events the logged-in user may see as Server-Sent Events, until the client disconnects.
+// The user is online while the stream is open.
+func (b *Broker) StreamHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Streaming is not supported"))
+		return
+	}
+	viewer, ok := middleware.CurrentUser(r)
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+	logger := logrus.WithFields(logrus.Fields{
+		"method": r.Method,
+		"url":    r.URL.String(),
+		"ip":     r.RemoteAddr,
+		"user":   viewer.Username,
+	})
+
+	filter, err := newStreamFilter(viewer.ID, b.Relations)
+	if err != nil {
+		logger.Errorf("Could not load block and mute lists: %v", err)
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not subscribe to events"))
+		return
+	}
+
+	ctx := r.Context()
+	messages, unsubscribe, err := b.Bus.Subscribe(ctx)
+	if err != nil {
+		logger.Errorf("Could not subscribe to events: %v", err)
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not subscribe to events"))
+		return
+	}
+	defer unsubscribe()
+
+	b.connect(ctx, viewer.ID, logger)
+	defer b.disconnect(viewer.ID, logger)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable response buffering in nginx
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			if b.Presence != nil {
+				if err := b.Presence.Refresh(ctx, viewer.ID); err != nil {
+					logger.Warnf("Could not refresh presence: %v", err)
+				}
+			}
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			var event Event
+			if err := json.Unmarshal(message, &event); err != nil {
+				continue
+			}
+			if event.Type == RelationsChanged && filter.isRecipient(event) {
+				if err := filter.load(b.Relations); err != nil {
+					logger.Warnf("Could not reload block and mute lists: %v", err)
+				}
+			}
+			if !filter.allow(event) {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, message)
+			flusher.Flush()
+		}
+	}
+}
+
+// connect records a new stream of the user and announces them as online if it is their first.
+func (b *Broker) connect(ctx context.Context, userID uint, logger *logrus.Entry) {
+	if b.Presence == nil {
+		return
+	}
+	online, err := b.Presence.Connect(ctx, userID)
+	if err != nil {
+		logger.Warnf("Could not record presence: %v", err)
+		return
+	}
+	if online {
+		b.publishPresence(ctx, userID, true, logger)
+	}
+}
+
+// disconnect records that a stream of the user was closed and announces them as offline if it was their last.
+// It runs after the request context was canceled, so it uses a context of its own.
+func (b *Broker) disconnect(userID uint, logger *logrus.Entry) {
+	if b.Presence == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	offline, err := b.Presence.Disconnect(ctx, userID)
+	if err != nil {
+		logger.Warnf("Could not record presence: %v", err)
+		return
+	}
+	if offline {
+		b.publishPresence(ctx, userID, false, logger)
+	}
+}
+
+func (b *Broker) publishPresence(ctx context.Context, userID uint, online bool, logger *logrus.Entry) {
+	event := Event{
+		Type:    UserPresenceChanged,
+		Data:    PresenceChanged{UserID: userID, Online: online},
+		ActorID: userID,
+	}
+	if err := b.Publish(ctx, event); err != nil {
+		logger.Warnf("Could not publish presence: %v", err)
+	}
+}
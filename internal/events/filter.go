@@ -0,0 +1,77 @@
+// Package events distributes real-time events to connected clients.
+// This file specifically includes the filter that applies a user's block and mute lists to their stream.
+package events
+
+// streamFilter decides which events are delivered on a user's stream.
+type streamFilter struct {
+	viewerID  uint
+	hidden    map[uint]bool // Users the viewer has blocked or muted
+	blockedBy map[uint]bool // Users who have blocked the viewer
+}
+
+// newStreamFilter creates a filter for the viewer's stream and loads their relations.
+// Without a relation store, only the recipients of events are checked.
+func newStreamFilter(viewerID uint, store RelationStore) (*streamFilter, error) {
+	f := &streamFilter{viewerID: viewerID}
+	if err := f.load(store); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// load (re)loads the viewer's block and mute lists, as well as the users who blocked them.
+func (f *streamFilter) load(store RelationStore) error {
+	f.hidden, f.blockedBy = map[uint]bool{}, map[uint]bool{}
+	if store == nil {
+		return nil
+	}
+
+	hidden, err := store.HiddenUserIDs(f.viewerID)
+	if err != nil {
+		return err
+	}
+	blockedBy, err := store.BlockedByIDs(f.viewerID)
+	if err != nil {
+		return err
+	}
+	for _, id := range hidden {
+		f.hidden[id] = true
+	}
+	for _, id := range blockedBy {
+		f.blockedBy[id] = true
+	}
+	return nil
+}
+
+// isRecipient reports whether the event is addressed to the viewer.
+func (f *streamFilter) isRecipient(event Event) bool {
+	if len(event.Recipients) == 0 {
+		return true
+	}
+	for _, id := range event.Recipients {
+		if id == f.viewerID {
+			return true
+		}
+	}
+	return false
+}
+
+// allow reports whether the event may be delivered to the viewer.
+func (f *streamFilter) allow(event Event) bool {
+	if event.Type == RelationsChanged || !f.isRecipient(event) {
+		return false
+	}
+	if event.ActorID == 0 || event.ActorID == f.viewerID {
+		return true
+	}
+
+	switch event.Type {
+	case MessageCreated:
+		// Messages from blocked and muted users are hidden
+		return !f.hidden[event.ActorID]
+	case UserPresenceChanged:
+		// Users who blocked the viewer appear offline to them
+		return !f.blockedBy[event.ActorID]
+	}
+	return true
+}
@@ -0,0 +1,43 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type relationStore struct {
+	hidden    map[uint][]uint
+	blockedBy map[uint][]uint
+}
+
+func (s *relationStore) HiddenUserIDs(userID uint) ([]uint, error) { return s.hidden[userID], nil }
+func (s *relationStore) BlockedByIDs(userID uint) ([]uint, error)  { return s.blockedBy[userID], nil }
+
+func TestStreamFilter(t *testing.T) {
+	// User 1 has muted user 2 and was blocked by user 3
+	store := &relationStore{
+		hidden:    map[uint][]uint{1: {2}},
+		blockedBy: map[uint][]uint{1: {3}},
+	}
+	f, err := newStreamFilter(1, store)
+	require.NoError(t, err)
+
+	assert.False(t, f.allow(Event{Type: MessageCreated, ActorID: 2}))
+	assert.True(t, f.allow(Event{Type: MessageCreated, ActorID: 3}))
+	assert.True(t, f.allow(Event{Type: MessageCreated, ActorID: 1}))
+	assert.True(t, f.allow(Event{Type: UserPresenceChanged, ActorID: 2}))
+	assert.False(t, f.allow(Event{Type: UserPresenceChanged, ActorID: 3}))
+	assert.True(t, f.allow(Event{Type: UserDisplayNameChanged, ActorID: 3}))
+
+	// Direct messages only reach their participants
+	assert.True(t, f.allow(Event{Type: MessageCreated, ActorID: 4, Recipients: []uint{4, 1}}))
+	assert.False(t, f.allow(Event{Type: MessageCreated, ActorID: 4, Recipients: []uint{4, 5}}))
+
+	// Relation changes are only used to reload the lists
+	assert.False(t, f.allow(Event{Type: RelationsChanged, Recipients: []uint{1}}))
+	store.hidden[1] = nil
+	require.NoError(t, f.load(store))
+	assert.True(t, f.allow(Event{Type: MessageCreated, ActorID: 2}))
+}
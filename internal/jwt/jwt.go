@@ -4,6 +4,8 @@
 package jwt
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
 	"net/http"
 	"time"
@@ -18,11 +20,58 @@ import (
 // JwtService is an interface for JWT-related operations.
 type JwtService interface {
 	GenerateToken(user models.User) (string, string, error)
+	GenerateMFAToken(user models.User) (string, error)
 	ParseToken(tokenString string) (*jwt.Token, error)
+	ParseMFAToken(tokenString string) (*MFAPendingToken, error)
 	SetTokenCookie(w http.ResponseWriter, token string)
 	ClearTokenCookie(w http.ResponseWriter)
 }
-type JwtManager struct{}
+
+// JwtManager issues and parses the application's tokens, and sets them in session cookies.
+type JwtManager struct {
+	Keys            *KeySet
+	Issuer          string
+	TokenExpiration time.Duration       // Lifetime of access tokens
+	Cookies         config.CookieConfig // Attributes of the session cookies
+}
+
+// NewJwtManager creates a JwtManager signing tokens with the keys, according to the JWT and cookie settings.
+func NewJwtManager(keys *KeySet, cfg config.JWTConfig, cookies config.CookieConfig) *JwtManager {
+	return &JwtManager{Keys: keys, Issuer: cfg.Issuer, TokenExpiration: cfg.TokenExpiration, Cookies: cookies}
+}
+
+// MFAPendingAudience is the audience of tokens issued after a successful password check
+// for users with two-factor authentication enabled. These tokens are only accepted by
+// the second login step and must never be treated as access tokens.
+const MFAPendingAudience = "mfa_pending"
+
+// RefreshAudience is the audience of refresh tokens, which may only be used to get new
+// access tokens and must never be treated as access tokens themselves.
+const RefreshAudience = "refresh"
+
+// MFATokenExpiration is how long a user has to complete the second login step.
+const MFATokenExpiration = 5 * time.Minute
+
+// Claims are the claims of access and refresh tokens. Role carries the user's global role,
+// so that other services can authorize requests without looking up the user. IssuedAtMicros
+// is the issue time in microseconds: iat only has a resolution of one second, which cannot
+// tell a token issued right before a revocation from one issued right after it.
+type Claims struct {
+	jwt.StandardClaims
+	IssuedAtMicros int64  `json:"iat_us,omitempty"`
+	Role           string `json:"role,omitempty"`
+}
+
+// MFAPendingToken is a verified "MFA pending" token.
+type MFAPendingToken struct {
+	Username  string
+	TokenID   string
+	IssuedAt  time.Time
+	ExpiresAt int64
+}
+
+// ErrNotMFAToken is returned when a token is not a valid MFA pending token.
+var ErrNotMFAToken = fmt.Errorf("not an MFA pending token")
 
 // GenerateToken generates a JWT for a given user.
 // The token will contain claims like the username and expiration time.
@@ -33,49 +82,55 @@ type JwtManager struct{}
 // Returns:
 // - A signed JWT string
 // - An error if something goes wrong
-func init() {
-	config.Initialize()
-}
-
 func (jm *JwtManager) GenerateToken(user models.User) (string, string, error) {
-	// Parse the token expiration duration from the configuration for access token
-	fmt.Println("Debug TokenExpiration in GenerateToken:", config.TokenExpiration)
-	logrus.Infof("JWT - TokenExpiration: %s", config.TokenExpiration)
+	// Calculate the expiration time for the access token
+	now := time.Now()
+	expirationTime := now.Add(jm.TokenExpiration).Unix()
 
-	expirationDuration, err := time.ParseDuration(config.TokenExpiration)
+	// Every token gets a unique ID, so that it can be revoked individually
+	accessTokenID, err := newTokenID()
+	if err != nil {
+		return "", "", err
+	}
+	refreshTokenID, err := newTokenID()
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"duration": config.TokenExpiration,
-		}).Fatalf("Invalid token expiration duration jwt: %v", err)
 		return "", "", err
 	}
-
-	// Calculate the expiration time for the access token
-	expirationTime := time.Now().Add(expirationDuration).Unix()
 
 	// Create the claims for the access token
-	claims := &jwt.StandardClaims{
-		ExpiresAt: expirationTime,
-		Issuer:    config.JwtIssuer,
-		Subject:   user.Username,
+	claims := &Claims{
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: expirationTime,
+			Id:        accessTokenID,
+			IssuedAt:  now.Unix(),
+			Issuer:    jm.Issuer,
+			Subject:   user.Username,
+		},
+		IssuedAtMicros: now.UnixMicro(),
+		Role:           user.EffectiveRole(),
 	}
 
-	// Generate the access token with the claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	accessToken, err := token.SignedString([]byte(config.JwtSecret))
+	// Sign the access token with the active key
+	accessToken, err := jm.Keys.Sign(claims)
 	if err != nil {
 		return "", "", err
 	}
 
 	// Generate refresh token with longer expiration (e.g., 24 hours)
-	refreshExpirationTime := time.Now().Add(24 * time.Hour).Unix()
-	refreshClaims := &jwt.StandardClaims{
-		ExpiresAt: refreshExpirationTime,
-		Issuer:    config.JwtIssuer,
-		Subject:   user.Username,
-	}
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
-	refreshTokenString, err := refreshToken.SignedString([]byte(config.JwtSecret))
+	refreshExpirationTime := now.Add(24 * time.Hour).Unix()
+	refreshClaims := &Claims{
+		StandardClaims: jwt.StandardClaims{
+			Audience:  RefreshAudience,
+			ExpiresAt: refreshExpirationTime,
+			Id:        refreshTokenID,
+			IssuedAt:  now.Unix(),
+			Issuer:    jm.Issuer,
+			Subject:   user.Username,
+		},
+		IssuedAtMicros: now.UnixMicro(),
+		Role:           user.EffectiveRole(),
+	}
+	refreshTokenString, err := jm.Keys.Sign(refreshClaims)
 	if err != nil {
 		return "", "", err
 	}
@@ -83,17 +138,116 @@ func (jm *JwtManager) GenerateToken(user models.User) (string, string, error) {
 	return accessToken, refreshTokenString, nil
 }
 
+// GenerateMFAToken generates a short-lived "MFA pending" token for a user who has
+// passed the password check but still needs to complete two-factor authentication.
+//
+// Parameters:
+// - user: The user for whom the token is generated
+//
+// Returns:
+// - A signed JWT string
+// - An error if something goes wrong
+func (jm *JwtManager) GenerateMFAToken(user models.User) (string, error) {
+	tokenID, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := &Claims{
+		StandardClaims: jwt.StandardClaims{
+			Audience:  MFAPendingAudience,
+			ExpiresAt: now.Add(MFATokenExpiration).Unix(),
+			Id:        tokenID,
+			IssuedAt:  now.Unix(),
+			Issuer:    jm.Issuer,
+			Subject:   user.Username,
+		},
+		IssuedAtMicros: now.UnixMicro(),
+	}
+	return jm.Keys.Sign(claims)
+}
+
+// ParseMFAToken parses an "MFA pending" token.
+//
+// Parameters:
+// - tokenString: The JWT string to parse
+//
+// Returns:
+// - The username, token ID and lifetime from the token's claims
+// - An error if the token is invalid, expired, or not an MFA pending token
+func (jm *JwtManager) ParseMFAToken(tokenString string) (*MFAPendingToken, error) {
+	token, err := jm.ParseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || !claims.VerifyAudience(MFAPendingAudience, true) {
+		return nil, ErrNotMFAToken
+	}
+	username, _ := claims["sub"].(string)
+	tokenID, _ := claims["jti"].(string)
+	expiresAt, _ := claims["exp"].(float64)
+	if username == "" || tokenID == "" {
+		return nil, ErrNotMFAToken
+	}
+	return &MFAPendingToken{Username: username, TokenID: tokenID, IssuedAt: IssuedAt(claims), ExpiresAt: int64(expiresAt)}, nil
+}
+
+// IssuedAt returns the time a token was issued, to the microsecond if the token has an iat_us claim
+// and to the second otherwise.
+func IssuedAt(claims jwt.MapClaims) time.Time {
+	if micros, ok := claims["iat_us"].(float64); ok {
+		return time.UnixMicro(int64(micros))
+	}
+	issuedAt, _ := claims["iat"].(float64)
+	return time.Unix(int64(issuedAt), 0)
+}
+
+// IsMFAPendingToken reports whether the claims belong to an "MFA pending" token.
+// Such tokens must be rejected wherever an access token is expected.
+func IsMFAPendingToken(claims jwt.Claims) bool {
+	return hasAudience(claims, MFAPendingAudience)
+}
+
+// IsRefreshToken reports whether the claims belong to a refresh token.
+// Such tokens must be rejected wherever an access token is expected.
+func IsRefreshToken(claims jwt.Claims) bool {
+	return hasAudience(claims, RefreshAudience)
+}
+
+// hasAudience reports whether the claims have the given audience.
+func hasAudience(claims jwt.Claims, audience string) bool {
+	switch c := claims.(type) {
+	case *jwt.StandardClaims:
+		return c.Audience == audience
+	case *Claims:
+		return c.Audience == audience
+	case jwt.MapClaims:
+		return c.VerifyAudience(audience, true)
+	}
+	return false
+}
+
 // SetTokenCookie sets a JWT as an HttpOnly cookie.
 //
 // Parameters:
 // - w: The http.ResponseWriter to write the cookie to
 // - token: The JWT string to set as a cookie
 func (jm *JwtManager) SetTokenCookie(w http.ResponseWriter, token string) {
-	http.SetCookie(w, &http.Cookie{
-		Name:     "token",
-		Value:    token,
+	http.SetCookie(w, jm.SessionCookie("token", token))
+}
+
+// SessionCookie returns an HttpOnly cookie carrying a session token, with the Secure, SameSite
+// and Path attributes from the cookie settings.
+func (jm *JwtManager) SessionCookie(name, value string) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     jm.Cookies.Path,
 		HttpOnly: true,
-	})
+		Secure:   jm.Cookies.Secure,
+		SameSite: jm.Cookies.SameSite,
+	}
 }
 
 // ParseToken parses a JWT string and returns the token object.
@@ -105,15 +259,7 @@ func (jm *JwtManager) SetTokenCookie(w http.ResponseWriter, token string) {
 // - A pointer to the parsed jwt.Token
 // - An error if something goes wrong
 func (jm *JwtManager) ParseToken(tokenString string) (*jwt.Token, error) {
-	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			logrus.WithFields(logrus.Fields{
-				"alg": token.Header["alg"],
-			}).Error("Unexpected signing method")
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(config.JwtSecret), nil
-	})
+	return jwt.Parse(tokenString, jm.Keys.Keyfunc)
 }
 
 // GenerateTokenAndSetCookie generates a JWT for a user and sets it as a cookie.
@@ -121,9 +267,8 @@ func (jm *JwtManager) ParseToken(tokenString string) (*jwt.Token, error) {
 // Parameters:
 // - w: The http.ResponseWriter to write the cookie to
 // - user: The user for whom the token is generated
-func GenerateTokenAndSetCookie(w http.ResponseWriter, user models.User) {
-	jwtManager := &JwtManager{}
-	accessToken, refreshToken, err := jwtManager.GenerateToken(user) // Updated this line
+func (jm *JwtManager) GenerateTokenAndSetCookie(w http.ResponseWriter, user models.User) {
+	accessToken, refreshToken, err := jm.GenerateToken(user)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"user": user.Username,
@@ -132,15 +277,12 @@ func GenerateTokenAndSetCookie(w http.ResponseWriter, user models.User) {
 		errors.RespondWithError(w, apiErr)
 		return
 	}
-	jwtManager.SetTokenCookie(w, accessToken) // Assuming this sets the access token cookie
+	jm.SetTokenCookie(w, accessToken) // Assuming this sets the access token cookie
 
 	// Set the refresh token as a cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "refresh_token",
-		Value:    refreshToken,
-		Expires:  time.Now().Add(48 * time.Hour), // Set your desired expiration time
-		HttpOnly: true,
-	})
+	refreshCookie := jm.SessionCookie("refresh_token", refreshToken)
+	refreshCookie.Expires = time.Now().Add(48 * time.Hour) // Set your desired expiration time
+	http.SetCookie(w, refreshCookie)
 }
 
 // ClearTokenCookie clears the JWT cookie.
@@ -148,11 +290,16 @@ func GenerateTokenAndSetCookie(w http.ResponseWriter, user models.User) {
 // Parameters:
 // - w: The http.ResponseWriter to clear the cookie from
 func (jm *JwtManager) ClearTokenCookie(w http.ResponseWriter) {
-	http.SetCookie(w, &http.Cookie{
-		Name:     "token",
-		Value:    "",
-		Path:     "/",
-		HttpOnly: true,
-		MaxAge:   -1,
-	})
+	cookie := jm.SessionCookie("token", "")
+	cookie.MaxAge = -1
+	http.SetCookie(w, cookie)
+}
+
+// newTokenID returns a random token ID for the jti claim.
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
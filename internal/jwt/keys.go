@@ -0,0 +1,399 @@
+// Package jwt provides utility functions for generating, parsing, and managing JSON Web Tokens (JWT).
+// This file specifically manages the signing keys, their rotation, and the public JWKS endpoint.
+
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pageza/chat-app/internal/config"
+	"github.com/pageza/chat-app/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// SigningKey is a key used to sign and verify tokens, identified by its key ID (kid).
+type SigningKey struct {
+	ID         string
+	Method     jwt.SigningMethod
+	PrivateKey interface{} // []byte for HS256, *rsa.PrivateKey or ed25519.PrivateKey
+	PublicKey  interface{} // []byte for HS256, *rsa.PublicKey or ed25519.PublicKey
+	RetiredAt  time.Time   // When the key stopped signing; zero for the active key
+}
+
+// KeySet holds the active signing key and the retired keys that are still accepted for verification.
+type KeySet struct {
+	mu        sync.RWMutex
+	alg       string
+	active    *SigningKey
+	keys      map[string]*SigningKey
+	retention time.Duration
+}
+
+// JSONWebKey is the public part of a signing key in JWK format (RFC 7517).
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`   // RSA modulus
+	E   string `json:"e,omitempty"`   // RSA exponent
+	Crv string `json:"crv,omitempty"` // OKP curve
+	X   string `json:"x,omitempty"`   // OKP public key
+}
+
+// JSONWebKeySet is a set of public keys in JWKS format.
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// NewKeys sets up the signing keys from the JWT settings.
+// With HS256, tokens are signed with the JWT secret. With RS256 or EdDSA, keys are loaded from
+// the key directory, or generated if ephemeral keys are enabled.
+func NewKeys(cfg config.JWTConfig) (*KeySet, error) {
+	ks, err := NewKeySet(cfg.SigningAlg, []byte(cfg.Secret), cfg.KeyRetention)
+	if err != nil {
+		return nil, err
+	}
+	if ks.alg == jwt.SigningMethodHS256.Alg() {
+		return ks, nil
+	}
+
+	switch {
+	case cfg.KeyDir != "":
+		if err := ks.LoadDir(cfg.KeyDir, cfg.ActiveKeyID); err != nil {
+			return nil, err
+		}
+	case cfg.EphemeralKeys:
+		logrus.Warn("JWT_EPHEMERAL_KEYS is set, generating JWT signing keys in memory: every token becomes " +
+			"invalid when the server restarts, and tokens are only accepted by the instance that issued them")
+		if err := ks.Rotate(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("JWT_KEY_DIR must be set for %s signing keys", cfg.SigningAlg)
+	}
+	return ks, nil
+}
+
+// NewKeySet creates a key set for the given algorithm ("HS256", "RS256" or "EdDSA").
+// HS256 key sets sign with the secret and cannot be rotated. Asymmetric key sets start empty;
+// call Rotate or LoadDir to add keys.
+func NewKeySet(alg string, secret []byte, retention time.Duration) (*KeySet, error) {
+	ks := &KeySet{alg: alg, keys: map[string]*SigningKey{}, retention: retention}
+
+	switch alg {
+	case jwt.SigningMethodHS256.Alg():
+		ks.active = &SigningKey{Method: jwt.SigningMethodHS256, PrivateKey: secret, PublicKey: secret}
+		ks.keys[""] = ks.active
+	case jwt.SigningMethodRS256.Alg(), SigningMethodEdDSA.Alg():
+	default:
+		return nil, fmt.Errorf("unsupported JWT signing algorithm: %q", alg)
+	}
+	return ks, nil
+}
+
+// Rotate generates a new active signing key. The previous key is retired but stays valid for
+// verification for the retention period, and keys retired for longer than that are removed.
+func (ks *KeySet) Rotate() error {
+	key, err := generateKey(ks.alg)
+	if err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.activate(key)
+	return nil
+}
+
+// activate makes the key the active signing key and prunes expired keys. The caller must hold the lock.
+func (ks *KeySet) activate(key *SigningKey) {
+	now := time.Now()
+	if ks.active != nil {
+		ks.active.RetiredAt = now
+	}
+	ks.active = key
+	ks.keys[key.ID] = key
+
+	for id, k := range ks.keys {
+		if !k.RetiredAt.IsZero() && now.Sub(k.RetiredAt) > ks.retention {
+			delete(ks.keys, id)
+		}
+	}
+}
+
+// StartRotation rotates the signing key at the given interval until the stop channel is closed.
+func (ks *KeySet) StartRotation(interval time.Duration, stop <-chan struct{}) {
+	ks.every(interval, stop, func() {
+		if err := ks.Rotate(); err != nil {
+			logrus.Errorf("JWT key rotation failed: %v", err)
+			return
+		}
+		logrus.Infof("Rotated JWT signing key, new key ID: %s", ks.ActiveKeyID())
+	})
+}
+
+// StartReload reloads the keys from a directory at the given interval until the stop channel is closed.
+// If a reload fails, e.g. because a key file is invalid, the keys loaded before are kept.
+func (ks *KeySet) StartReload(dir, activeID string, interval time.Duration, stop <-chan struct{}) {
+	ks.every(interval, stop, func() {
+		previous := ks.ActiveKeyID()
+		if err := ks.LoadDir(dir, activeID); err != nil {
+			logrus.Errorf("Could not reload the JWT signing keys, keeping the current keys: %v", err)
+			return
+		}
+		if current := ks.ActiveKeyID(); current != previous {
+			logrus.Infof("Reloaded JWT signing keys, new key ID: %s", current)
+		}
+	})
+}
+
+// every calls f at the given interval until the stop channel is closed.
+func (ks *KeySet) every(interval time.Duration, stop <-chan struct{}, f func()) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				f()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// LoadDir loads PEM-encoded private keys from a directory. Each file's name without its
+// extension is used as the key ID. The key with activeID signs new tokens; if activeID is empty,
+// the key whose ID sorts last is used, so date-based names such as "2024-01.pem" work naturally.
+//
+// LoadDir can be called again to pick up changes to the directory: new keys are added, and keys
+// whose file was removed are retired, so that they keep verifying tokens for the retention period.
+func (ks *KeySet) LoadDir(dir, activeID string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no .pem keys found in %s", dir)
+	}
+	sort.Strings(files)
+
+	loaded := make(map[string]*SigningKey, len(files))
+	var active *SigningKey
+	for _, file := range files {
+		key, err := loadKeyFile(file)
+		if err != nil {
+			return err
+		}
+		if key.Method.Alg() != ks.alg {
+			return fmt.Errorf("key %s is not a %s key", file, ks.alg)
+		}
+		loaded[key.ID] = key
+		if activeID == "" || key.ID == activeID {
+			active = key
+		}
+	}
+	if activeID != "" && active == nil {
+		return fmt.Errorf("active key %q not found in %s", activeID, dir)
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	// Keys are only retired once their file is removed, and removed once they have been retired for
+	// longer than the retention period
+	now := time.Now()
+	for id, key := range ks.keys {
+		if _, ok := loaded[id]; ok {
+			continue
+		}
+		if key.RetiredAt.IsZero() {
+			key.RetiredAt = now
+		}
+		if now.Sub(key.RetiredAt) > ks.retention {
+			delete(ks.keys, id)
+		}
+	}
+	for id, key := range loaded {
+		ks.keys[id] = key
+	}
+	ks.active = active
+	return nil
+}
+
+// ActiveKeyID returns the ID of the key currently used for signing.
+func (ks *KeySet) ActiveKeyID() string {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.active.ID
+}
+
+// Sign signs the claims with the active key and sets the kid header.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	ks.mu.RLock()
+	key := ks.active
+	ks.mu.RUnlock()
+
+	if key == nil {
+		return "", fmt.Errorf("no active JWT signing key")
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+	return token.SignedString(key.PrivateKey)
+}
+
+// Keyfunc returns the verification key for a token, based on its kid header.
+// The token's algorithm must match the key's algorithm, which prevents algorithm confusion attacks.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	ks.mu.RLock()
+	key, ok := ks.keys[kid]
+	ks.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key: %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		logrus.WithFields(logrus.Fields{
+			"alg": token.Header["alg"],
+			"kid": kid,
+		}).Error("Unexpected signing method")
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.PublicKey, nil
+}
+
+// JWKS returns the public keys that are currently valid for verification.
+// HS256 key sets have no public keys, so their JWKS is empty.
+func (ks *KeySet) JWKS() JSONWebKeySet {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, key := range ks.keys {
+		switch pub := key.PublicKey.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JSONWebKey{
+				Kty: "RSA",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JSONWebKey{
+				Kty: "OKP",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+
+	// Keep the output stable for caches
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+// StartKeyRotation starts the scheduled key rotation, if the JWT settings enable it. Keys loaded from
+// the key directory are reloaded every KeyRotationInterval, so that a key added to the directory
+// takes over signing and a removed key is retired; generated keys are replaced instead.
+func (ks *KeySet) StartKeyRotation(cfg config.JWTConfig, stop <-chan struct{}) {
+	if cfg.KeyRotationInterval <= 0 || ks.alg == jwt.SigningMethodHS256.Alg() {
+		return
+	}
+	switch {
+	case cfg.KeyDir != "":
+		ks.StartReload(cfg.KeyDir, cfg.ActiveKeyID, cfg.KeyRotationInterval, stop)
+	case cfg.EphemeralKeys:
+		ks.StartRotation(cfg.KeyRotationInterval, stop)
+	}
+}
+
+// JWKSHandler serves the public verification keys at /.well-known/jwks.json,
+// so that other services can verify tokens without sharing a secret.
+func (ks *KeySet) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	utils.SendJSONResponse(w, http.StatusOK, ks.JWKS())
+}
+
+// generateKey generates a new signing key for the algorithm with a time-based key ID.
+func generateKey(alg string) (*SigningKey, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	id := time.Now().UTC().Format("20060102T150405Z") + "-" + base64.RawURLEncoding.EncodeToString(suffix)
+
+	switch alg {
+	case jwt.SigningMethodRS256.Alg():
+		privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		return &SigningKey{ID: id, Method: jwt.SigningMethodRS256, PrivateKey: privateKey, PublicKey: &privateKey.PublicKey}, nil
+	case SigningMethodEdDSA.Alg():
+		publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return &SigningKey{ID: id, Method: SigningMethodEdDSA, PrivateKey: privateKey, PublicKey: publicKey}, nil
+	}
+	return nil, fmt.Errorf("cannot generate keys for JWT signing algorithm: %q", alg)
+}
+
+// loadKeyFile reads a PEM-encoded RSA or Ed25519 private key (PKCS#8, or PKCS#1 for RSA).
+func loadKeyFile(file string) (*SigningKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s does not contain a PEM block", file)
+	}
+
+	id := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+
+	var parsed interface{}
+	if block.Type == "RSA PRIVATE KEY" {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse key %s: %w", file, err)
+	}
+
+	switch privateKey := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &SigningKey{ID: id, Method: jwt.SigningMethodRS256, PrivateKey: privateKey, PublicKey: &privateKey.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &SigningKey{ID: id, Method: SigningMethodEdDSA, PrivateKey: privateKey, PublicKey: privateKey.Public()}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type in %s", file)
+}
@@ -0,0 +1,300 @@
+package jwt_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pageza/chat-app/internal/config"
+	jwtI "github.com/pageza/chat-app/internal/jwt"
+)
+
+// claims returns valid claims for a test token.
+func claims() *jwt.StandardClaims {
+	return &jwt.StandardClaims{Subject: "alice", ExpiresAt: time.Now().Add(time.Hour).Unix()}
+}
+
+// verify parses a token with the key set, reporting whether it is valid.
+func verify(ks *jwtI.KeySet, token string) bool {
+	parsed, err := jwt.Parse(token, ks.Keyfunc)
+	return err == nil && parsed.Valid
+}
+
+// writePEM writes a PEM block to a file in dir.
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0o600))
+}
+
+func TestKeySet(t *testing.T) {
+	for _, alg := range []string{"RS256", "EdDSA"} {
+		t.Run(alg+" tokens verify with the key that signed them", func(t *testing.T) {
+			ks, err := jwtI.NewKeySet(alg, nil, time.Hour)
+			require.NoError(t, err)
+			require.NoError(t, ks.Rotate())
+
+			token, err := ks.Sign(claims())
+			require.NoError(t, err)
+			parsed, err := jwt.Parse(token, ks.Keyfunc)
+			require.NoError(t, err)
+			assert.True(t, parsed.Valid)
+			assert.Equal(t, alg, parsed.Method.Alg())
+			assert.Equal(t, ks.ActiveKeyID(), parsed.Header["kid"])
+		})
+	}
+
+	t.Run("HS256 tokens verify with the secret", func(t *testing.T) {
+		ks, err := jwtI.NewKeySet("HS256", []byte("secret"), 0)
+		require.NoError(t, err)
+		token, err := ks.Sign(claims())
+		require.NoError(t, err)
+		assert.True(t, verify(ks, token))
+
+		other, err := jwtI.NewKeySet("HS256", []byte("another secret"), 0)
+		require.NoError(t, err)
+		assert.False(t, verify(other, token))
+	})
+
+	t.Run("Unsupported algorithms are rejected", func(t *testing.T) {
+		_, err := jwtI.NewKeySet("none", nil, 0)
+		assert.Error(t, err)
+	})
+
+	t.Run("Asymmetric key sets cannot sign before a key is added", func(t *testing.T) {
+		ks, err := jwtI.NewKeySet("RS256", nil, time.Hour)
+		require.NoError(t, err)
+		_, err = ks.Sign(claims())
+		assert.Error(t, err)
+	})
+
+	t.Run("Unknown key IDs are rejected", func(t *testing.T) {
+		ks, err := jwtI.NewKeySet("EdDSA", nil, time.Hour)
+		require.NoError(t, err)
+		require.NoError(t, ks.Rotate())
+
+		other, err := jwtI.NewKeySet("EdDSA", nil, time.Hour)
+		require.NoError(t, err)
+		require.NoError(t, other.Rotate())
+		token, err := other.Sign(claims())
+		require.NoError(t, err)
+		assert.False(t, verify(ks, token))
+	})
+
+	t.Run("Algorithm confusion is rejected", func(t *testing.T) {
+		ks, err := jwtI.NewKeySet("RS256", nil, time.Hour)
+		require.NoError(t, err)
+		require.NoError(t, ks.Rotate())
+
+		// An HS256 token "signed" with the RSA public key, which is public knowledge
+		publicKey := ks.JWKS().Keys[0]
+		forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims())
+		forged.Header["kid"] = publicKey.Kid
+		token, err := forged.SignedString([]byte(publicKey.N))
+		require.NoError(t, err)
+		assert.False(t, verify(ks, token))
+
+		// An HS256 key set does not accept asymmetric tokens either
+		hs, err := jwtI.NewKeySet("HS256", []byte("secret"), 0)
+		require.NoError(t, err)
+		token, err = ks.Sign(claims())
+		require.NoError(t, err)
+		assert.False(t, verify(hs, token))
+	})
+
+	t.Run("Retired keys verify until the retention period ends", func(t *testing.T) {
+		ks, err := jwtI.NewKeySet("EdDSA", nil, 50*time.Millisecond)
+		require.NoError(t, err)
+		require.NoError(t, ks.Rotate())
+		first := ks.ActiveKeyID()
+		token, err := ks.Sign(claims())
+		require.NoError(t, err)
+
+		require.NoError(t, ks.Rotate())
+		assert.NotEqual(t, first, ks.ActiveKeyID())
+		assert.True(t, verify(ks, token), "the retired key should still verify")
+		assert.Len(t, ks.JWKS().Keys, 2)
+
+		// Keys retired for longer than the retention period are pruned on the next rotation
+		time.Sleep(100 * time.Millisecond)
+		require.NoError(t, ks.Rotate())
+		assert.False(t, verify(ks, token), "the pruned key should no longer verify")
+		assert.Len(t, ks.JWKS().Keys, 2)
+	})
+}
+
+func TestKeySetLoadDir(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+	rsaPKCS8, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	require.NoError(t, err)
+
+	t.Run("The key that sorts last signs by default", func(t *testing.T) {
+		dir := t.TempDir()
+		writePEM(t, dir, "2024-01.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+		writePEM(t, dir, "2024-02.pem", "PRIVATE KEY", rsaPKCS8)
+
+		ks, err := jwtI.NewKeySet("RS256", nil, time.Hour)
+		require.NoError(t, err)
+		require.NoError(t, ks.LoadDir(dir, ""))
+		assert.Equal(t, "2024-02", ks.ActiveKeyID())
+		assert.Len(t, ks.JWKS().Keys, 2)
+
+		token, err := ks.Sign(claims())
+		require.NoError(t, err)
+		assert.True(t, verify(ks, token))
+	})
+
+	t.Run("The active key can be chosen", func(t *testing.T) {
+		dir := t.TempDir()
+		writePEM(t, dir, "a.pem", "PRIVATE KEY", edDER)
+		writePEM(t, dir, "b.pem", "PRIVATE KEY", edDER)
+
+		ks, err := jwtI.NewKeySet("EdDSA", nil, time.Hour)
+		require.NoError(t, err)
+		require.NoError(t, ks.LoadDir(dir, "a"))
+		assert.Equal(t, "a", ks.ActiveKeyID())
+
+		ks, err = jwtI.NewKeySet("EdDSA", nil, time.Hour)
+		require.NoError(t, err)
+		assert.Error(t, ks.LoadDir(dir, "missing"))
+	})
+
+	t.Run("Reloading picks up new keys and retires removed ones", func(t *testing.T) {
+		dir := t.TempDir()
+		writePEM(t, dir, "2024-01.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+
+		ks, err := jwtI.NewKeySet("RS256", nil, time.Hour)
+		require.NoError(t, err)
+		require.NoError(t, ks.LoadDir(dir, ""))
+		old, err := ks.Sign(claims())
+		require.NoError(t, err)
+
+		newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		writePEM(t, dir, "2024-02.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(newKey))
+		require.NoError(t, ks.LoadDir(dir, ""))
+		assert.Equal(t, "2024-02", ks.ActiveKeyID())
+		assert.True(t, verify(ks, old), "keys still on disk keep verifying")
+
+		require.NoError(t, os.Remove(filepath.Join(dir, "2024-01.pem")))
+		require.NoError(t, ks.LoadDir(dir, ""))
+		assert.True(t, verify(ks, old), "removed keys verify during the retention period")
+
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "2024-03.pem"), []byte("not a key"), 0o600))
+		assert.Error(t, ks.LoadDir(dir, ""))
+		assert.Equal(t, "2024-02", ks.ActiveKeyID(), "a failed reload keeps the current keys")
+	})
+
+	t.Run("Removed keys are dropped after the retention period", func(t *testing.T) {
+		dir := t.TempDir()
+		writePEM(t, dir, "a.pem", "PRIVATE KEY", edDER)
+
+		ks, err := jwtI.NewKeySet("EdDSA", nil, 0)
+		require.NoError(t, err)
+		require.NoError(t, ks.LoadDir(dir, ""))
+		old, err := ks.Sign(claims())
+		require.NoError(t, err)
+
+		require.NoError(t, os.Rename(filepath.Join(dir, "a.pem"), filepath.Join(dir, "b.pem")))
+		require.NoError(t, ks.LoadDir(dir, ""))
+		time.Sleep(time.Millisecond)
+		require.NoError(t, ks.LoadDir(dir, ""))
+		assert.Equal(t, "b", ks.ActiveKeyID())
+		assert.False(t, verify(ks, old))
+	})
+
+	t.Run("Invalid directories are rejected", func(t *testing.T) {
+		ks, err := jwtI.NewKeySet("EdDSA", nil, time.Hour)
+		require.NoError(t, err)
+		assert.Error(t, ks.LoadDir(t.TempDir(), ""), "empty directory")
+
+		dir := t.TempDir()
+		writePEM(t, dir, "rsa.pem", "PRIVATE KEY", rsaPKCS8)
+		assert.Error(t, ks.LoadDir(dir, ""), "key of another algorithm")
+
+		dir = t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "garbage.pem"), []byte("not a key"), 0o600))
+		assert.Error(t, ks.LoadDir(dir, ""), "not a PEM file")
+	})
+}
+
+func TestJWKS(t *testing.T) {
+	t.Run("RSA keys", func(t *testing.T) {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		dir := t.TempDir()
+		writePEM(t, dir, "rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+		ks, err := jwtI.NewKeySet("RS256", nil, time.Hour)
+		require.NoError(t, err)
+		require.NoError(t, ks.LoadDir(dir, ""))
+
+		keys := ks.JWKS().Keys
+		require.Len(t, keys, 1)
+		assert.Equal(t, jwtI.JSONWebKey{
+			Kty: "RSA",
+			Kid: "rsa",
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+		}, keys[0])
+	})
+
+	t.Run("Ed25519 keys", func(t *testing.T) {
+		publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		require.NoError(t, err)
+		dir := t.TempDir()
+		writePEM(t, dir, "ed.pem", "PRIVATE KEY", der)
+		ks, err := jwtI.NewKeySet("EdDSA", nil, time.Hour)
+		require.NoError(t, err)
+		require.NoError(t, ks.LoadDir(dir, ""))
+
+		keys := ks.JWKS().Keys
+		require.Len(t, keys, 1)
+		assert.Equal(t, jwtI.JSONWebKey{
+			Kty: "OKP",
+			Kid: "ed",
+			Use: "sig",
+			Alg: "EdDSA",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(publicKey),
+		}, keys[0])
+	})
+
+	t.Run("HS256 secrets are never published", func(t *testing.T) {
+		ks, err := jwtI.NewKeySet("HS256", []byte("secret"), 0)
+		require.NoError(t, err)
+		assert.Empty(t, ks.JWKS().Keys)
+	})
+}
+
+func TestNewKeys(t *testing.T) {
+	t.Run("Asymmetric keys need a key directory", func(t *testing.T) {
+		_, err := jwtI.NewKeys(config.JWTConfig{SigningAlg: "EdDSA"})
+		assert.Error(t, err)
+	})
+
+	t.Run("Ephemeral keys are generated when enabled", func(t *testing.T) {
+		ks, err := jwtI.NewKeys(config.JWTConfig{SigningAlg: "EdDSA", EphemeralKeys: true})
+		require.NoError(t, err)
+		assert.NotEmpty(t, ks.ActiveKeyID())
+	})
+}
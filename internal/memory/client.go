@@ -0,0 +1,189 @@
+// Package memory provides in-process implementations of the stores that are otherwise kept in Redis,
+// so that single-node deployments and tests can run without Redis. They are not shared between
+// server instances, and their contents are lost when the server stops.
+// This file specifically includes a key-value store implementing the Redis client interface.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// sweepInterval is how often expired entries are removed from the in-process stores.
+const sweepInterval = time.Minute
+
+// entry is a value of the key-value store.
+type entry struct {
+	value     string
+	expiresAt time.Time // Zero if the entry does not expire
+}
+
+// Client is an in-process key-value store implementing the subset of Redis commands in redis.Client
+// of the internal/redis package. It can back the token revocations, password reset tokens and login
+// challenges of a single server instance.
+type Client struct {
+	mu        sync.Mutex
+	entries   map[string]entry
+	lastSweep time.Time
+}
+
+// NewClient creates an empty Client.
+func NewClient() *Client {
+	return &Client{entries: map[string]entry{}, lastSweep: time.Now()}
+}
+
+// Set stores a value, which expires after the expiration unless it is zero.
+func (c *Client) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.sweep(now)
+	c.entries[key] = newEntry(value, expiration, now)
+	return redis.NewStatusResult("OK", nil)
+}
+
+// SetNX stores a value unless the key already exists, reporting whether it was stored.
+func (c *Client) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.BoolCmd {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.sweep(now)
+	if _, ok := c.get(key, now); ok {
+		return redis.NewBoolResult(false, nil)
+	}
+	c.entries[key] = newEntry(value, expiration, now)
+	return redis.NewBoolResult(true, nil)
+}
+
+// Get returns a value, or redis.Nil if it does not exist.
+func (c *Client) Get(ctx context.Context, key string) *redis.StringCmd {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.get(key, time.Now())
+	if !ok {
+		return redis.NewStringResult("", redis.Nil)
+	}
+	return redis.NewStringResult(value, nil)
+}
+
+// GetDel returns and deletes a value, or returns redis.Nil if it does not exist.
+func (c *Client) GetDel(ctx context.Context, key string) *redis.StringCmd {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.get(key, time.Now())
+	if !ok {
+		return redis.NewStringResult("", redis.Nil)
+	}
+	delete(c.entries, key)
+	return redis.NewStringResult(value, nil)
+}
+
+// MGet returns the values of the keys, with nil for the keys that don't exist.
+func (c *Client) MGet(ctx context.Context, keys ...string) *redis.SliceCmd {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		if value, ok := c.get(key, now); ok {
+			values[i] = value
+		}
+	}
+	return redis.NewSliceResult(values, nil)
+}
+
+// Del deletes the keys, returning the number of keys that existed.
+func (c *Client) Del(ctx context.Context, keys ...string) *redis.IntCmd {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	var deleted int64
+	for _, key := range keys {
+		if _, ok := c.get(key, now); ok {
+			delete(c.entries, key)
+			deleted++
+		}
+	}
+	return redis.NewIntResult(deleted, nil)
+}
+
+// Incr increments an integer value, starting from zero if it does not exist, and returns the new value.
+// The expiration of an existing value is kept.
+func (c *Client) Incr(ctx context.Context, key string) *redis.IntCmd {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.sweep(now)
+	var count int64
+	if value, ok := c.get(key, now); ok {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return redis.NewIntResult(0, fmt.Errorf("value is not an integer"))
+		}
+		count = n
+	}
+	count++
+	c.entries[key] = entry{value: strconv.FormatInt(count, 10), expiresAt: c.entries[key].expiresAt}
+	return redis.NewIntResult(count, nil)
+}
+
+// ExpireAt sets the time a value expires, reporting whether it exists.
+func (c *Client) ExpireAt(ctx context.Context, key string, tm time.Time) *redis.BoolCmd {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.get(key, time.Now())
+	if !ok {
+		return redis.NewBoolResult(false, nil)
+	}
+	c.entries[key] = entry{value: value, expiresAt: tm}
+	return redis.NewBoolResult(true, nil)
+}
+
+// newEntry creates an entry that expires after the expiration unless it is zero.
+// Values are stored as strings, like Redis does.
+func newEntry(value interface{}, expiration time.Duration, now time.Time) entry {
+	var e entry
+	switch v := value.(type) {
+	case string:
+		e.value = v
+	case []byte:
+		e.value = string(v)
+	default:
+		e.value = fmt.Sprint(v)
+	}
+	if expiration > 0 {
+		e.expiresAt = now.Add(expiration)
+	}
+	return e
+}
+
+// get returns a value that has not expired. The caller must hold the lock.
+func (c *Client) get(key string, now time.Time) (string, bool) {
+	e, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	if !e.expiresAt.IsZero() && !now.Before(e.expiresAt) {
+		delete(c.entries, key)
+		return "", false
+	}
+	return e.value, true
+}
+
+// sweep removes the expired entries, at most once per sweepInterval. The caller must hold the lock.
+func (c *Client) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < sweepInterval {
+		return
+	}
+	c.lastSweep = now
+	for key, e := range c.entries {
+		if !e.expiresAt.IsZero() && !now.Before(e.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+}
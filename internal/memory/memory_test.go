@@ -0,0 +1,137 @@
+package memory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pageza/chat-app/internal/memory"
+	redisI "github.com/pageza/chat-app/internal/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	client := memory.NewClient()
+
+	t.Run("Values can be read and deleted", func(t *testing.T) {
+		require.NoError(t, client.Set(ctx, "a", "1", 0).Err())
+		require.NoError(t, client.Set(ctx, "b", []byte("2"), 0).Err())
+
+		values, err := client.MGet(ctx, "a", "missing", "b").Result()
+		require.NoError(t, err)
+		assert.Equal(t, []interface{}{"1", nil, "2"}, values)
+
+		value, err := client.GetDel(ctx, "a").Result()
+		require.NoError(t, err)
+		assert.Equal(t, "1", value)
+		assert.Equal(t, redis.Nil, client.Get(ctx, "a").Err())
+		assert.Equal(t, int64(1), client.Del(ctx, "a", "b").Val())
+	})
+
+	t.Run("Values expire", func(t *testing.T) {
+		require.NoError(t, client.Set(ctx, "c", "3", 10*time.Millisecond).Err())
+		assert.Equal(t, "3", client.Get(ctx, "c").Val())
+		time.Sleep(20 * time.Millisecond)
+		assert.Equal(t, redis.Nil, client.Get(ctx, "c").Err())
+	})
+
+	t.Run("SetNX only sets missing keys", func(t *testing.T) {
+		assert.True(t, client.SetNX(ctx, "d", "4", 0).Val())
+		assert.False(t, client.SetNX(ctx, "d", "5", 0).Val())
+		assert.Equal(t, "4", client.Get(ctx, "d").Val())
+	})
+
+	t.Run("Failed attempts are counted until the token expires", func(t *testing.T) {
+		expiresAt := time.Now().Add(time.Second).Unix()
+		for i := int64(1); i <= 3; i++ {
+			count, err := redisI.CountFailedAttempt(ctx, client, "token-0", expiresAt)
+			require.NoError(t, err)
+			assert.Equal(t, i, count)
+		}
+		time.Sleep(time.Until(time.Unix(expiresAt, 0)) + 10*time.Millisecond)
+		count, err := redisI.CountFailedAttempt(ctx, client, "token-0", time.Now().Add(time.Hour).Unix())
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("Token revocation works in-process", func(t *testing.T) {
+		revocations := &redisI.Revocations{Client: client}
+		issuedAt := time.Now().Add(-time.Minute)
+		require.NoError(t, revocations.RevokeToken(ctx, "token-1", time.Now().Add(time.Hour).Unix()))
+		revoked, err := revocations.IsTokenRevoked(ctx, "token-1", "alice", issuedAt)
+		require.NoError(t, err)
+		assert.True(t, revoked)
+
+		require.NoError(t, revocations.RevokeUserTokens(ctx, "bob"))
+		revoked, err = revocations.IsTokenRevoked(ctx, "token-2", "bob", issuedAt)
+		require.NoError(t, err)
+		assert.True(t, revoked)
+	})
+}
+
+func TestRateLimiter(t *testing.T) {
+	ctx := context.Background()
+	limiter := memory.NewRateLimiter()
+
+	for i := 0; i < 3; i++ {
+		result, err := limiter.Allow(ctx, "ip:203.0.113.7", 3, time.Minute)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 2-i, result.Remaining)
+	}
+
+	result, err := limiter.Allow(ctx, "ip:203.0.113.7", 3, time.Minute)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 3, result.Limit)
+	// One token refills every 20 seconds
+	assert.InDelta(t, 20*time.Second, result.RetryAfter, float64(time.Second))
+
+	result, err = limiter.Allow(ctx, "ip:198.51.100.1", 3, time.Minute)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
+
+func TestPresence(t *testing.T) {
+	ctx := context.Background()
+	presence := memory.NewPresence()
+
+	online, _ := presence.Connect(ctx, 1)
+	assert.True(t, online)
+	online, _ = presence.Connect(ctx, 1)
+	assert.False(t, online, "Second connection")
+
+	users, err := presence.Online(ctx, []uint{1, 2})
+	require.NoError(t, err)
+	assert.Equal(t, map[uint]bool{1: true, 2: false}, users)
+
+	offline, _ := presence.Disconnect(ctx, 1)
+	assert.False(t, offline)
+	offline, _ = presence.Disconnect(ctx, 1)
+	assert.True(t, offline)
+	users, _ = presence.Online(ctx, []uint{1})
+	assert.False(t, users[1])
+}
+
+func TestPubSub(t *testing.T) {
+	ctx := context.Background()
+	bus := memory.NewPubSub()
+
+	first, unsubscribeFirst, err := bus.Subscribe(ctx)
+	require.NoError(t, err)
+	second, unsubscribeSecond, err := bus.Subscribe(ctx)
+	require.NoError(t, err)
+	defer unsubscribeSecond()
+
+	require.NoError(t, bus.Publish(ctx, []byte("hello")))
+	assert.Equal(t, []byte("hello"), <-first)
+	assert.Equal(t, []byte("hello"), <-second)
+
+	unsubscribeFirst()
+	require.NoError(t, bus.Publish(ctx, []byte("again")))
+	assert.Equal(t, []byte("again"), <-second)
+	assert.Empty(t, first)
+}
@@ -0,0 +1,56 @@
+// Package memory provides in-process implementations of the stores that are otherwise kept in Redis.
+// This file specifically includes presence tracking.
+package memory
+
+import (
+	"context"
+	"sync"
+)
+
+// Presence tracks which users are online by counting their open connections to this server instance.
+// Connections close with the process, so unlike Redis presence, it needs no expiry.
+type Presence struct {
+	mu          sync.Mutex
+	connections map[uint]int
+}
+
+// NewPresence creates a Presence without any users online.
+func NewPresence() *Presence {
+	return &Presence{connections: map[uint]int{}}
+}
+
+// Connect records a new connection of the user. It reports whether the user just came online.
+func (p *Presence) Connect(ctx context.Context, userID uint) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.connections[userID]++
+	return p.connections[userID] == 1, nil
+}
+
+// Refresh does nothing, since connections can't outlive the process.
+func (p *Presence) Refresh(ctx context.Context, userID uint) error {
+	return nil
+}
+
+// Disconnect records that a connection of the user was closed. It reports whether the user went offline.
+func (p *Presence) Disconnect(ctx context.Context, userID uint) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.connections[userID] <= 1 {
+		delete(p.connections, userID)
+		return true, nil
+	}
+	p.connections[userID]--
+	return false, nil
+}
+
+// Online reports which of the users are online.
+func (p *Presence) Online(ctx context.Context, userIDs []uint) (map[uint]bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	online := make(map[uint]bool, len(userIDs))
+	for _, id := range userIDs {
+		online[id] = p.connections[id] > 0
+	}
+	return online, nil
+}
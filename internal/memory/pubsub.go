@@ -0,0 +1,49 @@
+// Package memory provides in-process implementations of the stores that are otherwise kept in Redis.
+// This file specifically includes the event bus.
+package memory
+
+import (
+	"context"
+	"sync"
+)
+
+// subscriberBuffer is the number of payloads buffered for a subscriber. Payloads are dropped for
+// subscribers that fall further behind, like Redis does for slow Pub/Sub clients.
+const subscriberBuffer = 100
+
+// PubSub is an in-process event bus, delivering published payloads to the subscribers of this server instance.
+type PubSub struct {
+	mu          sync.Mutex
+	subscribers map[chan []byte]struct{}
+}
+
+// NewPubSub creates a PubSub without subscribers.
+func NewPubSub() *PubSub {
+	return &PubSub{subscribers: map[chan []byte]struct{}{}}
+}
+
+// Publish delivers a payload to every subscriber.
+func (p *PubSub) Publish(ctx context.Context, payload []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for subscriber := range p.subscribers {
+		select {
+		case subscriber <- payload:
+		default: // The subscriber is too slow
+		}
+	}
+	return nil
+}
+
+// Subscribe returns the payloads published from now on, until the returned function is called.
+func (p *PubSub) Subscribe(ctx context.Context) (<-chan []byte, func(), error) {
+	subscriber := make(chan []byte, subscriberBuffer)
+	p.mu.Lock()
+	p.subscribers[subscriber] = struct{}{}
+	p.mu.Unlock()
+	return subscriber, func() {
+		p.mu.Lock()
+		delete(p.subscribers, subscriber)
+		p.mu.Unlock()
+	}, nil
+}
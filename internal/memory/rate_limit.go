@@ -0,0 +1,75 @@
+// Package memory provides in-process implementations of the stores that are otherwise kept in Redis.
+// This file specifically includes the token bucket rate limiter.
+package memory
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	redisI "github.com/pageza/chat-app/internal/redis"
+)
+
+// bucket is the token bucket of a rate limit key.
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	window    time.Duration
+}
+
+// RateLimiter limits request rates with in-process token buckets, using the same algorithm as
+// the Redis rate limiter: each key has a bucket of limit tokens that refills evenly over the window.
+type RateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewRateLimiter creates a RateLimiter without any buckets.
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{buckets: map[string]*bucket{}, lastSweep: time.Now()}
+}
+
+// Allow takes a token from the key's bucket, reporting whether the request is allowed.
+func (l *RateLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (redisI.RateLimitResult, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.sweep(now)
+
+	capacity := float64(limit)
+	rate := capacity / float64(window) // Tokens per nanosecond
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, updatedAt: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+float64(now.Sub(b.updatedAt))*rate)
+	b.updatedAt = now
+	b.window = window
+
+	result := redisI.RateLimitResult{Limit: limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration(math.Ceil((1 - b.tokens) / rate))
+	}
+	result.Remaining = int(b.tokens)
+	return result, nil
+}
+
+// sweep removes the buckets that have refilled completely, at most once per sweepInterval.
+// The caller must hold the lock.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.updatedAt) >= b.window {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Package middleware provides utility functions for handling middleware logic in the application.
+// This file specifically includes the access log.
+
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// responseRecorder records the status and size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(data)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Flush lets streaming handlers, such as the event stream, flush through the recorder.
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// AccessLogMiddleware returns a middleware that logs every request with its status, response size and latency.
+// Client IP addresses are determined by the resolver, so that requests through proxies are attributed correctly.
+func AccessLogMiddleware(resolver *ClientIPResolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			recorder := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r)
+
+			status := recorder.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			entry := logrus.WithFields(logrus.Fields{
+				"method":     r.Method,
+				"url":        r.URL.String(),
+				"ip":         resolver.ClientIP(r),
+				"status":     status,
+				"bytes":      recorder.bytes,
+				"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+				"request_id": RequestID(r),
+				"user_agent": r.UserAgent(),
+			})
+			switch {
+			case status >= 500:
+				entry.Error("Request failed")
+			case status >= 400:
+				entry.Warn("Request rejected")
+			default:
+				entry.Info("Request handled")
+			}
+		})
+	}
+}
@@ -8,10 +8,14 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
-	"github.com/pageza/chat-app/internal/common"
-	"github.com/pageza/chat-app/internal/config"
+	apierrors "github.com/pageza/chat-app/internal/errors"
+	jwtI "github.com/pageza/chat-app/internal/jwt"
+	"github.com/pageza/chat-app/internal/models"
 	"github.com/pageza/chat-app/internal/redis"
 	"github.com/sirupsen/logrus"
 )
@@ -20,95 +24,295 @@ type Auth interface {
 	ValidateToken(r *http.Request) bool
 }
 
+// Reasons an access token is rejected. They are logged, but never sent to the client.
+var (
+	errNoToken      = errors.New("no access token")
+	errInvalidToken = errors.New("invalid access token")
+	errTokenRevoked = errors.New("access token has been revoked")
+	errUnknownUser  = errors.New("user not found")
+)
+
+// apiTokenTouchInterval limits how often the last-used time of an API token is written.
+const apiTokenTouchInterval = time.Minute
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	User     *models.User
+	Token    string           // The raw access token or API token
+	Claims   jwt.MapClaims    // The verified claims of the access token; nil for API tokens
+	APIToken *models.APIToken // Set when the request was authenticated with a personal API token
+}
+
+// HasScope reports whether the principal may act within the scope.
+// Sessions may act within every scope; API tokens only within the scopes they were granted.
+func (p *Principal) HasScope(scope string) bool {
+	return p.APIToken == nil || p.APIToken.HasScope(scope)
+}
+
+// principalKey is the context key under which the Principal is stored.
+type principalKey struct{}
+
+// WithPrincipal returns a copy of the context carrying the principal.
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal stored in the context by AuthMiddleware, if any.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
+
+// CurrentUser returns the authenticated user of the request, if any.
+func CurrentUser(r *http.Request) (*models.User, bool) {
+	principal, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		return nil, false
+	}
+	return principal.User, true
+}
+
+// UserLoader is an interface for loading the user an access token was issued to.
+type UserLoader interface {
+	GetUserByID(userID string) (*models.User, error)
+	GetUserByUsername(username string) (*models.User, error)
+}
+
+// APITokenLookup is an interface for looking up personal API tokens.
+type APITokenLookup interface {
+	GetAPITokenByHash(tokenHash string) (*models.APIToken, error)
+	TouchAPIToken(tokenID uint, usedAt time.Time) error
+}
+
+// Authenticator authenticates requests using the access token from the token cookie
+// or the Authorization header, and loads the user the token was issued to.
+// Personal API tokens are only accepted by routes wrapped with RequireScope.
+type Authenticator struct {
+	Keys        *jwtI.KeySet // Verifies the signatures of access tokens
+	Users       UserLoader
+	Revocations redis.RevocationStore
+	APITokens   APITokenLookup
+	Limiter     *RateLimiter // Limits the requests per user, if set
+
+	// FailOpen accepts tokens whose revocation can't be checked, e.g. while Redis is down.
+	// By default, they are rejected.
+	FailOpen bool
+}
+
 // AuthMiddleware is a middleware function for handling authentication.
-// It checks for a valid JWT token in the request cookie and proceeds to the next handler if valid.
-// AuthMiddleware is a middleware function for handling authentication.
-func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+// It rejects the request unless it carries a valid session access token, and otherwise stores
+// the Principal in the request context before calling the next handler.
+func (a *Authenticator) AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !ValidateToken(r) {
-			unauthorizedAccess(w, r)
+		principal, ok := a.authenticate(w, r)
+		if !ok {
+			return
+		}
+		if principal.APIToken != nil {
+			forbiddenAccess(w, r, "API tokens cannot be used for this endpoint")
 			return
 		}
-		next(w, r)
+		next(w, r.WithContext(WithPrincipal(r.Context(), principal)))
 	})
 }
 
-// validateToken validates the JWT token from the request.
-func ValidateToken(r *http.Request) bool {
-	// Check if the request object is nil
-	if r == nil {
-		return false
+// RequireScope returns a middleware that authenticates the request like AuthMiddleware, but also
+// accepts personal API tokens that were granted the scope.
+func (a *Authenticator) RequireScope(scope string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := a.authenticate(w, r)
+			if !ok {
+				return
+			}
+			if !principal.HasScope(scope) {
+				forbiddenAccess(w, r, "API token is missing the "+scope+" scope")
+				return
+			}
+			next(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+		})
 	}
+}
 
-	cookie, err := r.Cookie("token")
-	// Check if error occurred or cookie is nil
-	if err != nil || cookie == nil {
-		return false
+// authenticate authenticates the request, writing an error response and returning false if that fails.
+func (a *Authenticator) authenticate(w http.ResponseWriter, r *http.Request) (*Principal, bool) {
+	principal, err := a.Authenticate(r)
+	if err == models.ErrAccountSuspended {
+		forbiddenAccess(w, r, "Account suspended")
+		return nil, false
+	}
+	if err != nil {
+		unauthorizedAccess(w, r, err)
+		return nil, false
+	}
+	if a.Limiter != nil && !a.Limiter.AllowUser(w, r, principal.User.ID) {
+		return nil, false
+	}
+	return principal, true
+}
+
+// Authenticate validates the request's access token or personal API token and loads its user.
+// It returns models.ErrAccountSuspended if the user is suspended.
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	tokenString := TokenFromRequest(r)
+	if strings.HasPrefix(tokenString, models.APITokenPrefix) {
+		return a.authenticateAPIToken(r, tokenString)
 	}
 
-	// Check if the token is blacklisted
-	rdb := redis.GetRedisClient()
-	// Check if Redis client is nil
-	if rdb == nil {
-		return false
+	claims, err := a.checkToken(r.Context(), tokenString)
+	if err != nil {
+		return nil, err
 	}
 
-	isBlacklisted, err := rdb.Get(context.TODO(), cookie.Value).Result()
-	if err == nil && isBlacklisted == "blacklisted" {
-		return false
+	username, _ := claims["sub"].(string)
+	if username == "" {
+		return nil, errInvalidToken
+	}
+	user, err := a.Users.GetUserByUsername(username)
+	if err != nil || user == nil {
+		return nil, errUnknownUser
+	}
+	if user.IsSuspended() {
+		return nil, models.ErrAccountSuspended
+	}
+
+	return &Principal{User: user, Token: tokenString, Claims: claims}, nil
+}
+
+// authenticateAPIToken validates a personal API token and loads the user it acts as.
+func (a *Authenticator) authenticateAPIToken(r *http.Request, tokenString string) (*Principal, error) {
+	if a.APITokens == nil {
+		return nil, errInvalidToken
 	}
 
-	tokenStr := cookie.Value
-	claims := &jwt.StandardClaims{}
-	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-		// Check if token is nil
-		if token == nil {
-			return nil, errors.New("token is nil")
+	apiToken, err := a.APITokens.GetAPITokenByHash(models.HashAPIToken(tokenString))
+	if err != nil || apiToken == nil || apiToken.IsExpired() {
+		return nil, errInvalidToken
+	}
+
+	user, err := a.Users.GetUserByID(strconv.FormatUint(uint64(apiToken.UserID), 10))
+	if err != nil || user == nil {
+		return nil, errUnknownUser
+	}
+	if user.IsSuspended() {
+		return nil, models.ErrAccountSuspended
+	}
+
+	// Record the last use, but not on every request
+	now := time.Now()
+	if apiToken.LastUsedAt == nil || now.Sub(*apiToken.LastUsedAt) > apiTokenTouchInterval {
+		if err := a.APITokens.TouchAPIToken(apiToken.ID, now); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"token_id": apiToken.ID,
+			}).Warnf("Could not record API token use: %v", err)
 		}
-		return []byte(config.JwtSecret), nil
-	})
+		apiToken.LastUsedAt = &now
+	}
 
-	// Check if token is nil after parsing
-	if token == nil {
-		return false
+	return &Principal{User: user, Token: tokenString, APIToken: apiToken}, nil
+}
+
+// TokenFromRequest returns the access token from the Authorization header ("Bearer <token>")
+// or, failing that, from the token cookie. It returns an empty string if there is none.
+func TokenFromRequest(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	if cookie, err := r.Cookie("token"); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// checkToken verifies an access token and returns its claims.
+// It rejects revoked tokens and tokens that are still awaiting a second factor.
+// If the revocation store is unavailable, the token is accepted if FailOpen is set and rejected otherwise.
+func (a *Authenticator) checkToken(ctx context.Context, tokenString string) (jwt.MapClaims, error) {
+	if tokenString == "" {
+		return nil, errNoToken
+	}
+	if a.Keys == nil {
+		return nil, errInvalidToken
+	}
+
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, a.Keys.Keyfunc)
+	if err != nil || token == nil || !token.Valid {
+		return nil, errInvalidToken
+	}
+
+	// Check if the token has been revoked
+	tokenID, _ := claims["jti"].(string)
+	username, _ := claims["sub"].(string)
+	if tokenID == "" {
+		return nil, errInvalidToken
+	}
+	revoked, err := a.Revocations.IsTokenRevoked(ctx, tokenID, username, jwtI.IssuedAt(claims))
+	if err != nil && !a.FailOpen {
+		return nil, err
+	}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"user": username,
+		}).Warnf("Could not check token revocation, accepting the token: %v", err)
+	}
+	if revoked {
+		return nil, errTokenRevoked
+	}
+
+	// Tokens awaiting a second factor and refresh tokens are not access tokens
+	if jwtI.IsMFAPendingToken(claims) || jwtI.IsRefreshToken(claims) {
+		return nil, errInvalidToken
 	}
 
-	return err == nil && token.Valid
+	return claims, nil
+}
+
+// ValidateToken validates the JWT token from the request against the authenticator's revocation store.
+func (a *Authenticator) ValidateToken(r *http.Request) bool {
+	if r == nil {
+		return false
+	}
+	_, err := a.checkToken(r.Context(), TokenFromRequest(r))
+	return err == nil
 }
 
 // unauthorizedAccess logs and responds to unauthorized access attempts.
-func unauthorizedAccess(w http.ResponseWriter, r *http.Request) {
+func unauthorizedAccess(w http.ResponseWriter, r *http.Request, reason error) {
 	logrus.WithFields(logrus.Fields{
 		"method": r.Method,
 		"url":    r.URL.String(),
 		"ip":     r.RemoteAddr,
+		"reason": reason.Error(),
 	}).Warn("Unauthorized access attempt")
-	common.RespondWithError(w, common.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
+	apierrors.RespondWithError(w, apierrors.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
+}
+
+// forbiddenAccess logs and responds to requests by users who lack the required permissions.
+func forbiddenAccess(w http.ResponseWriter, r *http.Request, message string) {
+	logrus.WithFields(logrus.Fields{
+		"method": r.Method,
+		"url":    r.URL.String(),
+		"ip":     r.RemoteAddr,
+	}).Warn("Forbidden access attempt")
+	apierrors.RespondWithError(w, apierrors.NewAPIError(http.StatusForbidden, message))
 }
 
-// CheckAuth is a utility function to check if the request is authenticated.
-// It checks for a valid JWT token in the request cookie and responds with the authentication status.
-func CheckAuth(w http.ResponseWriter, r *http.Request) {
+// CheckAuth is a handler that checks if the request is authenticated.
+// It checks for a valid JWT token in the request and responds with the authentication status.
+func (a *Authenticator) CheckAuth(w http.ResponseWriter, r *http.Request) {
+	checkAuth(w, r, a.ValidateToken)
+}
+
+// checkAuth responds with the authentication status of the request.
+func checkAuth(w http.ResponseWriter, r *http.Request, validate func(r *http.Request) bool) {
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
-	// Retrieve and validate the token from the cookie
-	cookie, err := r.Cookie("token")
-	if err != nil {
-		common.RespondWithError(w, common.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
-		return
-	}
-
-	tokenStr := cookie.Value
-	claims := &jwt.StandardClaims{}
-	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.JwtSecret), nil
-	})
-
-	if err != nil || !token.Valid {
-		common.RespondWithError(w, common.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
+	if !validate(r) {
+		apierrors.RespondWithError(w, apierrors.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
 		return
 	}
 
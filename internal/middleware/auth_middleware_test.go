@@ -0,0 +1,165 @@
+package middleware_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pageza/chat-app/internal/config"
+	jwtI "github.com/pageza/chat-app/internal/jwt"
+	"github.com/pageza/chat-app/internal/memory"
+	"github.com/pageza/chat-app/internal/middleware"
+	"github.com/pageza/chat-app/internal/models"
+	redisI "github.com/pageza/chat-app/internal/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// memoryStore is an in-memory implementation of middleware.UserLoader and middleware.APITokenLookup.
+type memoryStore struct {
+	users  map[uint]*models.User
+	tokens map[string]*models.APIToken
+}
+
+func (s *memoryStore) GetUserByID(userID string) (*models.User, error) {
+	id, _ := strconv.ParseUint(userID, 10, 64)
+	if user, ok := s.users[uint(id)]; ok {
+		return user, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) GetUserByUsername(username string) (*models.User, error) {
+	for _, user := range s.users {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) GetAPITokenByHash(tokenHash string) (*models.APIToken, error) {
+	if token, ok := s.tokens[tokenHash]; ok {
+		return token, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) TouchAPIToken(tokenID uint, usedAt time.Time) error {
+	for _, token := range s.tokens {
+		if token.ID == tokenID {
+			token.LastUsedAt = &usedAt
+		}
+	}
+	return nil
+}
+
+func TestAPITokenAuthentication(t *testing.T) {
+	const token = models.APITokenPrefix + "bot-token"
+	expired := time.Now().Add(-time.Hour)
+
+	store := &memoryStore{
+		users: map[uint]*models.User{1: {ID: 1, Username: "bot"}},
+		tokens: map[string]*models.APIToken{
+			models.HashAPIToken(token):              {ID: 1, UserID: 1, Scopes: []string{models.ScopeMessagesWrite}},
+			models.HashAPIToken(token + "-expired"): {ID: 2, UserID: 1, Scopes: []string{models.ScopeMessagesWrite}, ExpiresAt: &expired},
+		},
+	}
+	authn := &middleware.Authenticator{Users: store, APITokens: store}
+
+	var seen *models.User
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = middleware.CurrentUser(r)
+	}
+
+	serve := func(wrap func(http.HandlerFunc) http.HandlerFunc, bearer string) int {
+		seen = nil
+		req := httptest.NewRequest("POST", "/send", nil)
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		rr := httptest.NewRecorder()
+		wrap(handler)(rr, req)
+		return rr.Code
+	}
+
+	t.Run("Token with the scope is accepted", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(authn.RequireScope(models.ScopeMessagesWrite), token))
+		assert.Equal(t, "bot", seen.Username)
+		assert.NotNil(t, store.tokens[models.HashAPIToken(token)].LastUsedAt)
+	})
+
+	t.Run("Token without the scope is forbidden", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, serve(authn.RequireScope(models.ScopeRoomsRead), token))
+		assert.Nil(t, seen)
+	})
+
+	t.Run("Tokens are not accepted by session-only routes", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, serve(authn.AuthMiddleware, token))
+		assert.Nil(t, seen)
+	})
+
+	t.Run("Unknown and expired tokens are rejected", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, serve(authn.RequireScope(models.ScopeMessagesWrite), token+"-unknown"))
+		assert.Equal(t, http.StatusUnauthorized, serve(authn.RequireScope(models.ScopeMessagesWrite), token+"-expired"))
+	})
+
+	t.Run("Tokens of suspended users are rejected", func(t *testing.T) {
+		store.users[1].SuspendedAt = &expired
+		defer func() { store.users[1].SuspendedAt = nil }()
+		assert.Equal(t, http.StatusForbidden, serve(authn.RequireScope(models.ScopeMessagesWrite), token))
+	})
+}
+
+func TestSessionTokenAuthentication(t *testing.T) {
+	keys, err := jwtI.NewKeySet("HS256", []byte("secret"), 0)
+	require.NoError(t, err)
+	tokens := jwtI.NewJwtManager(keys, config.JWTConfig{Issuer: "chat-app", TokenExpiration: time.Hour}, config.Default().Cookies)
+	user := &models.User{ID: 1, Username: "alice"}
+	store := &memoryStore{users: map[uint]*models.User{1: user}}
+	revocations := &redisI.Revocations{Client: memory.NewClient()}
+	authn := &middleware.Authenticator{Keys: keys, Users: store, Revocations: revocations}
+
+	serve := func(bearer string) int {
+		req := httptest.NewRequest("GET", "/protected", nil)
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		rr := httptest.NewRecorder()
+		authn.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})(rr, req)
+		return rr.Code
+	}
+
+	accessToken, refreshToken, err := tokens.GenerateToken(*user)
+	require.NoError(t, err)
+
+	t.Run("Access tokens are accepted", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, serve(accessToken))
+	})
+
+	t.Run("Refresh tokens are not access tokens", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, serve(refreshToken))
+	})
+
+	t.Run("MFA pending tokens are not access tokens", func(t *testing.T) {
+		pending, err := tokens.GenerateMFAToken(*user)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, serve(pending))
+	})
+
+	t.Run("Sessions issued right before a logout of all sessions are rejected", func(t *testing.T) {
+		accessToken, _, err := tokens.GenerateToken(*user)
+		require.NoError(t, err)
+		require.NoError(t, revocations.RevokeUserTokens(context.Background(), user.Username))
+		assert.Equal(t, http.StatusUnauthorized, serve(accessToken))
+	})
+
+	t.Run("Sessions issued right after a logout of all sessions are accepted", func(t *testing.T) {
+		require.NoError(t, revocations.RevokeUserTokens(context.Background(), user.Username))
+		accessToken, _, err := tokens.GenerateToken(*user)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, serve(accessToken))
+	})
+}
@@ -0,0 +1,105 @@
+package middleware_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pageza/chat-app/internal/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	trace := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	handler := middleware.Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	}), trace("outer"), trace("inner"))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, []string{"outer", "inner", "handler"}, order)
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var seen string
+	handler := middleware.RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = middleware.RequestID(r)
+	}))
+
+	t.Run("IDs are generated", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+		assert.Len(t, seen, 32)
+		assert.Equal(t, seen, rr.Header().Get(middleware.RequestIDHeader))
+	})
+
+	t.Run("Well-formed IDs from the client are kept", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(middleware.RequestIDHeader, "upstream-1234")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, "upstream-1234", seen)
+		assert.Equal(t, "upstream-1234", rr.Header().Get(middleware.RequestIDHeader))
+	})
+
+	t.Run("Malformed IDs are replaced", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(middleware.RequestIDHeader, "forged id")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		assert.Len(t, seen, 32)
+	})
+}
+
+func TestMaxBodySizeMiddleware(t *testing.T) {
+	handler := middleware.MaxBodySizeMiddleware(8, map[string]int64{"/upload": 16})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(path, body string, chunked bool) int {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		if chunked {
+			req.ContentLength = -1
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusNoContent, serve("/send", "12345678", false))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve("/send", "123456789", false))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve("/send", "123456789", true))
+	assert.Equal(t, http.StatusNoContent, serve("/upload", "123456789", false))
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	handler := middleware.TimeoutMiddleware(10*time.Millisecond, []string{"/events"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(50 * time.Millisecond):
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/chat", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Body.String(), `"code":"timeout"`)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/events", nil))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+}
@@ -0,0 +1,95 @@
+// Package middleware provides utility functions for handling middleware logic in the application.
+// This file specifically includes the extraction of the client's IP address behind reverse proxies.
+
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIPResolver determines the IP address of the client that made a request. The X-Forwarded-For
+// and X-Real-IP headers can be set by anyone, so they are only used when the request comes from a
+// trusted proxy, and only the addresses added by trusted proxies are believed.
+type ClientIPResolver struct {
+	TrustedProxies []*net.IPNet
+}
+
+// NewClientIPResolver creates a ClientIPResolver trusting the given proxies, which are IP addresses or CIDR ranges.
+func NewClientIPResolver(trustedProxies []string) (*ClientIPResolver, error) {
+	resolver := &ClientIPResolver{}
+	for _, proxy := range trustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			resolver.TrustedProxies = append(resolver.TrustedProxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		resolver.TrustedProxies = append(resolver.TrustedProxies, network)
+	}
+	return resolver, nil
+}
+
+// ClientIP returns the IP address of the client, without the port.
+// When the request comes from a trusted proxy, the X-Forwarded-For header is read from right to left,
+// and the first address that is not a trusted proxy is the client's.
+func (c *ClientIPResolver) ClientIP(r *http.Request) string {
+	remote := stripPort(r.RemoteAddr)
+	ip := net.ParseIP(remote)
+	if ip == nil || !c.isTrusted(ip) {
+		return remote
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	if len(hops) == 0 {
+		if realIP := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); realIP != nil {
+			return realIP.String()
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(stripPort(strings.TrimSpace(hops[i])))
+		if hop == nil {
+			break // Don't believe anything added before a malformed entry
+		}
+		if !c.isTrusted(hop) {
+			return hop.String()
+		}
+		ip = hop
+	}
+	// Every hop is a trusted proxy, so the left-most one is the closest to the client
+	return ip.String()
+}
+
+// isTrusted reports whether the address belongs to a trusted proxy.
+func (c *ClientIPResolver) isTrusted(ip net.IP) bool {
+	for _, network := range c.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripPort removes the port from an address, if it has one.
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
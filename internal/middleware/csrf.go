@@ -0,0 +1,139 @@
+// Package middleware provides utility functions for handling middleware logic in the application.
+// This file specifically includes a middleware for protecting cookie-authenticated requests against CSRF.
+
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/pageza/chat-app/internal/config"
+	"github.com/pageza/chat-app/internal/errors"
+	"github.com/pageza/chat-app/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// CSRF tokens are sent to the client in a cookie, and must be echoed back in a header
+// on every state-changing request (the "double-submit cookie" pattern).
+const (
+	CSRFCookieName = "csrf_token"
+	CSRFHeaderName = "X-CSRF-Token"
+)
+
+// CodeCSRFTokenInvalid is the error code of state-changing requests without a valid CSRF token.
+const CodeCSRFTokenInvalid = "csrf_token_invalid"
+
+// sessionCookies are the cookies that authenticate a request. Requests without them carry
+// no ambient credentials that a cross-site request could abuse.
+var sessionCookies = []string{"token", "refresh_token"}
+
+// CSRF protects cookie-authenticated requests against cross-site request forgery. Its tokens
+// are signed with Secret, so that they cannot be forged.
+type CSRF struct {
+	Secret  []byte
+	Cookies config.CookieConfig // Attributes of the csrf_token cookie, the same as the session cookies'
+}
+
+// Middleware rejects state-changing requests that are authenticated by cookies
+// unless the X-CSRF-Token header matches the csrf_token cookie.
+// Requests with an Authorization header (session Bearer tokens and personal API tokens) are exempt,
+// since browsers never attach that header to cross-site requests on their own.
+func (c *CSRF) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !requiresCSRFCheck(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cookie, err := r.Cookie(CSRFCookieName)
+		header := r.Header.Get(CSRFHeaderName)
+		if err != nil || header == "" || !c.validToken(cookie.Value) ||
+			subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) != 1 {
+			logrus.WithFields(logrus.Fields{
+				"method": r.Method,
+				"url":    r.URL.String(),
+				"ip":     r.RemoteAddr,
+			}).Warn("CSRF token missing or invalid")
+			errors.RespondWithError(w, errors.New(http.StatusForbidden, CodeCSRFTokenInvalid, "CSRF token missing or invalid"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// TokenHandler issues a CSRF token. The token is set in the csrf_token cookie and returned
+// in the response body; clients send it back in the X-CSRF-Token header.
+// An existing valid token is reused, so that concurrent tabs keep working.
+func (c *CSRF) TokenHandler(w http.ResponseWriter, r *http.Request) {
+	token := ""
+	if cookie, err := r.Cookie(CSRFCookieName); err == nil && c.validToken(cookie.Value) {
+		token = cookie.Value
+	} else {
+		token, err = c.newToken()
+		if err != nil {
+			errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not issue CSRF token"))
+			return
+		}
+	}
+
+	// The cookie is readable by scripts on purpose: the client must copy it into the header
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookieName,
+		Value:    token,
+		Path:     c.Cookies.Path,
+		Secure:   c.Cookies.Secure,
+		SameSite: c.Cookies.SameSite,
+	})
+	w.Header().Set("Cache-Control", "no-store")
+	utils.SendJSONResponse(w, http.StatusOK, map[string]string{"csrf_token": token})
+}
+
+// requiresCSRFCheck reports whether the request is state-changing and authenticated by cookies.
+func requiresCSRFCheck(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		return false
+	}
+	for _, name := range sessionCookies {
+		if _, err := r.Cookie(name); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// newToken returns a new token of the form "<random>.<signature>". The signature prevents
+// an attacker who can set cookies for the domain, e.g. from a subdomain, from choosing the token.
+func (c *CSRF) newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(b)
+	return nonce + "." + c.sign(nonce), nil
+}
+
+// validToken checks the signature of a CSRF token.
+func (c *CSRF) validToken(token string) bool {
+	nonce, signature, ok := strings.Cut(token, ".")
+	if !ok || nonce == "" {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(c.sign(nonce)))
+}
+
+// sign signs the random part of a CSRF token with the secret.
+func (c *CSRF) sign(nonce string) string {
+	mac := hmac.New(sha256.New, c.Secret)
+	mac.Write([]byte(nonce))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
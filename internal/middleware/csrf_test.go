@@ -0,0 +1,98 @@
+package middleware_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pageza/chat-app/internal/config"
+	"github.com/pageza/chat-app/internal/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	csrf := &middleware.CSRF{Secret: []byte("csrf-secret")}
+
+	// Issue a token
+	rr := httptest.NewRecorder()
+	csrf.TokenHandler(rr, httptest.NewRequest("GET", "/csrf-token", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var body map[string]string
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	token := body["csrf_token"]
+	require.NotEmpty(t, token)
+
+	handler := csrf.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	serve := func(method string, cookies map[string]string, headers map[string]string) int {
+		req := httptest.NewRequest(method, "/send", nil)
+		for name, value := range cookies {
+			req.AddCookie(&http.Cookie{Name: name, Value: value})
+		}
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	session := map[string]string{"token": "session", middleware.CSRFCookieName: token}
+
+	t.Run("Cookie-authenticated POST with matching header is allowed", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, serve("POST", session, map[string]string{middleware.CSRFHeaderName: token}))
+	})
+
+	t.Run("Cookie-authenticated POST without header is rejected", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, serve("POST", session, nil))
+	})
+
+	t.Run("Forged cookie and header are rejected", func(t *testing.T) {
+		forged := "attacker.chosen"
+		cookies := map[string]string{"token": "session", middleware.CSRFCookieName: forged}
+		assert.Equal(t, http.StatusForbidden, serve("POST", cookies, map[string]string{middleware.CSRFHeaderName: forged}))
+	})
+
+	t.Run("Safe methods and Bearer requests are exempt", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, serve("GET", session, nil))
+		assert.Equal(t, http.StatusNoContent, serve("POST", session, map[string]string{"Authorization": "Bearer api-token"}))
+	})
+
+	t.Run("Requests without session cookies are exempt", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, serve("POST", nil, nil))
+	})
+}
+
+func TestCSRFTokenCookie(t *testing.T) {
+	cookieFor := func(cookies config.CookieConfig) *http.Cookie {
+		csrf := &middleware.CSRF{Secret: []byte("csrf-secret"), Cookies: cookies}
+		rr := httptest.NewRecorder()
+		csrf.TokenHandler(rr, httptest.NewRequest("GET", "/csrf-token", nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+		for _, cookie := range rr.Result().Cookies() {
+			if cookie.Name == middleware.CSRFCookieName {
+				return cookie
+			}
+		}
+		t.Fatal("No csrf_token cookie was set")
+		return nil
+	}
+
+	t.Run("The cookie has the configured attributes", func(t *testing.T) {
+		cookie := cookieFor(config.CookieConfig{Secure: true, SameSite: http.SameSiteStrictMode, Path: "/api"})
+		assert.True(t, cookie.Secure)
+		assert.Equal(t, http.SameSiteStrictMode, cookie.SameSite)
+		assert.Equal(t, "/api", cookie.Path)
+		assert.False(t, cookie.HttpOnly, "clients must be able to read the token")
+	})
+
+	t.Run("Insecure cookies can be used in local development", func(t *testing.T) {
+		cookie := cookieFor(config.CookieConfig{Secure: false, SameSite: http.SameSiteLaxMode, Path: "/"})
+		assert.False(t, cookie.Secure)
+		assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+	})
+}
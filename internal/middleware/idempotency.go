@@ -0,0 +1,191 @@
+// Package middleware provides utility functions for handling middleware logic in the application.
+// This file specifically includes the handling of idempotency keys.
+
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pageza/chat-app/internal/errors"
+	"github.com/pageza/chat-app/internal/redis"
+	"github.com/sirupsen/logrus"
+)
+
+// Idempotency key headers. Clients send a random key with a request they may retry; replayed responses
+// are marked with IdempotentReplayedHeader.
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// Error codes of requests reusing an idempotency key.
+const (
+	CodeIdempotencyKeyReused = "idempotency_key_reused" // The key was used for a different request
+	CodeIdempotencyKeyInUse  = "idempotency_key_in_use" // The first request with the key is still being handled
+)
+
+// maxIdempotencyKeyLength is the maximum length of an idempotency key.
+const maxIdempotencyKeyLength = 255
+
+// idempotencyLockTTL is how long a key stays claimed by a request that is being handled. It is
+// longer than requests may take, and keeps the key from being locked for long if the server stops.
+const idempotencyLockTTL = 2 * time.Minute
+
+// replayedHeaders are the response headers that are stored and replayed. Other headers, such as
+// the session cookies set at registration, the request ID and rate limit headers, are not.
+var replayedHeaders = []string{"Content-Type", "Location"}
+
+// IdempotencyStore is an interface for storing idempotency records, such as redis.Idempotency.
+type IdempotencyStore interface {
+	Begin(ctx context.Context, key, fingerprint string, ttl time.Duration) (*redis.IdempotencyRecord, error)
+	Complete(ctx context.Context, key string, record *redis.IdempotencyRecord, ttl time.Duration) error
+	Release(ctx context.Context, key string) error
+}
+
+// Idempotency makes requests carrying an Idempotency-Key header safe to retry: the first request with
+// a key is handled and its response stored for TTL; retries with the same key and payload get the
+// stored response again instead of being handled twice.
+type Idempotency struct {
+	Store    IdempotencyStore
+	TTL      time.Duration
+	ClientIP *ClientIPResolver // Scopes the keys of anonymous requests to the client's address
+}
+
+// Middleware is a middleware function that honors idempotency keys. Keys are scoped to the route and
+// the logged-in user, or the client's IP address for anonymous requests, so it must run after
+// authentication on routes that require it. Requests without a key are handled as usual.
+//
+// A retry with a different payload is rejected with 422 Unprocessable Entity, and a retry while the
+// first request is still being handled with 409 Conflict. Server errors and rate limit rejections are
+// not stored, so that the request can be retried. When the store is unavailable, requests are handled
+// without idempotency.
+func (i *Idempotency) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idempotencyKey := r.Header.Get(IdempotencyKeyHeader)
+		if idempotencyKey == "" {
+			next(w, r)
+			return
+		}
+		if !printableASCII(idempotencyKey, maxIdempotencyKeyLength) {
+			errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid idempotency key"))
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid request payload"))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		key := i.scope(r) + ":" + idempotencyKey
+		fingerprint := requestFingerprint(r, body)
+		logger := logrus.WithFields(logrus.Fields{
+			"method":     r.Method,
+			"url":        r.URL.String(),
+			"ip":         r.RemoteAddr,
+			"request_id": RequestID(r),
+		})
+
+		record, err := i.Store.Begin(r.Context(), key, fingerprint, idempotencyLockTTL)
+		if err != nil {
+			logger.Warnf("Idempotency check failed, handling the request anyway: %v", err)
+			next(w, r)
+			return
+		}
+		if record != nil {
+			switch {
+			case record.Fingerprint != fingerprint:
+				errors.RespondWithError(w, errors.New(http.StatusUnprocessableEntity, CodeIdempotencyKeyReused, "This idempotency key was already used for a different request"))
+			case !record.Completed():
+				errors.RespondWithError(w, errors.New(http.StatusConflict, CodeIdempotencyKeyInUse, "A request with this idempotency key is still being handled"))
+			default:
+				replay(w, record)
+			}
+			return
+		}
+
+		recorder := &bufferingRecorder{responseRecorder: responseRecorder{ResponseWriter: w}}
+		stored := false
+		defer func() {
+			// Free the key if the response was not stored, even if the handler panicked
+			if !stored {
+				if err := i.Store.Release(context.Background(), key); err != nil {
+					logger.Warnf("Could not release idempotency key: %v", err)
+				}
+			}
+		}()
+		next(recorder, r)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if status >= 500 || status == http.StatusTooManyRequests {
+			return
+		}
+		record = &redis.IdempotencyRecord{Fingerprint: fingerprint, Status: status, Header: map[string]string{}, Body: recorder.body.Bytes()}
+		for _, name := range replayedHeaders {
+			if value := recorder.Header().Get(name); value != "" {
+				record.Header[name] = value
+			}
+		}
+		if err := i.Store.Complete(context.Background(), key, record, i.TTL); err != nil {
+			logger.Warnf("Could not store idempotent response: %v", err)
+			return
+		}
+		stored = true
+	})
+}
+
+// bufferingRecorder records a response, keeping a copy of its body.
+type bufferingRecorder struct {
+	responseRecorder
+	body bytes.Buffer
+}
+
+func (r *bufferingRecorder) Write(data []byte) (int, error) {
+	n, err := r.responseRecorder.Write(data)
+	r.body.Write(data[:n])
+	return n, err
+}
+
+// replay writes a stored response.
+func replay(w http.ResponseWriter, record *redis.IdempotencyRecord) {
+	for name, value := range record.Header {
+		w.Header().Set(name, value)
+	}
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(record.Status)
+	w.Write(record.Body)
+}
+
+// scope returns the scope of the request's idempotency key: the route, in any API version, and the
+// logged-in user. Anonymous requests are scoped to the client's IP address instead, so that one client
+// cannot get the responses stored for another by guessing or reusing their key.
+func (i *Idempotency) scope(r *http.Request) string {
+	path := UnversionedPath(r.URL.Path)
+	if user, ok := CurrentUser(r); ok {
+		return path + ":user:" + strconv.FormatUint(uint64(user.ID), 10)
+	}
+	resolver := i.ClientIP
+	if resolver == nil {
+		resolver = &ClientIPResolver{}
+	}
+	return path + ":ip:" + resolver.ClientIP(r)
+}
+
+// requestFingerprint identifies the request's payload, to detect keys that are reused for other requests.
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + UnversionedPath(r.URL.Path) + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,130 @@
+package middleware_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pageza/chat-app/internal/memory"
+	"github.com/pageza/chat-app/internal/middleware"
+	"github.com/pageza/chat-app/internal/models"
+	"github.com/pageza/chat-app/internal/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotency(t *testing.T) {
+	idempotency := &middleware.Idempotency{Store: &redis.Idempotency{Client: memory.NewClient()}, TTL: time.Hour}
+	handled := 0
+	status := http.StatusCreated
+	handler := idempotency.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		handled++
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(status)
+		w.Write([]byte("created " + string(body)))
+	})
+	send := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/send", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(middleware.IdempotencyKeyHeader, key)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	t.Run("Retries get the stored response", func(t *testing.T) {
+		first := send("key-1", "hello")
+		assert.Equal(t, http.StatusCreated, first.Code)
+		retry := send("key-1", "hello")
+		assert.Equal(t, 1, handled)
+		assert.Equal(t, http.StatusCreated, retry.Code)
+		assert.Equal(t, "created hello", retry.Body.String())
+		assert.Equal(t, "text/plain", retry.Header().Get("Content-Type"))
+		assert.Equal(t, "true", retry.Header().Get(middleware.IdempotentReplayedHeader))
+		assert.Empty(t, first.Header().Get(middleware.IdempotentReplayedHeader))
+	})
+
+	t.Run("Reusing a key for another payload is rejected", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnprocessableEntity, send("key-1", "goodbye").Code)
+		assert.Equal(t, 1, handled)
+	})
+
+	t.Run("Requests without a key are always handled", func(t *testing.T) {
+		send("", "hello")
+		send("", "hello")
+		assert.Equal(t, 3, handled)
+	})
+
+	t.Run("Server errors are not stored", func(t *testing.T) {
+		status = http.StatusInternalServerError
+		send("key-2", "hello")
+		status = http.StatusCreated
+		assert.Equal(t, http.StatusCreated, send("key-2", "hello").Code)
+		assert.Equal(t, 5, handled)
+	})
+
+	t.Run("Invalid keys are rejected", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, send(strings.Repeat("k", 256), "hello").Code)
+	})
+}
+
+func TestIdempotencyAcrossClients(t *testing.T) {
+	resolver, err := middleware.NewClientIPResolver([]string{"10.0.0.1"})
+	if err != nil {
+		t.Fatalf("Could not create the client IP resolver: %v", err)
+	}
+	idempotency := &middleware.Idempotency{Store: &redis.Idempotency{Client: memory.NewClient()}, TTL: time.Hour, ClientIP: resolver}
+	handled := 0
+	handler := idempotency.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		handled++
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("welcome " + r.RemoteAddr))
+	})
+	register := func(remoteAddr, forwardedFor string, user *models.User) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/register", strings.NewReader(`{"username": "alice"}`))
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		if user != nil {
+			req = req.WithContext(middleware.WithPrincipal(req.Context(), &middleware.Principal{User: user}))
+		}
+		req.Header.Set(middleware.IdempotencyKeyHeader, "shared-key")
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	first := register("203.0.113.7:5000", "", nil)
+	assert.Equal(t, http.StatusCreated, first.Code)
+
+	t.Run("Another anonymous client does not get the stored response", func(t *testing.T) {
+		other := register("198.51.100.1:6000", "", nil)
+		assert.Equal(t, 2, handled)
+		assert.Equal(t, "welcome 198.51.100.1:6000", other.Body.String())
+		assert.Empty(t, other.Header().Get(middleware.IdempotentReplayedHeader))
+	})
+
+	t.Run("Clients behind a trusted proxy are told apart", func(t *testing.T) {
+		register("10.0.0.1:7000", "198.51.100.2", nil)
+		behindProxy := register("10.0.0.1:7000", "198.51.100.3", nil)
+		assert.Equal(t, 4, handled)
+		assert.Empty(t, behindProxy.Header().Get(middleware.IdempotentReplayedHeader))
+	})
+
+	t.Run("Logged-in users do not share keys with anonymous clients", func(t *testing.T) {
+		register("203.0.113.7:5000", "", &models.User{ID: 1, Username: "alice"})
+		assert.Equal(t, 5, handled)
+	})
+
+	t.Run("The same client gets the stored response", func(t *testing.T) {
+		retry := register("203.0.113.7:5001", "", nil)
+		assert.Equal(t, 5, handled)
+		assert.Equal(t, first.Body.String(), retry.Body.String())
+		assert.Equal(t, "true", retry.Header().Get(middleware.IdempotentReplayedHeader))
+	})
+}
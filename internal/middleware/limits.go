@@ -0,0 +1,94 @@
+// Package middleware provides utility functions for handling middleware logic in the application.
+// This file specifically includes the request timeout and body size limits.
+
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pageza/chat-app/internal/errors"
+)
+
+// timeoutMessage is the body of the response to a request that timed out. http.TimeoutHandler
+// writes it as is, so it is built once.
+var timeoutMessage = func() string {
+	body, _ := json.Marshal(&errors.APIError{
+		Type:   "about:blank",
+		Title:  http.StatusText(http.StatusServiceUnavailable),
+		Status: http.StatusServiceUnavailable,
+		Code:   errors.CodeTimeout,
+		Detail: "Request timed out",
+	})
+	return string(body)
+}()
+
+// TimeoutMiddleware returns a middleware that aborts requests that take longer than the timeout, responding
+// with 503 Service Unavailable, and cancels their context. Responses are buffered until the handler is done,
+// so long-lived and streaming routes, such as the event stream, must be listed in exemptPaths. Exempt paths
+// are path prefixes, and apply to every API version. A timeout of zero disables the middleware.
+func TimeoutMiddleware(timeout time.Duration, exemptPaths []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if timeout <= 0 {
+			return next
+		}
+		limited := http.TimeoutHandler(next, timeout, timeoutMessage)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if hasPathPrefix(UnversionedPath(r.URL.Path), exemptPaths) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			limited.ServeHTTP(&timeoutWriter{ResponseWriter: w}, r)
+		})
+	}
+}
+
+// timeoutWriter labels the timeout response of http.TimeoutHandler, which has no Content-Type, as an error.
+type timeoutWriter struct {
+	http.ResponseWriter
+}
+
+func (w *timeoutWriter) WriteHeader(status int) {
+	if status == http.StatusServiceUnavailable && w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", errors.ContentType)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// MaxBodySizeMiddleware returns a middleware that limits the size of request bodies to limit bytes,
+// or to the limit listed in overrides for routes that accept larger bodies, such as uploads. Overrides
+// are keyed by exact path, without the API version prefix. Requests that announce a larger body are
+// rejected with 413 Request Entity Too Large; reading past the limit fails. A limit of zero disables
+// the middleware.
+func MaxBodySizeMiddleware(limit int64, overrides map[string]int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limit <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			max := limit
+			if override, ok := overrides[UnversionedPath(r.URL.Path)]; ok {
+				max = override
+			}
+			if r.ContentLength > max {
+				errors.RespondWithError(w, errors.NewAPIError(http.StatusRequestEntityTooLarge, "Request body is too large"))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, max)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// hasPathPrefix reports whether the path starts with one of the prefixes, matching whole path segments.
+func hasPathPrefix(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -1,5 +1,5 @@
 // Package middleware provides utility functions for handling middleware logic in the application.
-// This file specifically includes a middleware for recovering from panics.
+// This file specifically includes a middleware for recovering from panics, and the chaining of middlewares.
 
 package middleware
 
@@ -19,9 +19,10 @@ func RecoveryMiddleware(next http.Handler) http.Handler {
 			// Recover from panic and log the error
 			if err := recover(); err != nil {
 				logrus.WithFields(logrus.Fields{
-					"method": r.Method,
-					"url":    r.URL.String(),
-					"ip":     r.RemoteAddr,
+					"method":     r.Method,
+					"url":        r.URL.String(),
+					"ip":         r.RemoteAddr,
+					"request_id": RequestID(r),
 				}).Errorf("Recovered from panic: %v", err)
 				// Respond with a 500 Internal Server Error
 				errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Internal Server Error"))
@@ -31,3 +32,12 @@ func RecoveryMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Chain applies middlewares to a handler. The first middleware is the outermost one, which sees
+// the request first.
+func Chain(handler http.Handler, middlewares ...func(http.Handler) http.Handler) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
@@ -1,49 +1,162 @@
 // Package middleware provides utility functions for handling middleware logic in the application.
-// This file specifically includes a middleware for rate limiting based on the IP address.
+// This file specifically includes rate limiting per route group, client IP address and user.
 
 package middleware
 
 import (
+	"context"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/pageza/chat-app/internal/config"
+	"github.com/pageza/chat-app/internal/errors"
 	"github.com/pageza/chat-app/internal/redis"
 	"github.com/sirupsen/logrus"
 )
 
-// RateLimitMiddleware is a middleware function that enforces rate limiting based on the client's IP address.
-// It uses Redis to keep track of the number of requests from each IP and blocks any IP that exceeds the limit.
-func RateLimitMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get the client's IP address and User-Agent
-		ip := r.RemoteAddr
-		userAgent := r.UserAgent()
-
-		// Get the Redis client
-		rdb := redis.GetRedisClient()
-
-		// Check if the IP is allowed to make a request
-		allowed, err := redis.CheckRateLimit(ip, rdb)
-		if err != nil {
-			// Log the error and respond with a 500 Internal Server Error
-			logrus.WithFields(logrus.Fields{
-				"ip":        ip,
-				"userAgent": userAgent,
-			}).Errorf("Rate limit check failed: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
+// defaultRateLimitGroup is the name of the group that applies to routes that don't belong to another group.
+const defaultRateLimitGroup = "default"
+
+// RateLimitStore is an interface for counting requests against a limit, such as redis.RateLimiter
+// or the in-process memory.RateLimiter.
+type RateLimitStore interface {
+	Allow(ctx context.Context, key string, limit int, window time.Duration) (redis.RateLimitResult, error)
+}
+
+// RateLimitGroup holds the rate limits of a group of routes.
+type RateLimitGroup struct {
+	Name      string
+	Paths     []string // Path prefixes of the routes in the group
+	IPLimit   int      // Requests allowed per client IP address and window; zero for no limit
+	UserLimit int      // Requests allowed per user and window; zero for no limit
+	Window    time.Duration
+}
+
+// matches reports whether the path belongs to the group. Prefixes only match whole path segments.
+func (g *RateLimitGroup) matches(path string) bool {
+	return hasPathPrefix(path, g.Paths)
+}
+
+// RateLimiter enforces the rate limits of route groups. Its Middleware counts requests per client
+// IP address; requests authenticated by an Authenticator using the limiter are counted per user as well.
+type RateLimiter struct {
+	Store    RateLimitStore
+	Groups   []RateLimitGroup // Checked in order; the first group matching the path applies
+	Default  RateLimitGroup   // Applies when no group matches
+	ClientIP *ClientIPResolver
+
+	// FailOpen lets requests through when the store is unavailable, e.g. while Redis is down.
+	// By default, they are rejected.
+	FailOpen bool
+}
+
+// NewRateLimiter creates a RateLimiter from the rate limits and trusted proxies configuration.
+func NewRateLimiter(store RateLimitStore, limits []config.RateLimitConfig, trustedProxies []string) (*RateLimiter, error) {
+	resolver, err := NewClientIPResolver(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	limiter := &RateLimiter{Store: store, ClientIP: resolver}
+	for _, limit := range limits {
+		window, err := time.ParseDuration(limit.Window)
+		if err != nil || window <= 0 {
+			return nil, fmt.Errorf("invalid window %q for rate limit group %q", limit.Window, limit.Name)
+		}
+		if limit.IPLimit < 0 || limit.UserLimit < 0 {
+			return nil, fmt.Errorf("negative limit for rate limit group %q", limit.Name)
+		}
+		group := RateLimitGroup{
+			Name:      limit.Name,
+			Paths:     limit.Paths,
+			IPLimit:   limit.IPLimit,
+			UserLimit: limit.UserLimit,
+			Window:    window,
+		}
+		switch {
+		case limit.Name == defaultRateLimitGroup:
+			limiter.Default = group
+		case limit.Name == "" || len(limit.Paths) == 0:
+			return nil, fmt.Errorf("rate limit group %q needs a name and paths", limit.Name)
+		default:
+			limiter.Groups = append(limiter.Groups, group)
 		}
+	}
+	return limiter, nil
+}
 
-		// If the IP has exceeded the rate limit, respond with a 429 Too Many Requests
-		if !allowed {
-			logrus.WithFields(logrus.Fields{
-				"ip":        ip,
-				"userAgent": userAgent,
-			}).Warn("Rate limit exceeded")
-			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+// Middleware is a middleware function that limits the number of requests per client IP address
+// according to the group of the requested route.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		group := l.group(r.URL.Path)
+		ip := l.ClientIP.ClientIP(r)
+		if !l.check(w, r, group, group.IPLimit, "ip:"+ip) {
 			return
 		}
-
-		// Call the next middleware or handler
 		next.ServeHTTP(w, r)
 	})
 }
+
+// AllowUser checks the number of requests of an authenticated user against the limit of the requested
+// route's group. It writes an error response and returns false if the request is not allowed.
+func (l *RateLimiter) AllowUser(w http.ResponseWriter, r *http.Request, userID uint) bool {
+	group := l.group(r.URL.Path)
+	return l.check(w, r, group, group.UserLimit, "user:"+strconv.FormatUint(uint64(userID), 10))
+}
+
+// group returns the rate limit group of the path. Every API version of a route belongs to the same group.
+func (l *RateLimiter) group(path string) *RateLimitGroup {
+	path = UnversionedPath(path)
+	for i := range l.Groups {
+		if l.Groups[i].matches(path) {
+			return &l.Groups[i]
+		}
+	}
+	return &l.Default
+}
+
+// check counts the request against a limit of the group, setting the rate limit headers.
+// It writes an error response and returns false if the request is not allowed.
+func (l *RateLimiter) check(w http.ResponseWriter, r *http.Request, group *RateLimitGroup, limit int, key string) bool {
+	if limit == 0 {
+		return true
+	}
+
+	result, err := l.Store.Allow(r.Context(), group.Name+":"+key, limit, group.Window)
+	if err != nil {
+		logger := logrus.WithFields(logrus.Fields{
+			"method": r.Method,
+			"url":    r.URL.String(),
+			"ip":     r.RemoteAddr,
+		})
+		if l.FailOpen {
+			logger.Warnf("Rate limit check failed, letting the request through: %v", err)
+			return true
+		}
+		logger.Errorf("Rate limit check failed: %v", err)
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusServiceUnavailable, "Service Unavailable"))
+		return false
+	}
+
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	if result.Allowed {
+		return true
+	}
+
+	retryAfter := int(math.Ceil(result.RetryAfter.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	logrus.WithFields(logrus.Fields{
+		"method": r.Method,
+		"url":    r.URL.String(),
+		"ip":     r.RemoteAddr,
+		"key":    key,
+	}).Warn("Rate limit exceeded")
+	errors.RespondWithError(w, errors.RateLimited(errors.CodeRateLimited, "Too many requests, please try again later", retryAfter))
+	return false
+}
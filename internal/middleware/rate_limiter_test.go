@@ -0,0 +1,150 @@
+package middleware_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pageza/chat-app/internal/config"
+	"github.com/pageza/chat-app/internal/middleware"
+	"github.com/pageza/chat-app/internal/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStore is a RateLimitStore that allows limit requests per key and never refills.
+type countingStore map[string]int
+
+func (s countingStore) Allow(ctx context.Context, key string, limit int, window time.Duration) (redis.RateLimitResult, error) {
+	s[key]++
+	if s[key] > limit {
+		return redis.RateLimitResult{Limit: limit, RetryAfter: 1500 * time.Millisecond}, nil
+	}
+	return redis.RateLimitResult{Allowed: true, Limit: limit, Remaining: limit - s[key]}, nil
+}
+
+// failingStore is a RateLimitStore that is unavailable.
+type failingStore struct{}
+
+func (failingStore) Allow(ctx context.Context, key string, limit int, window time.Duration) (redis.RateLimitResult, error) {
+	return redis.RateLimitResult{}, errors.New("connection refused")
+}
+
+func TestClientIP(t *testing.T) {
+	resolver, err := middleware.NewClientIPResolver([]string{"10.0.0.0/8", "192.0.2.1"})
+	require.NoError(t, err)
+
+	clientIP := func(remoteAddr string, headers map[string]string) string {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		return resolver.ClientIP(req)
+	}
+
+	t.Run("Port is stripped", func(t *testing.T) {
+		assert.Equal(t, "203.0.113.7", clientIP("203.0.113.7:52100", nil))
+		assert.Equal(t, "2001:db8::1", clientIP("[2001:db8::1]:52100", nil))
+	})
+
+	t.Run("Forwarded headers from untrusted clients are ignored", func(t *testing.T) {
+		assert.Equal(t, "203.0.113.7", clientIP("203.0.113.7:52100", map[string]string{"X-Forwarded-For": "198.51.100.1"}))
+	})
+
+	t.Run("Right-most untrusted address is the client", func(t *testing.T) {
+		headers := map[string]string{"X-Forwarded-For": "198.51.100.1, 203.0.113.7, 10.1.2.3"}
+		assert.Equal(t, "203.0.113.7", clientIP("192.0.2.1:443", headers))
+	})
+
+	t.Run("X-Real-IP is used without X-Forwarded-For", func(t *testing.T) {
+		assert.Equal(t, "203.0.113.7", clientIP("10.0.0.1:443", map[string]string{"X-Real-IP": "203.0.113.7"}))
+	})
+
+	t.Run("Invalid proxies are rejected", func(t *testing.T) {
+		_, err := middleware.NewClientIPResolver([]string{"not-an-ip"})
+		assert.Error(t, err)
+	})
+}
+
+func TestRateLimiter(t *testing.T) {
+	store := countingStore{}
+	limiter, err := middleware.NewRateLimiter(store, []config.RateLimitConfig{
+		{Name: "auth", Paths: []string{"/login"}, IPLimit: 2, Window: "1m"},
+		{Name: "default", IPLimit: 5, UserLimit: 1, Window: "1m"},
+	}, nil)
+	require.NoError(t, err)
+
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(path, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, nil)
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Requests within the limit carry the rate limit headers", func(t *testing.T) {
+		rr := serve("/login", "203.0.113.7:1000")
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+		assert.Equal(t, "2", rr.Header().Get("X-RateLimit-Limit"))
+		assert.Equal(t, "1", rr.Header().Get("X-RateLimit-Remaining"))
+	})
+
+	t.Run("Requests over the limit are rejected", func(t *testing.T) {
+		// The port changes between connections, but the client is the same
+		assert.Equal(t, http.StatusNoContent, serve("/login", "203.0.113.7:1001").Code)
+		rr := serve("/login", "203.0.113.7:1002")
+		assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+		assert.Equal(t, "2", rr.Header().Get("Retry-After"))
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+		assert.Equal(t, float64(2), body["retry_after"])
+	})
+
+	t.Run("Groups and clients are counted separately", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, serve("/login", "198.51.100.1:1000").Code)
+		rr := serve("/loginx", "203.0.113.7:1000")
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+		assert.Equal(t, "5", rr.Header().Get("X-RateLimit-Limit"))
+	})
+
+	t.Run("Users are counted across addresses", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/me", nil)
+		assert.True(t, limiter.AllowUser(httptest.NewRecorder(), req, 42))
+		rr := httptest.NewRecorder()
+		assert.False(t, limiter.AllowUser(rr, req, 42))
+		assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+		assert.True(t, limiter.AllowUser(httptest.NewRecorder(), req, 43))
+	})
+
+	t.Run("Invalid configurations are rejected", func(t *testing.T) {
+		_, err := middleware.NewRateLimiter(store, []config.RateLimitConfig{{Name: "chat", IPLimit: 1, Window: "1m"}}, nil)
+		assert.Error(t, err)
+		_, err = middleware.NewRateLimiter(store, []config.RateLimitConfig{{Name: "default", IPLimit: 1, Window: "soon"}}, nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("Unavailable stores fail closed unless configured to fail open", func(t *testing.T) {
+		limiter, err := middleware.NewRateLimiter(failingStore{}, config.DefaultRateLimits, nil)
+		require.NoError(t, err)
+		handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/chat", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+		limiter.FailOpen = true
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/chat", nil))
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+	})
+}
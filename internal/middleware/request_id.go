@@ -0,0 +1,69 @@
+// Package middleware provides utility functions for handling middleware logic in the application.
+// This file specifically includes the generation and propagation of request IDs.
+
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// RequestIDHeader is the header carrying the request ID, in requests from clients and proxies and in responses.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the maximum length of a request ID accepted from the client.
+const maxRequestIDLength = 128
+
+// requestIDKey is the context key under which the request ID is stored.
+type requestIDKey struct{}
+
+// RequestIDMiddleware is a middleware function that assigns every request an ID, which is sent back in
+// the X-Request-ID response header and logged with the request. An ID set by the client or a proxy is
+// kept if it is well-formed, so that a request can be followed across services.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// RequestID returns the ID of the request, or an empty string if it has none.
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID reports whether a request ID from the client can be used: it must be short and only
+// use printable ASCII characters, so that it can't be used to forge log lines.
+func validRequestID(id string) bool {
+	return printableASCII(id, maxRequestIDLength)
+}
+
+// printableASCII reports whether a value from the client is non-empty, at most maxLength bytes long,
+// and only uses printable ASCII characters other than spaces.
+func printableASCII(value string, maxLength int) bool {
+	if value == "" || len(value) > maxLength {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] <= ' ' || value[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID generates a random request ID.
+func newRequestID() string {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(random)
+}
@@ -0,0 +1,35 @@
+// Package middleware provides utility functions for handling middleware logic in the application.
+// This file specifically includes a middleware for role-based access control.
+
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/pageza/chat-app/internal/models"
+)
+
+// RequireRole returns a middleware that only lets users with the given global role, or a higher one, through.
+// It must be used behind AuthMiddleware, which stores the authenticated user in the request context.
+func RequireRole(role string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := CurrentUser(r)
+			if !ok {
+				unauthorizedAccess(w, r, errNoToken)
+				return
+			}
+			if !user.HasRole(role) {
+				forbiddenAccess(w, r, "Forbidden")
+				return
+			}
+			next(w, r)
+		})
+	}
+}
+
+// RequireAdmin only lets administrators through.
+var RequireAdmin = RequireRole(models.RoleAdmin)
+
+// RequireModerator only lets moderators and administrators through.
+var RequireModerator = RequireRole(models.RoleModerator)
@@ -0,0 +1,50 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pageza/chat-app/internal/middleware"
+	"github.com/pageza/chat-app/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireRole(t *testing.T) {
+	serve := func(guard func(http.HandlerFunc) http.HandlerFunc, user *models.User) int {
+		handler := guard(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+		req := httptest.NewRequest("GET", "/admin/users", nil)
+		if user != nil {
+			req = req.WithContext(middleware.WithPrincipal(req.Context(), &middleware.Principal{User: user}))
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr.Code
+	}
+
+	tests := []struct {
+		name  string
+		guard func(http.HandlerFunc) http.HandlerFunc
+		role  string
+		want  int
+	}{
+		{"Users are not moderators", middleware.RequireModerator, models.RoleUser, http.StatusForbidden},
+		{"Users without a role are not moderators", middleware.RequireModerator, "", http.StatusForbidden},
+		{"Moderators are moderators", middleware.RequireModerator, models.RoleModerator, http.StatusNoContent},
+		{"Administrators include moderators", middleware.RequireModerator, models.RoleAdmin, http.StatusNoContent},
+		{"Moderators are not administrators", middleware.RequireAdmin, models.RoleModerator, http.StatusForbidden},
+		{"Administrators are administrators", middleware.RequireAdmin, models.RoleAdmin, http.StatusNoContent},
+		{"Unknown roles are never granted", middleware.RequireRole("owner"), models.RoleAdmin, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, serve(tt.guard, &models.User{ID: 1, Username: "alice", Role: tt.role}))
+		})
+	}
+
+	t.Run("Unauthenticated requests are rejected", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, serve(middleware.RequireModerator, nil))
+	})
+}
@@ -0,0 +1,54 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pageza/chat-app/internal/config"
+	"github.com/pageza/chat-app/internal/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	headers := &middleware.SecurityHeaders{
+		HSTSMaxAge:            365 * 24 * time.Hour,
+		HSTSIncludeSubdomains: true,
+		FrameOptions:          "DENY",
+		ReferrerPolicy:        "no-referrer",
+		ContentSecurityPolicy: config.DefaultContentSecurityPolicy,
+	}
+	var nonce string
+	handler := headers.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = middleware.CSPNonce(r)
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, "max-age=31536000; includeSubDomains", rr.Header().Get("Strict-Transport-Security"))
+	assert.Equal(t, "nosniff", rr.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "DENY", rr.Header().Get("X-Frame-Options"))
+	assert.Equal(t, "no-referrer", rr.Header().Get("Referrer-Policy"))
+	assert.Empty(t, rr.Header().Values("Permissions-Policy"))
+
+	csp := rr.Header().Get("Content-Security-Policy")
+	assert.NotEmpty(t, nonce)
+	assert.Contains(t, csp, "script-src 'self' 'nonce-"+nonce+"'")
+	assert.NotContains(t, csp, "{nonce}")
+
+	t.Run("Nonces differ between requests", func(t *testing.T) {
+		first := nonce
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		assert.NotEqual(t, first, nonce)
+	})
+
+	t.Run("Report-only policies use their own header", func(t *testing.T) {
+		headers := &middleware.SecurityHeaders{ContentSecurityPolicy: "default-src 'none'", CSPReportOnly: true}
+		rr := httptest.NewRecorder()
+		headers.Middleware(http.NotFoundHandler()).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, "default-src 'none'", rr.Header().Get("Content-Security-Policy-Report-Only"))
+		assert.Empty(t, rr.Header().Get("Content-Security-Policy"))
+		assert.Empty(t, rr.Header().Get("Strict-Transport-Security"))
+	})
+}
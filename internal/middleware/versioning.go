@@ -0,0 +1,63 @@
+// Package middleware provides utility functions for handling middleware logic in the application.
+// This file specifically includes the API version prefixes and the deprecation headers of legacy routes.
+
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIPrefix is the path prefix of the versioned API, e.g. /api/v1.
+const APIPrefix = "/api"
+
+// UnversionedPath removes the API version prefix from a path, e.g. /api/v1/send becomes /send, so that
+// path-based settings, such as rate limit groups, apply to every version of a route. Other paths are
+// returned unchanged.
+func UnversionedPath(path string) string {
+	rest := strings.TrimPrefix(path, APIPrefix+"/v")
+	if rest == path {
+		return path
+	}
+	end := strings.IndexByte(rest, '/')
+	if end == -1 {
+		end = len(rest)
+	}
+	if end == 0 {
+		return path
+	}
+	if _, err := strconv.ParseUint(rest[:end], 10, 32); err != nil {
+		return path
+	}
+	if end == len(rest) {
+		return "/"
+	}
+	return rest[end:]
+}
+
+// Deprecation announces that routes are deprecated, in the Deprecation (RFC 9745) and Sunset (RFC 8594)
+// headers, and points clients to the routes replacing them with a successor-version link.
+type Deprecation struct {
+	DeprecatedAt time.Time // When the routes were deprecated
+	Sunset       time.Time // When the routes will be removed; zero if no date is set yet
+}
+
+// Middleware returns a middleware function that adds the deprecation headers to responses. The successor
+// route is the requested path under successorPrefix, e.g. /api/v1.
+func (d *Deprecation) Middleware(successorPrefix string) func(http.Handler) http.Handler {
+	deprecation := "@" + strconv.FormatInt(d.DeprecatedAt.Unix(), 10)
+	sunset := ""
+	if !d.Sunset.IsZero() {
+		sunset = d.Sunset.UTC().Format(http.TimeFormat)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", deprecation)
+			setIfNotEmpty(w.Header(), "Sunset", sunset)
+			w.Header().Add("Link", "<"+successorPrefix+r.URL.Path+`>; rel="successor-version"`)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pageza/chat-app/internal/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnversionedPath(t *testing.T) {
+	for path, want := range map[string]string{
+		"/api/v1/send":     "/send",
+		"/api/v12/me/mute": "/me/mute",
+		"/api/v1":          "/",
+		"/send":            "/send",
+		"/api/vx/send":     "/api/vx/send",
+		"/api/v/send":      "/api/v/send",
+		"/apiv1/send":      "/apiv1/send",
+	} {
+		assert.Equal(t, want, middleware.UnversionedPath(path), path)
+	}
+}
+
+func TestDeprecation(t *testing.T) {
+	deprecation := &middleware.Deprecation{
+		DeprecatedAt: time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC),
+		Sunset:       time.Date(2027, 4, 1, 0, 0, 0, 0, time.UTC),
+	}
+	handler := deprecation.Middleware("/api/v1")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/send", nil))
+	assert.Equal(t, "@1792368000", rr.Header().Get("Deprecation"))
+	assert.Equal(t, "Thu, 01 Apr 2027 00:00:00 GMT", rr.Header().Get("Sunset"))
+	assert.Equal(t, `</api/v1/send>; rel="successor-version"`, rr.Header().Get("Link"))
+}
@@ -0,0 +1,70 @@
+// Package models defines the data structures used in the application.
+// This file specifically includes the personal API token model and its scopes.
+
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// APITokenPrefix starts every personal API token, so that they can be told apart from JWTs
+// and recognized by secret scanners.
+const APITokenPrefix = "chat_pat_"
+
+// Scopes that can be granted to personal API tokens.
+const (
+	ScopeMessagesRead  = "messages:read"
+	ScopeMessagesWrite = "messages:write"
+	ScopeRoomsRead     = "rooms:read"
+	ScopeProfileRead   = "profile:read"
+)
+
+// APITokenScopes lists all scopes that can be granted to personal API tokens.
+var APITokenScopes = []string{ScopeMessagesRead, ScopeMessagesWrite, ScopeRoomsRead, ScopeProfileRead}
+
+// APIToken is a named, scoped personal access token used by bots and integrations.
+// Only a hash of the token is stored; the token itself is shown once, when it is created.
+type APIToken struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`          // Primary key for the token
+	UserID     uint       `gorm:"index;not null" json:"-"`       // The user the token acts as
+	Name       string     `gorm:"not null" json:"name"`          // User-chosen label, e.g. "Resource bot"
+	Hint       string     `json:"hint"`                          // The last characters of the token, to help users recognize it
+	TokenHash  string     `gorm:"uniqueIndex;not null" json:"-"` // SHA-256 hash of the token
+	Scopes     []string   `gorm:"serializer:json" json:"scopes"` // Scopes granted to the token
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`          // Optional expiry time
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`        // Timestamp of the last authenticated request
+	CreatedAt  time.Time  `json:"created_at"`                    // Timestamp for when the token was created
+}
+
+// HashAPIToken returns the hash under which a personal API token is stored.
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsValidScope reports whether scope can be granted to a personal API token.
+func IsValidScope(scope string) bool {
+	for _, s := range APITokenScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope reports whether the token was granted the scope.
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsExpired reports whether the token has expired.
+func (t *APIToken) IsExpired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
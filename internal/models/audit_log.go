@@ -0,0 +1,39 @@
+// Package models defines the data structures used in the application.
+// This file specifically includes the audit log of administrative actions.
+
+package models
+
+import "time"
+
+// Audit log actions recorded for the admin API.
+const (
+	AuditUserSuspended   = "user.suspended"
+	AuditUserUnsuspended = "user.unsuspended"
+	AuditUserLoggedOut   = "user.logged_out"
+	AuditUserRoleChanged = "user.role_changed"
+
+	AuditUserVeteranVerified   = "user.veteran_verified"
+	AuditUserVeteranUnverified = "user.veteran_unverified"
+)
+
+// AuditLogEntry records an action taken by a moderator or administrator.
+// Entries are append-only and are never updated or deleted by the application.
+type AuditLogEntry struct {
+	ID         uint              `gorm:"primaryKey" json:"id"`           // Primary key for the entry
+	ActorID    uint              `gorm:"index;not null" json:"actor_id"` // User who performed the action
+	Action     string            `gorm:"index;not null" json:"action"`   // One of the Audit* actions
+	TargetType string            `gorm:"not null" json:"target_type"`    // Kind of object acted on, e.g. "user"
+	TargetID   uint              `gorm:"index" json:"target_id"`         // ID of the object acted on
+	Details    map[string]string `gorm:"serializer:json" json:"details"` // Additional information, e.g. a reason
+	IP         string            `json:"ip"`                             // Address the request came from
+	CreatedAt  time.Time         `gorm:"index" json:"created_at"`        // Timestamp for when the action was taken
+}
+
+// ModerationStats summarizes the user base and recent moderation activity.
+type ModerationStats struct {
+	TotalUsers     int64            `json:"total_users"`
+	SuspendedUsers int64            `json:"suspended_users"`
+	UsersByRole    map[string]int64 `json:"users_by_role"`
+	RecentActions  map[string]int64 `json:"recent_actions"` // Audit log actions in the reporting window, by action
+	Since          time.Time        `json:"since"`          // Start of the reporting window
+}
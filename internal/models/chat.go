@@ -0,0 +1,124 @@
+// Package models defines the data structures used in the application.
+// This file specifically includes the chat rooms and messages.
+
+package models
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Length limits for chat content, in characters.
+const (
+	MaxMessageLength         = 4000
+	maxRoomDescriptionLength = 500
+)
+
+// MaxSlowModeSeconds is the longest slow mode interval a room can have: six hours.
+const MaxSlowModeSeconds = 6 * 60 * 60
+
+// roomNameRegex restricts room names to lowercase letters, digits, hyphens and underscores.
+var roomNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{1,31}$`)
+
+// Room is a chat room. Users must join a room to read and send its messages.
+type Room struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`                        // Primary key for the room
+	Name         string    `gorm:"uniqueIndex;not null" json:"name"`            // Unique name, e.g. "general"
+	Description  string    `json:"description,omitempty"`                       // Short description of the room's topic
+	VerifiedOnly bool      `gorm:"not null;default:false" json:"verified_only"` // Only verified veterans may join and take part
+	CreatedByID  uint      `gorm:"not null" json:"created_by_id"`               // User who created the room
+	CreatedAt    time.Time `json:"created_at"`                                  // Timestamp for when the room was created
+
+	// SlowModeSeconds is the minimum time between two messages of a member, or zero if slow mode is off.
+	// Room moderators are exempt.
+	SlowModeSeconds int `gorm:"not null;default:0" json:"slow_mode_seconds"`
+}
+
+// Roles of room members, in increasing order of privilege.
+const (
+	RoomRoleMember    = "member"
+	RoomRoleModerator = "moderator" // Can change the room's settings
+	RoomRoleOwner     = "owner"     // The creator of the room
+)
+
+// RoomMember records that a user has joined a room.
+type RoomMember struct {
+	RoomID    uint      `gorm:"primaryKey" json:"room_id"`           // The room joined
+	UserID    uint      `gorm:"primaryKey;index" json:"user_id"`     // The member
+	Role      string    `gorm:"not null;default:member" json:"role"` // One of the RoomRole* roles
+	CreatedAt time.Time `json:"joined_at"`                           // Timestamp for when the user joined
+}
+
+// ErrVerifiedVeteransOnly is returned when a user who is not a verified veteran tries to take part in a verified-only room.
+var ErrVerifiedVeteransOnly = errors.New("this room is for verified veterans only")
+
+// CheckAccess checks whether the user may join and take part in the room. Verified-only rooms
+// are open to verified veterans, and to global moderators and administrators so they can moderate them.
+// Membership is checked separately; this check applies on top of it, so that losing verified
+// status takes effect immediately.
+func (r *Room) CheckAccess(user *User) error {
+	if r.VerifiedOnly && !user.VeteranStatus && !user.HasRole(RoleModerator) {
+		return ErrVerifiedVeteransOnly
+	}
+	return nil
+}
+
+// CanModerate reports whether the member may change the room's settings.
+func (m *RoomMember) CanModerate() bool {
+	return m.Role == RoomRoleModerator || m.Role == RoomRoleOwner
+}
+
+// Message is a chat message, sent either to a room or directly to another user.
+// Exactly one of RoomID and RecipientID is set.
+type Message struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`                // Primary key, increasing in order of sending
+	RoomID      *uint     `gorm:"index" json:"room_id,omitempty"`      // Room the message was sent to
+	RecipientID *uint     `gorm:"index" json:"recipient_id,omitempty"` // Recipient of a direct message
+	SenderID    uint      `gorm:"index;not null" json:"sender_id"`     // User who sent the message
+	Body        string    `gorm:"not null" json:"body"`                // Message text
+	CreatedAt   time.Time `json:"created_at"`                          // Timestamp for when the message was sent
+}
+
+// IsDirect reports whether the message is a direct message.
+func (m *Message) IsDirect() bool {
+	return m.RecipientID != nil
+}
+
+// SlowMode returns the minimum time between two messages of a member, or zero if slow mode is off.
+func (r *Room) SlowMode() time.Duration {
+	return time.Duration(r.SlowModeSeconds) * time.Second
+}
+
+// Validate checks the room's name, description and slow mode. It returns the problems found, keyed by field name,
+// or nil if the room is valid.
+func (r *Room) Validate() map[string]string {
+	problems := map[string]string{}
+	if !roomNameRegex.MatchString(r.Name) {
+		problems["name"] = "name must be 2 to 32 lowercase letters, digits, hyphens or underscores"
+	}
+	if utf8.RuneCountInString(r.Description) > maxRoomDescriptionLength {
+		problems["description"] = "description must be at most 500 characters"
+	}
+	if r.SlowModeSeconds < 0 || r.SlowModeSeconds > MaxSlowModeSeconds {
+		problems["slow_mode_seconds"] = "slow_mode_seconds must be between 0 and 21600"
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return problems
+}
+
+// ValidateMessageBody checks the text of a message. It returns a description of the problem, or an empty string.
+func ValidateMessageBody(body string) string {
+	if strings.TrimSpace(body) == "" {
+		return "message must not be empty"
+	}
+	if utf8.RuneCountInString(body) > MaxMessageLength {
+		return "message must be at most 4000 characters"
+	}
+	return ""
+}
@@ -0,0 +1,40 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pageza/chat-app/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoomCheckAccess(t *testing.T) {
+	open := &models.Room{Name: "general"}
+	verifiedOnly := &models.Room{Name: "veterans", VerifiedOnly: true}
+
+	member := &models.User{ID: 1}
+	veteran := &models.User{ID: 2}
+	veteran.VerifyVeteran(models.VerificationMethodManual, "DD-214 reviewed", &member.ID, time.Now())
+	moderator := &models.User{ID: 3, Role: models.RoleModerator}
+
+	assert.NoError(t, open.CheckAccess(member))
+	assert.Equal(t, models.ErrVerifiedVeteransOnly, verifiedOnly.CheckAccess(member))
+	assert.NoError(t, verifiedOnly.CheckAccess(veteran))
+	assert.NoError(t, verifiedOnly.CheckAccess(moderator))
+
+	// Revoking the verification takes effect immediately
+	veteran.RevokeVeteranVerification()
+	assert.Equal(t, models.ErrVerifiedVeteransOnly, verifiedOnly.CheckAccess(veteran))
+	assert.Nil(t, veteran.VeteranVerification.VerifiedAt)
+}
+
+func TestRoomValidateSlowMode(t *testing.T) {
+	room := &models.Room{Name: "general", SlowModeSeconds: 30}
+	assert.Nil(t, room.Validate())
+	assert.Equal(t, 30*time.Second, room.SlowMode())
+
+	room.SlowModeSeconds = models.MaxSlowModeSeconds + 1
+	assert.Contains(t, room.Validate(), "slow_mode_seconds")
+	room.SlowModeSeconds = -1
+	assert.Contains(t, room.Validate(), "slow_mode_seconds")
+}
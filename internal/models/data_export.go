@@ -0,0 +1,32 @@
+// Package models defines the data structures used in the application.
+// This file specifically includes the data export jobs users can request to download their data.
+
+package models
+
+import "time"
+
+// Statuses of a data export.
+const (
+	DataExportPending    = "pending"    // Waiting to be processed
+	DataExportProcessing = "processing" // Being bundled
+	DataExportReady      = "ready"      // Available for download until it expires
+	DataExportFailed     = "failed"     // Could not be bundled; the user can request a new export
+	DataExportExpired    = "expired"    // Deleted after the retention period
+)
+
+// DataExport is a request by a user for a ZIP archive of their data.
+type DataExport struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`         // Primary key for the export
+	UserID      uint       `gorm:"index;not null" json:"-"`      // User whose data is exported
+	Status      string     `gorm:"index;not null" json:"status"` // One of the DataExport* statuses
+	StorageKey  string     `json:"-"`                            // Storage key of the archive once it is ready
+	Size        int64      `json:"size,omitempty"`               // Size of the archive in bytes
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`         // Time after which the archive is deleted
+	CompletedAt *time.Time `json:"completed_at,omitempty"`       // Time the archive became ready, or failed
+	CreatedAt   time.Time  `json:"created_at"`                   // Timestamp for when the export was requested
+}
+
+// IsActive reports whether the export is still being prepared.
+func (e *DataExport) IsActive() bool {
+	return e.Status == DataExportPending || e.Status == DataExportProcessing
+}
@@ -0,0 +1,159 @@
+// Package models defines the data structures used in the application.
+// This file specifically includes the user profile, its validation logic and its privacy settings.
+
+package models
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Profile holds the public-facing information a user chooses to share.
+// It is embedded in the users table with a "profile_" column prefix.
+type Profile struct {
+	DisplayName     string `json:"display_name,omitempty"`      // Name shown in chats; the username is used if empty
+	Bio             string `json:"bio,omitempty"`               // Short free-form introduction
+	BranchOfService string `json:"branch_of_service,omitempty"` // One of the ServiceBranches
+	ServiceEra      string `json:"service_era,omitempty"`       // One of the ServiceEras
+	Pronouns        string `json:"pronouns,omitempty"`          // Free-form, e.g. "she/her"
+}
+
+// Profile field names, as used in API payloads and privacy settings.
+const (
+	ProfileFieldDisplayName     = "display_name"
+	ProfileFieldBio             = "bio"
+	ProfileFieldBranchOfService = "branch_of_service"
+	ProfileFieldServiceEra      = "service_era"
+	ProfileFieldPronouns        = "pronouns"
+)
+
+// Visibility levels for profile fields.
+const (
+	VisibilityEveryone = "everyone" // Any logged-in user
+	VisibilityVeterans = "veterans" // Only users whose veteran status has been asserted by an identity provider
+	VisibilityOnlyMe   = "only_me"  // Only the user themselves
+)
+
+// Length limits for free-form profile fields, in characters.
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 500
+	maxPronounsLength    = 30
+)
+
+// ServiceBranches are the accepted values of Profile.BranchOfService.
+var ServiceBranches = []string{"army", "navy", "air_force", "marine_corps", "coast_guard", "space_force", "national_guard"}
+
+// ServiceEras are the accepted values of Profile.ServiceEra.
+var ServiceEras = []string{"wwii", "korea", "vietnam", "cold_war", "gulf_war", "post_9_11"}
+
+// PrivateProfileFields are the profile fields whose visibility the user can choose.
+// The display name is always visible, since it identifies the user in chats.
+var PrivateProfileFields = []string{ProfileFieldBio, ProfileFieldBranchOfService, ProfileFieldServiceEra, ProfileFieldPronouns}
+
+// Validate checks the profile fields. It returns the problems found, keyed by field name,
+// or nil if the profile is valid.
+func (p *Profile) Validate() map[string]string {
+	problems := map[string]string{}
+
+	if utf8.RuneCountInString(p.DisplayName) > maxDisplayNameLength {
+		problems[ProfileFieldDisplayName] = "display name must be at most 50 characters"
+	} else if p.DisplayName != "" && strings.TrimSpace(p.DisplayName) != p.DisplayName {
+		problems[ProfileFieldDisplayName] = "display name must not start or end with whitespace"
+	}
+	if utf8.RuneCountInString(p.Bio) > maxBioLength {
+		problems[ProfileFieldBio] = "bio must be at most 500 characters"
+	}
+	if p.BranchOfService != "" && !contains(ServiceBranches, p.BranchOfService) {
+		problems[ProfileFieldBranchOfService] = "unknown branch of service"
+	}
+	if p.ServiceEra != "" && !contains(ServiceEras, p.ServiceEra) {
+		problems[ProfileFieldServiceEra] = "unknown service era"
+	}
+	if utf8.RuneCountInString(p.Pronouns) > maxPronounsLength {
+		problems[ProfileFieldPronouns] = "pronouns must be at most 30 characters"
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return problems
+}
+
+// ValidatePrivacy checks privacy settings. It returns the problems found, keyed by field name,
+// or nil if the settings are valid.
+func ValidatePrivacy(privacy map[string]string) map[string]string {
+	problems := map[string]string{}
+	for field, visibility := range privacy {
+		if !contains(PrivateProfileFields, field) {
+			problems[field] = "visibility cannot be set for this field"
+			continue
+		}
+		if visibility != VisibilityEveryone && visibility != VisibilityVeterans && visibility != VisibilityOnlyMe {
+			problems[field] = "visibility must be one of everyone, veterans or only_me"
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return problems
+}
+
+// FieldVisibility returns the visibility of a profile field, defaulting to VisibilityEveryone.
+func (u *User) FieldVisibility(field string) string {
+	if visibility, ok := u.ProfilePrivacy[field]; ok {
+		return visibility
+	}
+	return VisibilityEveryone
+}
+
+// ProfileVisibleTo returns the user's profile with the fields the viewer may not see left empty.
+func (u *User) ProfileVisibleTo(viewer *User) Profile {
+	if viewer != nil && viewer.ID == u.ID {
+		return u.Profile
+	}
+
+	canSee := func(field string) bool {
+		switch u.FieldVisibility(field) {
+		case VisibilityEveryone:
+			return true
+		case VisibilityVeterans:
+			return viewer != nil && viewer.VeteranStatus
+		}
+		return false
+	}
+
+	profile := Profile{DisplayName: u.Profile.DisplayName}
+	if canSee(ProfileFieldBio) {
+		profile.Bio = u.Profile.Bio
+	}
+	if canSee(ProfileFieldBranchOfService) {
+		profile.BranchOfService = u.Profile.BranchOfService
+	}
+	if canSee(ProfileFieldServiceEra) {
+		profile.ServiceEra = u.Profile.ServiceEra
+	}
+	if canSee(ProfileFieldPronouns) {
+		profile.Pronouns = u.Profile.Pronouns
+	}
+	return profile
+}
+
+// Name returns the name to show for the user: the display name, or the username if none is set.
+func (u *User) Name() string {
+	if u.Profile.DisplayName != "" {
+		return u.Profile.DisplayName
+	}
+	return u.Username
+}
+
+// contains reports whether values contains value.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
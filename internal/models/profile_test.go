@@ -0,0 +1,44 @@
+package models_test
+
+import (
+	"testing"
+
+	"github.com/pageza/chat-app/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfileVisibleTo(t *testing.T) {
+	owner := &models.User{
+		ID: 1,
+		Profile: models.Profile{
+			DisplayName:     "Sam",
+			Bio:             "Hello",
+			BranchOfService: "navy",
+			Pronouns:        "they/them",
+		},
+		ProfilePrivacy: map[string]string{
+			models.ProfileFieldBranchOfService: models.VisibilityVeterans,
+			models.ProfileFieldPronouns:        models.VisibilityOnlyMe,
+		},
+	}
+	member := &models.User{ID: 2}
+	veteran := &models.User{ID: 3, VeteranStatus: true}
+
+	assert.Equal(t, models.Profile{DisplayName: "Sam", Bio: "Hello"}, owner.ProfileVisibleTo(member))
+	assert.Equal(t, models.Profile{DisplayName: "Sam", Bio: "Hello", BranchOfService: "navy"}, owner.ProfileVisibleTo(veteran))
+	assert.Equal(t, owner.Profile, owner.ProfileVisibleTo(owner))
+}
+
+func TestProfileValidate(t *testing.T) {
+	valid := models.Profile{DisplayName: "Sam", BranchOfService: "army", ServiceEra: "post_9_11"}
+	assert.Nil(t, valid.Validate())
+
+	invalid := models.Profile{DisplayName: " Sam", BranchOfService: "pirates"}
+	problems := invalid.Validate()
+	assert.Contains(t, problems, models.ProfileFieldDisplayName)
+	assert.Contains(t, problems, models.ProfileFieldBranchOfService)
+
+	assert.Nil(t, models.ValidatePrivacy(map[string]string{models.ProfileFieldBio: models.VisibilityOnlyMe}))
+	assert.Contains(t, models.ValidatePrivacy(map[string]string{models.ProfileFieldDisplayName: models.VisibilityOnlyMe}), models.ProfileFieldDisplayName)
+	assert.Contains(t, models.ValidatePrivacy(map[string]string{models.ProfileFieldBio: "friends"}), models.ProfileFieldBio)
+}
@@ -6,6 +6,7 @@ package models
 import (
 	"errors"
 	"regexp"
+	"strconv"
 	"time"
 )
 
@@ -18,6 +19,100 @@ type User struct {
 	Password  string    `gorm:"not null"`        // Password, cannot be null
 	CreatedAt time.Time // Timestamp for when the user was created
 	UpdatedAt time.Time // Timestamp for when the user was last updated
+
+	// Two-factor authentication settings, never exposed in API payloads
+	TOTPSecret    string   `json:"-"`                        // Base32 TOTP secret, set during enrollment
+	TOTPEnabled   bool     `json:"-"`                        // Whether TOTP is required at login
+	TOTPLastStep  int64    `json:"-"`                        // Last accepted TOTP time step, to prevent code replay
+	RecoveryCodes []string `gorm:"serializer:json" json:"-"` // SHA-256 hashes of unused recovery codes
+
+	// Veteran status, verified by an identity provider such as ID.me or by an administrator
+	VeteranStatus       bool                `json:"-"` // Whether the user is a verified veteran
+	VeteranVerification VeteranVerification `gorm:"embedded;embeddedPrefix:veteran_verification_" json:"-"`
+
+	// Authorization and moderation, only changed through the admin API and exposed through its UserView
+	Role            string     `gorm:"not null;default:user" json:"-"` // Global role: user, moderator or admin
+	SuspendedAt     *time.Time `json:"-"`                              // Set while the account is suspended
+	SuspendedReason string     `json:"-"`                              // Reason given by the moderator who suspended the account
+
+	// Public profile and the visibility of its fields, keyed by field name
+	Profile        Profile           `gorm:"embedded;embeddedPrefix:profile_"`
+	ProfilePrivacy map[string]string `gorm:"serializer:json" json:"-"`
+
+	// Storage key prefix of the avatar thumbnails, e.g. "avatars/1/3f2a9c", empty if none was uploaded
+	AvatarKey string `json:"-"`
+
+	// Account deletion
+	DeletionScheduledFor *time.Time `gorm:"index" json:"-"` // Set while a requested deletion is in its grace period
+	AnonymizedAt         *time.Time `json:"-"`              // Set once the account has been deleted and anonymized
+}
+
+// Global roles, in increasing order of privilege.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// roleRanks orders the roles so that a higher role includes the privileges of the lower ones.
+var roleRanks = map[string]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+// ErrAccountSuspended is returned when a suspended user tries to log in.
+var ErrAccountSuspended = errors.New("account is suspended")
+
+// IsValidRole reports whether role is one of the global roles.
+func IsValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// EffectiveRole returns the user's role, treating an unset role as RoleUser.
+func (u *User) EffectiveRole() string {
+	if u.Role == "" {
+		return RoleUser
+	}
+	return u.Role
+}
+
+// HasRole reports whether the user has the given role or a higher one.
+func (u *User) HasRole(role string) bool {
+	return roleRanks[u.EffectiveRole()] >= roleRanks[role] && IsValidRole(role)
+}
+
+// IsSuspended reports whether the account is currently suspended.
+func (u *User) IsSuspended() bool {
+	return u.SuspendedAt != nil
+}
+
+// IsDeletionPending reports whether the user has requested the deletion of their account,
+// and the deletion is still in its grace period.
+func (u *User) IsDeletionPending() bool {
+	return u.DeletionScheduledFor != nil && u.AnonymizedAt == nil
+}
+
+// IsDeleted reports whether the account has been deleted and anonymized.
+func (u *User) IsDeleted() bool {
+	return u.AnonymizedAt != nil
+}
+
+// Anonymize removes all personal data from a deleted account. The row itself is kept,
+// so that the messages the user sent to shared rooms remain attributed to a "deleted user".
+// The password is replaced with a value that no password hash ever matches.
+func (u *User) Anonymize(now time.Time) {
+	id := strconv.FormatUint(uint64(u.ID), 10)
+	*u = User{
+		ID:           u.ID,
+		Username:     "deleted-user-" + id,
+		Email:        "deleted-user-" + id + "@deleted.invalid",
+		Password:     "!",
+		Role:         RoleUser,
+		CreatedAt:    u.CreatedAt,
+		AnonymizedAt: &now,
+	}
 }
 
 // Validate checks if the User fields are valid.
@@ -34,8 +129,13 @@ func (u *User) Validate() error {
 		return errors.New("invalid email format")
 	}
 
+	return ValidatePassword(u.Password)
+}
+
+// ValidatePassword checks that a plaintext password meets the length and complexity requirements.
+func ValidatePassword(password string) error {
 	// Validate password length
-	if len(u.Password) < 8 || len(u.Password) > 50 {
+	if len(password) < 8 || len(password) > 50 {
 		return errors.New("password must be between 8 and 50 characters")
 	}
 
@@ -47,7 +147,7 @@ func (u *User) Validate() error {
 		hasSpecial = regexp.MustCompile(`[@$!%*?&]`).MatchString // At least one special character
 	)
 
-	if !hasUpper(u.Password) || !hasLower(u.Password) || !hasDigit(u.Password) || !hasSpecial(u.Password) {
+	if !hasUpper(password) || !hasLower(password) || !hasDigit(password) || !hasSpecial(password) {
 		return errors.New("password must include at least one uppercase letter, one lowercase letter, one number, and one special character")
 	}
 
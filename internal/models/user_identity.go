@@ -0,0 +1,19 @@
+// Package models defines the data structures used in the application.
+// This file specifically includes the model linking users to external identity providers.
+
+package models
+
+import "time"
+
+// UserIdentity links a user to an account at an external OpenID Connect provider.
+// The pair of issuer and subject uniquely identifies the external account.
+type UserIdentity struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`                                      // Primary key for the identity
+	UserID      uint       `gorm:"index;not null" json:"-"`                                   // The linked user
+	Provider    string     `gorm:"not null" json:"provider"`                                  // Configured provider name, e.g. "idme"
+	Issuer      string     `gorm:"not null;uniqueIndex:idx_identity_issuer_subject" json:"-"` // Issuer of the ID token
+	Subject     string     `gorm:"not null;uniqueIndex:idx_identity_issuer_subject" json:"-"` // Subject of the ID token
+	Email       string     `json:"email,omitempty"`                                           // Email asserted by the provider
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`                                   // Timestamp of the last login through this identity
+	CreatedAt   time.Time  `json:"created_at"`                                                // Timestamp for when the identity was linked
+}
@@ -0,0 +1,33 @@
+// Package models defines the data structures used in the application.
+// This file specifically includes the block and mute lists users keep to protect themselves from harassment.
+
+package models
+
+import "time"
+
+// Kinds of relations a user can set on another user.
+const (
+	// RelationBlock hides the other user's messages, prevents direct messages in either direction,
+	// and hides the blocking user's presence from the blocked user.
+	RelationBlock = "block"
+	// RelationMute only hides the other user's messages.
+	RelationMute = "mute"
+)
+
+// MaxUserRelations is the maximum number of users a user can block or mute, per kind.
+const MaxUserRelations = 1000
+
+// UserRelation records that a user has blocked or muted another user.
+// Relations are one-sided: the other user is not notified and keeps their own lists.
+type UserRelation struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`                                         // Primary key for the relation
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_user_relation" json:"-"`             // User who set the relation
+	TargetID  uint      `gorm:"not null;uniqueIndex:idx_user_relation;index" json:"user_id"` // User who is blocked or muted
+	Kind      string    `gorm:"not null;uniqueIndex:idx_user_relation" json:"-"`             // RelationBlock or RelationMute
+	CreatedAt time.Time `json:"created_at"`                                                  // Timestamp for when the relation was set
+}
+
+// IsValidRelationKind reports whether kind is one of the relation kinds.
+func IsValidRelationKind(kind string) bool {
+	return kind == RelationBlock || kind == RelationMute
+}
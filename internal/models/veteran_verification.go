@@ -0,0 +1,38 @@
+// Package models defines the data structures used in the application.
+// This file specifically includes the record of how a user's veteran status was verified.
+
+package models
+
+import "time"
+
+// Methods by which veteran status can be verified.
+const (
+	VerificationMethodProvider = "provider" // Asserted by an identity provider such as ID.me at login
+	VerificationMethodManual   = "manual"   // Verified by an administrator, e.g. after reviewing a DD-214
+)
+
+// VeteranVerification records how and by whom a user's veteran status was verified.
+// It is embedded in the users table with a "veteran_verification_" column prefix.
+type VeteranVerification struct {
+	Method       string     `json:"method,omitempty"`         // One of the VerificationMethod* methods
+	Authority    string     `json:"authority,omitempty"`      // Issuer of the ID token, or the document an administrator reviewed
+	VerifiedAt   *time.Time `json:"verified_at,omitempty"`    // Timestamp of the verification
+	VerifiedByID *uint      `json:"verified_by_id,omitempty"` // Administrator who verified the status manually
+}
+
+// VerifyVeteran marks the user as a verified veteran and records how the status was verified.
+func (u *User) VerifyVeteran(method, authority string, verifiedByID *uint, now time.Time) {
+	u.VeteranStatus = true
+	u.VeteranVerification = VeteranVerification{
+		Method:       method,
+		Authority:    authority,
+		VerifiedAt:   &now,
+		VerifiedByID: verifiedByID,
+	}
+}
+
+// RevokeVeteranVerification removes the user's verified veteran status.
+func (u *User) RevokeVeteranVerification() {
+	u.VeteranStatus = false
+	u.VeteranVerification = VeteranVerification{}
+}
@@ -0,0 +1,24 @@
+// Package models defines the data structures used in the application.
+// This file specifically includes the WebAuthn (passkey) credential model.
+
+package models
+
+import "time"
+
+// WebAuthnCredential represents a passkey or security key registered by a user.
+// A user can have any number of credentials, each of which can be used to log in without a password.
+type WebAuthnCredential struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`              // Primary key for the credential
+	UserID          uint       `gorm:"index;not null" json:"-"`           // The user who owns the credential
+	Name            string     `json:"name"`                              // User-chosen label, e.g. "Laptop"
+	CredentialID    []byte     `gorm:"uniqueIndex;not null" json:"-"`     // Credential ID assigned by the authenticator
+	PublicKey       []byte     `gorm:"not null" json:"-"`                 // COSE-encoded credential public key
+	AttestationType string     `json:"-"`                                 // Attestation format used at registration
+	Transports      []string   `gorm:"serializer:json" json:"transports"` // Transports the authenticator supports
+	AAGUID          []byte     `json:"-"`                                 // Authenticator model identifier
+	SignCount       uint32     `json:"-"`                                 // Last seen signature counter
+	BackupEligible  bool       `json:"backup_eligible"`                   // Whether the credential can be synced
+	BackupState     bool       `json:"backup_state"`                      // Whether the credential is currently synced
+	LastUsedAt      *time.Time `json:"last_used_at,omitempty"`            // Timestamp of the last login with the credential
+	CreatedAt       time.Time  `json:"created_at"`                        // Timestamp for when the credential was registered
+}
@@ -0,0 +1,403 @@
+// Package oidc implements OpenID Connect login for the chat application.
+// This file specifically includes the HTTP handlers for the login, link and callback routes.
+package oidc
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gorilla/mux"
+	"github.com/pageza/chat-app/internal/config"
+	"github.com/pageza/chat-app/internal/errors"
+	"github.com/pageza/chat-app/internal/models"
+	"github.com/pageza/chat-app/internal/utils"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+// stateCookie binds the login state to the browser that started the login.
+const stateCookie = "oidc_state"
+
+// SessionIssuer is an interface for looking up the logged-in user and starting a new session,
+// or the second login step for users with two-factor authentication. It is implemented by auth.AuthHandler.
+type SessionIssuer interface {
+	IssueSession(w http.ResponseWriter, r *http.Request, user models.User) (string, error)
+	IssueMFAToken(user models.User) (string, error)
+	CurrentUser(r *http.Request) (*models.User, bool)
+}
+
+// Handler handles OpenID Connect login requests.
+type Handler struct {
+	Providers         map[string]*Provider
+	Store             Store
+	States            StateStore
+	Sessions          SessionIssuer
+	PostLoginRedirect string              // Where to send the browser after a successful login
+	Cookies           config.CookieConfig // Path and Secure attributes of the state cookie
+}
+
+// userInfo holds the standard claims used to create and link accounts.
+type userInfo struct {
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+// Errors reported to the client when an external account cannot be linked.
+var (
+	errIdentityInUse = fmt.Errorf("this provider account is already linked to another user")
+	errEmailInUse    = fmt.Errorf("an account with this email already exists, log in and link the provider from your account")
+	errNoEmail       = fmt.Errorf("the provider did not return an email address")
+)
+
+// usernameRe matches characters that are not allowed in generated usernames.
+var usernameRe = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// LoginHandler starts a login with the provider named in the URL.
+func (h *Handler) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	h.startFlow(w, r, 0)
+}
+
+// LinkHandler starts linking the provider named in the URL to the logged-in user.
+func (h *Handler) LinkHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.Sessions.CurrentUser(r)
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+	h.startFlow(w, r, user.ID)
+}
+
+// startFlow redirects the browser to the provider's authorization endpoint.
+// The state, nonce and PKCE verifier are stored until the provider redirects back.
+func (h *Handler) startFlow(w http.ResponseWriter, r *http.Request, linkUserID uint) {
+	provider, ok := h.Providers[mux.Vars(r)["provider"]]
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusNotFound, "Unknown identity provider"))
+		return
+	}
+
+	oauth2Config, _, err := provider.discover(r.Context())
+	if err != nil {
+		logrus.Errorf("Could not start OIDC login: %v", err)
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadGateway, "Identity provider is unavailable"))
+		return
+	}
+
+	nonce, err := randomString()
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not start login"))
+		return
+	}
+
+	state := loginState{
+		Provider:     provider.Config.Name,
+		Nonce:        nonce,
+		CodeVerifier: oauth2.GenerateVerifier(),
+		LinkUserID:   linkUserID,
+	}
+	key, err := saveState(r.Context(), h.States, state)
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not start login"))
+		return
+	}
+
+	http.SetCookie(w, h.newStateCookie(key, int(stateTTL.Seconds())))
+
+	authURL := oauth2Config.AuthCodeURL(key, gooidc.Nonce(nonce), oauth2.S256ChallengeOption(state.CodeVerifier))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// newStateCookie returns the cookie that binds the login state to the browser, with the Path and Secure
+// attributes from the cookie settings. It is always SameSite=Lax, whatever the settings, since the
+// provider's redirect back to the callback is a cross-site navigation that must carry the cookie.
+func (h *Handler) newStateCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     stateCookie,
+		Value:    value,
+		Path:     h.Cookies.Path,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   h.Cookies.Secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// CallbackHandler completes the login when the provider redirects back.
+// It exchanges the authorization code, verifies the ID token, links or creates the user,
+// records the provider-asserted veteran status and starts a session.
+func (h *Handler) CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.Providers[mux.Vars(r)["provider"]]
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusNotFound, "Unknown identity provider"))
+		return
+	}
+
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		logrus.WithFields(logrus.Fields{
+			"provider": provider.Config.Name,
+			"error":    providerErr,
+		}).Warn("Identity provider returned an error")
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Login was cancelled or denied"))
+		return
+	}
+
+	// The state must match the cookie set when the login started
+	key := query.Get("state")
+	cookie, err := r.Cookie(stateCookie)
+	if err != nil || key == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(key)) != 1 {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid login state"))
+		return
+	}
+	http.SetCookie(w, h.newStateCookie("", -1))
+
+	state, err := takeState(r.Context(), h.States, key)
+	if err != nil || state.Provider != provider.Config.Name {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Login has expired, please try again"))
+		return
+	}
+
+	oauth2Config, verifier, err := provider.discover(r.Context())
+	if err != nil {
+		logrus.Errorf("Could not complete OIDC login: %v", err)
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadGateway, "Identity provider is unavailable"))
+		return
+	}
+
+	token, err := oauth2Config.Exchange(r.Context(), query.Get("code"), oauth2.VerifierOption(state.CodeVerifier))
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"provider": provider.Config.Name,
+		}).Warnf("OIDC code exchange failed: %v", err)
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Could not complete login"))
+		return
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Could not complete login"))
+		return
+	}
+
+	idToken, err := verifier.Verify(r.Context(), rawIDToken)
+	if err != nil || subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(state.Nonce)) != 1 {
+		logrus.WithFields(logrus.Fields{
+			"provider": provider.Config.Name,
+		}).Warnf("OIDC ID token verification failed: %v", err)
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Could not complete login"))
+		return
+	}
+
+	var info userInfo
+	var claims map[string]interface{}
+	if err := idToken.Claims(&info); err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Could not complete login"))
+		return
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Could not complete login"))
+		return
+	}
+
+	user, err := h.resolveUser(provider, idToken, info, state)
+	switch err {
+	case nil:
+	case errIdentityInUse, errEmailInUse:
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusConflict, err.Error()))
+		return
+	case errNoEmail:
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, err.Error()))
+		return
+	default:
+		logrus.WithFields(logrus.Fields{
+			"provider": provider.Config.Name,
+		}).Errorf("Could not link OIDC identity: %v", err)
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not complete login"))
+		return
+	}
+
+	h.updateVeteranStatus(provider, idToken.Issuer, claims, user)
+
+	if user.IsSuspended() {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusForbidden, "Account suspended"))
+		return
+	}
+
+	// Users with two-factor authentication must complete a second step at /login/mfa, as after a
+	// password check. The application gets the "MFA pending" token in the fragment of the redirect,
+	// which the browser does not send to any server.
+	if user.TOTPEnabled {
+		mfaToken, err := h.Sessions.IssueMFAToken(*user)
+		if err != nil {
+			errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not log in"))
+			return
+		}
+		http.Redirect(w, r, mfaRedirect(h.PostLoginRedirect, mfaToken), http.StatusFound)
+		return
+	}
+
+	if _, err := h.Sessions.IssueSession(w, r, *user); err == models.ErrAccountSuspended {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusForbidden, "Account suspended"))
+		return
+	} else if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not log in"))
+		return
+	}
+
+	http.Redirect(w, r, h.PostLoginRedirect, http.StatusFound)
+}
+
+// mfaRedirect returns the post-login redirect with the "MFA pending" token in its fragment.
+func mfaRedirect(redirect, mfaToken string) string {
+	fragment := url.Values{"mfa_required": {"true"}, "mfa_token": {mfaToken}}.Encode()
+	if i := strings.IndexByte(redirect, '#'); i >= 0 {
+		redirect = redirect[:i]
+	}
+	return redirect + "#" + fragment
+}
+
+// resolveUser finds the user linked to the ID token's issuer and subject.
+// If there is none, the identity is linked to the logged-in user, to an existing user with
+// the same verified email (when the provider allows it), or to a newly created user.
+func (h *Handler) resolveUser(provider *Provider, idToken *gooidc.IDToken, info userInfo, state *loginState) (*models.User, error) {
+	now := time.Now()
+
+	identity, err := h.Store.GetUserIdentity(idToken.Issuer, idToken.Subject)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	if err == nil {
+		if state.LinkUserID != 0 && identity.UserID != state.LinkUserID {
+			return nil, errIdentityInUse
+		}
+		user, err := h.Store.GetUserByID(strconv.FormatUint(uint64(identity.UserID), 10))
+		if err != nil {
+			return nil, err
+		}
+		identity.LastLoginAt = &now
+		if err := h.Store.UpdateUserIdentity(identity); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+
+	var user *models.User
+	switch {
+	case state.LinkUserID != 0:
+		user, err = h.Store.GetUserByID(strconv.FormatUint(uint64(state.LinkUserID), 10))
+		if err != nil {
+			return nil, err
+		}
+	case info.Email == "":
+		return nil, errNoEmail
+	default:
+		existing, err := h.Store.GetUserByEmail(info.Email)
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return nil, err
+		}
+		if existing != nil {
+			if !provider.Config.LinkByEmail || !info.EmailVerified {
+				return nil, errEmailInUse
+			}
+			user = existing
+		} else if user, err = h.createUser(info); err != nil {
+			return nil, err
+		}
+	}
+
+	identity = &models.UserIdentity{
+		UserID:      user.ID,
+		Provider:    provider.Config.Name,
+		Issuer:      idToken.Issuer,
+		Subject:     idToken.Subject,
+		Email:       info.Email,
+		LastLoginAt: &now,
+	}
+	if err := h.Store.CreateUserIdentity(identity); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// createUser creates a user for a first-time login through a provider.
+// The user gets a random password, so they can only log in through the provider until they reset it.
+func (h *Handler) createUser(info userInfo) (*models.User, error) {
+	password, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &models.User{
+		Username: h.uniqueUsername(info),
+		Email:    info.Email,
+		Password: hashedPassword,
+	}
+	if err := h.Store.CreateUser(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// uniqueUsername derives an unused username from the preferred username or the email address.
+func (h *Handler) uniqueUsername(info userInfo) string {
+	base := info.PreferredUsername
+	if base == "" {
+		base = strings.SplitN(info.Email, "@", 2)[0]
+	}
+	base = usernameRe.ReplaceAllString(base, "")
+	if len(base) > 15 {
+		base = base[:15]
+	}
+	for len(base) < 3 {
+		base += "_"
+	}
+
+	candidate := base
+	for i := 0; i < 10; i++ {
+		if _, err := h.Store.GetUserByUsername(candidate); err != nil {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s%04d", base, rand.Intn(10000))
+	}
+	return candidate
+}
+
+// updateVeteranStatus stores the veteran status asserted in the ID token, if the provider is
+// configured with a veteran claim and the claim is present. A provider can only revoke a status
+// that was verified through a provider, not one an administrator verified manually.
+func (h *Handler) updateVeteranStatus(provider *Provider, issuer string, claims map[string]interface{}, user *models.User) {
+	if provider.Config.VeteranClaim == "" {
+		return
+	}
+	if _, ok := claims[provider.Config.VeteranClaim]; !ok {
+		return
+	}
+
+	if provider.isVeteran(claims) {
+		user.VerifyVeteran(models.VerificationMethodProvider, issuer, nil, time.Now())
+	} else if user.VeteranStatus && user.VeteranVerification.Method != models.VerificationMethodManual {
+		user.RevokeVeteranVerification()
+	} else {
+		return
+	}
+	if err := h.Store.UpdateUser(user); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"user":     user.Username,
+			"provider": provider.Config.Name,
+		}).Errorf("Could not store veteran status: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+// Package oidc implements OpenID Connect login for the chat application.
+// It acts as a relying party for any configured issuer, such as ID.me, using the
+// authorization code flow with PKCE, and links external accounts to users.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/pageza/chat-app/internal/config"
+	"github.com/pageza/chat-app/internal/models"
+	redisI "github.com/pageza/chat-app/internal/redis"
+	"golang.org/x/oauth2"
+)
+
+// stateTTL is how long a user has to complete the login at the provider.
+const stateTTL = 10 * time.Minute
+
+// Provider is a configured OpenID Connect identity provider.
+// Discovery is performed lazily on first use, so an unavailable provider does not prevent startup.
+type Provider struct {
+	Config config.OIDCProviderConfig
+
+	mu       sync.Mutex
+	oauth2   *oauth2.Config
+	verifier *gooidc.IDTokenVerifier
+}
+
+// NewProviders creates providers from the configuration, keyed by name.
+func NewProviders(configs []config.OIDCProviderConfig) map[string]*Provider {
+	providers := make(map[string]*Provider, len(configs))
+	for _, c := range configs {
+		providers[c.Name] = &Provider{Config: c}
+	}
+	return providers
+}
+
+// discover fetches the provider's discovery document and sets up the OAuth2 client and ID token verifier.
+func (p *Provider) discover(ctx context.Context) (*oauth2.Config, *gooidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.oauth2 != nil {
+		return p.oauth2, p.verifier, nil
+	}
+
+	provider, err := gooidc.NewProvider(ctx, p.Config.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("OIDC discovery failed for %s: %w", p.Config.Name, err)
+	}
+
+	scopes := append([]string{gooidc.ScopeOpenID}, p.Config.Scopes...)
+	p.oauth2 = &oauth2.Config{
+		ClientID:     p.Config.ClientID,
+		ClientSecret: p.Config.ClientSecret,
+		RedirectURL:  p.Config.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       scopes,
+	}
+	p.verifier = provider.Verifier(&gooidc.Config{ClientID: p.Config.ClientID})
+	return p.oauth2, p.verifier, nil
+}
+
+// isVeteran reports whether the ID token claims assert veteran status,
+// according to the provider's VeteranClaim and VeteranValues settings.
+func (p *Provider) isVeteran(claims map[string]interface{}) bool {
+	if p.Config.VeteranClaim == "" {
+		return false
+	}
+
+	matches := func(value interface{}) bool {
+		s, ok := value.(string)
+		if !ok {
+			return false
+		}
+		for _, v := range p.Config.VeteranValues {
+			if s == v {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch value := claims[p.Config.VeteranClaim].(type) {
+	case bool:
+		return value
+	case string:
+		return matches(value)
+	case []interface{}:
+		for _, v := range value {
+			if matches(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loginState is the state kept between the redirect to the provider and the callback.
+type loginState struct {
+	Provider     string `json:"provider"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+	LinkUserID   uint   `json:"link_user_id,omitempty"` // Set when linking to a logged-in user
+}
+
+// StateStore stores login state between the redirect to the provider and the callback.
+type StateStore interface {
+	Save(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Take(ctx context.Context, key string) ([]byte, error)
+}
+
+// RedisStateStore is a StateStore backed by Redis.
+type RedisStateStore struct {
+	Client redisI.Client
+}
+
+// Save stores the state under the given key.
+func (s *RedisStateStore) Save(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return redisI.StoreChallenge(ctx, s.Client, "oidc:"+key, value, ttl)
+}
+
+// Take loads and deletes the state stored under the given key.
+func (s *RedisStateStore) Take(ctx context.Context, key string) ([]byte, error) {
+	return redisI.ConsumeChallenge(ctx, s.Client, "oidc:"+key)
+}
+
+// saveState stores the login state and returns the random key that is sent as the OAuth2 state parameter.
+func saveState(ctx context.Context, store StateStore, state loginState) (string, error) {
+	key, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+	if err := store.Save(ctx, key, data, stateTTL); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// takeState loads and deletes the login state for the given key.
+func takeState(ctx context.Context, store StateStore, key string) (*loginState, error) {
+	data, err := store.Take(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	var state loginState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// randomString returns a random URL-safe string suitable for state and nonce values.
+func randomString() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Store is an interface for the user and identity persistence needed by the OIDC flow.
+type Store interface {
+	GetUserByID(userID string) (*models.User, error)
+	GetUserByUsername(username string) (*models.User, error)
+	GetUserByEmail(email string) (*models.User, error)
+	CreateUser(user *models.User) error
+	UpdateUser(user *models.User) error
+	GetUserIdentity(issuer, subject string) (*models.UserIdentity, error)
+	CreateUserIdentity(identity *models.UserIdentity) error
+	UpdateUserIdentity(identity *models.UserIdentity) error
+}
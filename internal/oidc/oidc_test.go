@@ -0,0 +1,363 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/gorilla/mux"
+	"github.com/pageza/chat-app/internal/config"
+	"github.com/pageza/chat-app/internal/models"
+	"github.com/pageza/chat-app/internal/oidc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// mockProvider is a minimal OpenID Connect provider supporting discovery,
+// the authorization code flow with PKCE, and a JWKS endpoint.
+type mockProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]interface{} // Claims added to every ID token
+
+	mu     sync.Mutex
+	grants map[string]url.Values // Authorization requests by code
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	p := &mockProvider{key: key, grants: map[string]url.Values{}, claims: map[string]interface{}{}}
+	r := http.NewServeMux()
+	r.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	r.HandleFunc("/authorize", p.authorize)
+	r.HandleFunc("/token", p.token)
+	r.HandleFunc("/jwks", p.jwks)
+	p.server = httptest.NewServer(r)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *mockProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                p.server.URL,
+		"authorization_endpoint":                p.server.URL + "/authorize",
+		"token_endpoint":                        p.server.URL + "/token",
+		"jwks_uri":                              p.server.URL + "/jwks",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+// authorize immediately "logs in" the user and redirects back with a code.
+func (p *mockProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	code := strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	p.mu.Lock()
+	p.grants[code] = query
+	p.mu.Unlock()
+
+	redirect, _ := url.Parse(query.Get("redirect_uri"))
+	v := url.Values{"code": {code}, "state": {query.Get("state")}}
+	redirect.RawQuery = v.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	p.mu.Lock()
+	grant, ok := p.grants[r.PostForm.Get("code")]
+	delete(p.grants, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	// Verify the PKCE code verifier against the challenge from the authorization request
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || grant.Get("code_challenge_method") != "S256" ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != grant.Get("code_challenge") {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := map[string]interface{}{
+		"iss":   p.server.URL,
+		"sub":   "external-123",
+		"aud":   grant.Get("client_id"),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": grant.Get("nonce"),
+	}
+	for k, v := range p.claims {
+		claims[k] = v
+	}
+
+	signer, _ := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.RS256,
+		Key:       jose.JSONWebKey{Key: p.key, KeyID: "test-key"},
+	}, (&jose.SignerOptions{}).WithType("JWT"))
+	idToken, _ := jwt.Signed(signer).Claims(claims).CompactSerialize()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func (p *mockProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &p.key.PublicKey, KeyID: "test-key", Algorithm: "RS256", Use: "sig"},
+	}})
+}
+
+// memoryStore is an in-memory implementation of oidc.Store.
+type memoryStore struct {
+	users      map[uint]*models.User
+	identities []*models.UserIdentity
+}
+
+func (s *memoryStore) GetUserByID(userID string) (*models.User, error) {
+	id, _ := strconv.ParseUint(userID, 10, 64)
+	if user, ok := s.users[uint(id)]; ok {
+		return user, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) GetUserByUsername(username string) (*models.User, error) {
+	for _, user := range s.users {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) GetUserByEmail(email string) (*models.User, error) {
+	for _, user := range s.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) CreateUser(user *models.User) error {
+	user.ID = uint(len(s.users) + 1)
+	s.users[user.ID] = user
+	return nil
+}
+
+func (s *memoryStore) UpdateUser(user *models.User) error {
+	s.users[user.ID] = user
+	return nil
+}
+
+func (s *memoryStore) GetUserIdentity(issuer, subject string) (*models.UserIdentity, error) {
+	for _, identity := range s.identities {
+		if identity.Issuer == issuer && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) CreateUserIdentity(identity *models.UserIdentity) error {
+	s.identities = append(s.identities, identity)
+	return nil
+}
+
+func (s *memoryStore) UpdateUserIdentity(identity *models.UserIdentity) error {
+	return nil
+}
+
+// memoryStates is an in-memory implementation of oidc.StateStore.
+type memoryStates map[string][]byte
+
+func (s memoryStates) Save(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s[key] = value
+	return nil
+}
+
+func (s memoryStates) Take(ctx context.Context, key string) ([]byte, error) {
+	value, ok := s[key]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	delete(s, key)
+	return value, nil
+}
+
+// fakeSessions records the users that sessions and "MFA pending" tokens were issued to.
+type fakeSessions struct {
+	issued    []models.User
+	mfaIssued []models.User
+}
+
+func (f *fakeSessions) IssueSession(w http.ResponseWriter, r *http.Request, user models.User) (string, error) {
+	f.issued = append(f.issued, user)
+	return "token", nil
+}
+
+func (f *fakeSessions) IssueMFAToken(user models.User) (string, error) {
+	f.mfaIssued = append(f.mfaIssued, user)
+	return "mfa-token", nil
+}
+
+func (f *fakeSessions) CurrentUser(r *http.Request) (*models.User, bool) {
+	return nil, false
+}
+
+func TestOIDCLogin(t *testing.T) {
+	provider := newMockProvider(t)
+	provider.claims["email"] = "vet@example.com"
+	provider.claims["email_verified"] = true
+	provider.claims["preferred_username"] = "vet"
+	provider.claims["groups"] = []string{"military"}
+
+	store := &memoryStore{users: map[uint]*models.User{}}
+	sessions := &fakeSessions{}
+	handler := &oidc.Handler{
+		Providers: oidc.NewProviders([]config.OIDCProviderConfig{{
+			Name:          "mock",
+			Issuer:        provider.server.URL,
+			ClientID:      "chat-app",
+			ClientSecret:  "secret",
+			RedirectURL:   "http://app.test/oidc/mock/callback",
+			Scopes:        []string{"email"},
+			VeteranClaim:  "groups",
+			VeteranValues: []string{"military"},
+		}}),
+		Store:             store,
+		States:            memoryStates{},
+		Sessions:          sessions,
+		PostLoginRedirect: "/chat",
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/oidc/{provider}/login", handler.LoginHandler)
+	router.HandleFunc("/oidc/{provider}/callback", handler.CallbackHandler)
+
+	// login performs the full flow and returns the callback response
+	login := func(t *testing.T, tamperState bool) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/oidc/mock/login", nil))
+		require.Equal(t, http.StatusFound, rr.Code)
+		stateCookie := rr.Result().Cookies()[0]
+
+		// Let the mock provider authorize the request and redirect back
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		resp, err := client.Get(rr.Header().Get("Location"))
+		require.NoError(t, err)
+		resp.Body.Close()
+		callback, _ := url.Parse(resp.Header.Get("Location"))
+
+		req := httptest.NewRequest("GET", callback.RequestURI(), nil)
+		if tamperState {
+			stateCookie.Value = "tampered"
+		}
+		req.AddCookie(stateCookie)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("First login creates a linked user with veteran status", func(t *testing.T) {
+		rr := login(t, false)
+
+		assert.Equal(t, http.StatusFound, rr.Code)
+		assert.Equal(t, "/chat", rr.Header().Get("Location"))
+		require.Len(t, store.users, 1)
+		user := store.users[1]
+		assert.Equal(t, "vet", user.Username)
+		assert.Equal(t, "vet@example.com", user.Email)
+		assert.True(t, user.VeteranStatus)
+		assert.Equal(t, provider.server.URL, user.VeteranVerification.Authority)
+		assert.Equal(t, models.VerificationMethodProvider, user.VeteranVerification.Method)
+		require.Len(t, store.identities, 1)
+		assert.Equal(t, "external-123", store.identities[0].Subject)
+		assert.Len(t, sessions.issued, 1)
+	})
+
+	t.Run("Second login reuses the linked user", func(t *testing.T) {
+		provider.claims["groups"] = []string{"student"}
+		rr := login(t, false)
+
+		assert.Equal(t, http.StatusFound, rr.Code)
+		assert.Len(t, store.users, 1)
+		assert.Len(t, store.identities, 1)
+		assert.False(t, store.users[1].VeteranStatus)
+		assert.Len(t, sessions.issued, 2)
+	})
+
+	t.Run("Mismatched state is rejected", func(t *testing.T) {
+		rr := login(t, true)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Len(t, sessions.issued, 2)
+	})
+
+	t.Run("Users with two-factor authentication must complete the second step", func(t *testing.T) {
+		store.users[1].TOTPEnabled = true
+		defer func() { store.users[1].TOTPEnabled = false }()
+		rr := login(t, false)
+
+		assert.Equal(t, http.StatusFound, rr.Code)
+		assert.Equal(t, "/chat#mfa_required=true&mfa_token=mfa-token", rr.Header().Get("Location"))
+		assert.Len(t, sessions.issued, 2, "no session before the second factor")
+		require.Len(t, sessions.mfaIssued, 1)
+		assert.Equal(t, "vet", sessions.mfaIssued[0].Username)
+	})
+
+	t.Run("Suspended users are refused", func(t *testing.T) {
+		suspendedAt := time.Now()
+		store.users[1].SuspendedAt = &suspendedAt
+		defer func() { store.users[1].SuspendedAt = nil }()
+		rr := login(t, false)
+
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.Len(t, sessions.issued, 2)
+	})
+
+	t.Run("The state cookie follows the cookie settings but stays SameSite=Lax", func(t *testing.T) {
+		handler.Cookies = config.CookieConfig{Secure: false, SameSite: http.SameSiteStrictMode, Path: "/app"}
+		defer func() { handler.Cookies = config.CookieConfig{} }()
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/oidc/mock/login", nil))
+		set := rr.Result().Cookies()[0]
+		assert.Equal(t, "/app", set.Path)
+		assert.False(t, set.Secure)
+		assert.True(t, set.HttpOnly)
+		assert.Equal(t, http.SameSiteLaxMode, set.SameSite)
+
+		rr = login(t, false)
+		var cleared *http.Cookie
+		for _, cookie := range rr.Result().Cookies() {
+			if cookie.Name == set.Name {
+				cleared = cookie
+			}
+		}
+		require.NotNil(t, cleared)
+		assert.Equal(t, "/app", cleared.Path)
+		assert.Equal(t, -1, cleared.MaxAge)
+		assert.Equal(t, http.SameSiteLaxMode, cleared.SameSite)
+	})
+}
@@ -0,0 +1,151 @@
+// Package openapi provides the OpenAPI document describing the chat application's API.
+// This file specifically includes the documentation page.
+
+package openapi
+
+import "html/template"
+
+// docsPage renders the OpenAPI document in the browser: the operations grouped by tag, with their
+// parameters, request body and responses. Schemas referenced by operations are listed at the end.
+var docsPage = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Chat App API</title>
+<style nonce="{{.Nonce}}">
+body { font-family: system-ui, sans-serif; margin: 0 auto; max-width: 60rem; padding: 1rem 2rem; color: #1f2328; }
+h2 { border-bottom: 1px solid #d0d7de; padding-bottom: .3rem; margin-top: 2rem; }
+details { border: 1px solid #d0d7de; border-radius: 6px; margin: .5rem 0; }
+summary { cursor: pointer; padding: .5rem; }
+details > div { padding: 0 1rem 1rem; }
+.method { display: inline-block; min-width: 4.5rem; font-weight: bold; text-transform: uppercase; }
+.get { color: #0969da; } .post { color: #1a7f37; } .put, .patch { color: #9a6700; } .delete { color: #cf222e; }
+.path { font-family: monospace; }
+.deprecated { text-decoration: line-through; }
+pre { background: #f6f8fa; padding: .5rem; overflow-x: auto; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #d0d7de; padding: .25rem .5rem; text-align: left; vertical-align: top; }
+</style>
+</head>
+<body>
+<main id="docs"><p>Loading the API description from <a href="{{.SpecURL}}">{{.SpecURL}}</a>…</p></main>
+<script nonce="{{.Nonce}}">
+(function () {
+  "use strict";
+  var main = document.getElementById("docs");
+
+  function el(tag, attrs, children) {
+    var node = document.createElement(tag);
+    Object.keys(attrs || {}).forEach(function (name) { node.setAttribute(name, attrs[name]); });
+    (children || []).forEach(function (child) {
+      node.appendChild(typeof child === "string" ? document.createTextNode(child) : child);
+    });
+    return node;
+  }
+
+  function json(value) { return el("pre", {}, [JSON.stringify(value, null, 2)]); }
+
+  function paragraphs(text) {
+    return (text || "").split(/\n\s*\n/).filter(Boolean).map(function (p) { return el("p", {}, [p]); });
+  }
+
+  function content(body) {
+    var nodes = [];
+    Object.keys(body.content || {}).forEach(function (type) {
+      nodes.push(el("p", {}, [el("code", {}, [type])]));
+      if (body.content[type].schema) { nodes.push(json(body.content[type].schema)); }
+    });
+    return nodes;
+  }
+
+  function resolve(spec, value) {
+    if (!value || !value.$ref) { return value; }
+    return value.$ref.replace(/^#\//, "").split("/").reduce(function (node, key) { return node[key]; }, spec);
+  }
+
+  function operation(spec, path, method, op, servers) {
+    var body = [];
+    body = body.concat(paragraphs(op.description));
+    if (servers) { body.push(el("p", {}, ["Served at: " + servers.map(function (s) { return s.url; }).join(", ")])); }
+    if (op.security && op.security.length === 0) { body.push(el("p", {}, ["No authentication required."])); }
+
+    var params = (op.parameters || []).map(function (p) { return resolve(spec, p); });
+    if (params.length) {
+      body.push(el("h4", {}, ["Parameters"]));
+      body.push(el("table", {}, [el("tr", {}, [el("th", {}, ["Name"]), el("th", {}, ["In"]), el("th", {}, ["Description"])])].concat(
+        params.map(function (p) {
+          return el("tr", {}, [el("td", {}, [el("code", {}, [p.name + (p.required ? " *" : "")])]), el("td", {}, [p.in]), el("td", {}, [p.description || ""])]);
+        }))));
+    }
+    var requestBody = resolve(spec, op.requestBody);
+    if (requestBody) {
+      body.push(el("h4", {}, ["Request body" + (requestBody.required ? "" : " (optional)")]));
+      body = body.concat(paragraphs(requestBody.description), content(requestBody));
+    }
+    body.push(el("h4", {}, ["Responses"]));
+    Object.keys(op.responses || {}).forEach(function (status) {
+      var response = resolve(spec, op.responses[status]);
+      body.push(el("p", {}, [el("strong", {}, [status]), " " + (response.description || "")]));
+      body = body.concat(content(response));
+    });
+
+    return el("details", {}, [
+      el("summary", {}, [
+        el("span", {class: "method " + method}, [method]),
+        el("span", {class: "path" + (op.deprecated ? " deprecated" : "")}, [path]),
+        " " + (op.summary || "")
+      ]),
+      el("div", {}, body)
+    ]);
+  }
+
+  function render(spec) {
+    main.textContent = "";
+    main.appendChild(el("h1", {}, [spec.info.title + " " + spec.info.version]));
+    paragraphs(spec.info.description).forEach(function (p) { main.appendChild(p); });
+    main.appendChild(el("p", {}, ["Servers: " + spec.servers.map(function (s) {
+      return s.url + (s.description ? " (" + s.description + ")" : "");
+    }).join(", ")]));
+
+    var sections = {};
+    (spec.tags || []).forEach(function (tag) {
+      sections[tag.name] = el("section", {}, [el("h2", {}, [tag.name])].concat(paragraphs(tag.description)));
+      main.appendChild(sections[tag.name]);
+    });
+    Object.keys(spec.paths).forEach(function (path) {
+      var item = spec.paths[path];
+      ["get", "post", "put", "patch", "delete"].forEach(function (method) {
+        var op = item[method];
+        if (!op) { return; }
+        var tag = (op.tags || ["other"])[0];
+        if (!sections[tag]) {
+          sections[tag] = el("section", {}, [el("h2", {}, [tag])]);
+          main.appendChild(sections[tag]);
+        }
+        sections[tag].appendChild(operation(spec, path, method, op, item.servers));
+      });
+    });
+
+    var schemas = (spec.components && spec.components.schemas) || {};
+    var section = el("section", {}, [el("h2", {}, ["Schemas"])]);
+    Object.keys(schemas).forEach(function (name) {
+      section.appendChild(el("details", {id: "schema-" + name}, [el("summary", {}, [el("code", {}, [name])]), el("div", {}, [json(schemas[name])])]));
+    });
+    main.appendChild(section);
+  }
+
+  fetch("{{.SpecURL}}", {credentials: "same-origin"})
+    .then(function (response) {
+      if (!response.ok) { throw new Error(response.status + " " + response.statusText); }
+      return response.json();
+    })
+    .then(render)
+    .catch(function (err) {
+      main.textContent = "Could not load the API description: " + err.message;
+    });
+})();
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,72 @@
+// Package openapi provides the OpenAPI document describing the chat application's API.
+// This file specifically includes loading the document and serving it with its documentation page.
+
+package openapi
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"net/http"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/pageza/chat-app/internal/middleware"
+	"github.com/sirupsen/logrus"
+)
+
+// spec is the OpenAPI document. It is maintained by hand alongside the routes: every route
+// has to be described in it, and request bodies are validated against its schemas.
+//
+//go:embed openapi.yaml
+var spec []byte
+
+// Load parses and validates the OpenAPI document.
+func Load() (*openapi3.T, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(spec)
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Handler serves the OpenAPI document and a page for browsing it.
+type Handler struct {
+	document []byte // The document, encoded as JSON once
+}
+
+// NewHandler creates a Handler serving the document.
+func NewHandler(doc *openapi3.T) (*Handler, error) {
+	document, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{document: document}, nil
+}
+
+// SpecHandler serves the OpenAPI document as JSON.
+func (h *Handler) SpecHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(h.document); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"method": r.Method,
+			"url":    r.URL.String(),
+			"ip":     r.RemoteAddr,
+		}).Errorf("Could not send the OpenAPI document: %v", err)
+	}
+}
+
+// DocsHandler serves a page that renders the OpenAPI document. The page is self-contained, so
+// its script and style carry the request's CSP nonce instead of being loaded from a CDN.
+func (h *Handler) DocsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := docsPage.Execute(w, struct{ Nonce, SpecURL string }{middleware.CSPNonce(r), "/openapi.json"}); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"method": r.Method,
+			"url":    r.URL.String(),
+			"ip":     r.RemoteAddr,
+		}).Errorf("Could not render the API documentation: %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package openapi_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pageza/chat-app/internal/openapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	doc, err := openapi.Load()
+	require.NoError(t, err)
+	assert.NotNil(t, doc.Paths.Find("/send"))
+	assert.NotNil(t, doc.Paths.Find("/admin/users/{id}/role"))
+
+	handler, err := openapi.NewHandler(doc)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	handler.SpecHandler(rr, httptest.NewRequest("GET", "/openapi.json", nil))
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	var spec map[string]interface{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &spec))
+	assert.Equal(t, "3.0.3", spec["openapi"])
+}
+
+func TestValidator(t *testing.T) {
+	doc, err := openapi.Load()
+	require.NoError(t, err)
+	validator, err := openapi.NewValidator(doc)
+	require.NoError(t, err)
+
+	var received string
+	handler := validator.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(method, path, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	problem := func(rr *httptest.ResponseRecorder) map[string]interface{} {
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+		return body
+	}
+
+	t.Run("Valid bodies reach the handler unchanged", func(t *testing.T) {
+		for _, path := range []string{"/send", "/api/v1/send"} {
+			rr := serve("POST", path, "application/json", `{"room_id": 1, "body": "Hello"}`)
+			assert.Equal(t, http.StatusNoContent, rr.Code, path)
+			assert.Equal(t, `{"room_id": 1, "body": "Hello"}`, received)
+		}
+	})
+
+	t.Run("Bodies without a content type are validated as JSON", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, serve("POST", "/api/v1/login", "", `{"username": "a", "password": "b"}`).Code)
+		assert.Equal(t, http.StatusBadRequest, serve("POST", "/api/v1/login", "", `{"username": "a"}`).Code)
+	})
+
+	t.Run("Invalid fields are listed", func(t *testing.T) {
+		rr := serve("POST", "/api/v1/send", "application/json", `{"room_id": "general", "body": "`+strings.Repeat("a", 4001)+`"}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		body := problem(rr)
+		assert.Equal(t, "validation_failed", body["code"])
+		fields := map[string]bool{}
+		for _, field := range body["errors"].([]interface{}) {
+			fields[field.(map[string]interface{})["field"].(string)] = true
+		}
+		assert.Equal(t, map[string]bool{"room_id": true, "body": true}, fields)
+	})
+
+	t.Run("Missing properties are named", func(t *testing.T) {
+		body := problem(serve("PUT", "/api/v1/admin/users/3/role", "application/json", `{}`))
+		assert.Equal(t, "role is required", body["detail"])
+	})
+
+	t.Run("Registrations cannot set other fields", func(t *testing.T) {
+		registration := `{"username": "a", "email": "a@example.com", "password": "b"`
+		assert.Equal(t, http.StatusNoContent, serve("POST", "/api/v1/register", "application/json", registration+`}`).Code)
+		assert.Equal(t, http.StatusBadRequest, serve("POST", "/api/v1/register", "application/json", registration+`, "role": "admin"}`).Code)
+	})
+
+	t.Run("Missing and malformed bodies are rejected", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, serve("POST", "/send", "application/json", "").Code)
+		assert.Equal(t, http.StatusBadRequest, serve("POST", "/send", "application/json", "{").Code)
+	})
+
+	t.Run("Unsupported content types are rejected", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnsupportedMediaType, serve("POST", "/send", "text/plain", "Hello").Code)
+	})
+
+	t.Run("Optional bodies may be left out", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, serve("POST", "/webauthn/login/begin", "", "").Code)
+	})
+
+	t.Run("Routes without a body and unknown routes are passed on", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, serve("GET", "/api/v1/receive", "", "").Code)
+		assert.Equal(t, http.StatusNoContent, serve("POST", "/api/v1/nowhere", "application/json", "{").Code)
+	})
+}
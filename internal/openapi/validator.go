@@ -0,0 +1,154 @@
+// Package openapi provides the OpenAPI document describing the chat application's API.
+// This file specifically includes the validation of request bodies against the document.
+
+package openapi
+
+import (
+	"bytes"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/pageza/chat-app/internal/errors"
+	"github.com/pageza/chat-app/internal/middleware"
+)
+
+// jsonContentType is the media type assumed for request bodies sent without a Content-Type header.
+const jsonContentType = "application/json"
+
+// Validator checks request bodies against the schemas of the OpenAPI document before they reach
+// the handlers, so that malformed payloads are rejected the same way on every route. Only JSON
+// bodies are validated; uploads are left to their handlers.
+type Validator struct {
+	router routers.Router
+}
+
+// NewValidator creates a Validator for the routes described in the document.
+func NewValidator(doc *openapi3.T) (*Validator, error) {
+	// Routes are looked up by their unversioned path, so that every API version of a route matches
+	// the same operation. Servers are therefore left out of the lookup.
+	lookup := *doc
+	lookup.Servers = openapi3.Servers{{URL: "/"}}
+	lookup.Paths = make(openapi3.Paths, len(doc.Paths))
+	for path, item := range doc.Paths {
+		item := *item
+		item.Servers = nil
+		lookup.Paths[path] = &item
+	}
+
+	router, err := gorillamux.NewRouter(&lookup)
+	if err != nil {
+		return nil, err
+	}
+	return &Validator{router: router}, nil
+}
+
+// Middleware is a middleware function that rejects requests whose body does not match the schema
+// of the requested operation. Requests for routes that are not described are passed on, so that
+// the router responds to them.
+func (v *Validator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookup := r.Clone(r.Context())
+		lookup.URL.Path = middleware.UnversionedPath(r.URL.Path)
+		lookup.URL.RawPath = ""
+		route, pathParams, err := v.router.FindRoute(lookup)
+		if err != nil || route.Operation.RequestBody == nil || route.Operation.RequestBody.Value == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		requestBody := route.Operation.RequestBody.Value
+
+		contentType := r.Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = jsonContentType
+		}
+		if requestBody.Content.Get(contentType) == nil {
+			if r.ContentLength == 0 && !requestBody.Required {
+				next.ServeHTTP(w, r)
+				return
+			}
+			errors.Respond(w, r, errors.NewAPIError(http.StatusUnsupportedMediaType, fmt.Sprintf("Unsupported content type %q", contentType)))
+			return
+		}
+		if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != jsonContentType {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if stderrors.As(err, &tooLarge) {
+				errors.Respond(w, r, errors.NewAPIError(http.StatusRequestEntityTooLarge, "Request body is too large"))
+				return
+			}
+			errors.Respond(w, r, errors.NewAPIError(http.StatusBadRequest, "Could not read the request body"))
+			return
+		}
+		// The handler reads the body again
+		r.Body = io.NopCloser(bytes.NewReader(data))
+
+		lookup.Body = io.NopCloser(bytes.NewReader(data))
+		lookup.Header = r.Header.Clone()
+		lookup.Header.Set("Content-Type", contentType)
+		err = openapi3filter.ValidateRequestBody(r.Context(), &openapi3filter.RequestValidationInput{
+			Request:    lookup,
+			PathParams: pathParams,
+			Route:      route,
+			Options:    &openapi3filter.Options{SkipSettingDefaults: true, MultiError: true},
+		}, requestBody)
+		if err != nil {
+			errors.Respond(w, r, validationError(err))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validationError converts a request body validation error into an APIError listing the invalid fields.
+func validationError(err error) *errors.APIError {
+	var requestErr *openapi3filter.RequestError
+	if !stderrors.As(err, &requestErr) {
+		return errors.NewAPIError(http.StatusBadRequest, "Invalid request payload")
+	}
+	if stderrors.Is(requestErr.Err, openapi3filter.ErrInvalidRequired) {
+		return errors.Validation(errors.FieldError{Message: "request body is required"})
+	}
+
+	fields := fieldErrors(requestErr.Err, nil)
+	if len(fields) == 0 {
+		return errors.NewAPIError(http.StatusBadRequest, "Invalid request payload")
+	}
+	return errors.Validation(fields...)
+}
+
+// fieldErrors collects the schema errors in err, which may be nested in MultiErrors.
+func fieldErrors(err error, fields []errors.FieldError) []errors.FieldError {
+	var multi openapi3.MultiError
+	if stderrors.As(err, &multi) {
+		for _, err := range multi {
+			fields = fieldErrors(err, fields)
+		}
+		return fields
+	}
+
+	var schemaErr *openapi3.SchemaError
+	if !stderrors.As(err, &schemaErr) {
+		return fields
+	}
+	field := strings.Join(schemaErr.JSONPointer(), ".")
+	message := schemaErr.Reason
+	if schemaErr.SchemaField == "required" {
+		message = field + " is required"
+	} else if field != "" {
+		message = field + ": " + message
+	}
+	return append(fields, errors.FieldError{Field: field, Message: message})
+}
@@ -0,0 +1,82 @@
+// Package redis provides utilities for interacting with Redis.
+// This file specifically includes the idempotency key store.
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// idempotencyPrefix prefixes the idempotency records.
+const idempotencyPrefix = "idempotency:"
+
+// IdempotencyClient is an interface describing the Redis commands used by the idempotency store.
+// *redis.Client and the in-process memory.Client satisfy it.
+type IdempotencyClient interface {
+	Client
+	SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.BoolCmd
+}
+
+// IdempotencyRecord is what is stored for an idempotency key: the fingerprint of the request that
+// first used the key, and the response to it once it has been handled.
+type IdempotencyRecord struct {
+	Fingerprint string            `json:"fingerprint"`
+	Status      int               `json:"status,omitempty"` // Zero while the request is being handled
+	Header      map[string]string `json:"header,omitempty"`
+	Body        []byte            `json:"body,omitempty"`
+}
+
+// Completed reports whether the response to the request has been stored.
+func (r *IdempotencyRecord) Completed() bool {
+	return r.Status != 0
+}
+
+// Idempotency stores idempotency records in a client, so that retried requests are only handled once.
+type Idempotency struct {
+	Client IdempotencyClient
+}
+
+// Begin claims the key for a request with the fingerprint. It returns nil if the key was free,
+// in which case the request must be handled and then completed or released. Otherwise, it returns
+// the record of the request that claimed the key first.
+func (s *Idempotency) Begin(ctx context.Context, key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, error) {
+	pending, err := json.Marshal(&IdempotencyRecord{Fingerprint: fingerprint})
+	if err != nil {
+		return nil, err
+	}
+	claimed, err := s.Client.SetNX(ctx, idempotencyPrefix+key, pending, ttl).Result()
+	if err != nil || claimed {
+		return nil, err
+	}
+
+	value, err := s.Client.Get(ctx, idempotencyPrefix+key).Result()
+	if err == redis.Nil {
+		// The record expired in the meantime; try again
+		return s.Begin(ctx, key, fingerprint, ttl)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var record IdempotencyRecord
+	if err := json.Unmarshal([]byte(value), &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// Complete stores the response to the request that claimed the key.
+func (s *Idempotency) Complete(ctx context.Context, key string, record *IdempotencyRecord, ttl time.Duration) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.Client.Set(ctx, idempotencyPrefix+key, value, ttl).Err()
+}
+
+// Release frees the key, so that the request can be retried, e.g. after it failed.
+func (s *Idempotency) Release(ctx context.Context, key string) error {
+	return s.Client.Del(ctx, idempotencyPrefix+key).Err()
+}
@@ -0,0 +1,98 @@
+// Package redis provides utilities for interacting with Redis.
+// This file specifically includes presence tracking, which records which users are online.
+package redis
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// presencePrefix prefixes the per-user count of open connections.
+const presencePrefix = "presence:"
+
+// PresenceTTL is how long a user stays online without a refresh, e.g. after a server crashed
+// without closing its connections. Connections must refresh their presence more often than this.
+const PresenceTTL = 75 * time.Second
+
+// PresenceClient describes the Redis commands used by Presence. *redis.Client satisfies it.
+type PresenceClient interface {
+	Client
+	Incr(ctx context.Context, key string) *redis.IntCmd
+	Decr(ctx context.Context, key string) *redis.IntCmd
+	Expire(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd
+}
+
+// PresenceStore is an interface for recording which users are online, such as Presence.
+type PresenceStore interface {
+	Connect(ctx context.Context, userID uint) (bool, error)
+	Refresh(ctx context.Context, userID uint) error
+	Disconnect(ctx context.Context, userID uint) (bool, error)
+	Online(ctx context.Context, userIDs []uint) (map[uint]bool, error)
+}
+
+// Presence tracks which users are online. A user is online while they have at least one open
+// connection, such as an event stream, on any server instance.
+type Presence struct {
+	Client PresenceClient
+}
+
+// Connect records a new connection of the user. It reports whether the user just came online.
+func (p *Presence) Connect(ctx context.Context, userID uint) (bool, error) {
+	key := presenceKey(userID)
+	count, err := p.Client.Incr(ctx, key).Result()
+	if err != nil {
+		return false, err
+	}
+	if err := p.Client.Expire(ctx, key, PresenceTTL).Err(); err != nil {
+		return false, err
+	}
+	return count == 1, nil
+}
+
+// Refresh keeps the user online while their connections are open.
+func (p *Presence) Refresh(ctx context.Context, userID uint) error {
+	return p.Client.Expire(ctx, presenceKey(userID), PresenceTTL).Err()
+}
+
+// Disconnect records that a connection of the user was closed. It reports whether the user went offline.
+func (p *Presence) Disconnect(ctx context.Context, userID uint) (bool, error) {
+	key := presenceKey(userID)
+	count, err := p.Client.Decr(ctx, key).Result()
+	if err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return false, nil
+	}
+	return true, p.Client.Del(ctx, key).Err()
+}
+
+// Online reports which of the given users are online.
+func (p *Presence) Online(ctx context.Context, userIDs []uint) (map[uint]bool, error) {
+	online := make(map[uint]bool, len(userIDs))
+	if len(userIDs) == 0 {
+		return online, nil
+	}
+
+	keys := make([]string, len(userIDs))
+	for i, id := range userIDs {
+		keys[i] = presenceKey(id)
+	}
+	values, err := p.Client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, value := range values {
+		count, _ := value.(string)
+		n, _ := strconv.Atoi(count)
+		online[userIDs[i]] = n > 0
+	}
+	return online, nil
+}
+
+func presenceKey(userID uint) string {
+	return presencePrefix + strconv.FormatUint(uint64(userID), 10)
+}
@@ -0,0 +1,81 @@
+// Package redis provides utilities for interacting with Redis.
+// This file specifically includes the token bucket rate limiter.
+package redis
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// rateLimitPrefix prefixes the token buckets of the rate limiter.
+const rateLimitPrefix = "ratelimit:"
+
+// tokenBucketScript refills and takes a token from the bucket in KEYS[1] in one atomic step.
+// A bucket holds up to ARGV[1] tokens and refills completely in ARGV[2] milliseconds.
+// It returns whether the request is allowed, the tokens left, and the milliseconds until
+// the next token is available if the request is not allowed.
+// The Redis server's clock is used, so that all application servers share the same time.
+var tokenBucketScript = redis.NewScript(`
+redis.replicate_commands()
+local capacity = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+
+local rate = capacity / window
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], window)
+return {allowed, math.floor(tokens), retry}
+`)
+
+// RateLimitResult is the outcome of a rate limit check.
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int           // The number of requests allowed per window
+	Remaining  int           // The number of requests that can still be made right away
+	RetryAfter time.Duration // Time until the next request is allowed; zero if this one was
+}
+
+// RateLimiter limits request rates with token buckets stored in Redis. Each key has a bucket
+// of limit tokens that refills evenly over the window, which allows short bursts of up to
+// limit requests but no more than limit requests per window on average.
+type RateLimiter struct {
+	Client redis.Scripter
+}
+
+// Allow takes a token from the key's bucket, reporting whether the request is allowed.
+func (l *RateLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (RateLimitResult, error) {
+	values, err := tokenBucketScript.Run(ctx, l.Client, []string{rateLimitPrefix + key},
+		limit, strconv.FormatInt(window.Milliseconds(), 10)).Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	return RateLimitResult{
+		Allowed:    values[0] == 1,
+		Limit:      limit,
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+	}, nil
+}
@@ -4,11 +4,12 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/go-redis/redis/v8"
-	"github.com/pageza/chat-app/internal/config"
 	"github.com/sirupsen/logrus"
 )
 
@@ -18,37 +19,64 @@ type RedisClient interface {
 	// You can add other methods as needed.
 }
 
-// rdb is the Redis client that will be used throughout the application.
-var rdb *redis.Client
+// Client is an interface describing the Redis commands used by the session
+// and password reset helpers. *redis.Client satisfies it.
+type Client interface {
+	RedisClient
+	Get(ctx context.Context, key string) *redis.StringCmd
+	GetDel(ctx context.Context, key string) *redis.StringCmd
+	MGet(ctx context.Context, keys ...string) *redis.SliceCmd
+	Del(ctx context.Context, keys ...string) *redis.IntCmd
+	SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.BoolCmd
+	Incr(ctx context.Context, key string) *redis.IntCmd
+	ExpireAt(ctx context.Context, key string, tm time.Time) *redis.BoolCmd
+}
+
+// ErrTokenNotFound is returned when a one-time token does not exist or has expired.
+var ErrTokenNotFound = errors.New("token not found or expired")
+
+// Key prefixes for the values stored by this package.
+const (
+	passwordResetPrefix     = "password_reset:"
+	passwordResetUserPrefix = "password_reset_user:"
+	revokedTokenPrefix      = "revoked_jti:"
+	revokedBeforePrefix     = "revoked_before:"
+	challengePrefix         = "challenge:"
+	failedAttemptsPrefix    = "failed_attempts:"
+)
+
+// RevocationWatermarkTTL is how long a "revoke all tokens issued before" watermark is kept.
+// It must exceed the lifetime of the longest-lived token (refresh tokens live 24 hours).
+const RevocationWatermarkTTL = 48 * time.Hour
+
+// Connect sets up a Redis client for the server at addr and checks the connection. Without an address,
+// no client is set up and nil is returned, and the application falls back to in-process stores.
+func Connect(addr string) (*redis.Client, error) {
+	if addr == "" {
+		logrus.Warn("REDIS_ADDR is not set, using in-process stores that only suit a single server instance")
+		return nil, nil
+	}
 
-// InitializeRedis sets up the Redis client.
-func InitializeRedis() {
 	// Create a new Redis client
-	rdb = redis.NewClient(&redis.Options{
-		Addr: config.RedisAddr, // Redis server address
+	rdb := redis.NewClient(&redis.Options{
+		Addr: addr, // Redis server address
 	})
 
 	// Test the Redis connection
-	_, err := rdb.Ping(context.TODO()).Result()
-	if err != nil {
-		// Log fatal error if Redis connection fails
-		logrus.Fatalf("Could not connect to Redis: %v", err)
+	if _, err := rdb.Ping(context.TODO()).Result(); err != nil {
+		return nil, fmt.Errorf("could not connect to Redis: %w", err)
 	}
+	return rdb, nil
 }
 
-// GetRedisClient returns the initialized Redis client.
-func GetRedisClient() *redis.Client {
-	return rdb
-}
-
-// BlacklistToken blacklists a given JWT token in Redis.
-func BlacklistToken(ctx context.Context, client RedisClient, tokenString string, expirationTime int64) error {
+// BlacklistToken blacklists a JWT by its ID (the jti claim) until the token expires.
+func BlacklistToken(ctx context.Context, client RedisClient, tokenID string, expirationTime int64) error {
 	const maxRetries = 3 // Maximum number of retries
 	var currentRetry = 0 // Current retry count
 
 	// Retry logic for blacklisting the token
 	for currentRetry < maxRetries {
-		err := client.Set(ctx, tokenString, "blacklisted", time.Until(time.Unix(expirationTime, 0))).Err()
+		err := client.Set(ctx, revokedTokenPrefix+tokenID, "blacklisted", time.Until(time.Unix(expirationTime, 0))).Err()
 		if err == nil {
 			return nil // Operation was successful, return
 		}
@@ -67,38 +95,126 @@ func BlacklistToken(ctx context.Context, client RedisClient, tokenString string,
 	return nil // This line is technically unreachable but added for completeness
 }
 
-// CheckRateLimit checks the rate limit for a given IP in Redis.
-func CheckRateLimit(ip string, rdb *redis.Client) (bool, error) {
-	ctx := context.TODO()
-	// Increment the request count for this IP
-	newCount, err := rdb.Incr(ctx, ip).Result()
+// IsTokenRevoked reports whether a JWT has been revoked, either individually by its ID (e.g. by a logout)
+// or because it was issued to the user before their revocation watermark (e.g. by a password change).
+func IsTokenRevoked(ctx context.Context, client Client, tokenID, username string, issuedAt time.Time) (bool, error) {
+	values, err := client.MGet(ctx, revokedTokenPrefix+tokenID, revokedBeforePrefix+username).Result()
 	if err != nil {
-		// Log the error and return
-		logrus.WithFields(logrus.Fields{
-			"ip": ip,
-		}).Errorf("Redis error: %v", err)
 		return false, err
 	}
 
-	// Set the key to expire after 1 minute if this is the first request from this IP
-	if newCount == 1 {
-		if _, err := rdb.Expire(ctx, ip, 1*time.Minute).Result(); err != nil {
-			// Log the error and return
-			logrus.WithFields(logrus.Fields{
-				"ip": ip,
-			}).Errorf("Redis error: %v", err)
-			return false, err
+	if values[0] != nil {
+		return true, nil
+	}
+	if watermark, ok := values[1].(string); ok {
+		revokedBefore, err := strconv.ParseInt(watermark, 10, 64)
+		if err != nil {
+			return false, fmt.Errorf("invalid revocation watermark: %w", err)
+		}
+		return issuedAt.UnixMicro() <= revokedBefore, nil
+	}
+	return false, nil
+}
+
+// RevokeUserTokens revokes every token issued to a user until now, by setting a watermark
+// that rejects tokens issued up to the current microsecond. This ends all of the user's sessions,
+// while sessions issued after it returns, e.g. after a password change, are kept.
+func RevokeUserTokens(ctx context.Context, client Client, username string) error {
+	now := time.Now()
+	watermark := strconv.FormatInt(now.UnixMicro(), 10)
+	if err := client.Set(ctx, revokedBeforePrefix+username, watermark, RevocationWatermarkTTL).Err(); err != nil {
+		return err
+	}
+
+	// Wait for the watermark's microsecond to pass, so that the next token is issued after it
+	time.Sleep(time.Until(now.Truncate(time.Microsecond).Add(time.Microsecond)))
+	return nil
+}
+
+// StorePasswordResetToken stores the hash of a password reset token for a user.
+// Any reset token previously issued to the same user is invalidated.
+func StorePasswordResetToken(ctx context.Context, client Client, tokenHash string, userID uint, ttl time.Duration) error {
+	userKey := passwordResetUserPrefix + strconv.FormatUint(uint64(userID), 10)
+
+	// Invalidate the previous reset token, if any
+	previous, err := client.GetDel(ctx, userKey).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if previous != "" {
+		if err := client.Del(ctx, passwordResetPrefix+previous).Err(); err != nil {
+			return err
 		}
 	}
 
-	// Check if the rate limit has been exceeded (e.g., more than 10 requests per minute)
-	if newCount > 10 {
-		// Log a warning and return false
-		logrus.WithFields(logrus.Fields{
-			"ip": ip,
-		}).Warn("Rate limit exceeded")
-		return false, nil
+	if err := client.Set(ctx, passwordResetPrefix+tokenHash, userID, ttl).Err(); err != nil {
+		return err
+	}
+	return client.Set(ctx, userKey, tokenHash, ttl).Err()
+}
+
+// ConsumePasswordResetToken looks up and deletes a password reset token by its hash.
+// It returns the ID of the user the token was issued to, or ErrTokenNotFound.
+func ConsumePasswordResetToken(ctx context.Context, client Client, tokenHash string) (uint, error) {
+	value, err := client.GetDel(ctx, passwordResetPrefix+tokenHash).Result()
+	if err == redis.Nil {
+		return 0, ErrTokenNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	userID, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid password reset token value: %w", err)
+	}
+
+	if err := client.Del(ctx, passwordResetUserPrefix+value).Err(); err != nil {
+		return 0, err
+	}
+	return uint(userID), nil
+}
+
+// StoreChallenge stores short-lived state for a multi-step authentication ceremony,
+// such as a WebAuthn session, under the given key.
+func StoreChallenge(ctx context.Context, client Client, key string, value []byte, ttl time.Duration) error {
+	return client.Set(ctx, challengePrefix+key, value, ttl).Err()
+}
+
+// ConsumeChallenge looks up and deletes the state stored by StoreChallenge, so that it can only be used once.
+// It returns ErrTokenNotFound if the state does not exist or has expired.
+func ConsumeChallenge(ctx context.Context, client Client, key string) ([]byte, error) {
+	value, err := client.GetDel(ctx, challengePrefix+key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrTokenNotFound
 	}
+	return value, err
+}
 
-	return true, nil // Return true if the rate limit has not been exceeded
+// ClaimToken atomically marks a one-time token, such as an "MFA pending" token, as used until it expires.
+// It reports whether this call claimed the token; false means that the token was already used, is being
+// used by a concurrent request or was revoked.
+func ClaimToken(ctx context.Context, client Client, tokenID string, expiresAt int64) (bool, error) {
+	return client.SetNX(ctx, revokedTokenPrefix+tokenID, "claimed", time.Until(time.Unix(expiresAt, 0))).Result()
+}
+
+// ReleaseToken releases a token claimed with ClaimToken, so that it can be used again.
+func ReleaseToken(ctx context.Context, client Client, tokenID string) error {
+	return client.Del(ctx, revokedTokenPrefix+tokenID).Err()
+}
+
+// CountFailedAttempt records a failed attempt to use a one-time token, such as an "MFA pending" token,
+// and returns the number of failed attempts so far. The count is kept until the token expires.
+func CountFailedAttempt(ctx context.Context, client Client, tokenID string, expiresAt int64) (int64, error) {
+	key := failedAttemptsPrefix + tokenID
+	count, err := client.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if count == 1 {
+		if err := client.ExpireAt(ctx, key, time.Unix(expiresAt, 0)).Err(); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
 }
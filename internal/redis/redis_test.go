@@ -0,0 +1,151 @@
+package redis_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	redisI "github.com/pageza/chat-app/internal/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryClient is an in-memory implementation of redisI.Client. Expirations are ignored.
+type memoryClient map[string]string
+
+func (m memoryClient) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	switch v := value.(type) {
+	case string:
+		m[key] = v
+	case []byte:
+		m[key] = string(v)
+	}
+	return redis.NewStatusResult("OK", nil)
+}
+
+func (m memoryClient) Get(ctx context.Context, key string) *redis.StringCmd {
+	value, ok := m[key]
+	if !ok {
+		return redis.NewStringResult("", redis.Nil)
+	}
+	return redis.NewStringResult(value, nil)
+}
+
+func (m memoryClient) GetDel(ctx context.Context, key string) *redis.StringCmd {
+	cmd := m.Get(ctx, key)
+	delete(m, key)
+	return cmd
+}
+
+func (m memoryClient) MGet(ctx context.Context, keys ...string) *redis.SliceCmd {
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		if value, ok := m[key]; ok {
+			values[i] = value
+		}
+	}
+	return redis.NewSliceResult(values, nil)
+}
+
+func (m memoryClient) Del(ctx context.Context, keys ...string) *redis.IntCmd {
+	for _, key := range keys {
+		delete(m, key)
+	}
+	return redis.NewIntResult(int64(len(keys)), nil)
+}
+
+func (m memoryClient) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.BoolCmd {
+	if _, ok := m[key]; ok {
+		return redis.NewBoolResult(false, nil)
+	}
+	m.Set(ctx, key, value, expiration)
+	return redis.NewBoolResult(true, nil)
+}
+
+func (m memoryClient) Incr(ctx context.Context, key string) *redis.IntCmd {
+	count, _ := strconv.ParseInt(m[key], 10, 64)
+	count++
+	m[key] = strconv.FormatInt(count, 10)
+	return redis.NewIntResult(count, nil)
+}
+
+func (m memoryClient) ExpireAt(ctx context.Context, key string, tm time.Time) *redis.BoolCmd {
+	_, ok := m[key]
+	return redis.NewBoolResult(ok, nil)
+}
+
+func TestTokenRevocation(t *testing.T) {
+	ctx := context.Background()
+	client := memoryClient{}
+	issuedAt := time.Now().Add(-time.Minute)
+	expiresAt := time.Now().Add(time.Hour).Unix()
+
+	revoked, err := redisI.IsTokenRevoked(ctx, client, "token-1", "alice", issuedAt)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	t.Run("Blacklisted token IDs are revoked", func(t *testing.T) {
+		require.NoError(t, redisI.BlacklistToken(ctx, client, "token-1", expiresAt))
+
+		revoked, err := redisI.IsTokenRevoked(ctx, client, "token-1", "alice", issuedAt)
+		require.NoError(t, err)
+		assert.True(t, revoked)
+
+		revoked, err = redisI.IsTokenRevoked(ctx, client, "token-2", "alice", issuedAt)
+		require.NoError(t, err)
+		assert.False(t, revoked)
+	})
+
+	t.Run("Watermark revokes earlier tokens of the user only", func(t *testing.T) {
+		require.NoError(t, redisI.RevokeUserTokens(ctx, client, "alice"))
+
+		revoked, err := redisI.IsTokenRevoked(ctx, client, "token-2", "alice", issuedAt)
+		require.NoError(t, err)
+		assert.True(t, revoked)
+
+		revoked, err = redisI.IsTokenRevoked(ctx, client, "token-3", "alice", time.Now().Add(time.Minute))
+		require.NoError(t, err)
+		assert.False(t, revoked)
+
+		revoked, err = redisI.IsTokenRevoked(ctx, client, "token-4", "bob", issuedAt)
+		require.NoError(t, err)
+		assert.False(t, revoked)
+	})
+
+	t.Run("Tokens can only be claimed once until they are released", func(t *testing.T) {
+		claimed, err := redisI.ClaimToken(ctx, client, "token-6", expiresAt)
+		require.NoError(t, err)
+		assert.True(t, claimed)
+		claimed, err = redisI.ClaimToken(ctx, client, "token-6", expiresAt)
+		require.NoError(t, err)
+		assert.False(t, claimed)
+
+		revoked, err := redisI.IsTokenRevoked(ctx, client, "token-6", "dave", issuedAt)
+		require.NoError(t, err)
+		assert.True(t, revoked, "claimed tokens count as used")
+
+		require.NoError(t, redisI.ReleaseToken(ctx, client, "token-6"))
+		claimed, err = redisI.ClaimToken(ctx, client, "token-6", expiresAt)
+		require.NoError(t, err)
+		assert.True(t, claimed)
+	})
+
+	t.Run("Tokens issued in the same second as the watermark are revoked", func(t *testing.T) {
+		before := time.Now()
+		require.NoError(t, redisI.RevokeUserTokens(ctx, client, "carol"))
+
+		revoked, err := redisI.IsTokenRevoked(ctx, client, "token-5", "carol", before)
+		require.NoError(t, err)
+		assert.True(t, revoked)
+
+		revoked, err = redisI.IsTokenRevoked(ctx, client, "token-5", "carol", time.Unix(before.Unix(), 0))
+		require.NoError(t, err)
+		assert.True(t, revoked, "tokens without a sub-second issue time")
+
+		revoked, err = redisI.IsTokenRevoked(ctx, client, "token-7", "carol", time.Now())
+		require.NoError(t, err)
+		assert.False(t, revoked, "tokens issued after the revocation returned")
+	})
+}
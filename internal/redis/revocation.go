@@ -0,0 +1,36 @@
+// Package redis provides utilities for interacting with Redis.
+// This file specifically includes the token revocation store.
+package redis
+
+import (
+	"context"
+	"time"
+)
+
+// RevocationStore is an interface for revoking access and refresh tokens before they expire.
+type RevocationStore interface {
+	RevokeToken(ctx context.Context, tokenID string, expiresAt int64) error
+	RevokeUserTokens(ctx context.Context, username string) error
+	IsTokenRevoked(ctx context.Context, tokenID, username string, issuedAt time.Time) (bool, error)
+}
+
+// Revocations is a RevocationStore that keeps the revocations in a Client: Redis, so that they
+// are shared by every server instance, or an in-process client for single-node deployments.
+type Revocations struct {
+	Client Client
+}
+
+// RevokeToken revokes a token by its ID until it expires.
+func (s *Revocations) RevokeToken(ctx context.Context, tokenID string, expiresAt int64) error {
+	return BlacklistToken(ctx, s.Client, tokenID, expiresAt)
+}
+
+// RevokeUserTokens revokes every token issued to the user until now.
+func (s *Revocations) RevokeUserTokens(ctx context.Context, username string) error {
+	return RevokeUserTokens(ctx, s.Client, username)
+}
+
+// IsTokenRevoked reports whether a token has been revoked.
+func (s *Revocations) IsTokenRevoked(ctx context.Context, tokenID, username string, issuedAt time.Time) (bool, error) {
+	return IsTokenRevoked(ctx, s.Client, tokenID, username, issuedAt)
+}
@@ -0,0 +1,44 @@
+// Package routes sets up all the routes for the application.
+// This file specifically includes the selection of the stores shared by the handlers.
+package routes
+
+import (
+	"github.com/go-redis/redis/v8"
+	"github.com/pageza/chat-app/internal/events"
+	"github.com/pageza/chat-app/internal/memory"
+	"github.com/pageza/chat-app/internal/middleware"
+	redisI "github.com/pageza/chat-app/internal/redis"
+)
+
+// Backends are the stores that are shared by every server instance when they are kept in Redis.
+type Backends struct {
+	KV          redisI.Client // One-time tokens, such as password reset tokens and login challenges
+	Revocations redisI.RevocationStore
+	Presence    redisI.PresenceStore
+	RateLimits  middleware.RateLimitStore
+	Idempotency middleware.IdempotencyStore
+	Events      events.Bus
+}
+
+// NewBackends returns the Redis stores, or in-process stores for a single server instance if rdb is nil.
+func NewBackends(rdb *redis.Client) Backends {
+	if rdb == nil {
+		kv := memory.NewClient()
+		return Backends{
+			KV:          kv,
+			Revocations: &redisI.Revocations{Client: kv},
+			Presence:    memory.NewPresence(),
+			RateLimits:  memory.NewRateLimiter(),
+			Idempotency: &redisI.Idempotency{Client: kv},
+			Events:      memory.NewPubSub(),
+		}
+	}
+	return Backends{
+		KV:          rdb,
+		Revocations: &redisI.Revocations{Client: rdb},
+		Presence:    &redisI.Presence{Client: rdb},
+		RateLimits:  &redisI.RateLimiter{Client: rdb},
+		Idempotency: &redisI.Idempotency{Client: rdb},
+		Events:      &events.RedisBus{Client: rdb},
+	}
+}
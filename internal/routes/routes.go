@@ -5,39 +5,218 @@ package routes
 import (
 	"net/http"
 
-	"github.com/go-redis/redis/v8"
+	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/gorilla/mux"
+	"github.com/pageza/chat-app/internal/account"
+	"github.com/pageza/chat-app/internal/admin"
 	"github.com/pageza/chat-app/internal/auth"
 	"github.com/pageza/chat-app/internal/chat"
+	"github.com/pageza/chat-app/internal/config"
+	"github.com/pageza/chat-app/internal/errors"
+	"github.com/pageza/chat-app/internal/events"
+	"github.com/pageza/chat-app/internal/jwt"
+	"github.com/pageza/chat-app/internal/mailer"
 	"github.com/pageza/chat-app/internal/middleware"
+	"github.com/pageza/chat-app/internal/models"
+	"github.com/pageza/chat-app/internal/oidc"
+	"github.com/pageza/chat-app/internal/openapi"
+	"github.com/pageza/chat-app/internal/storage"
 	"github.com/pageza/chat-app/internal/user"
 	"github.com/pageza/chat-app/internal/utils"
 	"github.com/pageza/chat-app/pkg/database"
+	"github.com/sirupsen/logrus"
 )
 
-func InitializeRoutes(r *mux.Router, rdb *redis.Client, db database.Database) {
-	authHandler := &auth.AuthHandler{DB: db}
-	userHandler := &user.UserHandler{DB: db}
+// InitializeRoutes registers every route on r. It returns the account service, whose background
+// worker the caller starts, so that the worker can be stopped when the server shuts down.
+func InitializeRoutes(r *mux.Router, cfg *config.Config, backends Backends, db *database.GormDatabase, limiter *middleware.RateLimiter, tokens *jwt.JwtManager, doc *openapi3.T) *account.Service {
+	authHandler := &auth.AuthHandler{
+		DB:               db,
+		JwtManager:       tokens,
+		Redis:            backends.KV,
+		Mailer:           mailer.New(cfg.Mail),
+		Credentials:      db,
+		APITokens:        db,
+		PasswordResetTTL: cfg.Auth.PasswordResetExpiration,
+		PasswordResetURL: cfg.Auth.PasswordResetURL,
+		TOTPIssuer:       cfg.Auth.TOTPIssuer,
+	}
+	webAuthn, err := auth.NewWebAuthn(cfg.WebAuthn)
+	if err != nil {
+		logrus.Errorf("Invalid WebAuthn configuration, passkey login is disabled: %v", err)
+	}
+	authHandler.WebAuthn = webAuthn
+	oidcHandler := &oidc.Handler{
+		Providers:         oidc.NewProviders(cfg.OIDC.Providers),
+		Store:             db,
+		States:            &oidc.RedisStateStore{Client: backends.KV},
+		Sessions:          authHandler,
+		PostLoginRedirect: cfg.OIDC.PostLoginRedirect,
+		Cookies:           cfg.Cookies,
+	}
+	eventBroker := &events.Broker{Bus: backends.Events, Relations: db, Presence: backends.Presence}
+	chatHandler := &chat.Handler{
+		Store:    db,
+		Events:   eventBroker,
+		Presence: backends.Presence,
+		Flood: &chat.FloodControl{
+			Limits:          backends.RateLimits,
+			Recent:          backends.KV,
+			MessageLimit:    cfg.Chat.MessageRateLimit,
+			MessageWindow:   cfg.Chat.MessageRateWindow,
+			DuplicateWindow: cfg.Chat.DuplicateWindow,
+		},
+	}
+	mediaStorage := &storage.LocalStorage{Dir: cfg.Storage.Dir, BaseURL: cfg.Storage.BaseURL}
+	userHandler := &user.UserHandler{DB: db, Events: eventBroker, Storage: mediaStorage, Relations: db}
+	accountService := &account.Service{
+		Store:           db,
+		Revocations:     backends.Revocations,
+		Media:           mediaStorage,
+		Exports:         &storage.LocalStorage{Dir: cfg.Account.DataExportDir},
+		GracePeriod:     cfg.Account.DeletionGracePeriod,
+		ExportRetention: cfg.Account.DataExportRetention,
+	}
+	authn := &middleware.Authenticator{
+		Keys:        tokens.Keys,
+		Users:       db,
+		Revocations: backends.Revocations,
+		APITokens:   db,
+		Limiter:     limiter,
+		FailOpen:    cfg.Redis.TokenRevocationFailOpen,
+	}
+	csrf := &middleware.CSRF{Secret: []byte(cfg.Security.CSRFSecret), Cookies: cfg.Cookies}
+	adminHandler := &admin.Handler{Store: db, Revocations: backends.Revocations, ClientIP: limiter.ClientIP}
+	docsHandler, err := openapi.NewHandler(doc)
+	if err != nil {
+		logrus.Fatalf("Could not encode the OpenAPI document: %v", err)
+	}
+	// Mobile clients retry messages and registrations on flaky connections
+	idempotency := &middleware.Idempotency{Store: backends.Idempotency, TTL: cfg.Server.IdempotencyKeyTTL, ClientIP: limiter.ClientIP}
 
-	// Health check route
+	// Requests for unknown routes get the same error responses as the handlers
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		errors.Respond(w, r, errors.NewAPIError(http.StatusNotFound, "No such route"))
+	})
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		errors.Respond(w, r, errors.NewAPIError(http.StatusMethodNotAllowed, "Method not allowed on this route"))
+	})
+
+	// Routes that are not part of the versioned API
 	r.HandleFunc("/health", utils.HealthCheckHandler).Methods("GET")
+	// Public keys for verifying tokens issued by this service, at their well-known location
+	r.HandleFunc("/.well-known/jwks.json", tokens.Keys.JWKSHandler).Methods("GET")
+	// Uploaded files, such as avatars
+	r.PathPrefix(cfg.Storage.BaseURL+"/").Handler(mediaStorage.Handler()).Methods("GET", "HEAD")
+	// Description of the API, covering every version, and a page for browsing it
+	r.HandleFunc("/openapi.json", docsHandler.SpecHandler).Methods("GET")
+	r.HandleFunc("/docs", docsHandler.DocsHandler).Methods("GET")
+
+	// Version 1 of the API
+	v1Routes := func(r *mux.Router) {
+		// Chat-related routes, which also accept personal API tokens with the matching scope
+		r.HandleFunc("/chat", authn.RequireScope(models.ScopeRoomsRead)(chatHandler.ChatHandler)).Methods("GET")
+		r.HandleFunc("/rooms", authn.AuthMiddleware(chatHandler.CreateRoomHandler)).Methods("POST")
+		r.HandleFunc("/rooms/{id:[0-9]+}", authn.AuthMiddleware(chatHandler.UpdateRoomHandler)).Methods("PATCH")
+		r.HandleFunc("/rooms/{id:[0-9]+}/join", authn.RequireScope(models.ScopeMessagesWrite)(chatHandler.JoinRoomHandler)).Methods("POST")
+		r.HandleFunc("/rooms/{id:[0-9]+}/leave", authn.RequireScope(models.ScopeMessagesWrite)(chatHandler.LeaveRoomHandler)).Methods("POST")
+		r.HandleFunc("/send", authn.RequireScope(models.ScopeMessagesWrite)(idempotency.Middleware(chatHandler.SendMessageHandler))).Methods("POST")
+		r.HandleFunc("/receive", authn.RequireScope(models.ScopeMessagesRead)(chatHandler.ReceiveMessageHandler)).Methods("GET")
+		r.HandleFunc("/presence", authn.RequireScope(models.ScopeMessagesRead)(chatHandler.PresenceHandler)).Methods("GET")
+
+		// Authentication-related routes
+		r.HandleFunc("/register", idempotency.Middleware(authHandler.RegisterHandler)).Methods("POST")
+		r.HandleFunc("/login", authHandler.LoginHandler).Methods("POST")
+		r.HandleFunc("/login/mfa", authHandler.LoginMFAHandler).Methods("POST")
+		// Logout route with inline function to pass Redis client
+		r.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
+			authHandler.LogoutHandler(w, r, backends.KV)
+		}).Methods("POST")
+		r.HandleFunc("/logout/all", authn.AuthMiddleware(authHandler.LogoutAllHandler)).Methods("POST")
+
+		// Password management routes
+		r.HandleFunc("/password/forgot", authHandler.ForgotPasswordHandler).Methods("POST")
+		r.HandleFunc("/password/reset", authHandler.ResetPasswordHandler).Methods("POST")
+		r.HandleFunc("/password/change", authn.AuthMiddleware(authHandler.ChangePasswordHandler)).Methods("POST")
+
+		// Two-factor authentication routes
+		r.HandleFunc("/mfa/totp/enroll", authn.AuthMiddleware(authHandler.EnrollTOTPHandler)).Methods("POST")
+		r.HandleFunc("/mfa/totp/confirm", authn.AuthMiddleware(authHandler.ConfirmTOTPHandler)).Methods("POST")
+		r.HandleFunc("/mfa/totp/disable", authn.AuthMiddleware(authHandler.DisableTOTPHandler)).Methods("POST")
+
+		// Passkey (WebAuthn) routes
+		r.HandleFunc("/webauthn/register/begin", authn.AuthMiddleware(authHandler.BeginPasskeyRegistrationHandler)).Methods("POST")
+		r.HandleFunc("/webauthn/register/finish", authn.AuthMiddleware(authHandler.FinishPasskeyRegistrationHandler)).Methods("POST")
+		r.HandleFunc("/webauthn/login/begin", authHandler.BeginPasskeyLoginHandler).Methods("POST")
+		r.HandleFunc("/webauthn/login/finish", authHandler.FinishPasskeyLoginHandler).Methods("POST")
+		r.HandleFunc("/webauthn/credentials", authn.AuthMiddleware(authHandler.ListPasskeysHandler)).Methods("GET")
+		r.HandleFunc("/webauthn/credentials/{id}", authn.AuthMiddleware(authHandler.DeletePasskeyHandler)).Methods("DELETE")
+
+		// Personal API token routes
+		r.HandleFunc("/api-tokens", authn.AuthMiddleware(authHandler.CreateAPITokenHandler)).Methods("POST")
+		r.HandleFunc("/api-tokens", authn.AuthMiddleware(authHandler.ListAPITokensHandler)).Methods("GET")
+		r.HandleFunc("/api-tokens/{id}", authn.AuthMiddleware(authHandler.RevokeAPITokenHandler)).Methods("DELETE")
+
+		// OpenID Connect login routes (e.g. ID.me)
+		r.HandleFunc("/oidc/{provider}/login", oidcHandler.LoginHandler).Methods("GET")
+		r.HandleFunc("/oidc/{provider}/callback", oidcHandler.CallbackHandler).Methods("GET")
+		r.HandleFunc("/oidc/{provider}/link", authn.AuthMiddleware(oidcHandler.LinkHandler)).Methods("GET")
+
+		// User information route with authentication middleware
+		r.HandleFunc("/userinfo", authn.RequireScope(models.ScopeProfileRead)(userHandler.UserInfoHandler)).Methods("GET")
+
+		// Admin routes, restricted to moderators and administrators. Every action is recorded in the audit log.
+		adminRouter := r.PathPrefix("/admin").Subrouter()
+		adminRouter.HandleFunc("/users", authn.AuthMiddleware(middleware.RequireModerator(adminHandler.ListUsersHandler))).Methods("GET")
+		adminRouter.HandleFunc("/users/{id}/suspend", authn.AuthMiddleware(middleware.RequireModerator(adminHandler.SuspendUserHandler))).Methods("POST")
+		adminRouter.HandleFunc("/users/{id}/unsuspend", authn.AuthMiddleware(middleware.RequireModerator(adminHandler.UnsuspendUserHandler))).Methods("POST")
+		adminRouter.HandleFunc("/users/{id}/logout", authn.AuthMiddleware(middleware.RequireModerator(adminHandler.LogoutUserHandler))).Methods("POST")
+		adminRouter.HandleFunc("/users/{id}/role", authn.AuthMiddleware(middleware.RequireAdmin(adminHandler.SetRoleHandler))).Methods("PUT")
+		adminRouter.HandleFunc("/users/{id}/veteran-verification", authn.AuthMiddleware(middleware.RequireAdmin(adminHandler.VerifyVeteranHandler))).Methods("PUT")
+		adminRouter.HandleFunc("/users/{id}/veteran-verification", authn.AuthMiddleware(middleware.RequireAdmin(adminHandler.UnverifyVeteranHandler))).Methods("DELETE")
+		adminRouter.HandleFunc("/stats", authn.AuthMiddleware(middleware.RequireModerator(adminHandler.StatsHandler))).Methods("GET")
+		adminRouter.HandleFunc("/audit-log", authn.AuthMiddleware(middleware.RequireAdmin(adminHandler.AuditLogHandler))).Methods("GET")
+
+		// CSRF token for cookie-authenticated clients, to be sent back in the X-CSRF-Token header
+		r.HandleFunc("/csrf-token", csrf.TokenHandler).Methods("GET")
+
+		// Profile routes
+		r.HandleFunc("/me", authn.RequireScope(models.ScopeProfileRead)(userHandler.GetMeHandler)).Methods("GET")
+		r.HandleFunc("/me", authn.AuthMiddleware(userHandler.UpdateMeHandler)).Methods("PATCH")
+		r.HandleFunc("/me/avatar", authn.AuthMiddleware(userHandler.UploadAvatarHandler)).Methods("PUT")
+		r.HandleFunc("/me/avatar", authn.AuthMiddleware(userHandler.DeleteAvatarHandler)).Methods("DELETE")
+		r.HandleFunc("/users/{id:[0-9]+}", authn.RequireScope(models.ScopeProfileRead)(userHandler.GetUserHandler)).Methods("GET")
+
+		// Account deletion and data export routes
+		r.HandleFunc("/me", authn.AuthMiddleware(accountService.DeleteAccountHandler)).Methods("DELETE")
+		r.HandleFunc("/me/restore", authn.AuthMiddleware(accountService.CancelDeletionHandler)).Methods("POST")
+		r.HandleFunc("/me/exports", authn.AuthMiddleware(accountService.RequestExportHandler)).Methods("POST")
+		r.HandleFunc("/me/exports", authn.AuthMiddleware(accountService.ListExportsHandler)).Methods("GET")
+		r.HandleFunc("/me/exports/{id:[0-9]+}/download", authn.AuthMiddleware(accountService.DownloadExportHandler)).Methods("GET")
+
+		// Block and mute lists
+		r.HandleFunc("/me/blocks", authn.AuthMiddleware(userHandler.ListBlocksHandler)).Methods("GET")
+		r.HandleFunc("/me/blocks", authn.AuthMiddleware(userHandler.BlockHandler)).Methods("POST")
+		r.HandleFunc("/me/blocks/{id:[0-9]+}", authn.AuthMiddleware(userHandler.UnblockHandler)).Methods("DELETE")
+		r.HandleFunc("/me/mutes", authn.AuthMiddleware(userHandler.ListMutesHandler)).Methods("GET")
+		r.HandleFunc("/me/mutes", authn.AuthMiddleware(userHandler.MuteHandler)).Methods("POST")
+		r.HandleFunc("/me/mutes/{id:[0-9]+}", authn.AuthMiddleware(userHandler.UnmuteHandler)).Methods("DELETE")
 
-	// Chat-related routes
-	r.HandleFunc("/chat", chat.ChatHandler).Methods("GET")
-	r.HandleFunc("/send", chat.SendMessageHandler).Methods("POST")
-	r.HandleFunc("/receive", chat.ReceiveMessageHandler).Methods("GET")
+		// Real-time event stream (Server-Sent Events)
+		r.HandleFunc("/events", authn.RequireScope(models.ScopeMessagesRead)(eventBroker.StreamHandler)).Methods("GET")
 
-	// Authentication-related routes
-	r.HandleFunc("/register", authHandler.RegisterHandler).Methods("POST")
-	r.HandleFunc("/login", authHandler.LoginHandler).Methods("POST")
-	// Logout route with inline function to pass Redis client
-	r.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
-		authHandler.LogoutHandler(w, r, rdb)
-	}).Methods("POST")
+		// Route to check if the user is authenticated
+		r.HandleFunc("/check-auth", authn.CheckAuth).Methods("GET")
+	}
 
-	// User information route with authentication middleware
-	r.HandleFunc("/userinfo", middleware.AuthMiddleware(userHandler.UserInfoHandler)).Methods("GET")
+	// The API is served under /api/v1. The routes are also served without the version prefix, as
+	// they were before the API was versioned, until clients have moved to the versioned routes.
+	api := NewAPI(r)
+	api.Version("v1", v1Routes)
+	api.Legacy("v1", &middleware.Deprecation{
+		DeprecatedAt: cfg.Server.LegacyAPIDeprecatedAt,
+		Sunset:       cfg.Server.LegacyAPISunset,
+	}, v1Routes)
 
-	// Route to check if the user is authenticated
-	r.HandleFunc("/check-auth", middleware.CheckAuth).Methods("GET")
+	return accountService
 }
@@ -0,0 +1,47 @@
+// Package routes sets up all the routes for the application.
+// This file specifically includes the mounting of the API versions and of the legacy unversioned routes.
+package routes
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/pageza/chat-app/internal/middleware"
+)
+
+// RouteRegistrar registers routes on a router.
+type RouteRegistrar func(r *mux.Router)
+
+// API mounts versions of the API side by side under /api, such as /api/v1 and /api/v2.
+type API struct {
+	router *mux.Router
+	api    *mux.Router
+}
+
+// NewAPI creates an API mounted on the router.
+func NewAPI(r *mux.Router) *API {
+	return &API{router: r, api: r.PathPrefix(middleware.APIPrefix).Subrouter()}
+}
+
+// Version mounts a version of the API under /api/<name>. When several registrars are given, the routes
+// registered by earlier ones take precedence. A new version can thus register the routes it changes,
+// followed by the previous version's registrar to keep the routes that did not change:
+//
+//	api.Version("v2", v2Routes, v1Routes)
+func (a *API) Version(name string, registrars ...RouteRegistrar) *mux.Router {
+	version := a.api.PathPrefix("/" + name).Subrouter()
+	for _, register := range registrars {
+		register(version)
+	}
+	return version
+}
+
+// Legacy mounts routes without a version prefix, for clients that predate versioning. Their responses
+// carry deprecation headers pointing to the same route under /api/<successor>. Legacy routes must be
+// mounted after every other route, so that they don't shadow them.
+func (a *API) Legacy(successor string, deprecation *middleware.Deprecation, registrars ...RouteRegistrar) *mux.Router {
+	legacy := a.router.NewRoute().Subrouter()
+	legacy.Use(deprecation.Middleware(middleware.APIPrefix + "/" + successor))
+	for _, register := range registrars {
+		register(legacy)
+	}
+	return legacy
+}
@@ -0,0 +1,59 @@
+package routes_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pageza/chat-app/internal/middleware"
+	"github.com/pageza/chat-app/internal/routes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPI(t *testing.T) {
+	respond := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		}
+	}
+	v1Routes := func(r *mux.Router) {
+		r.HandleFunc("/chat", respond("v1 chat")).Methods("GET")
+		r.HandleFunc("/send", respond("v1 send")).Methods("POST")
+	}
+	v2Routes := func(r *mux.Router) {
+		r.HandleFunc("/send", respond("v2 send")).Methods("POST")
+	}
+
+	r := mux.NewRouter()
+	api := routes.NewAPI(r)
+	api.Version("v1", v1Routes)
+	api.Version("v2", v2Routes, v1Routes)
+	api.Legacy("v1", &middleware.Deprecation{DeprecatedAt: time.Unix(1792368000, 0)}, v1Routes)
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+		return rr
+	}
+
+	t.Run("Versions are served side by side", func(t *testing.T) {
+		assert.Equal(t, "v1 send", serve("POST", "/api/v1/send").Body.String())
+		assert.Equal(t, "v2 send", serve("POST", "/api/v2/send").Body.String())
+		assert.Equal(t, "v1 chat", serve("GET", "/api/v2/chat").Body.String())
+		assert.Empty(t, serve("GET", "/api/v1/chat").Header().Get("Deprecation"))
+	})
+
+	t.Run("Legacy routes are deprecated", func(t *testing.T) {
+		rr := serve("GET", "/chat")
+		assert.Equal(t, "v1 chat", rr.Body.String())
+		assert.Equal(t, "@1792368000", rr.Header().Get("Deprecation"))
+		assert.Empty(t, rr.Header().Get("Sunset"))
+		assert.Equal(t, `</api/v1/chat>; rel="successor-version"`, rr.Header().Get("Link"))
+	})
+
+	t.Run("Unknown versions are not found", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, serve("GET", "/api/v3/chat").Code)
+	})
+}
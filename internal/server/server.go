@@ -8,33 +8,89 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
+	"github.com/pageza/chat-app/internal/avatar"
 	"github.com/pageza/chat-app/internal/config"
+	"github.com/pageza/chat-app/internal/jwt"
 	"github.com/pageza/chat-app/internal/middleware"
-	"github.com/pageza/chat-app/internal/redis"
+	"github.com/pageza/chat-app/internal/openapi"
 	"github.com/pageza/chat-app/internal/routes"
 	"github.com/pageza/chat-app/pkg/database"
 	"github.com/sirupsen/logrus"
 )
 
-var serverExit = make(chan struct{}) // Added this line
+// serverExit is closed when the server shuts down, which also stops the background tasks.
+var serverExit = make(chan struct{})
+
+// Connection timeouts. There is no write timeout, since it would cut off event streams;
+// TimeoutMiddleware limits the time spent on other requests instead.
+const (
+	readHeaderTimeout = 10 * time.Second
+	idleTimeout       = 2 * time.Minute
+)
+
+// accountWorkerInterval is how often the account worker deletes accounts and manages data exports.
+const accountWorkerInterval = time.Minute
 
 // StartServer initializes the HTTP server and listens for incoming requests.
-func StartServer(db *database.GormDatabase) {
-	// Initialize Redis client
-	rdb := redis.GetRedisClient()
+// rdb may be nil, in which case the server keeps its state in process.
+func StartServer(cfg *config.Config, db *database.GormDatabase, rdb *redis.Client, keys *jwt.KeySet) {
+	// Use Redis if it is configured, and in-process stores otherwise
+	backends := routes.NewBackends(rdb)
+
+	// Generated signing keys are rotated until the server shuts down
+	keys.StartKeyRotation(cfg.JWT, serverExit)
+
+	// Rate limits apply per client IP address to every request, and per user to authenticated requests
+	limiter, err := middleware.NewRateLimiter(backends.RateLimits, cfg.RateLimit.Groups, cfg.Server.TrustedProxies)
+	if err != nil {
+		logrus.Fatalf("Invalid rate limit configuration: %v", err)
+	}
+	limiter.FailOpen = cfg.Redis.RateLimitFailOpen
+	securityHeaders := middleware.NewSecurityHeaders(cfg.Security)
+	csrf := &middleware.CSRF{Secret: []byte(cfg.Security.CSRFSecret), Cookies: cfg.Cookies}
+
+	// Request bodies are validated against the OpenAPI document, which is also served to clients
+	doc, err := openapi.Load()
+	if err != nil {
+		logrus.Fatalf("Invalid OpenAPI document: %v", err)
+	}
+	validator, err := openapi.NewValidator(doc)
+	if err != nil {
+		logrus.Fatalf("Could not route requests to the OpenAPI document's operations: %v", err)
+	}
 
 	// Create a new router
 	r := mux.NewRouter()
-	r.Use(middleware.RateLimitMiddleware)
 
 	// Add your routes here
-	routes.InitializeRoutes(r, rdb, db)
+	accountService := routes.InitializeRoutes(r, cfg, backends, db, limiter, jwt.NewJwtManager(keys, cfg.JWT, cfg.Cookies), doc)
+
+	// Delete accounts and manage data export archives in the background until the server shuts down
+	accountService.StartWorker(accountWorkerInterval, serverExit)
+
+	// The middlewares wrap the router rather than being added with r.Use, so that they also
+	// apply to requests that match no route, such as CORS preflight requests
+	handler := middleware.Chain(r,
+		middleware.RequestIDMiddleware,
+		middleware.AccessLogMiddleware(limiter.ClientIP),
+		securityHeaders.Middleware,
+		middleware.RecoveryMiddleware,
+		config.InitializeCORS(cfg.CORS).Handler,
+		middleware.MaxBodySizeMiddleware(cfg.Server.MaxRequestBodyBytes, map[string]int64{"/me/avatar": avatar.MaxRequestSize}),
+		middleware.TimeoutMiddleware(cfg.Server.RequestTimeout, cfg.Server.TimeoutExemptPaths),
+		limiter.Middleware,
+		csrf.Middleware,
+		validator.Middleware,
+	)
 
 	// Create a new HTTP server
 	srv := &http.Server{
-		Addr:    ":" + config.ServerPort,
-		Handler: r,
+		Addr:              ":" + cfg.Server.Port,
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		IdleTimeout:       idleTimeout,
 	}
 
 	// Create a context for graceful shutdown
@@ -60,7 +116,7 @@ func StartServer(db *database.GormDatabase) {
 	}()
 
 	// Start the server
-	logrus.Infof("Server is running on port: %s", config.ServerPort)
+	logrus.Infof("Server is running on port: %s", cfg.Server.Port)
 	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		logrus.Fatalf("listen: %s\n", err)
 	}
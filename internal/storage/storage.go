@@ -0,0 +1,107 @@
+// Package storage provides a pluggable store for user-uploaded files such as avatars.
+// The local filesystem implementation is used by default; other backends, such as
+// an object store, only need to implement the Storage interface.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Storage stores files under slash-separated keys, e.g. "avatars/1/abc/256.png".
+type Storage interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error) // Returns ErrNotFound if the file does not exist
+	Delete(ctx context.Context, key string) error
+	URL(key string) string // Public URL of the file
+}
+
+// ErrNotFound is returned when a file does not exist.
+var ErrNotFound = errors.New("file not found")
+
+// LocalStorage stores files in a directory and serves them under a base URL.
+type LocalStorage struct {
+	Dir     string // Directory the files are written to
+	BaseURL string // URL prefix the files are served under, e.g. "/media"
+}
+
+// Put writes the file, creating parent directories as needed. The content type is
+// not stored; it is derived from the file extension when the file is served.
+func (s *LocalStorage) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	file, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so that readers never see a partial file
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// Open opens the file for reading.
+func (s *LocalStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	file, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Delete removes the file. Deleting a file that does not exist is not an error.
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	file, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// URL returns the URL the file is served under.
+func (s *LocalStorage) URL(key string) string {
+	return strings.TrimSuffix(s.BaseURL, "/") + "/" + key
+}
+
+// Handler serves the stored files under the base URL path.
+func (s *LocalStorage) Handler() http.Handler {
+	fileServer := http.StripPrefix(strings.TrimSuffix(s.BaseURL, "/"), http.FileServer(http.Dir(s.Dir)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Don't list directories
+		if strings.HasSuffix(r.URL.Path, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		// Keys are never reused, so files can be cached indefinitely
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		fileServer.ServeHTTP(w, r)
+	})
+}
+
+// path maps a key to a file in the storage directory, rejecting keys that would escape it.
+func (s *LocalStorage) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" || clean != "/"+key {
+		return "", fmt.Errorf("invalid storage key: %q", key)
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(clean)), nil
+}
@@ -0,0 +1,67 @@
+package totp_test
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pageza/chat-app/internal/totp"
+	"github.com/stretchr/testify/assert"
+)
+
+// rfcSecret is the SHA1 shared secret from RFC 6238 Appendix B.
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestGenerateCode(t *testing.T) {
+	// RFC 6238 test vectors, truncated to six digits
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for unix, want := range vectors {
+		code, err := totp.GenerateCode(rfcSecret, time.Unix(unix, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, want, code, "time %d", unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, _ := totp.GenerateCode(rfcSecret, now)
+
+	t.Run("Current step", func(t *testing.T) {
+		_, ok := totp.Validate(rfcSecret, code, now)
+		assert.True(t, ok)
+	})
+
+	t.Run("Within skew", func(t *testing.T) {
+		_, ok := totp.Validate(rfcSecret, code, now.Add(totp.Period))
+		assert.True(t, ok)
+	})
+
+	t.Run("Outside skew", func(t *testing.T) {
+		_, ok := totp.Validate(rfcSecret, code, now.Add(3*totp.Period))
+		assert.False(t, ok)
+	})
+
+	t.Run("Wrong code", func(t *testing.T) {
+		_, ok := totp.Validate(rfcSecret, "000000", now)
+		assert.False(t, ok)
+	})
+}
+
+func TestProvisioningURI(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	assert.NoError(t, err)
+
+	uri := totp.ProvisioningURI("Chat App", "testuser", secret)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Chat%20App:testuser?"))
+	assert.Contains(t, uri, "secret="+secret)
+	assert.Contains(t, uri, "issuer=Chat+App")
+}
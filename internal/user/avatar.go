@@ -0,0 +1,150 @@
+// Package user contains functionalities related to user operations.
+// This file specifically includes the avatar upload endpoints.
+
+package user
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/pageza/chat-app/internal/avatar"
+	"github.com/pageza/chat-app/internal/errors"
+	"github.com/pageza/chat-app/internal/middleware"
+	"github.com/pageza/chat-app/internal/models"
+	"github.com/pageza/chat-app/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// avatarFormField is the multipart form field the image is uploaded in.
+const avatarFormField = "avatar"
+
+// avatarDisplaySize is the thumbnail size returned as the avatar URL.
+const avatarDisplaySize = 256
+
+// UploadAvatarHandler replaces the logged-in user's avatar with an uploaded JPEG, PNG or GIF image.
+// The image is expected in the "avatar" field of a multipart form.
+func (uh *UserHandler) UploadAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.CurrentUser(r)
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, avatar.MaxRequestSize)
+	file, _, err := r.FormFile(avatarFormField)
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "An image must be uploaded in the \"avatar\" form field, up to 5 MB"))
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, avatar.MaxUploadSize+1))
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Could not read the uploaded image"))
+		return
+	}
+	if len(data) > avatar.MaxUploadSize {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusRequestEntityTooLarge, "The image must be at most 5 MB"))
+		return
+	}
+
+	thumbnails, err := avatar.Process(data)
+	if err == avatar.ErrUnsupportedFormat || err == avatar.ErrTooLarge {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnprocessableEntity, err.Error()))
+		return
+	}
+	if err != nil {
+		uh.avatarError(w, r, user, "Could not process avatar", err)
+		return
+	}
+
+	// Every upload gets a new key, so that cached copies of the previous avatar are never served
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		uh.avatarError(w, r, user, "Could not store avatar", err)
+		return
+	}
+	key := fmt.Sprintf("avatars/%d/%s", user.ID, hex.EncodeToString(random))
+	for size, thumbnail := range thumbnails {
+		if err := uh.Storage.Put(r.Context(), avatar.File(key, size), thumbnail, "image/png"); err != nil {
+			uh.deleteAvatar(r, key)
+			uh.avatarError(w, r, user, "Could not store avatar", err)
+			return
+		}
+	}
+
+	previousKey := user.AvatarKey
+	user.AvatarKey = key
+	if err := uh.DB.UpdateUser(user); err != nil {
+		uh.deleteAvatar(r, key)
+		uh.avatarError(w, r, user, "Could not store avatar", err)
+		return
+	}
+	uh.deleteAvatar(r, previousKey)
+
+	utils.SendJSONResponse(w, http.StatusOK, uh.newMeResponse(user))
+}
+
+// DeleteAvatarHandler removes the logged-in user's avatar.
+func (uh *UserHandler) DeleteAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.CurrentUser(r)
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+
+	previousKey := user.AvatarKey
+	if previousKey != "" {
+		user.AvatarKey = ""
+		if err := uh.DB.UpdateUser(user); err != nil {
+			uh.avatarError(w, r, user, "Could not remove avatar", err)
+			return
+		}
+		uh.deleteAvatar(r, previousKey)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteAvatar deletes the thumbnails stored under an avatar key. Failures are only logged,
+// since the avatar is no longer referenced and the files are merely left behind.
+func (uh *UserHandler) deleteAvatar(r *http.Request, key string) {
+	if key == "" {
+		return
+	}
+	for _, size := range avatar.Sizes {
+		if err := uh.Storage.Delete(r.Context(), avatar.File(key, size)); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"key": key,
+			}).Warnf("Could not delete avatar: %v", err)
+		}
+	}
+}
+
+// avatarError logs a server-side avatar error and responds with a generic message.
+func (uh *UserHandler) avatarError(w http.ResponseWriter, r *http.Request, user *models.User, message string, err error) {
+	logrus.WithFields(logrus.Fields{
+		"method": r.Method,
+		"url":    r.URL.String(),
+		"ip":     r.RemoteAddr,
+		"user":   user.Username,
+	}).Errorf("%s: %v", message, err)
+	errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, message))
+}
+
+// avatarURLs returns the URL of the user's avatar at the display size and at every thumbnail size,
+// or nothing if the user has not uploaded an avatar.
+func (uh *UserHandler) avatarURLs(user *models.User) (string, map[string]string) {
+	if user.AvatarKey == "" || uh.Storage == nil {
+		return "", nil
+	}
+	urls := make(map[string]string, len(avatar.Sizes))
+	for _, size := range avatar.Sizes {
+		urls[strconv.Itoa(size)] = uh.Storage.URL(avatar.File(user.AvatarKey, size))
+	}
+	return urls[strconv.Itoa(avatarDisplaySize)], urls
+}
@@ -0,0 +1,223 @@
+// Package user contains functionalities related to user operations.
+// This file specifically includes the profile endpoints and their privacy rules.
+
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pageza/chat-app/internal/errors"
+	"github.com/pageza/chat-app/internal/events"
+	"github.com/pageza/chat-app/internal/middleware"
+	"github.com/pageza/chat-app/internal/models"
+	"github.com/pageza/chat-app/internal/utils"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// MeResponse is the logged-in user's own account and profile.
+type MeResponse struct {
+	ID            uint              `json:"id"`
+	Username      string            `json:"username"`
+	Email         string            `json:"email"`
+	Role          string            `json:"role"`
+	VeteranStatus bool              `json:"veteran_status"`
+	Profile       models.Profile    `json:"profile"`
+	AvatarURL     string            `json:"avatar_url,omitempty"`  // URL of the 256x256 thumbnail
+	AvatarURLs    map[string]string `json:"avatar_urls,omitempty"` // Thumbnail URLs, keyed by size in pixels
+	Privacy       map[string]string `json:"privacy"`               // Visibility of every private profile field
+	CreatedAt     time.Time         `json:"created_at"`
+
+	// Set while a requested account deletion is in its grace period
+	DeletionScheduledFor *time.Time `json:"deletion_scheduled_for,omitempty"`
+}
+
+// PublicUserResponse is another user's profile, as visible to the viewer.
+type PublicUserResponse struct {
+	ID            uint              `json:"id"`
+	Username      string            `json:"username"`
+	VeteranStatus bool              `json:"veteran_status"`
+	Profile       models.Profile    `json:"profile"`
+	AvatarURL     string            `json:"avatar_url,omitempty"`
+	AvatarURLs    map[string]string `json:"avatar_urls,omitempty"`
+}
+
+// UpdateProfileRequest is the payload of a profile update. Fields that are omitted are left unchanged,
+// and an empty string clears a field. Privacy settings are merged into the existing settings.
+type UpdateProfileRequest struct {
+	DisplayName     *string           `json:"display_name"`
+	Bio             *string           `json:"bio"`
+	BranchOfService *string           `json:"branch_of_service"`
+	ServiceEra      *string           `json:"service_era"`
+	Pronouns        *string           `json:"pronouns"`
+	Privacy         map[string]string `json:"privacy"`
+}
+
+// GetMeHandler returns the logged-in user's account and full profile, including the privacy settings.
+func (uh *UserHandler) GetMeHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.CurrentUser(r)
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, uh.newMeResponse(user))
+}
+
+// UpdateMeHandler updates the logged-in user's profile and privacy settings.
+// A change of display name is broadcast to connected clients.
+func (uh *UserHandler) UpdateMeHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.CurrentUser(r)
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+
+	var req UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid request payload"))
+		return
+	}
+
+	profile := user.Profile
+	apply := func(field **string, target *string) {
+		if *field != nil {
+			*target = strings.TrimSpace(**field)
+		}
+	}
+	apply(&req.DisplayName, &profile.DisplayName)
+	apply(&req.Bio, &profile.Bio)
+	apply(&req.BranchOfService, &profile.BranchOfService)
+	apply(&req.ServiceEra, &profile.ServiceEra)
+	apply(&req.Pronouns, &profile.Pronouns)
+
+	problems := profile.Validate()
+	for field, problem := range models.ValidatePrivacy(req.Privacy) {
+		if problems == nil {
+			problems = map[string]string{}
+		}
+		problems["privacy."+field] = problem
+	}
+	if problems != nil {
+		respondWithValidationErrors(w, problems)
+		return
+	}
+
+	previousName := user.Profile.DisplayName
+	user.Profile = profile
+	if len(req.Privacy) > 0 {
+		privacy := make(map[string]string, len(user.ProfilePrivacy)+len(req.Privacy))
+		for field, visibility := range user.ProfilePrivacy {
+			privacy[field] = visibility
+		}
+		for field, visibility := range req.Privacy {
+			privacy[field] = visibility
+		}
+		user.ProfilePrivacy = privacy
+	}
+
+	if err := uh.DB.UpdateUser(user); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"method": r.Method,
+			"url":    r.URL.String(),
+			"ip":     r.RemoteAddr,
+			"user":   user.Username,
+		}).Errorf("Could not update profile: %v", err)
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not update profile"))
+		return
+	}
+
+	if profile.DisplayName != previousName {
+		uh.publish(r, events.Event{
+			Type: events.UserDisplayNameChanged,
+			Data: events.DisplayNameChanged{UserID: user.ID, Username: user.Username, DisplayName: user.Name()},
+		})
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, uh.newMeResponse(user))
+}
+
+// GetUserHandler returns another user's profile, leaving out the fields the viewer may not see.
+func (uh *UserHandler) GetUserHandler(w http.ResponseWriter, r *http.Request) {
+	viewer, ok := middleware.CurrentUser(r)
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+
+	target, err := uh.DB.GetUserByID(mux.Vars(r)["id"])
+	if err == gorm.ErrRecordNotFound || (err == nil && target == nil) {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusNotFound, "User not found"))
+		return
+	}
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, "Could not load user"))
+		return
+	}
+
+	avatarURL, avatarURLs := uh.avatarURLs(target)
+	utils.SendJSONResponse(w, http.StatusOK, PublicUserResponse{
+		ID:            target.ID,
+		Username:      target.Username,
+		VeteranStatus: target.VeteranStatus,
+		Profile:       target.ProfileVisibleTo(viewer),
+		AvatarURL:     avatarURL,
+		AvatarURLs:    avatarURLs,
+	})
+}
+
+// publish publishes an event, logging failures, since the change itself has already been stored.
+func (uh *UserHandler) publish(r *http.Request, event events.Event) {
+	if uh.Events == nil {
+		return
+	}
+	if err := uh.Events.Publish(r.Context(), event); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"event": event.Type,
+		}).Warnf("Could not publish event: %v", err)
+	}
+}
+
+// newMeResponse builds the logged-in user's view of their own account.
+func (uh *UserHandler) newMeResponse(user *models.User) MeResponse {
+	privacy := make(map[string]string, len(models.PrivateProfileFields))
+	for _, field := range models.PrivateProfileFields {
+		privacy[field] = user.FieldVisibility(field)
+	}
+
+	avatarURL, avatarURLs := uh.avatarURLs(user)
+	return MeResponse{
+		ID:            user.ID,
+		Username:      user.Username,
+		Email:         user.Email,
+		Role:          user.EffectiveRole(),
+		VeteranStatus: user.VeteranStatus,
+		Profile:       user.Profile,
+		AvatarURL:     avatarURL,
+		AvatarURLs:    avatarURLs,
+		Privacy:       privacy,
+		CreatedAt:     user.CreatedAt,
+
+		DeletionScheduledFor: user.DeletionScheduledFor,
+	}
+}
+
+// respondWithValidationErrors responds with a ValidationError listing every invalid field.
+func respondWithValidationErrors(w http.ResponseWriter, problems map[string]string) {
+	names := make([]string, 0, len(problems))
+	for field := range problems {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+
+	fields := make([]errors.FieldError, 0, len(names))
+	for _, field := range names {
+		fields = append(fields, errors.FieldError{Field: field, Message: problems[field]})
+	}
+	errors.RespondWithError(w, errors.Validation(fields...))
+}
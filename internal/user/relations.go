@@ -0,0 +1,207 @@
+// Package user contains functionalities related to user operations.
+// This file specifically includes the endpoints managing a user's block and mute lists.
+
+package user
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pageza/chat-app/internal/errors"
+	"github.com/pageza/chat-app/internal/events"
+	"github.com/pageza/chat-app/internal/middleware"
+	"github.com/pageza/chat-app/internal/models"
+	"github.com/pageza/chat-app/internal/utils"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// RelationStore is an interface for the persistence of block and mute lists.
+type RelationStore interface {
+	GetUsersByIDs(userIDs []uint) ([]models.User, error)
+	CreateUserRelation(relation *models.UserRelation) error
+	DeleteUserRelation(userID, targetID uint, kind string) error
+	ListUserRelations(userID uint, kind string) ([]models.UserRelation, error)
+	CountUserRelations(userID uint, kind string) (int64, error)
+}
+
+// RelationRequest is the payload of a block or mute request.
+type RelationRequest struct {
+	UserID uint `json:"user_id"`
+}
+
+// RelationView is a blocked or muted user, as listed to the user who blocked or muted them.
+type RelationView struct {
+	UserID      uint      `json:"user_id"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ListBlocksHandler lists the users the logged-in user has blocked.
+func (uh *UserHandler) ListBlocksHandler(w http.ResponseWriter, r *http.Request) {
+	uh.listRelations(w, r, models.RelationBlock)
+}
+
+// BlockHandler blocks a user. Blocked users cannot send direct messages to the user, their messages
+// are hidden from the user, and they cannot see whether the user is online.
+func (uh *UserHandler) BlockHandler(w http.ResponseWriter, r *http.Request) {
+	uh.addRelation(w, r, models.RelationBlock)
+}
+
+// UnblockHandler unblocks a user.
+func (uh *UserHandler) UnblockHandler(w http.ResponseWriter, r *http.Request) {
+	uh.removeRelation(w, r, models.RelationBlock)
+}
+
+// ListMutesHandler lists the users the logged-in user has muted.
+func (uh *UserHandler) ListMutesHandler(w http.ResponseWriter, r *http.Request) {
+	uh.listRelations(w, r, models.RelationMute)
+}
+
+// MuteHandler mutes a user. The messages of muted users are hidden from the user.
+func (uh *UserHandler) MuteHandler(w http.ResponseWriter, r *http.Request) {
+	uh.addRelation(w, r, models.RelationMute)
+}
+
+// UnmuteHandler unmutes a user.
+func (uh *UserHandler) UnmuteHandler(w http.ResponseWriter, r *http.Request) {
+	uh.removeRelation(w, r, models.RelationMute)
+}
+
+func (uh *UserHandler) listRelations(w http.ResponseWriter, r *http.Request, kind string) {
+	user, ok := middleware.CurrentUser(r)
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+
+	relations, err := uh.Relations.ListUserRelations(user.ID, kind)
+	if err != nil {
+		uh.relationError(w, r, user, "Could not load "+kind+" list", err)
+		return
+	}
+
+	ids := make([]uint, len(relations))
+	for i, relation := range relations {
+		ids[i] = relation.TargetID
+	}
+	targets, err := uh.Relations.GetUsersByIDs(ids)
+	if err != nil {
+		uh.relationError(w, r, user, "Could not load "+kind+" list", err)
+		return
+	}
+	byID := make(map[uint]*models.User, len(targets))
+	for i := range targets {
+		byID[targets[i].ID] = &targets[i]
+	}
+
+	views := make([]RelationView, 0, len(relations))
+	for _, relation := range relations {
+		view := RelationView{UserID: relation.TargetID, CreatedAt: relation.CreatedAt}
+		if target, ok := byID[relation.TargetID]; ok {
+			view.Username = target.Username
+			view.DisplayName = target.Profile.DisplayName
+		}
+		views = append(views, view)
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, views)
+}
+
+func (uh *UserHandler) addRelation(w http.ResponseWriter, r *http.Request, kind string) {
+	user, ok := middleware.CurrentUser(r)
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+
+	var req RelationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == 0 {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid request payload"))
+		return
+	}
+	if req.UserID == user.ID {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "You cannot "+kind+" yourself"))
+		return
+	}
+
+	targets, err := uh.Relations.GetUsersByIDs([]uint{req.UserID})
+	if err != nil {
+		uh.relationError(w, r, user, "Could not "+kind+" user", err)
+		return
+	}
+	if len(targets) == 0 {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusNotFound, "User not found"))
+		return
+	}
+
+	count, err := uh.Relations.CountUserRelations(user.ID, kind)
+	if err != nil {
+		uh.relationError(w, r, user, "Could not "+kind+" user", err)
+		return
+	}
+	if count >= models.MaxUserRelations {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, fmt.Sprintf("You can %s at most %d users", kind, models.MaxUserRelations)))
+		return
+	}
+
+	relation := &models.UserRelation{UserID: user.ID, TargetID: req.UserID, Kind: kind}
+	if err := uh.Relations.CreateUserRelation(relation); err != nil {
+		uh.relationError(w, r, user, "Could not "+kind+" user", err)
+		return
+	}
+	uh.publishRelationsChanged(r, user.ID, req.UserID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (uh *UserHandler) removeRelation(w http.ResponseWriter, r *http.Request, kind string) {
+	user, ok := middleware.CurrentUser(r)
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
+		return
+	}
+
+	targetID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusBadRequest, "Invalid user ID"))
+		return
+	}
+
+	err = uh.Relations.DeleteUserRelation(user.ID, uint(targetID), kind)
+	if err == gorm.ErrRecordNotFound {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusNotFound, "User is not on your "+kind+" list"))
+		return
+	}
+	if err != nil {
+		uh.relationError(w, r, user, "Could not un"+kind+" user", err)
+		return
+	}
+	uh.publishRelationsChanged(r, user.ID, uint(targetID))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// publishRelationsChanged makes the open event streams of both users reload their block and mute lists.
+func (uh *UserHandler) publishRelationsChanged(r *http.Request, userID, targetID uint) {
+	uh.publish(r, events.Event{
+		Type:       events.RelationsChanged,
+		Recipients: []uint{userID, targetID},
+	})
+}
+
+// relationError logs a server-side error and responds with a generic message.
+func (uh *UserHandler) relationError(w http.ResponseWriter, r *http.Request, user *models.User, message string, err error) {
+	logrus.WithFields(logrus.Fields{
+		"method": r.Method,
+		"url":    r.URL.String(),
+		"ip":     r.RemoteAddr,
+		"user":   user.Username,
+	}).Errorf("%s: %v", message, err)
+	errors.RespondWithError(w, errors.NewAPIError(http.StatusInternalServerError, message))
+}
@@ -1,5 +1,5 @@
 // Package user contains functionalities related to user operations.
-// TODO: Consider adding more user-related functionalities like updating profiles, password change, etc.
+// It includes the user information, profile, avatar, and block and mute list endpoints.
 
 package user
 
@@ -7,8 +7,11 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/pageza/chat-app/internal/errors"
+	"github.com/pageza/chat-app/internal/events"
 	"github.com/pageza/chat-app/internal/middleware"
 	"github.com/pageza/chat-app/internal/models"
+	"github.com/pageza/chat-app/internal/storage"
 	"github.com/pageza/chat-app/pkg/database"
 )
 
@@ -21,45 +24,35 @@ type TokenValidator interface {
 	ValidateToken(r *http.Request) bool
 }
 
-// RealAuth is a concrete implementation of the TokenValidator interface.
-type RealAuth struct{}
-
-// ValidateToken calls the actual ValidateToken function from the middleware.
-func (ra *RealAuth) ValidateToken(r *http.Request) bool {
-	return middleware.ValidateToken(r)
-}
-
 // UserHandler contains dependencies for handling user-related requests.
 type UserHandler struct {
 	DB             database.Database
 	TokenValidator TokenValidator
+	Events         events.Publisher // Used to broadcast profile changes, may be nil
+	Storage        storage.Storage  // Stores uploaded avatars
+	Relations      RelationStore    // Stores block and mute lists
 }
 
 // UserInfoHandler handles the request to get user information.
-// It expects the request to have valid JWT tokens in the header, which are validated by middleware.
+// It expects the request to have been authenticated by the auth middleware, which stores the user in the request context.
 func (uh *UserHandler) UserInfoHandler(w http.ResponseWriter, r *http.Request) {
-	// Retrieve username and email from request headers, which were set by ValidateMiddleware
-	username := r.Header.Get("username")
-	email := r.Header.Get("email")
-
-	// If either username or email is empty, it means the JWT was not validated
-	if username == "" || email == "" {
-		// TODO: Consider logging the error for debugging
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	// Retrieve the user that was loaded by the auth middleware
+	user, ok := middleware.CurrentUser(r)
+	if !ok {
+		errors.RespondWithError(w, errors.NewAPIError(http.StatusUnauthorized, "Unauthorized"))
 		return
 	}
 
 	// Create a user info JSON response
 	userInfo := map[string]string{
-		"username": username,
-		"email":    email,
+		"username": user.Username,
+		"email":    user.Email,
 	}
 
 	// Convert the map to JSON
 	jsonResponse, err := json.Marshal(userInfo)
 	if err != nil {
-		// TODO: Consider logging the error for debugging
-		http.Error(w, "Could not create user info response", http.StatusInternalServerError)
+		errors.Respond(w, r, errors.Wrap(err, http.StatusInternalServerError, errors.CodeInternal, "Could not create user info response"))
 		return
 	}
 
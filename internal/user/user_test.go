@@ -2,11 +2,11 @@ package user_test
 
 import (
 	"encoding/json"
-	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/pageza/chat-app/internal/middleware"
 	"github.com/pageza/chat-app/internal/models"
 	"github.com/pageza/chat-app/internal/user"
 	"github.com/stretchr/testify/assert"
@@ -74,28 +74,38 @@ func (m *MockDB) GetUserByID(userID string) (*models.User, error) {
 	return &user, nil
 }
 
+func (m *MockDB) GetUserByEmail(email string) (*models.User, error) {
+	args := m.Called(email)
+	user, ok := args.Get(0).(*models.User)
+	if !ok {
+		return nil, args.Error(1)
+	}
+	return user, args.Error(1)
+}
+
+func (m *MockDB) UpdateUser(user *models.User) error {
+	args := m.Called(user)
+	return args.Error(0)
+}
+
 func TestUserInfoHandler(t *testing.T) {
 	// Initialize mocks
 	dbMock := new(MockDB)
-	authMock := new(MockAuth)
 
 	// Initialize the handler
 	userHandler := &user.UserHandler{
 		DB: dbMock,
 	}
 	t.Run("Successful User Information Retrieval", func(t *testing.T) {
-		// Mock the JWT validation to return a valid user ID
-		authMock.On("ValidateToken", mock.Anything).Return("testuser", nil)
-
-		// Mock the database call to return a mock user object
+		// The auth middleware stores the authenticated user in the request context
 		mockUser := &models.User{
 			Username: "testuser",
 			Email:    "test@email.com",
 		}
-		dbMock.On("GetUserByID", "testuser").Return(mockUser, nil)
 
 		// Create a new HTTP request for the user info endpoint
 		req, _ := http.NewRequest("GET", "/user/info", nil)
+		req = req.WithContext(middleware.WithPrincipal(req.Context(), &middleware.Principal{User: mockUser}))
 
 		// Create a ResponseRecorder to record the HTTP response
 		rr := httptest.NewRecorder()
@@ -113,10 +123,7 @@ func TestUserInfoHandler(t *testing.T) {
 	})
 
 	t.Run("Unauthorized User", func(t *testing.T) {
-		// Mock the JWT validation to return an error
-		authMock.On("ValidateToken", mock.Anything).Return("", errors.New("Unauthorized"))
-
-		// Create a new HTTP request for the user info endpoint
+		// Create a new HTTP request for the user info endpoint without an authenticated user
 		req, _ := http.NewRequest("GET", "/user/info", nil)
 
 		// Create a ResponseRecorder to record the HTTP response
@@ -128,24 +135,4 @@ func TestUserInfoHandler(t *testing.T) {
 		// Assert that the HTTP status code should be 401 (Unauthorized)
 		assert.Equal(t, http.StatusUnauthorized, rr.Code)
 	})
-
-	t.Run("Internal Server Error", func(t *testing.T) {
-		// Mock the JWT validation to return a valid user ID
-		authMock.On("ValidateToken", mock.Anything).Return("testuser", nil)
-
-		// Inject an error in the JSON marshaling process by returning an invalid user object from the database
-		dbMock.On("GetUserByID", "testuser").Return(nil, errors.New("Internal Server Error"))
-
-		// Create a new HTTP request for the user info endpoint
-		req, _ := http.NewRequest("GET", "/user/info", nil)
-
-		// Create a ResponseRecorder to record the HTTP response
-		rr := httptest.NewRecorder()
-
-		// Call the UserInfoHandler function
-		userHandler.UserInfoHandler(rr, req)
-
-		// Assert that the HTTP status code should be 500 (Internal Server Error)
-		assert.Equal(t, http.StatusInternalServerError, rr.Code)
-	})
 }
@@ -35,3 +35,13 @@ func ValidateUser(user *models.User, password string) error {
 	// Return the result of the comparison (nil if passwords match, error otherwise)
 	return err
 }
+
+// HashPassword hashes a plaintext password using bcrypt with the default cost.
+// Returns the hashed password as a string, or an error if hashing fails.
+func HashPassword(password string) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashedPassword), nil
+}
@@ -3,15 +3,21 @@
 package main
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
 
+	"github.com/joho/godotenv"
 	"github.com/pageza/chat-app/internal/config"
+	"github.com/pageza/chat-app/internal/jwt"
 	"github.com/pageza/chat-app/internal/logging"
+	"github.com/pageza/chat-app/internal/redis"
 	"github.com/pageza/chat-app/internal/server"
 	"github.com/pageza/chat-app/pkg/database"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
 )
 
 // main is the entry point function for the chat application.
@@ -27,12 +33,39 @@ func main() {
 		log.Println(http.ListenAndServe("localhost:6060", nil))
 	}()
 	logrus.Info("Starting config initialization")
-	config.Initialize()
-	logrus.Infof("Config - TokenExpiration: %s", config.TokenExpiration)
+	// A .env file in the working directory is optional; its variables don't override the environment
+	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
+		logrus.Fatalf("Could not read the .env file: %v", err)
+		return
+	}
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, pflag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		logrus.Fatalf("Config initialization failed: %v", err)
+		return
+	}
 	logrus.Info("Config initialized")
 
+	logrus.Info("Starting JWT key initialization")
+	keys, err := jwt.NewKeys(cfg.JWT)
+	if err != nil {
+		logrus.Fatalf("JWT key initialization failed: %v", err)
+		return
+	}
+	logrus.Info("JWT keys initialized")
+
+	logrus.Info("Starting Redis initialization")
+	rdb, err := redis.Connect(cfg.Redis.Addr)
+	if err != nil {
+		logrus.Fatalf("Redis initialization failed: %v", err)
+		return
+	}
+	logrus.Info("Redis initialized")
+
 	logrus.Info("Starting database initialization")
-	db, err := database.NewGormDatabase()
+	db, err := database.NewGormDatabase(cfg.Database.PostgresDSN)
 	if err != nil {
 		logrus.Fatalf("Database initialization failed: %v", err)
 		return
@@ -41,7 +74,7 @@ func main() {
 	logrus.Info("Database initialized")
 
 	logrus.Info("Starting server initialization")
-	server.StartServer(db)
+	server.StartServer(cfg, db, rdb, keys)
 	logrus.Info("Server initialized")
 
 	logrus.Info("Application started")
@@ -2,11 +2,13 @@ package database
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
-	"github.com/pageza/chat-app/internal/config"
 	"github.com/pageza/chat-app/internal/models"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Database interface {
@@ -18,20 +20,44 @@ type Database interface {
 	HandleFailedLoginAttempt(user *models.User) error
 	Where(query interface{}, args ...interface{}) *gorm.DB
 	GetUserByID(userID string) (*models.User, error)
+	GetUserByEmail(email string) (*models.User, error)
+	UpdateUser(user *models.User) error
 }
 
 type GormDatabase struct {
 	DB *gorm.DB
 }
 
-func NewGormDatabase() (*GormDatabase, error) {
-	db, err := gorm.Open(postgres.Open(config.PostgreDSN), &gorm.Config{})
+// NewGormDatabase connects to the PostgreSQL database at dsn and migrates its schema.
+func NewGormDatabase(dsn string) (*GormDatabase, error) {
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize database: %w", err)
 	}
 	if err := db.AutoMigrate(&models.User{}); err != nil {
 		return nil, fmt.Errorf("failed to auto-migrate User model: %w", err)
 	}
+	if err := db.AutoMigrate(&models.WebAuthnCredential{}); err != nil {
+		return nil, fmt.Errorf("failed to auto-migrate WebAuthnCredential model: %w", err)
+	}
+	if err := db.AutoMigrate(&models.UserIdentity{}); err != nil {
+		return nil, fmt.Errorf("failed to auto-migrate UserIdentity model: %w", err)
+	}
+	if err := db.AutoMigrate(&models.AuditLogEntry{}); err != nil {
+		return nil, fmt.Errorf("failed to auto-migrate AuditLogEntry model: %w", err)
+	}
+	if err := db.AutoMigrate(&models.APIToken{}); err != nil {
+		return nil, fmt.Errorf("failed to auto-migrate APIToken model: %w", err)
+	}
+	if err := db.AutoMigrate(&models.UserRelation{}); err != nil {
+		return nil, fmt.Errorf("failed to auto-migrate UserRelation model: %w", err)
+	}
+	if err := db.AutoMigrate(&models.Room{}, &models.RoomMember{}, &models.Message{}); err != nil {
+		return nil, fmt.Errorf("failed to auto-migrate chat models: %w", err)
+	}
+	if err := db.AutoMigrate(&models.DataExport{}); err != nil {
+		return nil, fmt.Errorf("failed to auto-migrate DataExport model: %w", err)
+	}
 	return &GormDatabase{DB: db}, nil
 }
 
@@ -40,7 +66,9 @@ func (g *GormDatabase) InitializeDB() (*gorm.DB, error) {
 }
 
 func (g *GormDatabase) AutoMigrateDB() error {
-	return g.DB.AutoMigrate(&models.User{})
+	return g.DB.AutoMigrate(&models.User{}, &models.WebAuthnCredential{}, &models.UserIdentity{}, &models.AuditLogEntry{},
+		&models.APIToken{}, &models.UserRelation{}, &models.Room{}, &models.RoomMember{}, &models.Message{},
+		&models.DataExport{})
 }
 
 func (g *GormDatabase) CreateUser(user *models.User) error {
@@ -76,3 +104,462 @@ func (g *GormDatabase) GetUserByID(userID string) (*models.User, error) {
 	}
 	return &user, nil
 }
+
+func (g *GormDatabase) GetUserByEmail(email string) (*models.User, error) {
+	var user models.User
+	if err := g.DB.Where("email = ?", email).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (g *GormDatabase) UpdateUser(user *models.User) error {
+	return g.DB.Save(user).Error
+}
+
+func (g *GormDatabase) ListWebAuthnCredentials(userID uint) ([]models.WebAuthnCredential, error) {
+	var credentials []models.WebAuthnCredential
+	if err := g.DB.Where("user_id = ?", userID).Order("created_at").Find(&credentials).Error; err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+func (g *GormDatabase) CreateWebAuthnCredential(credential *models.WebAuthnCredential) error {
+	return g.DB.Create(credential).Error
+}
+
+func (g *GormDatabase) UpdateWebAuthnCredential(credential *models.WebAuthnCredential) error {
+	return g.DB.Save(credential).Error
+}
+
+func (g *GormDatabase) DeleteWebAuthnCredential(userID, credentialID uint) error {
+	result := g.DB.Where("id = ? AND user_id = ?", credentialID, userID).Delete(&models.WebAuthnCredential{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (g *GormDatabase) GetUserIdentity(issuer, subject string) (*models.UserIdentity, error) {
+	var identity models.UserIdentity
+	if err := g.DB.Where("issuer = ? AND subject = ?", issuer, subject).First(&identity).Error; err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (g *GormDatabase) CreateUserIdentity(identity *models.UserIdentity) error {
+	return g.DB.Create(identity).Error
+}
+
+func (g *GormDatabase) ListUserIdentities(userID uint) ([]models.UserIdentity, error) {
+	var identities []models.UserIdentity
+	if err := g.DB.Where("user_id = ?", userID).Order("created_at").Find(&identities).Error; err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+func (g *GormDatabase) UpdateUserIdentity(identity *models.UserIdentity) error {
+	return g.DB.Save(identity).Error
+}
+
+func (g *GormDatabase) CreateAPIToken(token *models.APIToken) error {
+	return g.DB.Create(token).Error
+}
+
+func (g *GormDatabase) ListAPITokens(userID uint) ([]models.APIToken, error) {
+	var tokens []models.APIToken
+	if err := g.DB.Where("user_id = ?", userID).Order("created_at").Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (g *GormDatabase) DeleteAPIToken(userID, tokenID uint) error {
+	result := g.DB.Where("id = ? AND user_id = ?", tokenID, userID).Delete(&models.APIToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (g *GormDatabase) GetAPITokenByHash(tokenHash string) (*models.APIToken, error) {
+	var token models.APIToken
+	if err := g.DB.Where("token_hash = ?", tokenHash).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// TouchAPIToken records the time a token was last used, without updating any other column.
+func (g *GormDatabase) TouchAPIToken(tokenID uint, usedAt time.Time) error {
+	return g.DB.Model(&models.APIToken{}).Where("id = ?", tokenID).UpdateColumn("last_used_at", usedAt).Error
+}
+
+// ListUsers returns a page of users ordered by ID, optionally filtered by a case-insensitive
+// search on username and email, along with the total number of matching users.
+func (g *GormDatabase) ListUsers(search string, offset, limit int) ([]models.User, int64, error) {
+	query := g.DB.Model(&models.User{})
+	if search != "" {
+		pattern := "%" + strings.ToLower(search) + "%"
+		query = query.Where("LOWER(username) LIKE ? OR LOWER(email) LIKE ?", pattern, pattern)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var users []models.User
+	if err := query.Order("id").Offset(offset).Limit(limit).Find(&users).Error; err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+func (g *GormDatabase) CreateAuditLogEntry(entry *models.AuditLogEntry) error {
+	return g.DB.Create(entry).Error
+}
+
+// ListAuditLogEntries returns a page of audit log entries, newest first, along with the total number of entries.
+func (g *GormDatabase) ListAuditLogEntries(offset, limit int) ([]models.AuditLogEntry, int64, error) {
+	var total int64
+	if err := g.DB.Model(&models.AuditLogEntry{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var entries []models.AuditLogEntry
+	if err := g.DB.Order("id DESC").Offset(offset).Limit(limit).Find(&entries).Error; err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
+
+// GetModerationStats counts users by role and suspension, and audit log actions since the given time.
+func (g *GormDatabase) GetModerationStats(since time.Time) (*models.ModerationStats, error) {
+	stats := &models.ModerationStats{
+		UsersByRole:   map[string]int64{},
+		RecentActions: map[string]int64{},
+		Since:         since,
+	}
+
+	if err := g.DB.Model(&models.User{}).Count(&stats.TotalUsers).Error; err != nil {
+		return nil, err
+	}
+	if err := g.DB.Model(&models.User{}).Where("suspended_at IS NOT NULL").Count(&stats.SuspendedUsers).Error; err != nil {
+		return nil, err
+	}
+
+	type count struct {
+		Name  string
+		Count int64
+	}
+
+	var roles []count
+	if err := g.DB.Model(&models.User{}).Select("role AS name, COUNT(*) AS count").Group("role").Scan(&roles).Error; err != nil {
+		return nil, err
+	}
+	for _, c := range roles {
+		stats.UsersByRole[c.Name] = c.Count
+	}
+
+	var actions []count
+	if err := g.DB.Model(&models.AuditLogEntry{}).Select("action AS name, COUNT(*) AS count").
+		Where("created_at >= ?", since).Group("action").Scan(&actions).Error; err != nil {
+		return nil, err
+	}
+	for _, c := range actions {
+		stats.RecentActions[c.Name] = c.Count
+	}
+
+	return stats, nil
+}
+
+// GetUsersByIDs returns the users with the given IDs, in no particular order. Unknown IDs are skipped.
+func (g *GormDatabase) GetUsersByIDs(userIDs []uint) ([]models.User, error) {
+	var users []models.User
+	if len(userIDs) == 0 {
+		return users, nil
+	}
+	if err := g.DB.Where("id IN ?", userIDs).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// CreateUserRelation blocks or mutes a user. Setting a relation that already exists is not an error.
+func (g *GormDatabase) CreateUserRelation(relation *models.UserRelation) error {
+	return g.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(relation).Error
+}
+
+func (g *GormDatabase) DeleteUserRelation(userID, targetID uint, kind string) error {
+	result := g.DB.Where("user_id = ? AND target_id = ? AND kind = ?", userID, targetID, kind).Delete(&models.UserRelation{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (g *GormDatabase) ListUserRelations(userID uint, kind string) ([]models.UserRelation, error) {
+	var relations []models.UserRelation
+	if err := g.DB.Where("user_id = ? AND kind = ?", userID, kind).Order("created_at").Find(&relations).Error; err != nil {
+		return nil, err
+	}
+	return relations, nil
+}
+
+func (g *GormDatabase) CountUserRelations(userID uint, kind string) (int64, error) {
+	var count int64
+	err := g.DB.Model(&models.UserRelation{}).Where("user_id = ? AND kind = ?", userID, kind).Count(&count).Error
+	return count, err
+}
+
+// HiddenUserIDs returns the users whose messages the user has hidden, by blocking or muting them.
+func (g *GormDatabase) HiddenUserIDs(userID uint) ([]uint, error) {
+	var ids []uint
+	if err := g.DB.Model(&models.UserRelation{}).Where("user_id = ?", userID).Distinct().Pluck("target_id", &ids).Error; err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// BlockedByIDs returns the users who have blocked the user.
+func (g *GormDatabase) BlockedByIDs(userID uint) ([]uint, error) {
+	var ids []uint
+	err := g.DB.Model(&models.UserRelation{}).Where("target_id = ? AND kind = ?", userID, models.RelationBlock).
+		Pluck("user_id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// IsBlockedBetween reports whether either user has blocked the other.
+func (g *GormDatabase) IsBlockedBetween(userID, otherID uint) (bool, error) {
+	var count int64
+	err := g.DB.Model(&models.UserRelation{}).
+		Where("kind = ? AND ((user_id = ? AND target_id = ?) OR (user_id = ? AND target_id = ?))",
+			models.RelationBlock, userID, otherID, otherID, userID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (g *GormDatabase) CreateRoom(room *models.Room) error {
+	return g.DB.Create(room).Error
+}
+
+func (g *GormDatabase) GetRoomByID(roomID uint) (*models.Room, error) {
+	var room models.Room
+	if err := g.DB.Where("id = ?", roomID).First(&room).Error; err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+func (g *GormDatabase) GetRoomByName(name string) (*models.Room, error) {
+	var room models.Room
+	if err := g.DB.Where("name = ?", name).First(&room).Error; err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+func (g *GormDatabase) ListRooms() ([]models.Room, error) {
+	var rooms []models.Room
+	if err := g.DB.Order("name").Find(&rooms).Error; err != nil {
+		return nil, err
+	}
+	return rooms, nil
+}
+
+func (g *GormDatabase) UpdateRoom(room *models.Room) error {
+	return g.DB.Save(room).Error
+}
+
+// AddRoomMember adds a user to a room. Adding a user who is already a member is not an error.
+func (g *GormDatabase) AddRoomMember(member *models.RoomMember) error {
+	return g.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(member).Error
+}
+
+func (g *GormDatabase) GetRoomMember(roomID, userID uint) (*models.RoomMember, error) {
+	var member models.RoomMember
+	if err := g.DB.Where("room_id = ? AND user_id = ?", roomID, userID).First(&member).Error; err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+func (g *GormDatabase) RemoveRoomMember(roomID, userID uint) error {
+	result := g.DB.Where("room_id = ? AND user_id = ?", roomID, userID).Delete(&models.RoomMember{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ListRoomMemberIDs returns the IDs of a room's members. If verifiedOnly is set, only members who are
+// verified veterans, moderators or administrators are returned, as for a verified-only room.
+func (g *GormDatabase) ListRoomMemberIDs(roomID uint, verifiedOnly bool) ([]uint, error) {
+	query := g.DB.Model(&models.RoomMember{}).Where("room_members.room_id = ?", roomID)
+	if verifiedOnly {
+		query = query.Joins("JOIN users ON users.id = room_members.user_id").
+			Where("users.veteran_status = ? OR users.role IN ?", true, []string{models.RoleModerator, models.RoleAdmin})
+	}
+
+	var ids []uint
+	if err := query.Pluck("room_members.user_id", &ids).Error; err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (g *GormDatabase) CreateMessage(message *models.Message) error {
+	return g.DB.Create(message).Error
+}
+
+// ListRoomMessages returns up to limit messages sent to a room before the message beforeID (or the latest
+// messages if beforeID is 0), newest first. Messages from users the viewer has blocked or muted are left out.
+func (g *GormDatabase) ListRoomMessages(roomID, viewerID, beforeID uint, limit int) ([]models.Message, error) {
+	return g.listMessages(g.DB.Where("room_id = ?", roomID), viewerID, beforeID, limit)
+}
+
+// ListDirectMessages returns up to limit direct messages exchanged between the viewer and another user,
+// like ListRoomMessages.
+func (g *GormDatabase) ListDirectMessages(viewerID, otherID, beforeID uint, limit int) ([]models.Message, error) {
+	query := g.DB.Where("(sender_id = ? AND recipient_id = ?) OR (sender_id = ? AND recipient_id = ?)",
+		viewerID, otherID, otherID, viewerID)
+	return g.listMessages(query, viewerID, beforeID, limit)
+}
+
+func (g *GormDatabase) listMessages(query *gorm.DB, viewerID, beforeID uint, limit int) ([]models.Message, error) {
+	hidden := g.DB.Model(&models.UserRelation{}).Select("target_id").Where("user_id = ?", viewerID)
+	query = query.Where("sender_id NOT IN (?)", hidden)
+	if beforeID > 0 {
+		query = query.Where("id < ?", beforeID)
+	}
+
+	var messages []models.Message
+	if err := query.Order("id DESC").Limit(limit).Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// ListMessagesBySender returns up to limit messages sent by a user after the message afterID, oldest first.
+func (g *GormDatabase) ListMessagesBySender(userID, afterID uint, limit int) ([]models.Message, error) {
+	var messages []models.Message
+	if err := g.DB.Where("sender_id = ? AND id > ?", userID, afterID).Order("id").Limit(limit).Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// ListUsersDueForDeletion returns up to limit users whose deletion grace period ended before now.
+func (g *GormDatabase) ListUsersDueForDeletion(now time.Time, limit int) ([]models.User, error) {
+	var users []models.User
+	err := g.DB.Where("deletion_scheduled_for <= ? AND anonymized_at IS NULL", now).Order("deletion_scheduled_for").
+		Limit(limit).Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// AnonymizeUser deletes a user's account in a single transaction. Credentials, linked identities,
+// block and mute lists, data exports and the direct messages the user sent are deleted. Messages sent
+// to rooms are kept, so that conversations stay coherent, and the user row is replaced by an anonymous
+// placeholder they remain attributed to.
+func (g *GormDatabase) AnonymizeUser(user *models.User, now time.Time) error {
+	return g.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.APIToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.WebAuthnCredential{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.UserIdentity{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ? OR target_id = ?", user.ID, user.ID).Delete(&models.UserRelation{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.DataExport{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.RoomMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("sender_id = ? AND recipient_id IS NOT NULL", user.ID).Delete(&models.Message{}).Error; err != nil {
+			return err
+		}
+
+		user.Anonymize(now)
+		return tx.Select("*").Save(user).Error
+	})
+}
+
+func (g *GormDatabase) CreateDataExport(export *models.DataExport) error {
+	return g.DB.Create(export).Error
+}
+
+func (g *GormDatabase) UpdateDataExport(export *models.DataExport) error {
+	return g.DB.Save(export).Error
+}
+
+func (g *GormDatabase) GetDataExport(userID, exportID uint) (*models.DataExport, error) {
+	var export models.DataExport
+	if err := g.DB.Where("id = ? AND user_id = ?", exportID, userID).First(&export).Error; err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// ListDataExports returns a user's data exports, newest first.
+func (g *GormDatabase) ListDataExports(userID uint) ([]models.DataExport, error) {
+	var exports []models.DataExport
+	if err := g.DB.Where("user_id = ?", userID).Order("id DESC").Find(&exports).Error; err != nil {
+		return nil, err
+	}
+	return exports, nil
+}
+
+// ListDataExportsByStatus returns up to limit data exports with the given status, oldest first.
+func (g *GormDatabase) ListDataExportsByStatus(status string, limit int) ([]models.DataExport, error) {
+	var exports []models.DataExport
+	if err := g.DB.Where("status = ?", status).Order("id").Limit(limit).Find(&exports).Error; err != nil {
+		return nil, err
+	}
+	return exports, nil
+}
+
+// ListExpiredDataExports returns up to limit ready data exports whose retention period ended before now.
+func (g *GormDatabase) ListExpiredDataExports(now time.Time, limit int) ([]models.DataExport, error) {
+	var exports []models.DataExport
+	err := g.DB.Where("status = ? AND expires_at <= ?", models.DataExportReady, now).Order("id").Limit(limit).Find(&exports).Error
+	if err != nil {
+		return nil, err
+	}
+	return exports, nil
+}
+
+// ClaimDataExport marks a pending data export as being processed. It reports whether the export
+// was claimed, so that an export is never processed twice, even by different server instances.
+func (g *GormDatabase) ClaimDataExport(exportID uint) (bool, error) {
+	result := g.DB.Model(&models.DataExport{}).Where("id = ? AND status = ?", exportID, models.DataExportPending).
+		UpdateColumn("status", models.DataExportProcessing)
+	return result.RowsAffected == 1, result.Error
+}